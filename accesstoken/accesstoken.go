@@ -0,0 +1,258 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package accesstoken provides personal access tokens, which let scripts
+// call the API on behalf of a user without a session.
+//
+// A token is made of a public prefix, used to look the token up, and a
+// secret of which only the SHA-256 hash is stored.
+package accesstoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+var (
+	// ErrInvalid is returned by Manager.Authenticate when a token is
+	// malformed, does not exist, has been revoked or has expired.
+	ErrInvalid = errors.New("accesstoken: invalid or expired token")
+
+	// ErrInvalidName is returned when a token's name is empty or too long.
+	ErrInvalidName = errors.New("accesstoken: name must be between 1 and 64 characters")
+
+	// ErrInvalidScopes is returned when a token has no scopes or a scope is
+	// malformed.
+	ErrInvalidScopes = errors.New("accesstoken: at least one valid scope is required")
+
+	// ErrScopeNotGranted is returned when creating a token with a scope the
+	// user's role does not grant.
+	ErrScopeNotGranted = errors.New("accesstoken: scope is not granted by the user's role")
+
+	// ErrInvalidExpiry is returned when creating a token that has already
+	// expired.
+	ErrInvalidExpiry = errors.New("accesstoken: expiry must be in the future")
+)
+
+const (
+	// Prefix is the identifiable start of every token, allowing leaked tokens
+	// to be found by secret scanners.
+	Prefix = "cosmos_"
+
+	// prefixLength is the length of the hex encoded public part of a token.
+	prefixLength = 12
+
+	// touchInterval is how often the last used time of a token is updated,
+	// avoiding a write on every request.
+	touchInterval = time.Minute
+)
+
+// Token is a personal access token.
+type Token struct {
+	// ID is the token's unique identifier.
+	ID snowflake.Snowflake `json:"id"`
+
+	// UserID is the ID of the user the token acts on behalf of.
+	UserID snowflake.Snowflake `json:"-"`
+
+	// Name is a name chosen by the user to identify the token.
+	Name string `json:"name"`
+
+	// Prefix is the public part of the token, it is safe to display.
+	Prefix string `json:"prefix"`
+
+	// Hash is the SHA-256 hash of the token's secret.
+	Hash string `json:"-"`
+
+	// Scopes are the permissions the token is limited to, a token never
+	// grants more than the user's role.
+	Scopes role.Permissions `json:"scopes"`
+
+	// CreatedAt is a timestamp of when the token was created.
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt is a timestamp of when the token expires, if nil the token
+	// never expires.
+	ExpiresAt *time.Time `json:"expires_at"`
+
+	// LastUsedAt is a timestamp of when the token was last used.
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// expired returns true if the token has expired at now.
+func (t *Token) expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// Users is used by a Manager to load the user a token belongs to.
+type Users interface {
+	ByID(ctx context.Context, id snowflake.Snowflake) (*user.User, error)
+}
+
+// Manager creates, authenticates and revokes personal access tokens.
+type Manager struct {
+	store Store
+	users Users
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+}
+
+// NewManager returns a new Manager.
+func NewManager(s Store, users Users) *Manager {
+	return &Manager{
+		store: s,
+		users: users,
+		Now:   time.Now,
+	}
+}
+
+// Create creates a new token for a user, returning the token that must be
+// shown to the user, it can not be retrieved again.  If expiresAt is nil the
+// token never expires.
+func (m *Manager) Create(ctx context.Context, u *user.User, name string, scopes role.Permissions, expiresAt *time.Time) (*Token, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return nil, "", ErrInvalidName
+	}
+	if len(scopes) == 0 || scopes.Validate() != nil {
+		return nil, "", ErrInvalidScopes
+	}
+	for _, scope := range scopes {
+		if !u.Can(scope) {
+			return nil, "", ErrScopeNotGranted
+		}
+	}
+	now := m.Now()
+	if expiresAt != nil && !now.Before(*expiresAt) {
+		return nil, "", ErrInvalidExpiry
+	}
+
+	prefix, err := random(prefixLength / 2)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := random(32)
+	if err != nil {
+		return nil, "", err
+	}
+	t := &Token{
+		ID:        snowflake.New(),
+		UserID:    u.ID,
+		Name:      name,
+		Prefix:    hex.EncodeToString(prefix),
+		Hash:      token.Hash(base64.RawURLEncoding.EncodeToString(secret)),
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	if err := m.store.Create(ctx, t); err != nil {
+		return nil, "", err
+	}
+	return t, Prefix + t.Prefix + "_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// Authenticate authenticates a token, returning the token and the user it
+// acts on behalf of.  The user's role is restricted to the token's scopes,
+// see role.Role.Restrict.
+func (m *Manager) Authenticate(ctx context.Context, raw string) (*Token, *user.User, error) {
+	prefix, secret, ok := parse(raw)
+	if !ok {
+		return nil, nil, ErrInvalid
+	}
+	t, err := m.store.ByPrefix(ctx, prefix)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil, ErrInvalid
+		}
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(token.Hash(secret))) != 1 {
+		return nil, nil, ErrInvalid
+	}
+	now := m.Now()
+	if t.expired(now) {
+		return nil, nil, ErrInvalid
+	}
+
+	u, err := m.users.ByID(ctx, t.UserID)
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return nil, nil, ErrInvalid
+		}
+		return nil, nil, err
+	}
+	if u.Locked {
+		return nil, nil, ErrInvalid
+	}
+
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= touchInterval {
+		if err := m.store.Use(ctx, t.ID, now); err != nil {
+			return nil, nil, err
+		}
+		t.LastUsedAt = &now
+	}
+	u.Role = u.Role.Restrict(t.Scopes)
+	return t, u, nil
+}
+
+// List returns all of a user's tokens.
+func (m *Manager) List(ctx context.Context, userID snowflake.Snowflake) ([]*Token, error) {
+	return m.store.ByUser(ctx, userID)
+}
+
+// Revoke revokes one of a user's tokens.
+func (m *Manager) Revoke(ctx context.Context, userID, id snowflake.Snowflake) error {
+	return m.store.Delete(ctx, userID, id)
+}
+
+// parse splits a token into its prefix and secret.
+func parse(raw string) (string, string, bool) {
+	if !strings.HasPrefix(raw, Prefix) {
+		return "", "", false
+	}
+	raw = raw[len(Prefix):]
+	if len(raw) < prefixLength+2 || raw[prefixLength] != '_' {
+		return "", "", false
+	}
+	return raw[:prefixLength], raw[prefixLength+1:], true
+}
+
+// random returns n random bytes.
+func random(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
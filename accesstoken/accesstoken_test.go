@@ -0,0 +1,245 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package accesstoken_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/accesstoken"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// memoryStore is an in-memory accesstoken.Store used for testing.
+type memoryStore map[snowflake.Snowflake]*accesstoken.Token
+
+func (s memoryStore) Create(_ context.Context, t *accesstoken.Token) error {
+	v := *t
+	s[t.ID] = &v
+	return nil
+}
+
+func (s memoryStore) ByPrefix(_ context.Context, prefix string) (*accesstoken.Token, error) {
+	for _, t := range s {
+		if t.Prefix == prefix {
+			v := *t
+			return &v, nil
+		}
+	}
+	return nil, accesstoken.ErrNotFound
+}
+
+func (s memoryStore) ByUser(_ context.Context, userID snowflake.Snowflake) ([]*accesstoken.Token, error) {
+	var tokens []*accesstoken.Token
+	for _, t := range s {
+		if t.UserID == userID {
+			v := *t
+			tokens = append(tokens, &v)
+		}
+	}
+	return tokens, nil
+}
+
+func (s memoryStore) Use(_ context.Context, id snowflake.Snowflake, t time.Time) error {
+	s[id].LastUsedAt = &t
+	return nil
+}
+
+func (s memoryStore) Delete(_ context.Context, userID, id snowflake.Snowflake) error {
+	t, ok := s[id]
+	if !ok || t.UserID != userID {
+		return accesstoken.ErrNotFound
+	}
+	delete(s, id)
+	return nil
+}
+
+// memoryUsers is an in-memory accesstoken.Users used for testing.
+type memoryUsers map[snowflake.Snowflake]*user.User
+
+func (s memoryUsers) ByID(_ context.Context, id snowflake.Snowflake) (*user.User, error) {
+	u, ok := s[id]
+	if !ok {
+		return nil, user.ErrNotFound
+	}
+	v := *u
+	return &v, nil
+}
+
+func newTestManager() (*accesstoken.Manager, memoryStore, memoryUsers) {
+	s := memoryStore{}
+	users := memoryUsers{1: {
+		ID:    1,
+		Email: "user@example.com",
+		Role:  &role.Role{Name: "Support", Permissions: role.Permissions{"users.*", "audit_log.read"}},
+	}}
+	return accesstoken.NewManager(s, users), s, users
+}
+
+func TestManager_Create(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	for i, tc := range []struct {
+		Name      string
+		Scopes    role.Permissions
+		ExpiresAt *time.Time
+		Expect    error
+	}{
+		{Name: "CI", Scopes: role.Permissions{"users.read"}},
+		{Name: "CI", Scopes: role.Permissions{"users.*", "audit_log.read"}},
+		{Name: " ", Scopes: role.Permissions{"users.read"}, Expect: accesstoken.ErrInvalidName},
+		{Name: strings.Repeat("a", 65), Scopes: role.Permissions{"users.read"}, Expect: accesstoken.ErrInvalidName},
+		{Name: "CI", Expect: accesstoken.ErrInvalidScopes},
+		{Name: "CI", Scopes: role.Permissions{"users..read"}, Expect: accesstoken.ErrInvalidScopes},
+		{Name: "CI", Scopes: role.Permissions{"roles.read"}, Expect: accesstoken.ErrScopeNotGranted},
+		{Name: "CI", Scopes: role.Permissions{role.All}, Expect: accesstoken.ErrScopeNotGranted},
+		{Name: "CI", Scopes: role.Permissions{"users.read"}, ExpiresAt: &past, Expect: accesstoken.ErrInvalidExpiry},
+	} {
+		m, _, users := newTestManager()
+		tok, raw, err := m.Create(context.Background(), users[1], tc.Name, tc.Scopes, tc.ExpiresAt)
+		if !errors.Is(err, tc.Expect) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !strings.HasPrefix(raw, accesstoken.Prefix+tok.Prefix+"_") {
+			t.Errorf("Test #%d: Expected \"%s\" to start with its prefix \"%s\"", i, raw, tok.Prefix)
+		}
+		if strings.Contains(tok.Hash, raw[len(accesstoken.Prefix)+len(tok.Prefix)+1:]) {
+			t.Errorf("Test #%d: Expected the secret to be hashed", i)
+		}
+	}
+}
+
+func TestManager_Authenticate(t *testing.T) {
+	m, s, users := newTestManager()
+	expiresAt := time.Now().Add(time.Hour)
+	tok, raw, err := m.Create(context.Background(), users[1], "CI", role.Permissions{"users.read", "audit_log.read"}, &expiresAt)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	wrong := raw[:len(raw)-1] + "x"
+	if strings.HasSuffix(raw, "x") {
+		wrong = raw[:len(raw)-1] + "y"
+	}
+
+	for i, tc := range []struct {
+		Token  string
+		Expect error
+	}{
+		{Token: raw},
+		{Token: wrong, Expect: accesstoken.ErrInvalid},
+		{Token: "", Expect: accesstoken.ErrInvalid},
+		{Token: strings.TrimPrefix(raw, accesstoken.Prefix), Expect: accesstoken.ErrInvalid},
+		{Token: accesstoken.Prefix + "000000000000_secret", Expect: accesstoken.ErrInvalid},
+		{Token: accesstoken.Prefix + tok.Prefix, Expect: accesstoken.ErrInvalid},
+	} {
+		_, u, err := m.Authenticate(context.Background(), tc.Token)
+		if !errors.Is(err, tc.Expect) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		// The user's role is narrowed to the token's scopes.
+		if !u.Can("users.read") || u.Can("users.update") || !u.Can("audit_log.read") {
+			t.Errorf("Test #%d: Expected the role to be restricted, but got \"%v\"", i, u.Role.Permissions)
+		}
+		if !users[1].Role.Can("users.update") {
+			t.Errorf("Test #%d: Expected the user's role to be unchanged", i)
+		}
+	}
+	if s[tok.ID].LastUsedAt == nil {
+		t.Errorf("Expected the token's last use to be recorded")
+	}
+
+	users[1].Locked = true
+	if _, _, err := m.Authenticate(context.Background(), raw); !errors.Is(err, accesstoken.ErrInvalid) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", accesstoken.ErrInvalid, err)
+	}
+	users[1].Locked = false
+
+	m.Now = func() time.Time { return expiresAt }
+	if _, _, err := m.Authenticate(context.Background(), raw); !errors.Is(err, accesstoken.ErrInvalid) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", accesstoken.ErrInvalid, err)
+	}
+	m.Now = time.Now
+
+	if err := m.Revoke(context.Background(), 2, tok.ID); !errors.Is(err, accesstoken.ErrNotFound) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", accesstoken.ErrNotFound, err)
+	}
+	if err := m.Revoke(context.Background(), 1, tok.ID); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if _, _, err := m.Authenticate(context.Background(), raw); !errors.Is(err, accesstoken.ErrInvalid) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", accesstoken.ErrInvalid, err)
+	}
+}
+
+func TestManager_Middleware(t *testing.T) {
+	m, _, users := newTestManager()
+	_, raw, err := m.Create(context.Background(), users[1], "CI", role.Permissions{"users.read"}, nil)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, authenticated := user.FromContext(r.Context())
+		_, ok := accesstoken.FromContext(r.Context())
+		if authenticated != ok {
+			t.Errorf("Expected the token and user to be attached together")
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	for i, tc := range []struct {
+		Header string
+		Expect int
+	}{
+		{Header: "", Expect: http.StatusNoContent},
+		{Header: "Basic dXNlcjpwYXNz", Expect: http.StatusNoContent},
+		{Header: "Bearer " + raw, Expect: http.StatusOK},
+		{Header: "bearer " + raw, Expect: http.StatusOK},
+		{Header: "Bearer " + raw + "x", Expect: http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.Header != "" {
+			r.Header.Set("Authorization", tc.Header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.Expect {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Expect, w.Code)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package accesstoken
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/matthewpi/cosmos"
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// contextKey is the type of the context key used to store a Token.
+type contextKey struct{}
+
+// NewContext returns a new context carrying the Token.
+func NewContext(ctx context.Context, t *Token) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the Token stored in the context, if any.
+func FromContext(ctx context.Context) (*Token, bool) {
+	t, ok := ctx.Value(contextKey{}).(*Token)
+	return t, ok && t != nil
+}
+
+// Middleware authenticates requests carrying a token in an
+// "Authorization: Bearer" header, attaching both the token and its user to
+// the request's context.  Requests without the header are passed through, so
+// this middleware is used alongside session.Manager.Middleware.
+//
+// A request with an invalid token is rejected with 401 Unauthorized rather
+// than being handled as unauthenticated, so scripts notice a revoked or
+// expired token.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, ok := bearer(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t, u, err := m.Authenticate(r.Context(), raw)
+		if err != nil {
+			if !errors.Is(err, ErrInvalid) {
+				cosmos.Log().Error("failed to authenticate access token", zap.Error(err))
+				server.WriteError(w, http.StatusInternalServerError, "internal_error", "internal server error")
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			server.WriteError(w, http.StatusUnauthorized, "invalid_token", "invalid or expired access token")
+			return
+		}
+		ctx := NewContext(r.Context(), t)
+		ctx = user.NewContext(ctx, u)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// bearer returns the token of an "Authorization: Bearer" header.
+func bearer(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return "", false
+	}
+	t := strings.TrimSpace(h[7:])
+	return t, t != ""
+}
@@ -0,0 +1,132 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package accesstoken
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// ErrNotFound is returned when a Token does not exist.
+var ErrNotFound = errors.New("accesstoken: not found")
+
+// Store persists Tokens.
+type Store interface {
+	// Create stores a new Token.
+	Create(ctx context.Context, t *Token) error
+
+	// ByPrefix returns the Token with the given prefix.
+	ByPrefix(ctx context.Context, prefix string) (*Token, error)
+
+	// ByUser returns all of a user's Tokens.
+	ByUser(ctx context.Context, userID snowflake.Snowflake) ([]*Token, error)
+
+	// Use records a Token being used.
+	Use(ctx context.Context, id snowflake.Snowflake, t time.Time) error
+
+	// Delete deletes one of a user's Tokens.
+	Delete(ctx context.Context, userID, id snowflake.Snowflake) error
+}
+
+// selectTokens selects the columns expected by scan.
+const selectTokens = "SELECT id, user_id, name, prefix, hash, scopes, created_at, expires_at, last_used_at FROM access_tokens"
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db db.Querier
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "access_tokens" table.
+func NewStore(q db.Querier) Store {
+	return &store{db: q}
+}
+
+func (s *store) Create(ctx context.Context, t *Token) error {
+	_, err := s.db.Exec(
+		ctx,
+		"INSERT INTO access_tokens (id, user_id, name, prefix, hash, scopes, created_at, expires_at) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		t.ID, t.UserID, t.Name, t.Prefix, t.Hash, t.Scopes, t.CreatedAt, t.ExpiresAt,
+	)
+	return err
+}
+
+func (s *store) ByPrefix(ctx context.Context, prefix string) (*Token, error) {
+	return scan(s.db.QueryRow(ctx, selectTokens+" WHERE prefix = $1", prefix))
+}
+
+func (s *store) ByUser(ctx context.Context, userID snowflake.Snowflake) ([]*Token, error) {
+	rows, err := s.db.Query(ctx, selectTokens+" WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*Token
+	for rows.Next() {
+		t, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *store) Use(ctx context.Context, id snowflake.Snowflake, t time.Time) error {
+	_, err := s.db.Exec(ctx, "UPDATE access_tokens SET last_used_at = $2 WHERE id = $1", id, t)
+	return err
+}
+
+func (s *store) Delete(ctx context.Context, userID, id snowflake.Snowflake) error {
+	tag, err := s.db.Exec(ctx, "DELETE FROM access_tokens WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// scan scans a row selected by selectTokens into a Token.
+func scan(row pgx.Row) (*Token, error) {
+	t := &Token{}
+	if err := row.Scan(
+		&t.ID, &t.UserID, &t.Name, &t.Prefix, &t.Hash, &t.Scopes, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt,
+	); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,349 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package audit records a tamper-evident trail of security relevant events,
+// such as logins, password changes and role changes.
+//
+// Every event stores the hash of the event before it, forming a chain; if an
+// event is modified or removed from the middle of the trail the chain no
+// longer verifies.
+//
+// The personal data of an event, its IP address, user agent and payload, is
+// only covered by the chain through a salted digest.  This allows the
+// personal data to be erased, see Event.Redact, while the rest of the event
+// still verifies.
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+)
+
+// PermissionRead is the permission required to query and verify the audit
+// log.
+const PermissionRead role.Permission = "audit_log.read"
+
+// Types of events.
+const (
+	TypeLogin              = "login"
+	TypeLoginFailed        = "login.failed"
+	TypeLogout             = "logout"
+	TypePasswordChanged    = "password.changed"
+	TypeEmailChanged       = "email.changed"
+	TypeRoleChanged        = "role.changed"
+	TypeLocked             = "account.locked"
+	TypeUnlocked           = "account.unlocked"
+	TypeSessionRevoked     = "session.revoked"
+	TypeSessionsRevoked    = "sessions.revoked"
+	TypeAccessTokenCreated = "access_token.created"
+	TypeAccessTokenRevoked = "access_token.revoked"
+	TypeDataExported       = "account.exported"
+	TypeDeletionRequested  = "account.deletion_requested"
+	TypeDeletionCancelled  = "account.deletion_cancelled"
+	TypeInviteCreated      = "invite.created"
+	TypeInviteRevoked      = "invite.revoked"
+	TypeInviteRedeemed     = "invite.redeemed"
+
+	TypeImpersonationStarted = "impersonation.started"
+	TypeImpersonationStopped = "impersonation.stopped"
+	TypeImpersonatedRequest  = "impersonation.request"
+
+	TypeOrganizationCreated     = "organization.created"
+	TypeOrganizationUpdated     = "organization.updated"
+	TypeOrganizationDeleted     = "organization.deleted"
+	TypeOrganizationTransferred = "organization.transferred"
+	TypeMemberJoined            = "organization.member_joined"
+	TypeMemberRoleChanged       = "organization.member_role_changed"
+	TypeMemberRemoved           = "organization.member_removed"
+	TypeMemberInvited           = "organization.member_invited"
+	TypeInvitationRevoked       = "organization.invitation_revoked"
+)
+
+// Event is an entry in the audit log.
+type Event struct {
+	ID   snowflake.Snowflake `json:"id"`
+	Type string              `json:"type"`
+
+	// ActorID is the ID of the user who caused the event, snowflake.Nil if
+	// the request was not authenticated.
+	ActorID snowflake.Snowflake `json:"actor_id,omitempty"`
+
+	// TargetID is the ID of the user the event happened to, snowflake.Nil if
+	// there is no such user, for example a failed login for an unknown email
+	// address.
+	TargetID snowflake.Snowflake `json:"target_id,omitempty"`
+
+	// IP is the IP address of the client.
+	IP string `json:"ip"`
+
+	// UserAgent is the User-Agent header of the request.
+	UserAgent string `json:"user_agent"`
+
+	// Payload holds details specific to the type of event.
+	Payload json.RawMessage `json:"payload"`
+
+	CreatedAt time.Time `json:"created_at"`
+
+	// Salt is mixed into the Digest so the digest of an erased event can not
+	// be used to guess its personal data, it is erased along with it.
+	Salt string `json:"-"`
+
+	// Digest is the hex encoded SHA-256 hash of the event's Salt, IP,
+	// UserAgent and Payload.  It is empty for events recorded before
+	// personal data could be redacted, whose Hash covers the personal data
+	// directly, see Sum.
+	Digest string `json:"-"`
+
+	// Redacted is true if the event's personal data has been erased.
+	Redacted bool `json:"redacted,omitempty"`
+
+	// PrevHash is the Hash of the previous event, empty for the first event.
+	PrevHash string `json:"prev_hash"`
+
+	// Hash is the hex encoded SHA-256 hash of the event, see Sum.
+	Hash string `json:"hash"`
+}
+
+// Link appends the event to the chain after prev, which is nil if the event
+// is the first, setting its ID, Digest, PrevHash and Hash.  The ID is always
+// greater than prev's so the chain can be walked in ID order, even if the
+// clocks of multiple instances disagree.
+func (e *Event) Link(prev *Event) {
+	e.Digest = e.digest()
+	e.ID = snowflake.New()
+	e.PrevHash = ""
+	if prev != nil {
+		if e.ID <= prev.ID {
+			e.ID = prev.ID + 1
+		}
+		e.PrevHash = prev.Hash
+	}
+	e.Hash = e.Sum()
+}
+
+// Sum returns the hash of the event, covering every field except Hash.  The
+// personal data is covered through the Digest, unless the event has none.
+func (e *Event) Sum() string {
+	if e.legacy() {
+		return e.legacySum()
+	}
+	h := sha256.New()
+	for _, v := range []string{
+		e.PrevHash,
+		e.ID.String(),
+		e.Type,
+		e.ActorID.String(),
+		e.TargetID.String(),
+		e.Digest,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	} {
+		write(h, v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// legacySum returns the hash of an event recorded before personal data could
+// be redacted, which covers the personal data itself.
+func (e *Event) legacySum() string {
+	h := sha256.New()
+	for _, v := range []string{
+		e.PrevHash,
+		e.ID.String(),
+		e.Type,
+		e.ActorID.String(),
+		e.TargetID.String(),
+		e.IP,
+		e.UserAgent,
+		string(e.Payload),
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	} {
+		write(h, v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// legacy returns true if the event was recorded before personal data could
+// be redacted, such events have neither a Salt nor a Digest.
+func (e *Event) legacy() bool {
+	return e.Digest == ""
+}
+
+// Redact erases the event's personal data, keeping its Digest so the event
+// still verifies.  Legacy events are left untouched, as erasing their
+// personal data would break the chain.
+func (e *Event) Redact() {
+	if e.legacy() {
+		return
+	}
+	e.IP = ""
+	e.UserAgent = ""
+	e.Payload = json.RawMessage("{}")
+	e.Salt = ""
+	e.Redacted = true
+}
+
+// valid returns true if the event's personal data matches its Digest and the
+// event matches its Hash.  The personal data of a redacted event can not be
+// checked.
+func (e *Event) valid() bool {
+	if !e.Redacted && !e.legacy() && e.digest() != e.Digest {
+		return false
+	}
+	return e.Sum() == e.Hash
+}
+
+// digest returns the hash of the event's personal data.
+func (e *Event) digest() string {
+	h := sha256.New()
+	for _, v := range []string{e.Salt, e.IP, e.UserAgent, string(e.Payload)} {
+		write(h, v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// write writes a length prefixed string to h, so the boundaries between
+// fields are unambiguous.
+func write(h hash.Hash, v string) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(v)))
+	h.Write(n[:])
+	h.Write([]byte(v))
+}
+
+// Query filters the events returned by Log.Query.
+type Query struct {
+	// UserID, if set, only matches events where the user is either the
+	// actor or the target.
+	UserID snowflake.Snowflake
+
+	// Since and Until, if set, only match events created at or after Since
+	// and before Until.
+	Since time.Time
+	Until time.Time
+
+	// Before, if set, only matches events with a lower ID, used to page
+	// through results.
+	Before snowflake.Snowflake
+
+	// Limit is the maximum number of events returned.
+	Limit int
+}
+
+// Result is the result of verifying the audit log.
+type Result struct {
+	// Valid is true if every event matches the chain.
+	Valid bool `json:"valid"`
+
+	// Events is the number of events verified.
+	Events int `json:"events"`
+
+	// Broken is the ID of the first event that does not match the chain.
+	Broken snowflake.Snowflake `json:"broken,omitempty"`
+}
+
+// verifyBatch is the number of events loaded at once by Log.Verify.
+const verifyBatch = 500
+
+// Log records and queries audit events.
+type Log struct {
+	store Store
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+}
+
+// NewLog returns a new Log.
+func NewLog(s Store) *Log {
+	return &Log{
+		store: s,
+		Now:   time.Now,
+	}
+}
+
+// Record appends an event to the log.  The event's CreatedAt is set to the
+// current time, truncated to the precision stored by PostgreSQL so the hash
+// still matches after a round trip.
+func (l *Log) Record(ctx context.Context, e *Event) error {
+	e.CreatedAt = l.Now().UTC().Truncate(time.Microsecond)
+	if len(e.Payload) == 0 {
+		e.Payload = json.RawMessage("{}")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	e.Salt = hex.EncodeToString(salt)
+	return l.store.Append(ctx, e)
+}
+
+// Redact erases the personal data of every event where the user is either
+// the actor or the target, see Event.Redact.
+func (l *Log) Redact(ctx context.Context, userID snowflake.Snowflake) error {
+	return l.store.Redact(ctx, userID)
+}
+
+// Query returns the events matching q, most recent first.
+func (l *Log) Query(ctx context.Context, q *Query) ([]*Event, error) {
+	return l.store.Query(ctx, q)
+}
+
+// Verify walks the entire log, checking that every event's hash matches its
+// contents and links to the event before it.
+func (l *Log) Verify(ctx context.Context) (*Result, error) {
+	res := &Result{Valid: true}
+	var prev *Event
+	for {
+		var after snowflake.Snowflake
+		if prev != nil {
+			after = prev.ID
+		}
+		events, err := l.store.Chain(ctx, after, verifyBatch)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range events {
+			prevHash := ""
+			if prev != nil {
+				prevHash = prev.Hash
+			}
+			if e.PrevHash != prevHash || !e.valid() {
+				res.Valid = false
+				res.Broken = e.ID
+				return res, nil
+			}
+			res.Events++
+			prev = e
+		}
+		if len(events) < verifyBatch {
+			return res, nil
+		}
+	}
+}
@@ -0,0 +1,265 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package audit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// memoryStore is an in-memory audit.Store used for testing.
+type memoryStore struct {
+	mu     sync.Mutex
+	events []*audit.Event
+}
+
+func (s *memoryStore) Append(_ context.Context, e *audit.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var prev *audit.Event
+	if len(s.events) > 0 {
+		prev = s.events[len(s.events)-1]
+	}
+	e.Link(prev)
+	v := *e
+	s.events = append(s.events, &v)
+	return nil
+}
+
+func (s *memoryStore) Query(_ context.Context, q *audit.Query) ([]*audit.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []*audit.Event
+	for i := len(s.events) - 1; i >= 0 && len(events) < q.Limit; i-- {
+		e := s.events[i]
+		if q.UserID.Valid() && e.ActorID != q.UserID && e.TargetID != q.UserID {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (s *memoryStore) Chain(_ context.Context, after snowflake.Snowflake, limit int) ([]*audit.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []*audit.Event
+	for _, e := range s.events {
+		if e.ID > after && len(events) < limit {
+			v := *e
+			events = append(events, &v)
+		}
+	}
+	return events, nil
+}
+
+func (s *memoryStore) Redact(_ context.Context, userID snowflake.Snowflake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.events {
+		if !e.Redacted && (e.ActorID == userID || e.TargetID == userID) {
+			e.Redact()
+		}
+	}
+	return nil
+}
+
+func TestLog_Verify(t *testing.T) {
+	for i, tc := range []struct {
+		Tamper func(events []*audit.Event) []*audit.Event
+		Broken int
+	}{
+		{Broken: -1},
+		{
+			Tamper: func(events []*audit.Event) []*audit.Event {
+				events[1].Payload = json.RawMessage(`{"role":"admin"}`)
+				return events
+			},
+			Broken: 1,
+		},
+		{
+			// Rewriting the hash of a modified event breaks the link to the
+			// event after it.
+			Tamper: func(events []*audit.Event) []*audit.Event {
+				events[1].ActorID = 42
+				events[1].Hash = events[1].Sum()
+				return events
+			},
+			Broken: 2,
+		},
+		{
+			Tamper: func(events []*audit.Event) []*audit.Event {
+				return append(events[:1], events[2:]...)
+			},
+			Broken: 1,
+		},
+		{
+			// Erasing an event's personal data keeps the chain intact.
+			Tamper: func(events []*audit.Event) []*audit.Event {
+				events[1].Redact()
+				return events
+			},
+			Broken: -1,
+		},
+		{
+			// But the digest still protects the rest of the event.
+			Tamper: func(events []*audit.Event) []*audit.Event {
+				events[1].Redact()
+				events[1].Digest = events[0].Digest
+				return events
+			},
+			Broken: 1,
+		},
+		{
+			Tamper: func(events []*audit.Event) []*audit.Event {
+				events[1].IP = "198.51.100.1"
+				return events
+			},
+			Broken: 1,
+		},
+	} {
+		s := &memoryStore{}
+		l := audit.NewLog(s)
+		for _, typ := range []string{audit.TypeLogin, audit.TypeRoleChanged, audit.TypeLogout} {
+			if err := l.Record(context.Background(), &audit.Event{Type: typ, TargetID: 1}); err != nil {
+				t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			}
+		}
+		var broken snowflake.Snowflake
+		if tc.Tamper != nil {
+			s.events = tc.Tamper(s.events)
+		}
+		if tc.Broken >= 0 {
+			broken = s.events[tc.Broken].ID
+		}
+
+		res, err := l.Verify(context.Background())
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		if res.Valid != (tc.Broken < 0) {
+			t.Errorf("Test #%d: Expected \"%t\", but got \"%t\"", i, tc.Broken < 0, res.Valid)
+		}
+		if res.Broken != broken {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, broken, res.Broken)
+		}
+	}
+}
+
+func TestLog_Redact(t *testing.T) {
+	s := &memoryStore{}
+	l := audit.NewLog(s)
+	for _, e := range []*audit.Event{
+		{Type: audit.TypeLogin, ActorID: 1, TargetID: 1, IP: "192.0.2.1", UserAgent: "test"},
+		{Type: audit.TypeRoleChanged, ActorID: 2, TargetID: 1, IP: "192.0.2.2", Payload: json.RawMessage(`{"to":"admin"}`)},
+		{Type: audit.TypeLogin, ActorID: 2, TargetID: 2, IP: "192.0.2.2", UserAgent: "test"},
+	} {
+		if err := l.Record(context.Background(), e); err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+	}
+	if err := l.Redact(context.Background(), 1); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	for i, redacted := range []bool{true, true, false} {
+		e := s.events[i]
+		if e.Redacted != redacted || (e.IP == "") != redacted || (e.Salt == "") != redacted {
+			t.Errorf("Test #%d: Expected \"%t\", but got \"%v\"", i, redacted, e)
+		}
+	}
+	if string(s.events[1].Payload) != "{}" {
+		t.Errorf("Expected \"%s\", but got \"%s\"", "{}", s.events[1].Payload)
+	}
+
+	res, err := l.Verify(context.Background())
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if !res.Valid || res.Events != 3 {
+		t.Errorf("Expected a valid chain of 3 events, but got \"%v\"", res)
+	}
+}
+
+func TestEvent_Link(t *testing.T) {
+	prev := &audit.Event{ID: snowflake.New() + 1000, Hash: "abc"}
+	e := &audit.Event{Type: audit.TypeLogin}
+	e.Link(prev)
+	if e.ID <= prev.ID {
+		t.Errorf("Expected an ID greater than \"%s\", but got \"%s\"", prev.ID, e.ID)
+	}
+	if e.PrevHash != prev.Hash {
+		t.Errorf("Expected \"%s\", but got \"%s\"", prev.Hash, e.PrevHash)
+	}
+	if e.Hash != e.Sum() {
+		t.Errorf("Expected \"%s\", but got \"%s\"", e.Sum(), e.Hash)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	s := &memoryStore{}
+	l := audit.NewLog(s)
+	now := time.Date(2021, 1, 2, 3, 4, 5, 6789, time.UTC)
+	l.Now = func() time.Time { return now }
+
+	actor, _ := user.New("admin@example.com", []byte("password123"))
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		audit.Record(user.NewContext(r.Context(), actor), audit.TypeRoleChanged, 7, map[string]string{"role": "admin"})
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("User-Agent", "test")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	// Events are not recorded outside of a request served by the middleware.
+	audit.Record(context.Background(), audit.TypeLogout, 7, nil)
+
+	if len(s.events) != 1 {
+		t.Fatalf("Expected \"%d\", but got \"%d\"", 1, len(s.events))
+	}
+	e := s.events[0]
+	for i, tc := range []struct {
+		Expect interface{}
+		Actual interface{}
+	}{
+		{Expect: audit.TypeRoleChanged, Actual: e.Type},
+		{Expect: actor.ID, Actual: e.ActorID},
+		{Expect: snowflake.Snowflake(7), Actual: e.TargetID},
+		{Expect: "192.0.2.1", Actual: e.IP},
+		{Expect: "test", Actual: e.UserAgent},
+		{Expect: `{"role":"admin"}`, Actual: string(e.Payload)},
+		{Expect: now.Truncate(time.Microsecond), Actual: e.CreatedAt},
+	} {
+		if tc.Expect != tc.Actual {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, tc.Actual)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/matthewpi/cosmos"
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// contextKey is the type of the context key used to store a recorder.
+type contextKey struct{}
+
+// recorder records events for a request.
+type recorder struct {
+	log       *Log
+	ip        string
+	userAgent string
+}
+
+// Middleware stores the client's IP address and user agent in the request's
+// context, allowing handlers to record events using Record.
+//
+// Every request made while impersonating a user is recorded as a
+// TypeImpersonatedRequest event, see user.ImpersonatorFromContext.
+func (l *Log) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &recorder{
+			log:       l,
+			ip:        server.ClientIP(r),
+			userAgent: r.UserAgent(),
+		}
+		ctx := context.WithValue(r.Context(), contextKey{}, rec)
+		impersonator, ok := user.ImpersonatorFromContext(ctx)
+		if !ok {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		var target snowflake.Snowflake
+		if u, ok := user.FromContext(ctx); ok {
+			target = u.ID
+		}
+		RecordAs(ctx, TypeImpersonatedRequest, impersonator.ID, target, map[string]interface{}{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"status": sw.status,
+		})
+	})
+}
+
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader satisfies http.ResponseWriter.
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Record records an event of the given type about the target user, marshaling
+// payload as the event's payload.  The actor is the authenticated user, if
+// any, or the user impersonating them.
+//
+// Record does nothing if the context does not come from a request served
+// through Log.Middleware.  Failures are logged rather than returned, so a
+// request is not failed after the action it audits has already happened.
+func Record(ctx context.Context, typ string, target snowflake.Snowflake, payload interface{}) {
+	var actor snowflake.Snowflake
+	if u, ok := user.ImpersonatorFromContext(ctx); ok {
+		actor = u.ID
+	} else if u, ok := user.FromContext(ctx); ok {
+		actor = u.ID
+	}
+	RecordAs(ctx, typ, actor, target, payload)
+}
+
+// RecordAs is like Record, but with an explicit actor, used when the user is
+// not yet authenticated, for example while logging in.
+func RecordAs(ctx context.Context, typ string, actor, target snowflake.Snowflake, payload interface{}) {
+	rec, ok := ctx.Value(contextKey{}).(*recorder)
+	if !ok {
+		return
+	}
+	e := &Event{
+		Type:      typ,
+		ActorID:   actor,
+		TargetID:  target,
+		IP:        rec.ip,
+		UserAgent: rec.userAgent,
+	}
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			cosmos.Log().Error("failed to marshal audit event payload", zap.String("type", typ), zap.Error(err))
+			return
+		}
+		e.Payload = b
+	}
+	if err := rec.log.Record(ctx, e); err != nil {
+		cosmos.Log().Error("failed to record audit event", zap.String("type", typ), zap.Error(err))
+	}
+}
@@ -0,0 +1,169 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package audit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// lockKey is the key of the advisory lock held while appending an event, so
+// concurrent appends cannot link to the same previous event.
+const lockKey = 0x6175646974 // "audit"
+
+// Store persists audit events.
+type Store interface {
+	// Append links the event to the most recent event, see Event.Link, and
+	// stores it.  Appends are serialized so the chain never forks.
+	Append(ctx context.Context, e *Event) error
+
+	// Query returns the events matching q, ordered by descending ID.
+	Query(ctx context.Context, q *Query) ([]*Event, error)
+
+	// Chain returns up to limit events with an ID greater than after,
+	// ordered by ID.
+	Chain(ctx context.Context, after snowflake.Snowflake, limit int) ([]*Event, error)
+
+	// Redact erases the personal data of every event where the user is
+	// either the actor or the target.
+	Redact(ctx context.Context, userID snowflake.Snowflake) error
+}
+
+// selectEvents selects the columns expected by scan.
+const selectEvents = "SELECT id, type, actor_id, target_id, ip, user_agent, payload, created_at, salt, digest, prev_hash, hash FROM audit_events"
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db db.Querier
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "audit_events" table.
+func NewStore(q db.Querier) Store {
+	return &store{db: q}
+}
+
+func (s *store) Append(ctx context.Context, e *Event) error {
+	return s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", int64(lockKey)); err != nil {
+			return err
+		}
+		prev, err := scan(tx.QueryRow(ctx, selectEvents+" ORDER BY id DESC LIMIT 1"))
+		if err != nil && !errors.Is(err, db.ErrNoRows) {
+			return err
+		}
+		e.Link(prev)
+		_, err = tx.Exec(
+			ctx,
+			"INSERT INTO audit_events (id, type, actor_id, target_id, ip, user_agent, payload, created_at, salt, digest, prev_hash, hash) "+
+				"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)",
+			e.ID, e.Type, e.ActorID, e.TargetID, e.IP, e.UserAgent, string(e.Payload), e.CreatedAt, e.Salt, e.Digest, e.PrevHash, e.Hash,
+		)
+		return err
+	})
+}
+
+func (s *store) Query(ctx context.Context, q *Query) ([]*Event, error) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if q.UserID.Valid() {
+		p := arg(q.UserID)
+		where = append(where, "(actor_id = "+p+" OR target_id = "+p+")")
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "created_at >= "+arg(q.Since))
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "created_at < "+arg(q.Until))
+	}
+	if q.Before.Valid() {
+		where = append(where, "id < "+arg(q.Before))
+	}
+	sql := selectEvents
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	sql += " ORDER BY id DESC LIMIT " + arg(q.Limit)
+	return s.query(ctx, sql, args...)
+}
+
+func (s *store) Chain(ctx context.Context, after snowflake.Snowflake, limit int) ([]*Event, error) {
+	// after is passed as an integer, an invalid snowflake would otherwise be
+	// sent as NULL and match nothing.
+	return s.query(ctx, selectEvents+" WHERE id > $1 ORDER BY id LIMIT $2", int64(after), limit)
+}
+
+func (s *store) Redact(ctx context.Context, userID snowflake.Snowflake) error {
+	// Redacted and legacy events have no salt, see Event.Redact.
+	_, err := s.db.Exec(
+		ctx,
+		"UPDATE audit_events SET ip = '', user_agent = '', payload = '{}', salt = '' WHERE (actor_id = $1 OR target_id = $1) AND salt <> ''",
+		userID,
+	)
+	return err
+}
+
+func (s *store) query(ctx context.Context, sql string, args ...interface{}) ([]*Event, error) {
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []*Event
+	for rows.Next() {
+		e, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// scan scans a row selected by selectEvents into an Event.
+func scan(row pgx.Row) (*Event, error) {
+	e := &Event{}
+	var payload string
+	if err := row.Scan(
+		&e.ID, &e.Type, &e.ActorID, &e.TargetID, &e.IP, &e.UserAgent, &payload, &e.CreatedAt, &e.Salt, &e.Digest, &e.PrevHash, &e.Hash,
+	); err != nil {
+		return nil, err
+	}
+	e.Payload = []byte(payload)
+	// Legacy events have neither a salt nor a digest, but have not been
+	// redacted, see Event.legacy.
+	e.Redacted = e.Salt == "" && e.Digest != ""
+	return e, nil
+}
@@ -0,0 +1,162 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package audit_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/db/dbtest"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// legacyHash returns the hash of an event recorded before audit events had a
+// salted digest, which covers the personal data directly.
+func legacyHash(e *audit.Event) string {
+	h := sha256.New()
+	for _, v := range []string{
+		e.PrevHash,
+		e.ID.String(),
+		e.Type,
+		e.ActorID.String(),
+		e.TargetID.String(),
+		e.IP,
+		e.UserAgent,
+		string(e.Payload),
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	} {
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], uint64(len(v)))
+		h.Write(n[:])
+		h.Write([]byte(v))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func TestStore_VerifyLegacy(t *testing.T) {
+	for i, tc := range []struct {
+		Tamper func(events []*audit.Event)
+		Broken int
+	}{
+		{Broken: -1},
+		{
+			Tamper: func(events []*audit.Event) {
+				events[1].IP = "198.51.100.1"
+			},
+			Broken: 1,
+		},
+		{
+			// A legacy event can not be passed off as redacted.
+			Tamper: func(events []*audit.Event) {
+				events[1].IP = ""
+				events[1].UserAgent = ""
+				events[1].Payload = json.RawMessage("{}")
+			},
+			Broken: 1,
+		},
+	} {
+		// The first two events were recorded before events had a salt and
+		// digest, the others after.
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		var events []*audit.Event
+		var prev *audit.Event
+		for j := 0; j < 2; j++ {
+			e := &audit.Event{
+				ID:        snowflake.Snowflake(j + 1),
+				Type:      audit.TypeLogin,
+				TargetID:  1,
+				IP:        "192.0.2.1",
+				UserAgent: "test",
+				Payload:   json.RawMessage(`{"method":"password"}`),
+				CreatedAt: now,
+			}
+			if prev != nil {
+				e.PrevHash = prev.Hash
+			}
+			e.Hash = legacyHash(e)
+			events = append(events, e)
+			prev = e
+		}
+		for j := 0; j < 2; j++ {
+			e := &audit.Event{
+				Type:      audit.TypeLogout,
+				TargetID:  1,
+				IP:        "192.0.2.1",
+				UserAgent: "test",
+				Payload:   json.RawMessage("{}"),
+				CreatedAt: now,
+				Salt:      "0123456789abcdef0123456789abcdef",
+			}
+			e.Link(prev)
+			events = append(events, e)
+			prev = e
+		}
+		events[3].Redact()
+		if tc.Tamper != nil {
+			tc.Tamper(events)
+		}
+
+		q := dbtest.New()
+		q.Handle("FROM audit_events WHERE id > $1", func(s dbtest.Statement) ([]dbtest.Row, error) {
+			var rows []dbtest.Row
+			for _, e := range events {
+				if int64(e.ID) <= s.Args[0].(int64) {
+					continue
+				}
+				rows = append(rows, dbtest.Row{
+					"id":         e.ID,
+					"type":       e.Type,
+					"actor_id":   e.ActorID,
+					"target_id":  e.TargetID,
+					"ip":         e.IP,
+					"user_agent": e.UserAgent,
+					"payload":    string(e.Payload),
+					"created_at": e.CreatedAt,
+					"salt":       e.Salt,
+					"digest":     e.Digest,
+					"prev_hash":  e.PrevHash,
+					"hash":       e.Hash,
+				})
+			}
+			return rows, nil
+		})
+		res, err := audit.NewLog(audit.NewStore(q)).Verify(context.Background())
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		var broken snowflake.Snowflake
+		if tc.Broken >= 0 {
+			broken = events[tc.Broken].ID
+		}
+		if res.Valid != (tc.Broken < 0) || res.Broken != broken {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, broken, res.Broken)
+		}
+	}
+}
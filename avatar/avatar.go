@@ -0,0 +1,205 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package avatar processes and stores user avatars.
+//
+// Uploaded images are decoded, cropped to a square and rendered at each of a
+// fixed set of sizes.  Rendering re-encodes the pixels as PNG, so metadata
+// embedded in the upload (such as EXIF location data) is never stored.  The
+// rendered images are stored under the SHA-256 hash of the largest size,
+// which is the value stored in user.User.Avatar.
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/draw"
+	"image/png"
+
+	// Register the supported upload formats.
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+var (
+	// ErrUnsupportedFormat is returned when an upload is not a PNG, JPEG or
+	// GIF image.
+	ErrUnsupportedFormat = errors.New("avatar: unsupported image format")
+
+	// ErrTooLarge is returned when an upload's dimensions exceed the
+	// configured maximum.
+	ErrTooLarge = errors.New("avatar: image dimensions are too large")
+
+	// ErrInvalidImage is returned when an upload can not be decoded.
+	ErrInvalidImage = errors.New("avatar: invalid image")
+)
+
+// formats are the supported upload formats, as named by image.Decode.
+var formats = map[string]bool{
+	"png":  true,
+	"jpeg": true,
+	"gif":  true,
+}
+
+// Avatar is a processed avatar.
+type Avatar struct {
+	// Hash is the hex encoded SHA-256 hash of the largest image.
+	Hash string
+
+	// Images are the PNG encoded images, keyed by size.
+	Images map[int][]byte
+}
+
+// Process decodes an uploaded image and renders it at each size.  Only the
+// first frame of an animated GIF is used.
+func Process(data []byte, sizes []int, maxDimension int) (*Avatar, error) {
+	c, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, ErrUnsupportedFormat
+		}
+		return nil, ErrInvalidImage
+	}
+	if !formats[format] {
+		return nil, ErrUnsupportedFormat
+	}
+	// The dimensions are checked before decoding, as a small file can
+	// describe an image large enough to exhaust memory.
+	if c.Width < 1 || c.Height < 1 || c.Width > maxDimension || c.Height > maxDimension {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	src := square(img)
+	a := &Avatar{Images: make(map[int][]byte, len(sizes))}
+	largest := 0
+	for _, size := range sizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, resize(src, size)); err != nil {
+			return nil, err
+		}
+		a.Images[size] = buf.Bytes()
+		if size > largest {
+			largest = size
+		}
+	}
+	sum := sha256.Sum256(a.Images[largest])
+	a.Hash = hex.EncodeToString(sum[:])
+	return a, nil
+}
+
+// square returns the centered square of an image, converted to RGBA.
+func square(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	n := b.Dx()
+	if b.Dy() < n {
+		n = b.Dy()
+	}
+	offset := image.Pt(b.Min.X+(b.Dx()-n)/2, b.Min.Y+(b.Dy()-n)/2)
+	dst := image.NewRGBA(image.Rect(0, 0, n, n))
+	draw.Draw(dst, dst.Bounds(), img, offset, draw.Src)
+	return dst
+}
+
+// contribution is the weight of a source pixel in a destination pixel.
+type contribution struct {
+	index  int
+	weight float64
+}
+
+// weights returns the contributions of the source pixels to each
+// destination pixel when scaling from n to size pixels, each destination
+// pixel averages the area of the source it covers.
+func weights(n, size int) [][]contribution {
+	scale := float64(n) / float64(size)
+	w := make([][]contribution, size)
+	for i := range w {
+		start, end := float64(i)*scale, float64(i+1)*scale
+		var total float64
+		for j := int(start); j < n && float64(j) < end; j++ {
+			lo, hi := float64(j), float64(j+1)
+			if lo < start {
+				lo = start
+			}
+			if hi > end {
+				hi = end
+			}
+			if hi <= lo {
+				continue
+			}
+			w[i] = append(w[i], contribution{index: j, weight: hi - lo})
+			total += hi - lo
+		}
+		for k := range w[i] {
+			w[i][k].weight /= total
+		}
+	}
+	return w
+}
+
+// resize scales a square image to size by area averaging, first
+// horizontally then vertically.  Averaging is done on premultiplied alpha so
+// transparent pixels do not bleed their color.
+func resize(src *image.RGBA, size int) *image.RGBA {
+	n := src.Bounds().Dx()
+	w := weights(n, size)
+
+	tmp := make([]float64, size*n*4)
+	for y := 0; y < n; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, cs := range w {
+			o := (y*size + x) * 4
+			for _, c := range cs {
+				p := row[c.index*4:]
+				tmp[o] += float64(p[0]) * c.weight
+				tmp[o+1] += float64(p[1]) * c.weight
+				tmp[o+2] += float64(p[2]) * c.weight
+				tmp[o+3] += float64(p[3]) * c.weight
+			}
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y, cs := range w {
+		for x := 0; x < size; x++ {
+			var v [4]float64
+			for _, c := range cs {
+				o := (c.index*size + x) * 4
+				v[0] += tmp[o] * c.weight
+				v[1] += tmp[o+1] * c.weight
+				v[2] += tmp[o+2] * c.weight
+				v[3] += tmp[o+3] * c.weight
+			}
+			p := dst.Pix[y*dst.Stride+x*4:]
+			for i := range v {
+				p[i] = uint8(v[i] + 0.5)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,189 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package avatar_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/matthewpi/cosmos/avatar"
+)
+
+// newImage returns a PNG encoded image, the left half is red and the right
+// half is blue.
+func newImage(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= width/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	return buf.Bytes()
+}
+
+// withText inserts a tEXt chunk after the IHDR chunk of a PNG image.
+func withText(data []byte, text string) []byte {
+	chunk := append([]byte("tEXt"), text...)
+	var b bytes.Buffer
+	b.Write(data[:33])
+	_ = binary.Write(&b, binary.BigEndian, uint32(len(text)))
+	b.Write(chunk)
+	_ = binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	b.Write(data[33:])
+	return b.Bytes()
+}
+
+func TestProcess(t *testing.T) {
+	data := withText(newImage(t, 300, 200), "Comment\x00secret location")
+	a, err := avatar.Process(data, []int{32, 128, 256}, 4096)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if !avatar.ValidHash(a.Hash) {
+		t.Errorf("Expected a SHA-256 hash, but got \"%s\"", a.Hash)
+	}
+	for size, v := range a.Images {
+		if bytes.Contains(v, []byte("secret location")) {
+			t.Errorf("Test #%d: Expected metadata to be stripped", size)
+		}
+		img, err := png.Decode(bytes.NewReader(v))
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", size, err)
+			continue
+		}
+		if b := img.Bounds(); b.Dx() != size || b.Dy() != size {
+			t.Errorf("Test #%d: Expected \"%dx%d\", but got \"%dx%d\"", size, size, size, b.Dx(), b.Dy())
+		}
+		// The image is cropped to the center 200x200 square, so the
+		// corners are still red and blue.
+		if r, _, b, _ := img.At(0, 0).RGBA(); r>>8 != 255 || b != 0 {
+			t.Errorf("Test #%d: Expected the top left to be red", size)
+		}
+		if r, _, b, _ := img.At(size-1, size-1).RGBA(); r != 0 || b>>8 != 255 {
+			t.Errorf("Test #%d: Expected the bottom right to be blue", size)
+		}
+	}
+
+	// Processing the same image produces the same hash.
+	b, err := avatar.Process(data, []int{32, 128, 256}, 4096)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if a.Hash != b.Hash {
+		t.Errorf("Expected \"%s\", but got \"%s\"", a.Hash, b.Hash)
+	}
+}
+
+func TestProcess_Errors(t *testing.T) {
+	var jpg bytes.Buffer
+	_ = jpeg.Encode(&jpg, image.NewRGBA(image.Rect(0, 0, 64, 64)), nil)
+
+	for i, tc := range []struct {
+		data   []byte
+		expect error
+	}{
+		{data: jpg.Bytes()},
+		{data: []byte("BM not a supported format"), expect: avatar.ErrUnsupportedFormat},
+		{data: newImage(t, 5000, 10), expect: avatar.ErrTooLarge},
+		{data: newImage(t, 64, 64)[:100], expect: avatar.ErrInvalidImage},
+	} {
+		if _, err := avatar.Process(tc.data, []int{32}, 4096); err != tc.expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.expect, err)
+		}
+	}
+}
+
+func TestStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avatars")
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := avatar.NewStorage(dir)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	a, err := avatar.Process(newImage(t, 64, 64), []int{32, 64}, 4096)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.Save(a); err != nil {
+			t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+	}
+
+	f, err := s.Open(a.Hash, 32)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	data, _ := ioutil.ReadAll(f)
+	f.Close()
+	if !bytes.Equal(data, a.Images[32]) {
+		t.Errorf("Expected the stored image to match")
+	}
+
+	for i, tc := range []struct {
+		hash string
+		size int
+	}{
+		{hash: a.Hash, size: 128},
+		{hash: "../../etc/passwd", size: 32},
+		{hash: a.Hash[:63] + "/", size: 32},
+	} {
+		if _, err := s.Open(tc.hash, tc.size); err != avatar.ErrNotFound {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, avatar.ErrNotFound, err)
+		}
+	}
+
+	sizes, err := s.Sizes(a.Hash)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if len(sizes) != 2 || sizes[0] != 32 || sizes[1] != 64 {
+		t.Errorf("Expected \"%v\", but got \"%v\"", []int{32, 64}, sizes)
+	}
+	if err := s.Delete(a.Hash); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if _, err := s.Sizes(a.Hash); err != avatar.ErrNotFound {
+		t.Errorf("Expected \"%v\", but got \"%v\"", avatar.ErrNotFound, err)
+	}
+}
@@ -0,0 +1,119 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package avatar
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/matthewpi/cosmos/internal/config/lexer"
+)
+
+// Config represents the configuration for avatars.
+type Config struct {
+	// Directory is the directory avatars are stored in.
+	Directory string `json:"directory"`
+
+	// Sizes are the sizes, in pixels, avatars are rendered at.
+	Sizes []int `json:"sizes"`
+
+	// MaxUploadSize is the maximum size of an upload in bytes.
+	MaxUploadSize int64 `json:"max_upload_size"`
+
+	// MaxDimension is the maximum width or height of an upload in pixels.
+	MaxDimension int `json:"max_dimension"`
+}
+
+// DefaultConfig returns the default avatar configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		Directory:     "avatars",
+		Sizes:         []int{32, 64, 128, 256},
+		MaxUploadSize: 5 << 20,
+		MaxDimension:  4096,
+	}
+}
+
+// HasSize returns true if avatars are rendered at a size.
+func (c *Config) HasSize(size int) bool {
+	for _, v := range c.Sizes {
+		if v == size {
+			return true
+		}
+	}
+	return false
+}
+
+// FromLexer .
+func FromLexer(b lexer.Block) (*Config, error) {
+	c := DefaultConfig()
+	var sizes []int
+	for _, s := range b.Segments {
+		d := s.Directive()
+		switch d {
+		case "directory":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after directory directive")
+			}
+			c.Directory = s[1].Text
+		case "sizes":
+			if len(s) < 2 {
+				return nil, fmt.Errorf("missing argument after sizes directive")
+			}
+			for _, t := range s[1:] {
+				v, err := strconv.Atoi(t.Text)
+				if err != nil {
+					return nil, fmt.Errorf("invalid size: %w", err)
+				}
+				if v < 1 || v > 1024 {
+					return nil, fmt.Errorf("sizes must be between 1 and 1024")
+				}
+				sizes = append(sizes, v)
+			}
+		case "max_upload_size", "max_dimension":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after %s directive", d)
+			}
+			v, err := strconv.ParseInt(s[1].Text, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", d, err)
+			}
+			if v < 1 {
+				return nil, fmt.Errorf("%s must be positive", d)
+			}
+			if d == "max_upload_size" {
+				c.MaxUploadSize = v
+			} else {
+				c.MaxDimension = int(v)
+			}
+		default:
+			return nil, fmt.Errorf("unknown directive: \"" + d + "\"")
+		}
+	}
+	if sizes != nil {
+		sort.Ints(sizes)
+		c.Sizes = sizes
+	}
+	return c, nil
+}
@@ -0,0 +1,162 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package avatar
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is returned when an avatar does not exist.
+var ErrNotFound = errors.New("avatar: not found")
+
+// Storage stores avatars on the local disk, content-addressed by their hash.
+type Storage struct {
+	dir string
+}
+
+// NewStorage returns a Storage that stores avatars in a directory, creating
+// it if it does not exist.
+func NewStorage(dir string) (*Storage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Storage{dir: dir}, nil
+}
+
+// Save stores an avatar's images.  Avatars are immutable, so saving an
+// avatar that is already stored does nothing.
+func (s *Storage) Save(a *Avatar) error {
+	dir := s.path(a.Hash)
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	// The images are written to a temporary directory which is renamed into
+	// place, so a partially written avatar is never served.
+	parent := filepath.Dir(dir)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(parent, ".tmp-")
+	if err != nil {
+		return err
+	}
+	for size, data := range a.Images {
+		if err := ioutil.WriteFile(filepath.Join(tmp, filename(size)), data, 0o644); err != nil {
+			_ = os.RemoveAll(tmp)
+			return err
+		}
+	}
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		_ = os.RemoveAll(tmp)
+		// Another upload of the same image may have finished first.
+		if _, statErr := os.Stat(dir); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Open opens the image of an avatar at a size.
+func (s *Storage) Open(hash string, size int) (*os.File, error) {
+	if !ValidHash(hash) {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(filepath.Join(s.path(hash), filename(size)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// Sizes returns the sizes an avatar is stored at, in ascending order.
+func (s *Storage) Sizes(hash string) ([]int, error) {
+	if !ValidHash(hash) {
+		return nil, ErrNotFound
+	}
+	files, err := ioutil.ReadDir(s.path(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	var sizes []int
+	for _, f := range files {
+		size, err := strconv.Atoi(strings.TrimSuffix(f.Name(), ".png"))
+		if err != nil || filename(size) != f.Name() {
+			continue
+		}
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	return sizes, nil
+}
+
+// Delete deletes an avatar's images.  Avatars are shared by every user who
+// uploaded the same image, so an avatar must only be deleted once no user
+// has it.
+func (s *Storage) Delete(hash string) error {
+	if !ValidHash(hash) {
+		return ErrNotFound
+	}
+	return os.RemoveAll(s.path(hash))
+}
+
+// path returns the directory an avatar is stored in, avatars are sharded by
+// the first two characters of their hash to keep directories small.
+func (s *Storage) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+// filename returns the name of the file an image is stored in.
+func filename(size int) string {
+	return strconv.Itoa(size) + ".png"
+}
+
+// ValidHash returns true if v is a hex encoded SHA-256 hash, as produced by
+// Process.
+func ValidHash(v string) bool {
+	if len(v) != 64 {
+		return false
+	}
+	for _, c := range v {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -33,8 +33,31 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/matthewpi/cosmos"
+	"github.com/matthewpi/cosmos/accesstoken"
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/avatar"
+	"github.com/matthewpi/cosmos/identity"
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/db/migrations"
 	"github.com/matthewpi/cosmos/internal/log"
+	"github.com/matthewpi/cosmos/internal/mail"
+	"github.com/matthewpi/cosmos/internal/oidc"
+	"github.com/matthewpi/cosmos/internal/password"
+	"github.com/matthewpi/cosmos/internal/outbox"
 	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/internal/webauthn"
+	"github.com/matthewpi/cosmos/invite"
+	"github.com/matthewpi/cosmos/lockout"
+	"github.com/matthewpi/cosmos/magiclink"
+	"github.com/matthewpi/cosmos/organization"
+	"github.com/matthewpi/cosmos/passkey"
+	"github.com/matthewpi/cosmos/privacy"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/twofactor"
+	"github.com/matthewpi/cosmos/user"
 )
 
 func main() {
@@ -73,7 +96,163 @@ License: https://github.com/matthewpi/cosmos/blob/master/LICENSE.md
 	log.SetGlobal(productionLogger)
 	defer cosmos.Log().Sync()
 
-	s, err := server.FromLexer(cfg.Key("http"))
+	runCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	dc, err := db.FromLexer(cfg.Key("database"))
+	if err != nil {
+		cosmos.Log().Fatal("failed to load database config", zap.Error(err))
+		return
+	}
+	pool, err := db.Connect(runCtx, dc)
+	if err != nil {
+		cosmos.Log().Fatal("failed to connect to database", zap.Error(err))
+		return
+	}
+	defer pool.Close()
+
+	events := outbox.NewMux()
+	go func() {
+		if err := outbox.NewRelay(outbox.NewStore(pool), events).Run(runCtx); err != nil && err != context.Canceled {
+			cosmos.Log().Error("outbox relay stopped", zap.Error(err))
+		}
+	}()
+
+	users := user.NewStore(pool)
+	roles := role.NewManager(role.NewStore(pool))
+	auditLog := audit.NewLog(audit.NewStore(pool))
+
+	sc, err := session.FromLexer(cfg.Key("session"))
+	if err != nil {
+		cosmos.Log().Fatal("failed to load session config", zap.Error(err))
+		return
+	}
+	sessions := session.NewManager(session.NewStore(pool), users, sc)
+	tokens := token.NewManager(token.NewStore(pool))
+
+	mc, err := mail.FromLexer(cfg.Key("mail"))
+	if err != nil {
+		cosmos.Log().Fatal("failed to load mail config", zap.Error(err))
+		return
+	}
+	mailer := mail.Async(mc.Mailer())
+	// Outbox handlers send synchronously, so a failed send is retried rather
+	// than the event being marked as delivered.
+	events.Handle(session.EventNewDevice, api.NewDeviceNotifier(users, mc.Mailer()))
+
+	tfc, err := twofactor.FromLexer(cfg.Key("two_factor"))
+	if err != nil {
+		cosmos.Log().Fatal("failed to load two_factor config", zap.Error(err))
+		return
+	}
+	twoFactor := twofactor.NewManager(twofactor.NewStore(pool), tfc)
+
+	wc, err := webauthn.FromLexer(cfg.Key("webauthn"))
+	if err != nil {
+		cosmos.Log().Fatal("failed to load webauthn config", zap.Error(err))
+		return
+	}
+	passkeys := passkey.NewManager(passkey.NewStore(pool), users, wc)
+
+	accessTokens := accesstoken.NewManager(accesstoken.NewStore(pool), users)
+
+	lc, err := lockout.FromLexer(cfg.Key("lockout"))
+	if err != nil {
+		cosmos.Log().Fatal("failed to load lockout config", zap.Error(err))
+		return
+	}
+	lockouts := lockout.NewManager(lockout.NewStore(pool), lc)
+
+	mlc, err := magiclink.FromLexer(cfg.Key("magic_link"))
+	if err != nil {
+		cosmos.Log().Fatal("failed to load magic_link config", zap.Error(err))
+		return
+	}
+	magicLinks := magiclink.NewManager(magiclink.NewStore(pool), users, mlc)
+
+	pc, err := password.FromLexer(cfg.Key("password"))
+	if err != nil {
+		cosmos.Log().Fatal("failed to load password config", zap.Error(err))
+		return
+	}
+	policy, err := pc.Policy()
+	if err != nil {
+		cosmos.Log().Fatal("failed to load password policy", zap.Error(err))
+		return
+	}
+
+	ac, err := api.FromLexer(cfg.Key("auth"))
+	if err != nil {
+		cosmos.Log().Fatal("failed to load auth config", zap.Error(err))
+		return
+	}
+
+	avc, err := avatar.FromLexer(cfg.Key("avatars"))
+	if err != nil {
+		cosmos.Log().Fatal("failed to load avatars config", zap.Error(err))
+		return
+	}
+	avatars, err := avatar.NewStorage(avc.Directory)
+	if err != nil {
+		cosmos.Log().Fatal("failed to create avatar storage", zap.Error(err))
+		return
+	}
+
+	prc, err := privacy.FromLexer(cfg.Key("privacy"))
+	if err != nil {
+		cosmos.Log().Fatal("failed to load privacy config", zap.Error(err))
+		return
+	}
+	schema, err := db.Inspect(migrations.Migrations())
+	if err != nil {
+		cosmos.Log().Fatal("failed to inspect database schema", zap.Error(err))
+		return
+	}
+	organizations := organization.NewManager(organization.NewStore(pool), role.NewStore(pool))
+	dataPrivacy := privacy.NewManager(privacy.NewStore(pool, schema.References("users")), users, sessions, auditLog, organizations, avatars, prc)
+	go func() {
+		if err := dataPrivacy.Run(runCtx); err != nil && err != context.Canceled {
+			cosmos.Log().Error("account purge stopped", zap.Error(err))
+		}
+	}()
+
+	invites := invite.NewManager(invite.NewStore(pool), role.NewStore(pool))
+
+	authOpts := []api.AuthOpt{
+		api.WithPasswordPolicy(policy),
+		api.WithTwoFactor(twoFactor),
+		api.WithPasskeys(passkeys),
+		api.WithLockout(lockouts),
+		api.WithInvites(invites),
+		api.WithMagicLinks(magicLinks),
+	}
+	oc, err := oidc.FromLexer(cfg.Key("oidc"))
+	if err != nil {
+		cosmos.Log().Fatal("failed to load oidc config", zap.Error(err))
+		return
+	}
+	if ac.InviteOnly && oc.Register {
+		cosmos.Log().Fatal("oidc register can not be used with invite_only, accounts would be created without an invite")
+		return
+	}
+	if oc.Enabled() {
+		provider := oidc.NewProvider(oc, nil)
+		authOpts = append(authOpts, api.WithOIDC(identity.NewManager(identity.NewStore(pool), users, provider)))
+	}
+
+	s, err := server.FromLexer(
+		cfg.Key("http"),
+		server.WithMiddleware(sessions.Middleware, accessTokens.Middleware, auditLog.Middleware),
+		server.WithRoutes(api.NewAuth(ac, users, sessions, tokens, mailer, authOpts...).Routes),
+		server.WithRoutes(api.NewRoles(roles, users).Routes),
+		server.WithRoutes(api.NewUsers(users, sessions, dataPrivacy, policy).Routes),
+		server.WithRoutes(api.NewAvatars(avc, avatars, users).Routes),
+		server.WithRoutes(api.NewAudit(auditLog).Routes),
+		server.WithRoutes(api.NewAccessTokens(accessTokens).Routes),
+		server.WithRoutes(api.NewPrivacy(dataPrivacy, lockouts).Routes),
+		server.WithRoutes(api.NewInvites(invites).Routes),
+		server.WithRoutes(api.NewOrganizations(organizations).Routes),
+	)
 	if err != nil {
 		cosmos.Log().Fatal("failed to create new server", zap.Error(err))
 		return
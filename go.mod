@@ -6,6 +6,7 @@ require (
 	github.com/VictoriaMetrics/metrics v1.18.1
 	github.com/go-chi/chi/v5 v5.0.5
 	github.com/jackc/pgproto3/v2 v2.0.7 // indirect
+	github.com/matthewpi/pgconn v1.8.2
 	github.com/matthewpi/pgx/v4 v4.11.2
 	github.com/pkg/errors v0.9.1
 	go.uber.org/zap v1.19.1
@@ -0,0 +1,241 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package identity links accounts at an OpenID provider to users, allowing
+// them to login with their company's identity provider.
+package identity
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/oidc"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/internal/uuid"
+	"github.com/matthewpi/cosmos/user"
+)
+
+var (
+	// ErrInvalidState is returned when a login does not exist, has expired
+	// or has already been finished.
+	ErrInvalidState = errors.New("identity: invalid or expired state")
+
+	// ErrEmailUnverified is returned when logging in with an identity that
+	// is not linked yet and the provider has not verified its email address.
+	ErrEmailUnverified = errors.New("identity: email address has not been verified by the provider")
+
+	// ErrNoAccount is returned when logging in with an identity whose email
+	// address does not have an account and registering is disabled.
+	ErrNoAccount = errors.New("identity: no account with the email address")
+)
+
+// Identity is an account at an OpenID provider linked to a user.
+type Identity struct {
+	// ID is the SHA-256 hash of the issuer and subject.
+	ID string `json:"-"`
+
+	// UserID is the ID of the user the identity is linked to.
+	UserID snowflake.Snowflake `json:"-"`
+
+	// Issuer is the URL of the provider.
+	Issuer string `json:"issuer"`
+
+	// Subject is the provider's identifier of the account.
+	Subject string `json:"subject"`
+
+	// Email is the email address of the account when it was linked.
+	Email string `json:"email"`
+
+	// CreatedAt is a timestamp of when the identity was linked.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// State is the server side state of a login.
+type State struct {
+	// ID is the SHA-256 hash of the state given to the provider.
+	ID string
+
+	// Nonce is bound to the ID token issued by the provider.
+	Nonce string
+
+	// Verifier is the PKCE code verifier.
+	Verifier string
+
+	// ExpiresAt is a timestamp of when the login expires.
+	ExpiresAt time.Time
+}
+
+// Users is used by a Manager to find and register the user an identity
+// belongs to.
+type Users interface {
+	ByID(ctx context.Context, id snowflake.Snowflake) (*user.User, error)
+	ByEmail(ctx context.Context, email string) (*user.User, error)
+	Create(ctx context.Context, u *user.User) error
+}
+
+// Manager runs logins with an OpenID provider.
+type Manager struct {
+	store    Store
+	users    Users
+	provider *oidc.Provider
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+}
+
+// NewManager returns a new Manager.
+func NewManager(s Store, users Users, p *oidc.Provider) *Manager {
+	return &Manager{
+		store:    s,
+		users:    users,
+		provider: p,
+		Now:      time.Now,
+	}
+}
+
+// Begin starts a login, returning the state that must be passed to Finish
+// and the URL of the provider the user must be sent to.
+func (m *Manager) Begin(ctx context.Context) (string, string, error) {
+	id, err := uuid.New()
+	if err != nil {
+		return "", "", err
+	}
+	state := id.String()
+	nonce, err := oidc.NewVerifier()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return "", "", err
+	}
+	u, err := m.provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+	if err := m.store.CreateState(ctx, &State{
+		ID:        token.Hash(state),
+		Nonce:     nonce,
+		Verifier:  verifier,
+		ExpiresAt: m.Now().Add(m.provider.Config().StateTTL),
+	}); err != nil {
+		return "", "", err
+	}
+	return state, u, nil
+}
+
+// Finish finishes a login with the authorization code the provider
+// redirected back with, returning the user the identity is linked to.
+//
+// An identity that is not linked yet is linked to the user with the same
+// email address, but only if the provider has verified it. If no user has
+// the email address one is created when the provider is configured to allow
+// registering.
+func (m *Manager) Finish(ctx context.Context, state, code string) (*user.User, error) {
+	if state == "" {
+		return nil, ErrInvalidState
+	}
+	s, err := m.store.ConsumeState(ctx, token.Hash(state))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidState
+		}
+		return nil, err
+	}
+	if !m.Now().Before(s.ExpiresAt) {
+		return nil, ErrInvalidState
+	}
+
+	t, err := m.provider.Exchange(ctx, code, s.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := m.provider.Verify(ctx, t.IDToken, s.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	key := Key(claims.Issuer, claims.Subject)
+	i, err := m.store.ByID(ctx, key)
+	if err == nil {
+		return m.users.ByID(ctx, i.UserID)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	// Linking by email address is only safe if the provider has verified
+	// it, otherwise anyone could take over an account by setting its email
+	// address at the provider.
+	if !claims.EmailVerified || claims.Email == "" {
+		return nil, ErrEmailUnverified
+	}
+	email := strings.ToLower(claims.Email)
+	u, err := m.users.ByEmail(ctx, email)
+	switch {
+	case errors.Is(err, user.ErrNotFound):
+		if !m.provider.Config().Register {
+			return nil, ErrNoAccount
+		}
+		if u, err = user.New(email, nil); err != nil {
+			return nil, err
+		}
+		u.Confirmed = true
+		if err := m.users.Create(ctx, u); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	}
+	if err := m.store.Create(ctx, &Identity{
+		ID:        key,
+		UserID:    u.ID,
+		Issuer:    claims.Issuer,
+		Subject:   claims.Subject,
+		Email:     email,
+		CreatedAt: m.Now(),
+	}); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Config returns the configuration of the provider.
+func (m *Manager) Config() *oidc.Config {
+	return m.provider.Config()
+}
+
+// List returns the identities linked to a user.
+func (m *Manager) List(ctx context.Context, userID snowflake.Snowflake) ([]*Identity, error) {
+	return m.store.ByUser(ctx, userID)
+}
+
+// Key returns the ID of the identity with the issuer and subject.
+func Key(issuer, subject string) string {
+	sum := sha256.Sum256([]byte(issuer + "\x00" + subject))
+	return hex.EncodeToString(sum[:])
+}
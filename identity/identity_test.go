@@ -0,0 +1,241 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package identity_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/identity"
+	"github.com/matthewpi/cosmos/internal/oidc"
+	"github.com/matthewpi/cosmos/internal/oidc/oidctest"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// memoryStore is an in-memory identity.Store used for testing.
+type memoryStore struct {
+	identities map[string]*identity.Identity
+	states     map[string]*identity.State
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		identities: make(map[string]*identity.Identity),
+		states:     make(map[string]*identity.State),
+	}
+}
+
+func (s *memoryStore) Create(_ context.Context, i *identity.Identity) error {
+	v := *i
+	s.identities[i.ID] = &v
+	return nil
+}
+
+func (s *memoryStore) ByID(_ context.Context, id string) (*identity.Identity, error) {
+	i, ok := s.identities[id]
+	if !ok {
+		return nil, identity.ErrNotFound
+	}
+	v := *i
+	return &v, nil
+}
+
+func (s *memoryStore) ByUser(_ context.Context, userID snowflake.Snowflake) ([]*identity.Identity, error) {
+	var identities []*identity.Identity
+	for _, i := range s.identities {
+		if i.UserID == userID {
+			v := *i
+			identities = append(identities, &v)
+		}
+	}
+	return identities, nil
+}
+
+func (s *memoryStore) CreateState(_ context.Context, st *identity.State) error {
+	s.states[st.ID] = st
+	return nil
+}
+
+func (s *memoryStore) ConsumeState(_ context.Context, id string) (*identity.State, error) {
+	st, ok := s.states[id]
+	if !ok {
+		return nil, identity.ErrNotFound
+	}
+	delete(s.states, id)
+	return st, nil
+}
+
+// memoryUsers is an in-memory identity.Users used for testing.
+type memoryUsers map[snowflake.Snowflake]*user.User
+
+func (s memoryUsers) ByID(_ context.Context, id snowflake.Snowflake) (*user.User, error) {
+	u, ok := s[id]
+	if !ok {
+		return nil, user.ErrNotFound
+	}
+	return u, nil
+}
+
+func (s memoryUsers) ByEmail(_ context.Context, email string) (*user.User, error) {
+	for _, u := range s {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, user.ErrNotFound
+}
+
+func (s memoryUsers) Create(_ context.Context, u *user.User) error {
+	u.ID = snowflake.Snowflake(len(s) + 1)
+	s[u.ID] = u
+	return nil
+}
+
+const (
+	issuerURL   = "https://idp.example.com"
+	redirectURL = "https://example.com/login/oidc"
+)
+
+func newTestManager(t *testing.T, register bool) (*identity.Manager, *oidctest.Issuer, memoryUsers) {
+	iss, err := oidctest.NewIssuer(issuerURL, "client", "secret", redirectURL)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	c := oidc.DefaultConfig()
+	c.Issuer = issuerURL
+	c.ClientID = "client"
+	c.ClientSecret = "secret"
+	c.RedirectURL = redirectURL
+	c.Register = register
+	users := memoryUsers{1: {ID: 1, Email: "user@example.com"}}
+	return identity.NewManager(newMemoryStore(), users, oidc.NewProvider(c, iss.Client())), iss, users
+}
+
+// login logs in as id, returning the user the identity is linked to.
+func login(m *identity.Manager, iss *oidctest.Issuer, id oidctest.Identity) (*user.User, error) {
+	state, u, err := m.Begin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	redirect, err := iss.Authorize(u, id)
+	if err != nil {
+		return nil, err
+	}
+	if redirect.Query().Get("state") != state {
+		return nil, identity.ErrInvalidState
+	}
+	return m.Finish(context.Background(), state, redirect.Query().Get("code"))
+}
+
+func TestManager_Finish(t *testing.T) {
+	for i, tc := range []struct {
+		Register bool
+		Identity oidctest.Identity
+		Expect   error
+		UserID   snowflake.Snowflake
+	}{
+		{Identity: oidctest.Identity{Subject: "a", Email: "user@example.com", EmailVerified: true}, UserID: 1},
+		// Email addresses are case-insensitive.
+		{Identity: oidctest.Identity{Subject: "a", Email: "User@Example.com", EmailVerified: true}, UserID: 1},
+		{Identity: oidctest.Identity{Subject: "a", Email: "user@example.com"}, Expect: identity.ErrEmailUnverified},
+		{Identity: oidctest.Identity{Subject: "a", Email: "other@example.com", EmailVerified: true}, Expect: identity.ErrNoAccount},
+		{Register: true, Identity: oidctest.Identity{Subject: "a", Email: "other@example.com", EmailVerified: true}, UserID: 2},
+	} {
+		m, iss, users := newTestManager(t, tc.Register)
+		u, err := login(m, iss, tc.Identity)
+		if !errors.Is(err, tc.Expect) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if u.ID != tc.UserID {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.UserID, u.ID)
+		}
+		if tc.Register && !users[u.ID].Confirmed {
+			t.Errorf("Test #%d: Expected a registered user to be confirmed", i)
+		}
+	}
+}
+
+func TestManager_Linked(t *testing.T) {
+	m, iss, users := newTestManager(t, false)
+	id := oidctest.Identity{Subject: "a", Email: "user@example.com", EmailVerified: true}
+	if _, err := login(m, iss, id); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	// Once linked the identity keeps logging in as the same user, even if
+	// its email address changes and is no longer verified.
+	users[1].Email = "renamed@example.com"
+	id.Email = "changed@example.com"
+	id.EmailVerified = false
+	u, err := login(m, iss, id)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if u.ID != 1 {
+		t.Errorf("Expected \"%v\", but got \"%v\"", 1, u.ID)
+	}
+
+	identities, err := m.List(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if len(identities) != 1 || identities[0].Issuer != issuerURL || identities[0].Subject != "a" {
+		t.Errorf("Expected a single identity, but got \"%v\"", identities)
+	}
+}
+
+func TestManager_State(t *testing.T) {
+	m, iss, _ := newTestManager(t, false)
+	id := oidctest.Identity{Subject: "a", Email: "user@example.com", EmailVerified: true}
+	_, u, err := m.Begin(context.Background())
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	redirect, err := iss.Authorize(u, id)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	state, code := redirect.Query().Get("state"), redirect.Query().Get("code")
+
+	if _, err := m.Finish(context.Background(), "forged", code); !errors.Is(err, identity.ErrInvalidState) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", identity.ErrInvalidState, err)
+	}
+
+	m.Now = func() time.Time { return time.Now().Add(time.Hour) }
+	if _, err := m.Finish(context.Background(), state, code); !errors.Is(err, identity.ErrInvalidState) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", identity.ErrInvalidState, err)
+	}
+
+	// A state can only be used once, even if it failed.
+	m.Now = time.Now
+	if _, err := m.Finish(context.Background(), state, code); !errors.Is(err, identity.ErrInvalidState) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", identity.ErrInvalidState, err)
+	}
+}
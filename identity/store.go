@@ -0,0 +1,137 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package identity
+
+import (
+	"context"
+	"errors"
+
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// ErrNotFound is returned when an Identity or State does not exist.
+var ErrNotFound = errors.New("identity: not found")
+
+// Store persists Identities and the state of logins.
+type Store interface {
+	// Create links a new Identity.
+	Create(ctx context.Context, i *Identity) error
+
+	// ByID returns the Identity with the given ID.
+	ByID(ctx context.Context, id string) (*Identity, error)
+
+	// ByUser returns all of the Identities linked to a user.
+	ByUser(ctx context.Context, userID snowflake.Snowflake) ([]*Identity, error)
+
+	// CreateState stores the state of a new login.
+	CreateState(ctx context.Context, s *State) error
+
+	// ConsumeState deletes and returns the state of a login.
+	ConsumeState(ctx context.Context, id string) (*State, error)
+}
+
+// selectIdentities selects the columns expected by scan.
+const selectIdentities = "SELECT id, user_id, issuer, subject, email, created_at FROM oidc_identities"
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db db.Querier
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "oidc_identities" and "oidc_states"
+// tables.
+func NewStore(q db.Querier) Store {
+	return &store{db: q}
+}
+
+func (s *store) Create(ctx context.Context, i *Identity) error {
+	_, err := s.db.Exec(
+		ctx,
+		"INSERT INTO oidc_identities (id, user_id, issuer, subject, email, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		i.ID, i.UserID, i.Issuer, i.Subject, i.Email, i.CreatedAt,
+	)
+	return err
+}
+
+func (s *store) ByID(ctx context.Context, id string) (*Identity, error) {
+	return scan(s.db.QueryRow(ctx, selectIdentities+" WHERE id = $1", id))
+}
+
+func (s *store) ByUser(ctx context.Context, userID snowflake.Snowflake) ([]*Identity, error) {
+	rows, err := s.db.Query(ctx, selectIdentities+" WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*Identity
+	for rows.Next() {
+		i, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+func (s *store) CreateState(ctx context.Context, st *State) error {
+	_, err := s.db.Exec(
+		ctx,
+		"INSERT INTO oidc_states (id, nonce, verifier, expires_at) VALUES ($1, $2, $3, $4)",
+		st.ID, st.Nonce, st.Verifier, st.ExpiresAt,
+	)
+	return err
+}
+
+func (s *store) ConsumeState(ctx context.Context, id string) (*State, error) {
+	st := &State{}
+	if err := s.db.QueryRow(
+		ctx,
+		"DELETE FROM oidc_states WHERE id = $1 RETURNING id, nonce, verifier, expires_at",
+		id,
+	).Scan(&st.ID, &st.Nonce, &st.Verifier, &st.ExpiresAt); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return st, nil
+}
+
+// scan scans a row selected by selectIdentities into an Identity.
+func scan(row pgx.Row) (*Identity, error) {
+	i := &Identity{}
+	if err := row.Scan(&i.ID, &i.UserID, &i.Issuer, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return i, nil
+}
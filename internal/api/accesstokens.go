@@ -0,0 +1,135 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/accesstoken"
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// AccessTokens serves the personal access token API.
+type AccessTokens struct {
+	tokens *accesstoken.Manager
+}
+
+// NewAccessTokens returns a new AccessTokens.
+func NewAccessTokens(m *accesstoken.Manager) *AccessTokens {
+	return &AccessTokens{tokens: m}
+}
+
+// Routes registers the personal access token API's routes.
+func (h *AccessTokens) Routes(r chi.Router) {
+	r.Route("/auth/tokens", func(r chi.Router) {
+		r.Use(session.RequireSession)
+		r.Get("/", h.list)
+		r.With(session.RejectImpersonation).Post("/", h.create)
+		r.Delete("/{id}", h.revoke)
+	})
+}
+
+func (h *AccessTokens) list(w http.ResponseWriter, r *http.Request) {
+	u, _ := user.FromContext(r.Context())
+	tokens, err := h.tokens.List(r.Context(), u.ID)
+	if err != nil {
+		writeInternalError(w, "failed to list access tokens", err)
+		return
+	}
+	if tokens == nil {
+		tokens = []*accesstoken.Token{}
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// create creates a token, the token is only ever included in this response.
+// A token can not be granted scopes beyond the role of the user creating it,
+// which when authenticated with a token is already restricted to that
+// token's scopes.
+func (h *AccessTokens) create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string           `json:"name"`
+		Scopes    role.Permissions `json:"scopes"`
+		ExpiresAt *time.Time       `json:"expires_at"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	t, raw, err := h.tokens.Create(r.Context(), u, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		writeAccessTokenError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeAccessTokenCreated, u.ID, map[string]interface{}{
+		"id":     t.ID,
+		"name":   t.Name,
+		"scopes": t.Scopes,
+	})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"access_token": t,
+		"token":        raw,
+	})
+}
+
+func (h *AccessTokens) revoke(w http.ResponseWriter, r *http.Request) {
+	id := snowflake.Parse(chi.URLParam(r, "id"))
+	if !id.Valid() {
+		writeError(w, http.StatusNotFound, "not_found", "access token not found")
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	if err := h.tokens.Revoke(r.Context(), u.ID, id); err != nil {
+		writeAccessTokenError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeAccessTokenRevoked, u.ID, map[string]interface{}{"id": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAccessTokenError writes the response for an error returned by
+// accesstoken.Manager.
+func writeAccessTokenError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, accesstoken.ErrInvalidName):
+		writeError(w, http.StatusBadRequest, "invalid", "name must be between 1 and 64 characters")
+	case errors.Is(err, accesstoken.ErrInvalidScopes):
+		writeError(w, http.StatusBadRequest, "invalid", "at least one valid scope is required")
+	case errors.Is(err, accesstoken.ErrInvalidExpiry):
+		writeError(w, http.StatusBadRequest, "invalid", "expires_at must be in the future")
+	case errors.Is(err, accesstoken.ErrScopeNotGranted):
+		writeError(w, http.StatusForbidden, "forbidden", "scopes must be granted by your role")
+	case errors.Is(err, accesstoken.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not_found", "access token not found")
+	default:
+		writeInternalError(w, "failed to handle access token request", err)
+	}
+}
@@ -0,0 +1,245 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/accesstoken"
+	"github.com/matthewpi/cosmos/identity"
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/oidc"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/webauthn"
+	"github.com/matthewpi/cosmos/passkey"
+	"github.com/matthewpi/cosmos/privacy"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// memoryAccessTokens is an in-memory accesstoken.Store used for testing.
+type memoryAccessTokens struct {
+	mu     sync.Mutex
+	tokens map[snowflake.Snowflake]accesstoken.Token
+}
+
+func newMemoryAccessTokens() *memoryAccessTokens {
+	return &memoryAccessTokens{tokens: make(map[snowflake.Snowflake]accesstoken.Token)}
+}
+
+func (s *memoryAccessTokens) Create(_ context.Context, t *accesstoken.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[t.ID] = *t
+	return nil
+}
+
+func (s *memoryAccessTokens) ByPrefix(_ context.Context, prefix string) (*accesstoken.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.Prefix == prefix {
+			return &t, nil
+		}
+	}
+	return nil, accesstoken.ErrNotFound
+}
+
+func (s *memoryAccessTokens) ByUser(_ context.Context, userID snowflake.Snowflake) ([]*accesstoken.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tokens []*accesstoken.Token
+	for _, t := range s.tokens {
+		if t.UserID == userID {
+			t := t
+			tokens = append(tokens, &t)
+		}
+	}
+	return tokens, nil
+}
+
+func (s *memoryAccessTokens) Use(_ context.Context, id snowflake.Snowflake, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tokens[id]
+	t.LastUsedAt = &now
+	s.tokens[id] = t
+	return nil
+}
+
+func (s *memoryAccessTokens) Delete(_ context.Context, userID, id snowflake.Snowflake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok || t.UserID != userID {
+		return accesstoken.ErrNotFound
+	}
+	delete(s.tokens, id)
+	return nil
+}
+
+func TestAccessTokens(t *testing.T) {
+	ts := newTestServer(
+		func(ts *testServer) func(chi.Router) {
+			m := accesstoken.NewManager(newMemoryAccessTokens(), ts.users)
+			passkeys := passkey.NewManager(newMemoryPasskeys(), ts.users, &webauthn.Config{
+				RPID:    "example.com",
+				RPName:  "Example",
+				Origins: []string{"https://example.com"},
+				Timeout: time.Minute,
+			})
+			identities := identity.NewManager(newMemoryIdentities(), ts.users, oidc.NewProvider(oidc.DefaultConfig(), nil))
+			return func(r chi.Router) {
+				r.Group(func(r chi.Router) {
+					r.Use(m.Middleware)
+					api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail,
+						api.WithPasskeys(passkeys),
+						api.WithOIDC(identities),
+					).Routes(r)
+					api.NewAccessTokens(m).Routes(r)
+					api.NewUsers(ts.users, ts.manager, newPrivacy(ts, ts.users), nil).Routes(r)
+					api.NewPrivacy(privacy.NewManager(nil, ts.users, ts.manager, ts.log, nil, nil, nil), nil).Routes(r)
+				})
+			}
+		},
+	)
+	admin, _ := user.New("admin@example.com", []byte("password123"))
+	admin.ID = 1
+	admin.Role = &role.Role{ID: 1, Permissions: role.Permissions{"users.*"}}
+	_ = ts.users.Create(context.Background(), admin)
+	cookie := ts.do(http.MethodPost, "/auth/login", map[string]string{
+		"email":    "admin@example.com",
+		"password": "password123",
+	}).Result().Cookies()[0]
+
+	// bearer performs a request authenticated with a token.
+	bearer := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			_ = json.NewEncoder(&buf).Encode(body)
+		}
+		r := httptest.NewRequest(method, path, &buf)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		ts.router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := ts.do(http.MethodPost, "/auth/tokens", map[string]interface{}{
+		"name":   "CI",
+		"scopes": []string{"users.read"},
+	}, cookie)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var created struct {
+		AccessToken accesstoken.Token `json:"access_token"`
+		Token       string            `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	for i, tc := range []struct {
+		Method string
+		Path   string
+		Token  string
+		Body   interface{}
+		Status int
+	}{
+		{Method: http.MethodGet, Path: "/api/v1/users", Token: created.Token, Status: http.StatusOK},
+		// The token is limited to its scopes, even though the role grants
+		// more.
+		{Method: http.MethodDelete, Path: "/api/v1/users/1", Token: created.Token, Status: http.StatusForbidden},
+		// A token can not create a token with more scopes than itself, nor
+		// any other token.
+		{
+			Method: http.MethodPost,
+			Path:   "/auth/tokens",
+			Token:  created.Token,
+			Body:   map[string]interface{}{"name": "Escalate", "scopes": []string{"users.*"}},
+			Status: http.StatusForbidden,
+		},
+		{
+			Method: http.MethodPost,
+			Path:   "/auth/tokens",
+			Token:  created.Token,
+			Body:   map[string]interface{}{"name": "Copy", "scopes": []string{"users.read"}},
+			Status: http.StatusForbidden,
+		},
+		// Managing the account requires a session, regardless of scopes.
+		{Method: http.MethodPost, Path: "/auth/passkeys/register/begin", Token: created.Token, Status: http.StatusForbidden},
+		{Method: http.MethodGet, Path: "/auth/account/export", Token: created.Token, Status: http.StatusForbidden},
+		{Method: http.MethodGet, Path: "/auth/oidc/identities", Token: created.Token, Status: http.StatusForbidden},
+		{Method: http.MethodPost, Path: "/auth/account/deletion", Token: created.Token, Status: http.StatusForbidden},
+		{Method: http.MethodDelete, Path: "/auth/sessions", Token: created.Token, Status: http.StatusForbidden},
+		{Method: http.MethodPost, Path: "/auth/email", Token: created.Token, Body: map[string]string{"email": "new@example.com"}, Status: http.StatusForbidden},
+		{Method: http.MethodGet, Path: "/api/v1/users", Token: created.Token + "x", Status: http.StatusUnauthorized},
+		{Method: http.MethodGet, Path: "/api/v1/users", Token: "cosmos_", Status: http.StatusUnauthorized},
+	} {
+		if w := bearer(tc.Method, tc.Path, tc.Token, tc.Body); w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\": %s", i, tc.Status, w.Code, w.Body.String())
+		}
+	}
+
+	// The role does not grant the scope.
+	w = ts.do(http.MethodPost, "/auth/tokens", map[string]interface{}{
+		"name":   "Roles",
+		"scopes": []string{"roles.read"},
+	}, cookie)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusForbidden, w.Code)
+	}
+
+	var tokens []accesstoken.Token
+	if err := json.Unmarshal(ts.do(http.MethodGet, "/auth/tokens", nil, cookie).Body.Bytes(), &tokens); err != nil || len(tokens) != 1 {
+		t.Fatalf("Expected a single token, but got \"%v\"", tokens)
+	}
+	if tokens[0].Prefix != created.AccessToken.Prefix || tokens[0].LastUsedAt == nil {
+		t.Errorf("Expected the token's last use to be recorded, but got \"%v\"", tokens[0])
+	}
+
+	path := "/auth/tokens/" + created.AccessToken.ID.String()
+	for i, status := range []int{http.StatusNoContent, http.StatusNotFound} {
+		if w := ts.do(http.MethodDelete, path, nil, cookie); w.Code != status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, status, w.Code)
+		}
+	}
+	if w := bearer(http.MethodGet, "/api/v1/users", created.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusUnauthorized, w.Code)
+	}
+
+	types := ts.audit.types(1)
+	if len(types) < 2 || types[len(types)-2] != "access_token.created" || types[len(types)-1] != "access_token.revoked" {
+		t.Errorf("Expected the token to be audited, but got \"%v\"", types)
+	}
+}
@@ -0,0 +1,97 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package api implements the JSON HTTP API.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/matthewpi/cosmos"
+	"github.com/matthewpi/cosmos/internal/server"
+)
+
+const (
+	// maxBodySize is the maximum size of a request body.
+	maxBodySize = 1 << 20
+
+	// defaultLimit is the number of results in a page when the request does
+	// not specify a limit.
+	defaultLimit = 50
+
+	// maxLimit is the maximum number of results in a page.
+	maxLimit = 200
+)
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if v == nil {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		cosmos.Log().Warn("failed to write response", zap.Error(err))
+	}
+}
+
+// writeError writes an error response, see server.Error for the format.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	server.WriteError(w, status, code, message)
+}
+
+// writeInternalError logs err and writes a generic 500 response.
+func writeInternalError(w http.ResponseWriter, msg string, err error) {
+	cosmos.Log().Error(msg, zap.Error(err))
+	writeError(w, http.StatusInternalServerError, "internal_error", "internal server error")
+}
+
+// decode decodes the JSON request body into v, writing an error response
+// and returning false if the body is invalid.
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "invalid request body")
+		return false
+	}
+	return true
+}
+
+// queryLimit returns the page size requested by the limit query parameter,
+// writing an error response and returning false if it is invalid.
+func queryLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return defaultLimit, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > maxLimit {
+		writeError(w, http.StatusBadRequest, "invalid_query", "limit must be between 1 and "+strconv.Itoa(maxLimit))
+		return 0, false
+	}
+	return n, true
+}
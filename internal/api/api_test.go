@@ -0,0 +1,586 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/argon2"
+	"github.com/matthewpi/cosmos/internal/mail"
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/user"
+	"github.com/matthewpi/cosmos/user/usertest"
+)
+
+func TestMain(m *testing.M) {
+	// Use cheap argon2 parameters, the tests hash a lot of passwords.
+	argon2.Memory = 1024
+	argon2.Iterations = 1
+	os.Exit(m.Run())
+}
+
+// memoryUsers is an in-memory user.Store used for testing, users are copied
+// in and out so changes are only visible once they are stored.
+type memoryUsers struct {
+	mu    sync.Mutex
+	users map[snowflake.Snowflake]*user.User
+}
+
+func newMemoryUsers() *memoryUsers {
+	return &memoryUsers{users: make(map[snowflake.Snowflake]*user.User)}
+}
+
+func (s *memoryUsers) Create(_ context.Context, u *user.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.users {
+		if v.Email == u.Email {
+			return user.ErrEmailTaken
+		}
+	}
+	v := *u
+	s.users[u.ID] = &v
+	return nil
+}
+
+func (s *memoryUsers) Update(_ context.Context, u *user.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[u.ID]; !ok {
+		return user.ErrNotFound
+	}
+	for _, v := range s.users {
+		if v.ID != u.ID && v.Email == u.Email {
+			return user.ErrEmailTaken
+		}
+	}
+	v := *u
+	s.users[u.ID] = &v
+	return nil
+}
+
+func (s *memoryUsers) Confirm(_ context.Context, id snowflake.Snowflake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return user.ErrNotFound
+	}
+	u.Confirmed = true
+	return nil
+}
+
+func (s *memoryUsers) SetEmail(_ context.Context, id snowflake.Snowflake, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return user.ErrNotFound
+	}
+	for _, v := range s.users {
+		if v.ID != id && v.Email == email {
+			return user.ErrEmailTaken
+		}
+	}
+	u.Email, u.Confirmed = email, true
+	return nil
+}
+
+func (s *memoryUsers) UpdatePassword(_ context.Context, u *user.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.users[u.ID]
+	if !ok {
+		return user.ErrNotFound
+	}
+	// The password hash is unexported, so it is carried over from u and
+	// every other field from the stored user.
+	v := *u
+	v.Email, v.Confirmed, v.Locked, v.Avatar = cur.Email, cur.Confirmed, cur.Locked, cur.Avatar
+	s.users[u.ID] = &v
+	return nil
+}
+
+func (s *memoryUsers) SetAvatar(_ context.Context, id snowflake.Snowflake, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return user.ErrNotFound
+	}
+	u.Avatar = hash
+	return nil
+}
+
+func (s *memoryUsers) ByID(_ context.Context, id snowflake.Snowflake) (*user.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return nil, user.ErrNotFound
+	}
+	v := *u
+	return &v, nil
+}
+
+func (s *memoryUsers) ByEmail(_ context.Context, email string) (*user.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Email == email {
+			v := *u
+			return &v, nil
+		}
+	}
+	return nil, user.ErrNotFound
+}
+
+func (s *memoryUsers) List(_ context.Context, q *user.Query) ([]*user.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []*user.User
+	for _, u := range s.users {
+		switch {
+		case q.After.Valid() && !q.Descending && u.ID <= q.After,
+			q.After.Valid() && q.Descending && u.ID >= q.After,
+			q.Confirmed != nil && u.Confirmed != *q.Confirmed,
+			q.Locked != nil && u.Locked != *q.Locked,
+			!strings.HasPrefix(u.Email, q.EmailPrefix),
+			!q.DeletionDue.IsZero() && (u.DeleteAfter.IsZero() || u.DeleteAfter.After(q.DeletionDue)):
+			continue
+		}
+		v := *u
+		users = append(users, &v)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if q.Descending {
+			return users[i].ID > users[j].ID
+		}
+		return users[i].ID < users[j].ID
+	})
+	if len(users) > q.Limit {
+		users = users[:q.Limit]
+	}
+	return users, nil
+}
+
+func (s *memoryUsers) Delete(_ context.Context, id snowflake.Snowflake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[id]; !ok {
+		return user.ErrNotFound
+	}
+	delete(s.users, id)
+	return nil
+}
+
+func (s *memoryUsers) SetLastLogin(_ context.Context, id snowflake.Snowflake, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return user.ErrNotFound
+	}
+	u.LastLoginAt = t
+	return nil
+}
+
+func (s *memoryUsers) ScheduleDeletion(_ context.Context, id snowflake.Snowflake, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return user.ErrNotFound
+	}
+	u.DeleteAfter = t
+	return nil
+}
+
+// memorySessions is an in-memory session.Store used for testing.
+type memorySessions struct {
+	mu       sync.Mutex
+	sessions map[string]session.Session
+	devices  map[string]snowflake.Snowflake
+	events   []*session.NewDeviceEvent
+}
+
+func newMemorySessions() *memorySessions {
+	return &memorySessions{
+		sessions: make(map[string]session.Session),
+		devices:  make(map[string]snowflake.Snowflake),
+	}
+}
+
+func (s *memorySessions) Create(_ context.Context, v *session.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[v.ID] = *v
+	return nil
+}
+
+func (s *memorySessions) ByID(_ context.Context, id string) (*session.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.sessions[id]
+	if !ok {
+		return nil, session.ErrNotFound
+	}
+	return &v, nil
+}
+
+func (s *memorySessions) ByUser(_ context.Context, userID snowflake.Snowflake) ([]*session.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sessions []*session.Session
+	for _, v := range s.sessions {
+		if v.UserID == userID {
+			v := v
+			sessions = append(sessions, &v)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (s *memorySessions) Touch(_ context.Context, id string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.sessions[id]; ok {
+		v.LastSeenAt = t
+		s.sessions[id] = v
+	}
+	return nil
+}
+
+func (s *memorySessions) Rotate(_ context.Context, oldID string, v *session.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, oldID)
+	s.sessions[v.ID] = *v
+	return nil
+}
+
+func (s *memorySessions) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *memorySessions) DeleteByUser(_ context.Context, userID snowflake.Snowflake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, v := range s.sessions {
+		if v.UserID == userID {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (s *memorySessions) DeleteOthers(_ context.Context, userID snowflake.Snowflake, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.sessions {
+		if v.UserID == userID && k != id {
+			delete(s.sessions, k)
+		}
+	}
+	return nil
+}
+
+func (s *memorySessions) AddDevice(_ context.Context, id string, e *session.NewDeviceEvent) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.devices[id]; ok {
+		return false, nil
+	}
+	known := false
+	for _, userID := range s.devices {
+		known = known || userID == e.UserID
+	}
+	s.devices[id] = e.UserID
+	if !known {
+		return false, nil
+	}
+	s.events = append(s.events, e)
+	return true, nil
+}
+
+// memoryTokens is an in-memory token.Store used for testing.
+type memoryTokens struct {
+	mu     sync.Mutex
+	tokens map[string]token.Token
+}
+
+func newMemoryTokens() *memoryTokens {
+	return &memoryTokens{tokens: make(map[string]token.Token)}
+}
+
+func (s *memoryTokens) Create(_ context.Context, t *token.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[t.ID] = *t
+	return nil
+}
+
+func (s *memoryTokens) Consume(_ context.Context, p token.Purpose, id string) (*token.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok || t.Purpose != p {
+		return nil, token.ErrNotFound
+	}
+	delete(s.tokens, id)
+	return &t, nil
+}
+
+func (s *memoryTokens) Latest(_ context.Context, p token.Purpose, userID snowflake.Snowflake) (*token.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var latest *token.Token
+	for _, t := range s.tokens {
+		if t.Purpose == p && t.UserID == userID && (latest == nil || t.CreatedAt.After(latest.CreatedAt)) {
+			t := t
+			latest = &t
+		}
+	}
+	if latest == nil {
+		return nil, token.ErrNotFound
+	}
+	return latest, nil
+}
+
+func (s *memoryTokens) DeleteByUser(_ context.Context, p token.Purpose, userID snowflake.Snowflake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.tokens {
+		if t.Purpose == p && t.UserID == userID {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+// memoryAudit is an in-memory audit.Store used for testing.
+type memoryAudit struct {
+	mu     sync.Mutex
+	events []*audit.Event
+}
+
+func (s *memoryAudit) Append(_ context.Context, e *audit.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var prev *audit.Event
+	if len(s.events) > 0 {
+		prev = s.events[len(s.events)-1]
+	}
+	e.Link(prev)
+	v := *e
+	s.events = append(s.events, &v)
+	return nil
+}
+
+func (s *memoryAudit) Query(_ context.Context, q *audit.Query) ([]*audit.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []*audit.Event
+	for i := len(s.events) - 1; i >= 0 && len(events) < q.Limit; i-- {
+		e := s.events[i]
+		switch {
+		case q.UserID.Valid() && e.ActorID != q.UserID && e.TargetID != q.UserID,
+			q.Before.Valid() && e.ID >= q.Before,
+			!q.Since.IsZero() && e.CreatedAt.Before(q.Since),
+			!q.Until.IsZero() && !e.CreatedAt.Before(q.Until):
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (s *memoryAudit) Chain(_ context.Context, after snowflake.Snowflake, limit int) ([]*audit.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []*audit.Event
+	for _, e := range s.events {
+		if e.ID > after && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (s *memoryAudit) Redact(_ context.Context, userID snowflake.Snowflake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.events {
+		if !e.Redacted && (e.ActorID == userID || e.TargetID == userID) {
+			e.Redact()
+		}
+	}
+	return nil
+}
+
+// types returns the types of the recorded events about a user.
+func (s *memoryAudit) types(userID snowflake.Snowflake) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var types []string
+	for _, e := range s.events {
+		if e.ActorID == userID || e.TargetID == userID {
+			types = append(types, e.Type)
+		}
+	}
+	return types
+}
+
+// mailbox is a mail.Mailer that records sent messages.
+type mailbox struct {
+	mu       sync.Mutex
+	messages []*mail.Message
+}
+
+func (m *mailbox) Send(_ context.Context, msg *mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// last returns the last message sent to an address.
+func (m *mailbox) last(to string) *mail.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i]
+		}
+	}
+	return nil
+}
+
+// tokenPattern matches the token in a link sent by email.
+var tokenPattern = regexp.MustCompile(`token=([0-9a-f]+)`)
+
+// linkToken returns the token in the last message sent to an address.
+func (m *mailbox) linkToken(t *testing.T, to string) string {
+	msg := m.last(to)
+	if msg == nil {
+		t.Fatalf("Expected an email to be sent to \"%s\"", to)
+	}
+	match := tokenPattern.FindStringSubmatch(msg.Body)
+	if match == nil {
+		t.Fatalf("Expected email to contain a token, but got \"%s\"", msg.Body)
+	}
+	return match[1]
+}
+
+// testServer wires the API handlers together with in-memory stores.
+type testServer struct {
+	config   *api.Config
+	users    *memoryUsers
+	db       *usertest.DB
+	sessions *memorySessions
+	manager  *session.Manager
+	tokens   *token.Manager
+	mail     *mailbox
+	audit    *memoryAudit
+	log      *audit.Log
+	router   chi.Router
+}
+
+func newTestServer(routes ...func(ts *testServer) func(chi.Router)) *testServer {
+	ts := &testServer{users: newMemoryUsers()}
+	return ts.init(ts.users, routes)
+}
+
+// newStoreTestServer returns a testServer whose sessions load users and their
+// roles through user.Store from db, rather than from memoryUsers, handlers
+// should use db.Users() and db.Roles().
+func newStoreTestServer(db *usertest.DB, routes ...func(ts *testServer) func(chi.Router)) *testServer {
+	ts := &testServer{db: db}
+	return ts.init(db.Users(), routes)
+}
+
+func (ts *testServer) init(users user.Store, routes []func(ts *testServer) func(chi.Router)) *testServer {
+	ts.config = api.DefaultConfig()
+	ts.sessions = newMemorySessions()
+	ts.tokens = token.NewManager(newMemoryTokens())
+	ts.mail = &mailbox{}
+	ts.audit = &memoryAudit{}
+	ts.manager = session.NewManager(ts.sessions, users, nil)
+	ts.log = audit.NewLog(ts.audit)
+	ts.router = chi.NewRouter()
+	ts.router.Use(ts.manager.Middleware, ts.log.Middleware)
+	for _, f := range routes {
+		f(ts)(ts.router)
+	}
+	return ts
+}
+
+// do performs a request, sending body as JSON and adding the cookies.
+func (ts *testServer) do(method, path string, body interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	r := httptest.NewRequest(method, path, &buf)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	ts.router.ServeHTTP(w, r)
+	return w
+}
+
+// errorCode returns the code of an error response.
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var body struct {
+		Error server.Error `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected an error response, but got \"%s\"", w.Body.String())
+	}
+	return body.Error.Code
+}
@@ -0,0 +1,122 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// Audit serves the audit log API.
+type Audit struct {
+	log *audit.Log
+}
+
+// NewAudit returns a new Audit.
+func NewAudit(l *audit.Log) *Audit {
+	return &Audit{log: l}
+}
+
+// Routes registers the audit log API's routes.
+func (h *Audit) Routes(r chi.Router) {
+	r.Route("/audit-events", func(r chi.Router) {
+		r.Use(server.RequirePermission(audit.PermissionRead))
+		r.Get("/", h.list)
+		r.Get("/verify", h.verify)
+	})
+}
+
+// list returns audit events, most recent first.  Events can be filtered by
+// user_id, matching either the actor or the target, and by an RFC 3339 time
+// range using since and until.  Results are paged by passing the returned
+// next cursor as before.
+func (h *Audit) list(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+	q := &audit.Query{Limit: limit}
+	v := r.URL.Query()
+	if s := v.Get("user_id"); s != "" {
+		if q.UserID = snowflake.Parse(s); !q.UserID.Valid() {
+			writeError(w, http.StatusBadRequest, "invalid_query", "invalid user_id")
+			return
+		}
+	}
+	if s := v.Get("before"); s != "" {
+		if q.Before = snowflake.Parse(s); !q.Before.Valid() {
+			writeError(w, http.StatusBadRequest, "invalid_query", "invalid before")
+			return
+		}
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{name: "since", t: &q.Since},
+		{name: "until", t: &q.Until},
+	} {
+		s := v.Get(p.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_query", "invalid "+p.name+", expected an RFC 3339 timestamp")
+			return
+		}
+		*p.t = t
+	}
+
+	events, err := h.log.Query(r.Context(), q)
+	if err != nil {
+		writeInternalError(w, "failed to query audit log", err)
+		return
+	}
+	res := struct {
+		Events []*audit.Event      `json:"events"`
+		Next   snowflake.Snowflake `json:"next,omitempty"`
+	}{Events: events}
+	if res.Events == nil {
+		res.Events = []*audit.Event{}
+	}
+	if len(events) == q.Limit {
+		res.Next = events[len(events)-1].ID
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Audit) verify(w http.ResponseWriter, r *http.Request) {
+	res, err := h.log.Verify(r.Context())
+	if err != nil {
+		writeInternalError(w, "failed to verify audit log", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,136 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+func TestAudit(t *testing.T) {
+	ts := newTestServer(
+		func(ts *testServer) func(chi.Router) {
+			return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail).Routes
+		},
+		func(ts *testServer) func(chi.Router) {
+			return api.NewAudit(ts.log).Routes
+		},
+	)
+	admin, _ := user.New("admin@example.com", []byte("password123"))
+	admin.Role = &role.Role{ID: 1, Permissions: role.Permissions{audit.PermissionRead}}
+	u, _ := user.New("user@example.com", []byte("password123"))
+	_ = ts.users.Create(context.Background(), admin)
+	_ = ts.users.Create(context.Background(), u)
+
+	ts.do(http.MethodPost, "/auth/login", map[string]string{"email": "user@example.com", "password": "wrong-password"})
+	cookie := ts.do(http.MethodPost, "/auth/login", map[string]string{
+		"email":    "user@example.com",
+		"password": "password123",
+	}).Result().Cookies()[0]
+	if w := ts.do(http.MethodGet, "/audit-events", nil, cookie); w.Code != http.StatusForbidden {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusForbidden, w.Code)
+	}
+	ts.do(http.MethodPost, "/auth/logout", nil, cookie)
+
+	expect := []string{audit.TypeLoginFailed, audit.TypeLogin, audit.TypeLogout}
+	if types := ts.audit.types(u.ID); !reflect.DeepEqual(types, expect) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", expect, types)
+	}
+
+	cookie = ts.do(http.MethodPost, "/auth/login", map[string]string{
+		"email":    "admin@example.com",
+		"password": "password123",
+	}).Result().Cookies()[0]
+	var next snowflake.Snowflake
+	for i, tc := range []struct {
+		Query  func() string
+		Status int
+		Types  []string
+	}{
+		{
+			Query:  func() string { return "?user_id=" + u.ID.String() + "&limit=2" },
+			Status: http.StatusOK,
+			Types:  []string{audit.TypeLogout, audit.TypeLogin},
+		},
+		{
+			Query:  func() string { return "?user_id=" + u.ID.String() + "&limit=2&before=" + next.String() },
+			Status: http.StatusOK,
+			Types:  []string{audit.TypeLoginFailed},
+		},
+		{
+			Query:  func() string { return "?until=2021-01-01T00:00:00Z" },
+			Status: http.StatusOK,
+			Types:  []string{},
+		},
+		{Query: func() string { return "?since=yesterday" }, Status: http.StatusBadRequest},
+		{Query: func() string { return "?limit=1000" }, Status: http.StatusBadRequest},
+	} {
+		w := ts.do(http.MethodGet, "/audit-events"+tc.Query(), nil, cookie)
+		if w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+			continue
+		}
+		if tc.Status != http.StatusOK {
+			continue
+		}
+		var body struct {
+			Events []*audit.Event      `json:"events"`
+			Next   snowflake.Snowflake `json:"next"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		types := []string{}
+		for _, e := range body.Events {
+			types = append(types, e.Type)
+			if e.IP != "192.0.2.1" {
+				t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, "192.0.2.1", e.IP)
+			}
+		}
+		if !reflect.DeepEqual(types, tc.Types) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Types, types)
+		}
+		next = body.Next
+	}
+
+	w := ts.do(http.MethodGet, "/audit-events/verify", nil, cookie)
+	var res audit.Result
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if !res.Valid || res.Events != 4 {
+		t.Errorf("Expected a valid chain of \"%d\" events, but got \"%+v\"", 4, res)
+	}
+}
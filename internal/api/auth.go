@@ -0,0 +1,483 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/identity"
+	"github.com/matthewpi/cosmos/internal/address"
+	"github.com/matthewpi/cosmos/internal/mail"
+	"github.com/matthewpi/cosmos/internal/password"
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/internal/uuid"
+	"github.com/matthewpi/cosmos/invite"
+	"github.com/matthewpi/cosmos/lockout"
+	"github.com/matthewpi/cosmos/magiclink"
+	"github.com/matthewpi/cosmos/passkey"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/twofactor"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// Auth serves the authentication API.
+type Auth struct {
+	config   *Config
+	users    user.Store
+	sessions *session.Manager
+	tokens   *token.Manager
+	mailer   mail.Mailer
+	policy   *password.Policy
+
+	// twoFactor is optional, if nil two-factor authentication is disabled.
+	twoFactor *twofactor.Manager
+
+	// passkeys is optional, if nil passkeys are disabled.
+	passkeys *passkey.Manager
+
+	// lockout is optional, if nil failed logins are not tracked.
+	lockout *lockout.Manager
+
+	// identities is optional, if nil logging in with an OpenID provider is
+	// disabled.
+	identities *identity.Manager
+
+	// invites is optional, if nil registering with an invite is disabled.
+	invites *invite.Manager
+
+	// magicLinks is optional, if nil logging in with a magic link is
+	// disabled.
+	magicLinks *magiclink.Manager
+
+	// dummy is a user with a random password, used to verify passwords
+	// against when an account does not exist so failed logins take the same
+	// amount of time either way.
+	dummy     *user.User
+	dummyErr  error
+	dummyOnce sync.Once
+}
+
+// AuthOpt is an option used to enable optional features of Auth.
+type AuthOpt func(*Auth)
+
+// WithPasswordPolicy sets the policy new passwords are checked against,
+// password.DefaultPolicy is used otherwise.
+func WithPasswordPolicy(p *password.Policy) AuthOpt {
+	return func(h *Auth) {
+		h.policy = p
+	}
+}
+
+// WithTwoFactor enables two-factor authentication.
+func WithTwoFactor(m *twofactor.Manager) AuthOpt {
+	return func(h *Auth) {
+		h.twoFactor = m
+	}
+}
+
+// WithPasskeys enables registering and logging in with passkeys.
+func WithPasskeys(m *passkey.Manager) AuthOpt {
+	return func(h *Auth) {
+		h.passkeys = m
+	}
+}
+
+// WithLockout enables delaying and locking accounts after failed logins.
+func WithLockout(m *lockout.Manager) AuthOpt {
+	return func(h *Auth) {
+		h.lockout = m
+	}
+}
+
+// WithOIDC enables logging in with an OpenID provider.
+func WithOIDC(m *identity.Manager) AuthOpt {
+	return func(h *Auth) {
+		h.identities = m
+	}
+}
+
+// WithInvites enables registering with an invite, which is required when
+// InviteOnly is set.
+func WithInvites(m *invite.Manager) AuthOpt {
+	return func(h *Auth) {
+		h.invites = m
+	}
+}
+
+// WithMagicLinks enables logging in with a link emailed to the user.
+func WithMagicLinks(m *magiclink.Manager) AuthOpt {
+	return func(h *Auth) {
+		h.magicLinks = m
+	}
+}
+
+// NewAuth returns a new Auth, if c is nil DefaultConfig is used.
+func NewAuth(c *Config, users user.Store, sessions *session.Manager, tokens *token.Manager, mailer mail.Mailer, opts ...AuthOpt) *Auth {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	h := &Auth{
+		config:   c,
+		users:    users,
+		sessions: sessions,
+		tokens:   tokens,
+		mailer:   mailer,
+		policy:   password.DefaultPolicy(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Routes registers the authentication API's routes.
+func (h *Auth) Routes(r chi.Router) {
+	r.Route("/auth", func(r chi.Router) {
+		r.Post("/register", h.register)
+		r.Post("/login", h.login)
+		r.Post("/logout", h.logout)
+		r.Post("/confirm", h.confirm)
+		r.Post("/confirm/resend", h.resendConfirmation)
+		r.Post("/password/forgot", h.forgotPassword)
+		r.Post("/password/reset", h.resetPassword)
+		r.With(session.RequireSession, session.RejectImpersonation).Post("/email", h.changeEmail)
+		r.Post("/email/confirm", h.confirmEmailChange)
+		r.Post("/email/revert", h.revertEmailChange)
+		r.With(server.RequirePermission(user.PermissionImpersonate)).Post("/impersonation", h.startImpersonation)
+		r.With(session.RequireSession).Delete("/impersonation", h.stopImpersonation)
+		r.Route("/sessions", func(r chi.Router) {
+			r.Use(session.RequireSession)
+			r.Get("/", h.listSessions)
+			r.With(session.RejectImpersonation).Delete("/", h.revokeOtherSessions)
+			r.With(session.RejectImpersonation).Delete("/{id}", h.revokeSession)
+		})
+		if h.twoFactor != nil {
+			r.Post("/login/2fa", h.loginTwoFactor)
+			r.Route("/2fa", func(r chi.Router) {
+				r.Use(session.RequireSession, session.RejectImpersonation)
+				r.Post("/totp", h.enrollTOTP)
+				r.Post("/totp/confirm", h.enableTOTP)
+				r.Delete("/totp", h.disableTOTP)
+				r.Post("/recovery-codes", h.regenerateRecoveryCodes)
+			})
+		}
+		if h.magicLinks != nil {
+			r.Post("/magic-link", h.requestMagicLink)
+			r.Post("/magic-link/login", h.loginMagicLink)
+		}
+		if h.lockout != nil {
+			r.Post("/unlock", h.requestUnlock)
+			r.Post("/unlock/confirm", h.unlock)
+		}
+		if h.passkeys != nil {
+			r.Route("/passkeys", func(r chi.Router) {
+				r.Post("/login/begin", h.beginPasskeyLogin)
+				r.Post("/login/finish", h.finishPasskeyLogin)
+				r.Group(func(r chi.Router) {
+					r.Use(session.RequireSession)
+					r.Get("/", h.listPasskeys)
+					r.With(session.RejectImpersonation).Delete("/{id}", h.deletePasskey)
+					r.With(session.RejectImpersonation).Post("/register/begin", h.beginPasskeyRegistration)
+					r.With(session.RejectImpersonation).Post("/register/finish", h.finishPasskeyRegistration)
+				})
+			})
+		}
+		if h.identities != nil {
+			r.Route("/oidc", func(r chi.Router) {
+				r.Post("/login", h.beginOIDCLogin)
+				r.Post("/callback", h.finishOIDCLogin)
+				r.With(session.RequireSession).Get("/identities", h.listIdentities)
+			})
+		}
+	})
+	if h.lockout != nil {
+		r.With(server.RequirePermission(lockout.PermissionUnlock)).Post("/api/v1/users/{id}/unlock", h.unlockUser)
+	}
+}
+
+// credentials is the request body used to register and login.
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// register creates an account.  An invite is required when InviteOnly is
+// set, otherwise an invite may still be used to be assigned its role.
+func (h *Auth) register(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		credentials
+		Invite string `json:"invite"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Invite == "" && h.config.InviteOnly {
+		writeError(w, http.StatusForbidden, "invite_required", "an invite is required to register")
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_email", "invalid email address")
+		return
+	}
+	if !h.checkPassword(w, req.Password, email) {
+		return
+	}
+
+	// The password is hashed before checking if the email is taken and the
+	// response is the same either way, so registering does not reveal which
+	// email addresses have an account.
+	u, err := user.New(email, []byte(req.Password))
+	if err != nil {
+		writeInternalError(w, "failed to create user", err)
+		return
+	}
+	if req.Invite != "" {
+		err = h.redeemInvite(r, req.Invite, u)
+	} else {
+		err = h.users.Create(r.Context(), u)
+	}
+	switch {
+	case err == nil:
+		if err := h.sendConfirmation(r.Context(), u); err != nil {
+			writeInternalError(w, "failed to send confirmation", err)
+			return
+		}
+	case errors.Is(err, invite.ErrInvalid):
+		writeError(w, http.StatusForbidden, "invalid_invite", "invalid or expired invite")
+		return
+	case !errors.Is(err, user.ErrEmailTaken):
+		writeInternalError(w, "failed to create user", err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"message": "registration received, check your email to confirm your account",
+	})
+}
+
+// redeemInvite creates a user by redeeming an invite, recording the
+// redemption in the audit log.
+func (h *Auth) redeemInvite(r *http.Request, code string, u *user.User) error {
+	if h.invites == nil {
+		return invite.ErrInvalid
+	}
+	inv, err := h.invites.Redeem(r.Context(), code, u)
+	if err != nil {
+		return err
+	}
+	audit.RecordAs(r.Context(), audit.TypeInviteRedeemed, u.ID, u.ID, map[string]interface{}{
+		"invite_id":  inv.ID,
+		"invited_by": inv.CreatedBy,
+	})
+	return nil
+}
+
+func (h *Auth) login(w http.ResponseWriter, r *http.Request) {
+	var req credentials
+	if !decode(w, r, &req) {
+		return
+	}
+	if !checkLockout(w, r, h.lockout, req.Email) {
+		return
+	}
+	u, err := h.authenticate(r, req)
+	if err != nil {
+		if errors.Is(err, errInvalidCredentials) {
+			if !recordFailure(w, r, h.lockout, req.Email, u, "password") {
+				return
+			}
+			writeError(w, http.StatusUnauthorized, "invalid_credentials", "invalid email or password")
+			return
+		}
+		writeInternalError(w, "failed to authenticate user", err)
+		return
+	}
+	if u.Locked {
+		writeError(w, http.StatusForbidden, "account_locked", "account is locked")
+		return
+	}
+	if h.config.RequireConfirmation && !u.Confirmed {
+		writeError(w, http.StatusForbidden, "email_unconfirmed", "email address has not been confirmed")
+		return
+	}
+	if h.twoFactor != nil {
+		enabled, err := h.twoFactor.Enabled(r.Context(), u.ID)
+		if err != nil {
+			writeInternalError(w, "failed to check two-factor authentication", err)
+			return
+		}
+		if enabled {
+			h.challengeTwoFactor(w, r, u)
+			return
+		}
+	}
+	h.startSession(w, r, u, "password")
+}
+
+// startSession starts a new session for a user authenticated by method.
+func (h *Auth) startSession(w http.ResponseWriter, r *http.Request, u *user.User, method string) {
+	// Failed logins are only reset once every factor has been provided, so
+	// knowing the password does not reset the failures of the second
+	// factor.
+	if h.lockout != nil {
+		if err := h.lockout.Succeed(r.Context(), u.Email); err != nil {
+			writeInternalError(w, "failed to reset failed logins", err)
+			return
+		}
+	}
+	// Always issue a new session on login, preventing session fixation.
+	if s, ok := session.FromContext(r.Context()); ok {
+		if err := h.sessions.Revoke(r.Context(), s.ID); err != nil {
+			writeInternalError(w, "failed to revoke session", err)
+			return
+		}
+	}
+	if _, err := h.sessions.Create(r.Context(), w, r, u); err != nil {
+		writeInternalError(w, "failed to create session", err)
+		return
+	}
+	u.LastLoginAt = time.Now()
+	if err := h.users.SetLastLogin(r.Context(), u.ID, u.LastLoginAt); err != nil {
+		writeInternalError(w, "failed to record login", err)
+		return
+	}
+	audit.RecordAs(r.Context(), audit.TypeLogin, u.ID, u.ID, map[string]string{"method": method})
+	writeJSON(w, http.StatusOK, u.As(user.ViewSelf))
+}
+
+func (h *Auth) logout(w http.ResponseWriter, r *http.Request) {
+	s, ok := session.FromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+		return
+	}
+	if s.Impersonating() {
+		// Logging out while impersonating returns to the impersonator's
+		// own session rather than leaving it behind.
+		h.stopImpersonation(w, r)
+		return
+	}
+	if err := h.sessions.Destroy(r.Context(), w, s); err != nil {
+		writeInternalError(w, "failed to destroy session", err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeLogout, s.UserID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errInvalidCredentials is returned by authenticate when the email or
+// password is incorrect.
+var errInvalidCredentials = errors.New("api: invalid credentials")
+
+// authenticate returns the user matching the credentials.  A password is
+// always verified, even if the account does not exist, so the time taken does
+// not reveal whether an account exists.
+//
+// If the password is incorrect for an existing account the account's user is
+// returned along with errInvalidCredentials, so the failure can be audited.
+func (h *Auth) authenticate(r *http.Request, req credentials) (*user.User, error) {
+	dummy, err := h.dummyUser()
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Password) > password.Limit {
+		return nil, errInvalidCredentials
+	}
+
+	u := dummy
+	if email, err := normalizeEmail(req.Email); err == nil {
+		found, err := h.users.ByEmail(r.Context(), email)
+		switch {
+		case err == nil:
+			u = found
+		case !errors.Is(err, user.ErrNotFound):
+			return nil, err
+		}
+	}
+	if err := u.VerifyPassword([]byte(req.Password)); err != nil || u == dummy {
+		if u == dummy {
+			return nil, errInvalidCredentials
+		}
+		return u, errInvalidCredentials
+	}
+	return u, nil
+}
+
+// dummyUser returns a user with a random password.
+func (h *Auth) dummyUser() (*user.User, error) {
+	h.dummyOnce.Do(func() {
+		password, err := uuid.New()
+		if err != nil {
+			h.dummyErr = err
+			return
+		}
+		h.dummy, h.dummyErr = user.New("", []byte(password.String()))
+	})
+	return h.dummy, h.dummyErr
+}
+
+// normalizeEmail validates an email address, returning it in lowercase.
+func normalizeEmail(v string) (string, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if _, _, err := address.Parse(v); err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+// checkPassword writes an error response and returns false if the password
+// does not meet the password policy.
+func (h *Auth) checkPassword(w http.ResponseWriter, v, email string) bool {
+	err := h.policy.Check([]byte(v), email)
+	if err == nil {
+		return true
+	}
+	writePasswordError(w, h.policy, err)
+	return false
+}
+
+// writePasswordError writes the response for an error returned by
+// password.Policy.
+func writePasswordError(w http.ResponseWriter, p *password.Policy, err error) {
+	switch {
+	case errors.Is(err, password.ErrTooShort):
+		writeError(w, http.StatusBadRequest, "invalid_password", "password must be at least "+strconv.Itoa(p.MinLength)+" characters")
+	case errors.Is(err, password.ErrTooLong):
+		writeError(w, http.StatusBadRequest, "invalid_password", "password must be at most "+strconv.Itoa(p.MaxLength)+" bytes")
+	case errors.Is(err, password.ErrContainsEmail):
+		writeError(w, http.StatusBadRequest, "invalid_password", "password must not contain your email address")
+	case errors.Is(err, password.ErrBreached):
+		writeError(w, http.StatusBadRequest, "password_breached", "password has appeared in a data breach, choose a different password")
+	default:
+		writeInternalError(w, "failed to check password", err)
+	}
+}
@@ -0,0 +1,135 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/internal/api"
+)
+
+func newAuthServer() *testServer {
+	return newTestServer(func(ts *testServer) func(chi.Router) {
+		return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail).Routes
+	})
+}
+
+func TestAuth_Register(t *testing.T) {
+	ts := newAuthServer()
+
+	for i, tc := range []struct {
+		Email    string
+		Password string
+		Status   int
+		Code     string
+	}{
+		{Email: "not an email", Password: "password123", Status: http.StatusBadRequest, Code: "invalid_email"},
+		{Email: "user@example.com", Password: "short", Status: http.StatusBadRequest, Code: "invalid_password"},
+		{Email: "matthew@example.com", Password: "matthew1234", Status: http.StatusBadRequest, Code: "invalid_password"},
+		{Email: "user@example.com", Password: "password123", Status: http.StatusAccepted},
+		// Registering a taken email looks exactly the same as a success.
+		{Email: "USER@example.com", Password: "password456", Status: http.StatusAccepted},
+	} {
+		w := ts.do(http.MethodPost, "/auth/register", map[string]string{"email": tc.Email, "password": tc.Password})
+		if w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+			continue
+		}
+		if tc.Code != "" {
+			if code := errorCode(t, w); code != tc.Code {
+				t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, tc.Code, code)
+			}
+		}
+	}
+	if len(ts.users.users) != 1 {
+		t.Errorf("Expected a single user to be created, but got %d", len(ts.users.users))
+	}
+}
+
+func TestAuth_Login(t *testing.T) {
+	ts := newAuthServer()
+	ts.do(http.MethodPost, "/auth/register", map[string]string{"email": "user@example.com", "password": "password123"})
+
+	unknown := ts.do(http.MethodPost, "/auth/login", map[string]string{"email": "other@example.com", "password": "password123"})
+	wrong := ts.do(http.MethodPost, "/auth/login", map[string]string{"email": "user@example.com", "password": "password456"})
+	if unknown.Code != http.StatusUnauthorized || wrong.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected failed logins to return \"%d\", but got \"%d\" and \"%d\"", http.StatusUnauthorized, unknown.Code, wrong.Code)
+	}
+	if unknown.Body.String() != wrong.Body.String() {
+		t.Errorf("Expected identical responses for unknown accounts and wrong passwords")
+	}
+
+	w := ts.do(http.MethodPost, "/auth/login", map[string]string{"email": "user@example.com", "password": "password123"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected \"%d\", but got \"%d\"", http.StatusOK, w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Expected a session cookie to be set")
+	}
+
+	if w := ts.do(http.MethodPost, "/auth/logout", nil, cookies[0]); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected \"%d\", but got \"%d\"", http.StatusNoContent, w.Code)
+	}
+	if len(ts.sessions.sessions) != 0 {
+		t.Errorf("Expected session to be revoked on logout")
+	}
+	if w := ts.do(http.MethodPost, "/auth/logout", nil, cookies[0]); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestAuth_Confirm(t *testing.T) {
+	ts := newAuthServer()
+	ts.config.RequireConfirmation = true
+	credentials := map[string]string{"email": "user@example.com", "password": "password123"}
+	ts.do(http.MethodPost, "/auth/register", credentials)
+
+	if w := ts.do(http.MethodPost, "/auth/login", credentials); w.Code != http.StatusForbidden || errorCode(t, w) != "email_unconfirmed" {
+		t.Fatalf("Expected login to be blocked until the email is confirmed, but got \"%d\"", w.Code)
+	}
+
+	// Resending is throttled.
+	ts.do(http.MethodPost, "/auth/confirm/resend", map[string]string{"email": "user@example.com"})
+	if len(ts.mail.messages) != 1 {
+		t.Errorf("Expected resend to be throttled, but %d emails were sent", len(ts.mail.messages))
+	}
+	secret := ts.mail.linkToken(t, "user@example.com")
+
+	if w := ts.do(http.MethodPost, "/auth/confirm", map[string]string{"token": "invalid"}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusBadRequest, w.Code)
+	}
+	if w := ts.do(http.MethodPost, "/auth/confirm", map[string]string{"token": secret}); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected \"%d\", but got \"%d\"", http.StatusNoContent, w.Code)
+	}
+	// Tokens are single-use.
+	if w := ts.do(http.MethodPost, "/auth/confirm", map[string]string{"token": secret}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusBadRequest, w.Code)
+	}
+	if w := ts.do(http.MethodPost, "/auth/login", credentials); w.Code != http.StatusOK {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusOK, w.Code)
+	}
+}
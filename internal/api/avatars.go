@@ -0,0 +1,136 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/avatar"
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// Avatars serves the avatars API.
+type Avatars struct {
+	config  *avatar.Config
+	storage *avatar.Storage
+	users   user.Store
+}
+
+// NewAvatars returns a new Avatars, if c is nil avatar.DefaultConfig is used.
+func NewAvatars(c *avatar.Config, s *avatar.Storage, users user.Store) *Avatars {
+	if c == nil {
+		c = avatar.DefaultConfig()
+	}
+	return &Avatars{
+		config:  c,
+		storage: s,
+		users:   users,
+	}
+}
+
+// Routes registers the avatars API's routes.
+func (h *Avatars) Routes(r chi.Router) {
+	r.Get("/avatars/{hash}/{size}", h.serve)
+	r.Route("/avatar", func(r chi.Router) {
+		r.Use(server.RequireAuthentication)
+		r.Put("/", h.upload)
+		r.Delete("/", h.delete)
+	})
+}
+
+func (h *Avatars) serve(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+	size, err := strconv.Atoi(chi.URLParam(r, "size"))
+	if err != nil || !h.config.HasSize(size) {
+		writeError(w, http.StatusNotFound, "not_found", "avatar not found")
+		return
+	}
+	f, err := h.storage.Open(hash, size)
+	if err != nil {
+		if errors.Is(err, avatar.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "avatar not found")
+			return
+		}
+		writeInternalError(w, "failed to open avatar", err)
+		return
+	}
+	defer f.Close()
+
+	// Avatars are content-addressed, so the content at a URL never changes
+	// and can be cached forever.
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", "\""+hash+"-"+strconv.Itoa(size)+"\"")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
+
+func (h *Avatars) upload(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, h.config.MaxUploadSize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "too_large", "avatar must be at most "+strconv.FormatInt(h.config.MaxUploadSize, 10)+" bytes")
+		return
+	}
+	a, err := avatar.Process(data, h.config.Sizes, h.config.MaxDimension)
+	if err != nil {
+		switch {
+		case errors.Is(err, avatar.ErrUnsupportedFormat):
+			writeError(w, http.StatusUnsupportedMediaType, "unsupported_format", "avatar must be a PNG, JPEG or GIF image")
+		case errors.Is(err, avatar.ErrTooLarge):
+			writeError(w, http.StatusBadRequest, "too_large", "avatar must be at most "+strconv.Itoa(h.config.MaxDimension)+" pixels wide and high")
+		case errors.Is(err, avatar.ErrInvalidImage):
+			writeError(w, http.StatusBadRequest, "invalid_image", "avatar could not be decoded")
+		default:
+			writeInternalError(w, "failed to process avatar", err)
+		}
+		return
+	}
+	if err := h.storage.Save(a); err != nil {
+		writeInternalError(w, "failed to store avatar", err)
+		return
+	}
+	h.setAvatar(w, r, a.Hash)
+}
+
+func (h *Avatars) delete(w http.ResponseWriter, r *http.Request) {
+	h.setAvatar(w, r, "")
+}
+
+// setAvatar updates the authenticated user's avatar.  Images are not deleted
+// when replaced, as they may be shared by other users with the same avatar.
+func (h *Avatars) setAvatar(w http.ResponseWriter, r *http.Request, hash string) {
+	u, _ := user.FromContext(r.Context())
+	if err := h.users.SetAvatar(r.Context(), u.ID, hash); err != nil {
+		writeInternalError(w, "failed to update avatar", err)
+		return
+	}
+	u.Avatar = hash
+	writeJSON(w, http.StatusOK, u.As(user.ViewSelf))
+}
@@ -0,0 +1,122 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/avatar"
+	"github.com/matthewpi/cosmos/internal/api"
+)
+
+func TestAvatars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "avatars")
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	defer os.RemoveAll(dir)
+	storage, err := avatar.NewStorage(dir)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	ts := newTestServer(func(ts *testServer) func(chi.Router) {
+		return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail).Routes
+	}, func(ts *testServer) func(chi.Router) {
+		return api.NewAvatars(nil, storage, ts.users).Routes
+	})
+	credentials := map[string]string{"email": "user@example.com", "password": "password123"}
+	ts.do(http.MethodPost, "/auth/register", credentials)
+	cookie := ts.do(http.MethodPost, "/auth/login", credentials).Result().Cookies()[0]
+
+	upload := func(data []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/avatar", bytes.NewReader(data))
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		ts.router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := upload([]byte("not an image")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusUnsupportedMediaType, w.Code)
+	}
+
+	var img bytes.Buffer
+	_ = png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 100, 80)))
+	w := upload(img.Bytes())
+	var body struct {
+		Avatar string `json:"avatar"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || !avatar.ValidHash(body.Avatar) {
+		t.Fatalf("Expected the user's avatar to be set, but got \"%s\"", w.Body.String())
+	}
+
+	path := "/avatars/" + body.Avatar + "/64"
+	w = ts.do(http.MethodGet, path, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected \"%d\", but got \"%d\"", http.StatusOK, w.Code)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("Expected an ETag and a PNG content type, but got \"%v\"", w.Header())
+	}
+	if decoded, err := png.Decode(w.Body); err != nil || decoded.Bounds().Dx() != 64 {
+		t.Errorf("Expected a 64x64 image")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	ts.router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusNotModified, w.Code)
+	}
+
+	for i, path := range []string{
+		"/avatars/" + body.Avatar + "/65",
+		"/avatars/" + strings.Repeat("0", 64) + "/64",
+	} {
+		if w := ts.do(http.MethodGet, path, nil); w.Code != http.StatusNotFound {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, http.StatusNotFound, w.Code)
+		}
+	}
+
+	if w := ts.do(http.MethodDelete, "/avatar", nil, cookie); w.Code != http.StatusOK {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusOK, w.Code)
+	}
+	if u, _ := ts.users.ByEmail(context.Background(), "user@example.com"); u.Avatar != "" {
+		t.Errorf("Expected the avatar to be removed, but got \"%s\"", u.Avatar)
+	}
+}
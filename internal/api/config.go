@@ -0,0 +1,149 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/config/lexer"
+)
+
+// Config represents the configuration for the authentication API.
+type Config struct {
+	// BaseURL is the URL of the frontend, used to build links sent to users.
+	BaseURL string `json:"base_url"`
+
+	// RequireConfirmation prevents users from logging in until they have
+	// confirmed their email address.
+	RequireConfirmation bool `json:"require_confirmation"`
+
+	// InviteOnly requires an invite to register, see package invite.
+	InviteOnly bool `json:"invite_only"`
+
+	// ConfirmationTTL is how long an email confirmation token is valid for.
+	ConfirmationTTL time.Duration `json:"confirmation_ttl"`
+
+	// ResendInterval is the minimum amount of time between sending
+	// confirmation or password reset emails to the same user.
+	ResendInterval time.Duration `json:"resend_interval"`
+
+	// ResetTTL is how long a password reset token is valid for.
+	ResetTTL time.Duration `json:"reset_ttl"`
+
+	// ChangeEmailTTL is how long a user has to confirm a new email address.
+	ChangeEmailTTL time.Duration `json:"change_email_ttl"`
+
+	// RevertEmailTTL is how long the previous email address can revert a
+	// change of email address.
+	RevertEmailTTL time.Duration `json:"revert_email_ttl"`
+
+	// TwoFactorTTL is how long a user has to provide their second factor
+	// after providing their password.
+	TwoFactorTTL time.Duration `json:"two_factor_ttl"`
+
+	// ReauthenticationTTL is how recently a user without a password must
+	// have logged in to make changes that require others to provide their
+	// password.
+	ReauthenticationTTL time.Duration `json:"reauthentication_ttl"`
+}
+
+// DefaultConfig returns the default authentication API configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		BaseURL:             "http://localhost",
+		ConfirmationTTL:     48 * time.Hour,
+		ResendInterval:      5 * time.Minute,
+		ResetTTL:            30 * time.Minute,
+		ChangeEmailTTL:      24 * time.Hour,
+		RevertEmailTTL:      7 * 24 * time.Hour,
+		TwoFactorTTL:        5 * time.Minute,
+		ReauthenticationTTL: 5 * time.Minute,
+	}
+}
+
+// FromLexer .
+func FromLexer(b lexer.Block) (*Config, error) {
+	c := DefaultConfig()
+	for _, s := range b.Segments {
+		d := s.Directive()
+		switch d {
+		case "base_url":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after base_url directive")
+			}
+			c.BaseURL = strings.TrimSuffix(s[1].Text, "/")
+		case "require_confirmation":
+			if len(s) != 1 {
+				return nil, fmt.Errorf("unexpected argument after require_confirmation directive")
+			}
+			c.RequireConfirmation = true
+		case "invite_only":
+			if len(s) != 1 {
+				return nil, fmt.Errorf("unexpected argument after invite_only directive")
+			}
+			c.InviteOnly = true
+		case "confirmation_ttl", "resend_interval", "reset_ttl", "change_email_ttl", "revert_email_ttl", "two_factor_ttl", "reauthentication_ttl":
+			v, err := duration(s)
+			if err != nil {
+				return nil, err
+			}
+			switch d {
+			case "confirmation_ttl":
+				c.ConfirmationTTL = v
+			case "resend_interval":
+				c.ResendInterval = v
+			case "reset_ttl":
+				c.ResetTTL = v
+			case "change_email_ttl":
+				c.ChangeEmailTTL = v
+			case "revert_email_ttl":
+				c.RevertEmailTTL = v
+			case "two_factor_ttl":
+				c.TwoFactorTTL = v
+			case "reauthentication_ttl":
+				c.ReauthenticationTTL = v
+			}
+		default:
+			return nil, fmt.Errorf("unknown directive: \"" + d + "\"")
+		}
+	}
+	return c, nil
+}
+
+// duration parses the single positive duration argument of a directive.
+func duration(s lexer.Segment) (time.Duration, error) {
+	d := s.Directive()
+	if len(s) != 2 {
+		return 0, fmt.Errorf("expected a single argument after %s directive", d)
+	}
+	v, err := time.ParseDuration(s[1].Text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", d, err)
+	}
+	if v <= 0 {
+		return 0, fmt.Errorf("%s must be positive", d)
+	}
+	return v, nil
+}
@@ -0,0 +1,141 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/matthewpi/cosmos/internal/mail"
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// purposeConfirm is the purpose of email confirmation tokens.
+const purposeConfirm token.Purpose = "confirm"
+
+func (h *Auth) confirm(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	t, err := h.tokens.Consume(r.Context(), purposeConfirm, req.Token)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	u, err := h.users.ByID(r.Context(), t.UserID)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	// A confirmation token is bound to the address it was sent to, if the
+	// address has since changed the token is no longer valid.
+	if u.Email != t.Data {
+		writeTokenError(w, token.ErrInvalid)
+		return
+	}
+	if !u.Confirmed {
+		if err := h.users.Confirm(r.Context(), u.ID); err != nil {
+			writeInternalError(w, "failed to confirm user", err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Auth) resendConfirmation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	// The response is the same whether or not the email belongs to an
+	// unconfirmed account.
+	if email, err := normalizeEmail(req.Email); err == nil {
+		u, err := h.users.ByEmail(r.Context(), email)
+		switch {
+		case err == nil:
+			if u.Confirmed {
+				break
+			}
+			throttled, err := h.tokens.IssuedSince(r.Context(), purposeConfirm, u.ID, h.tokens.Now().Add(-h.config.ResendInterval))
+			if err != nil {
+				writeInternalError(w, "failed to resend confirmation", err)
+				return
+			}
+			if throttled {
+				break
+			}
+			if err := h.sendConfirmation(r.Context(), u); err != nil {
+				writeInternalError(w, "failed to resend confirmation", err)
+				return
+			}
+		case !errors.Is(err, user.ErrNotFound):
+			writeInternalError(w, "failed to resend confirmation", err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"message": "if the account exists and is unconfirmed, a confirmation email has been sent",
+	})
+}
+
+// sendConfirmation issues a confirmation token to a user and emails it to
+// them, revoking any previously issued tokens.
+func (h *Auth) sendConfirmation(ctx context.Context, u *user.User) error {
+	if err := h.tokens.Revoke(ctx, purposeConfirm, u.ID); err != nil {
+		return err
+	}
+	secret, err := h.tokens.Issue(ctx, purposeConfirm, u.ID, h.config.ConfirmationTTL, u.Email)
+	if err != nil {
+		return err
+	}
+	return h.mailer.Send(ctx, &mail.Message{
+		To:      u.Email,
+		Subject: "Confirm your email address",
+		Body: "Confirm your email address by opening the link below.\n\n" +
+			h.link("/confirm", secret) + "\n\n" +
+			"If you did not create an account, you can ignore this email.\n",
+	})
+}
+
+// link returns a link to a page of the frontend that includes a token.
+func (h *Auth) link(path, secret string) string {
+	return h.config.BaseURL + path + "?token=" + url.QueryEscape(secret)
+}
+
+// writeTokenError writes the response for an error caused by consuming a
+// token.
+func writeTokenError(w http.ResponseWriter, err error) {
+	if errors.Is(err, token.ErrInvalid) || errors.Is(err, user.ErrNotFound) {
+		writeError(w, http.StatusBadRequest, "invalid_token", "invalid or expired token")
+		return
+	}
+	writeInternalError(w, "failed to consume token", err)
+}
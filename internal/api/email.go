@@ -0,0 +1,243 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/mail"
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/user"
+)
+
+const (
+	// purposeChangeEmail is the purpose of tokens sent to a new email
+	// address to confirm a change.
+	purposeChangeEmail token.Purpose = "change_email"
+
+	// purposeRevertEmail is the purpose of tokens sent to the old email
+	// address to revert a change.
+	purposeRevertEmail token.Purpose = "revert_email"
+)
+
+// emailChange is a pending change of email address, stored as the data of
+// change and revert tokens.
+type emailChange struct {
+	// Old is the email address when the change was requested.
+	Old string `json:"old"`
+
+	// New is the requested email address.
+	New string `json:"new"`
+}
+
+// parseEmailChange parses the data of a change or revert token.
+func parseEmailChange(t *token.Token) (*emailChange, error) {
+	var c emailChange
+	if err := json.Unmarshal([]byte(t.Data), &c); err != nil {
+		return nil, token.ErrInvalid
+	}
+	return &c, nil
+}
+
+func (h *Auth) changeEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+		Email    string `json:"email"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	if !h.verifyIdentity(w, r, u, req.Password) {
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_email", "invalid email address")
+		return
+	}
+	if email == u.Email {
+		writeError(w, http.StatusBadRequest, "invalid_email", "email address is unchanged")
+		return
+	}
+	// Revert tokens are used to throttle requests as they outlive the
+	// confirmation tokens, which are consumed by failed confirmations.
+	throttled, err := h.tokens.IssuedSince(r.Context(), purposeRevertEmail, u.ID, h.tokens.Now().Add(-h.config.ResendInterval))
+	if err != nil {
+		writeInternalError(w, "failed to change email", err)
+		return
+	}
+	if throttled {
+		writeError(w, http.StatusTooManyRequests, "too_many_requests", "an email change was requested recently, try again later")
+		return
+	}
+
+	// Whether the new address is taken is only checked once it has been
+	// confirmed, so requesting a change does not reveal which addresses
+	// have an account.
+	data, err := json.Marshal(&emailChange{Old: u.Email, New: email})
+	if err != nil {
+		writeInternalError(w, "failed to change email", err)
+		return
+	}
+	if err := h.tokens.Revoke(r.Context(), purposeChangeEmail, u.ID); err != nil {
+		writeInternalError(w, "failed to change email", err)
+		return
+	}
+	confirm, err := h.tokens.Issue(r.Context(), purposeChangeEmail, u.ID, h.config.ChangeEmailTTL, string(data))
+	if err != nil {
+		writeInternalError(w, "failed to change email", err)
+		return
+	}
+	revert, err := h.tokens.Issue(r.Context(), purposeRevertEmail, u.ID, h.config.RevertEmailTTL, string(data))
+	if err != nil {
+		writeInternalError(w, "failed to change email", err)
+		return
+	}
+	if err := h.mailer.Send(r.Context(), &mail.Message{
+		To:      email,
+		Subject: "Confirm your new email address",
+		Body: "Confirm your new email address by opening the link below.\n\n" +
+			h.link("/email/confirm", confirm) + "\n\n" +
+			"If you did not request this change, you can ignore this email.\n",
+	}); err != nil {
+		writeInternalError(w, "failed to send email change confirmation", err)
+		return
+	}
+	if err := h.mailer.Send(r.Context(), &mail.Message{
+		To:      u.Email,
+		Subject: "Your email address is being changed",
+		Body: "A request was made to change the email address of your account to " + email + ".\n\n" +
+			"If you did not make this request, open the link below to cancel or undo the change, " +
+			"then reset your password.\n\n" +
+			h.link("/email/revert", revert) + "\n",
+	}); err != nil {
+		writeInternalError(w, "failed to send email change notification", err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"message": "check your new email address to confirm the change",
+	})
+}
+
+func (h *Auth) confirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	t, err := h.tokens.Consume(r.Context(), purposeChangeEmail, req.Token)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	c, err := parseEmailChange(t)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	u, err := h.users.ByID(r.Context(), t.UserID)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	// The change is bound to the address it was requested from, if the
+	// address has since changed the token is no longer valid.
+	if u.Email != c.Old {
+		writeTokenError(w, token.ErrInvalid)
+		return
+	}
+	if err := h.users.SetEmail(r.Context(), u.ID, c.New); err != nil {
+		if errors.Is(err, user.ErrEmailTaken) {
+			writeError(w, http.StatusConflict, "email_taken", "email address is already in use")
+			return
+		}
+		writeInternalError(w, "failed to change email", err)
+		return
+	}
+	u.Email, u.Confirmed = c.New, true
+	audit.Record(r.Context(), audit.TypeEmailChanged, u.ID, c)
+	writeJSON(w, http.StatusOK, u.As(user.ViewSelf))
+}
+
+func (h *Auth) revertEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	t, err := h.tokens.Consume(r.Context(), purposeRevertEmail, req.Token)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	c, err := parseEmailChange(t)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	u, err := h.users.ByID(r.Context(), t.UserID)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	if err := h.tokens.Revoke(r.Context(), purposeChangeEmail, u.ID); err != nil {
+		writeInternalError(w, "failed to revert email change", err)
+		return
+	}
+	switch u.Email {
+	case c.Old:
+		// The change was never confirmed, revoking the confirmation token
+		// cancelled it.
+	case c.New:
+		if err := h.users.SetEmail(r.Context(), u.ID, c.Old); err != nil {
+			if errors.Is(err, user.ErrEmailTaken) {
+				writeError(w, http.StatusConflict, "email_taken", "email address is already in use")
+				return
+			}
+			writeInternalError(w, "failed to revert email change", err)
+			return
+		}
+		audit.Record(r.Context(), audit.TypeEmailChanged, u.ID, map[string]interface{}{
+			"old":      c.New,
+			"new":      c.Old,
+			"reverted": true,
+		})
+	default:
+		writeTokenError(w, token.ErrInvalid)
+		return
+	}
+	// Someone else may have requested the change, so all sessions are
+	// revoked.
+	if err := h.sessions.RevokeAll(r.Context(), u.ID); err != nil {
+		writeInternalError(w, "failed to revoke sessions", err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeSessionsRevoked, u.ID, map[string]string{"reason": "email_reverted"})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,108 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/user"
+)
+
+func TestAuth_ChangeEmail(t *testing.T) {
+	ts := newAuthServer()
+	credentials := map[string]string{"email": "user@example.com", "password": "password123"}
+	ts.do(http.MethodPost, "/auth/register", credentials)
+	cookie := ts.do(http.MethodPost, "/auth/login", credentials).Result().Cookies()[0]
+	taken, _ := user.New("taken@example.com", []byte("password123"))
+	_ = ts.users.Create(context.Background(), taken)
+
+	for i, tc := range []struct {
+		Body   map[string]string
+		Status int
+		Code   string
+	}{
+		{Body: map[string]string{"password": "wrong", "email": "new@example.com"}, Status: http.StatusUnauthorized, Code: "invalid_credentials"},
+		{Body: map[string]string{"password": "password123", "email": "invalid"}, Status: http.StatusBadRequest, Code: "invalid_email"},
+		{Body: map[string]string{"password": "password123", "email": "user@example.com"}, Status: http.StatusBadRequest, Code: "invalid_email"},
+		{Body: map[string]string{"password": "password123", "email": "taken@example.com"}, Status: http.StatusAccepted},
+	} {
+		w := ts.do(http.MethodPost, "/auth/email", tc.Body, cookie)
+		if w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+			continue
+		}
+		if tc.Code != "" {
+			if code := errorCode(t, w); code != tc.Code {
+				t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, tc.Code, code)
+			}
+		}
+	}
+
+	// The unique constraint is enforced when the change is confirmed.
+	w := ts.do(http.MethodPost, "/auth/email/confirm", map[string]string{"token": ts.mail.linkToken(t, "taken@example.com")})
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusConflict, w.Code)
+	}
+
+	// Requests are throttled.
+	change := map[string]string{"password": "password123", "email": "new@example.com"}
+	if w := ts.do(http.MethodPost, "/auth/email", change, cookie); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusTooManyRequests, w.Code)
+	}
+	ts.tokens.Now = func() time.Time { return time.Now().Add(ts.config.ResendInterval) }
+	if w := ts.do(http.MethodPost, "/auth/email", change, cookie); w.Code != http.StatusAccepted {
+		t.Fatalf("Expected \"%d\", but got \"%d\"", http.StatusAccepted, w.Code)
+	}
+	notification := ts.mail.last("user@example.com")
+	if notification == nil || notification.Subject != "Your email address is being changed" {
+		t.Fatalf("Expected the old address to be notified")
+	}
+	revert := ts.mail.linkToken(t, "user@example.com")
+
+	// The email address is only changed once confirmed.
+	u, _ := ts.users.ByEmail(context.Background(), "user@example.com")
+	if u == nil {
+		t.Fatalf("Expected the email address to be unchanged before confirmation")
+	}
+	confirm := ts.mail.linkToken(t, "new@example.com")
+	if w := ts.do(http.MethodPost, "/auth/email/confirm", map[string]string{"token": confirm}); w.Code != http.StatusOK {
+		t.Fatalf("Expected \"%d\", but got \"%d\"", http.StatusOK, w.Code)
+	}
+	if u, _ := ts.users.ByID(context.Background(), u.ID); u.Email != "new@example.com" {
+		t.Errorf("Expected \"%s\", but got \"%s\"", "new@example.com", u.Email)
+	}
+
+	// The old address can revert the change, which revokes all sessions.
+	if w := ts.do(http.MethodPost, "/auth/email/revert", map[string]string{"token": revert}); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected \"%d\", but got \"%d\"", http.StatusNoContent, w.Code)
+	}
+	if u, _ := ts.users.ByID(context.Background(), u.ID); u.Email != "user@example.com" {
+		t.Errorf("Expected \"%s\", but got \"%s\"", "user@example.com", u.Email)
+	}
+	if w := ts.do(http.MethodPost, "/auth/email", change, cookie); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusUnauthorized, w.Code)
+	}
+}
@@ -0,0 +1,108 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// startImpersonation replaces the actor's session with a short-lived session
+// impersonating another user.  Responses to requests made while
+// impersonating carry the session.ImpersonatorHeader, and every request is
+// recorded in the audit log.
+func (h *Auth) startImpersonation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID snowflake.Snowflake `json:"user_id"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	current, ok := session.FromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "a session is required to impersonate a user")
+		return
+	}
+	if !req.UserID.Valid() {
+		writeError(w, http.StatusNotFound, "not_found", "user not found")
+		return
+	}
+	target, err := h.users.ByID(r.Context(), req.UserID)
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "user not found")
+			return
+		}
+		writeInternalError(w, "failed to load user", err)
+		return
+	}
+	actor, _ := user.FromContext(r.Context())
+	s, err := h.sessions.Impersonate(r.Context(), w, r, current, actor, target)
+	if err != nil {
+		writeImpersonationError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeImpersonationStarted, target.ID, map[string]interface{}{"expires_at": s.ExpiresAt})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user":       target.As(user.ViewSelf),
+		"expires_at": s.ExpiresAt,
+	})
+}
+
+// stopImpersonation ends impersonating a user, restoring the impersonator's
+// own session.
+func (h *Auth) stopImpersonation(w http.ResponseWriter, r *http.Request) {
+	s, ok := session.FromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+		return
+	}
+	if err := h.sessions.StopImpersonating(r.Context(), w, r, s); err != nil {
+		writeImpersonationError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeImpersonationStopped, s.UserID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeImpersonationError writes the response for an impersonation error
+// returned by session.Manager.
+func writeImpersonationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, session.ErrImpersonationForbidden):
+		writeError(w, http.StatusForbidden, "forbidden", "you may only impersonate users ranked below your own role")
+	case errors.Is(err, session.ErrAlreadyImpersonating):
+		writeError(w, http.StatusConflict, "already_impersonating", "already impersonating a user")
+	case errors.Is(err, session.ErrNotImpersonating):
+		writeError(w, http.StatusConflict, "not_impersonating", "not impersonating a user")
+	case errors.Is(err, session.ErrNoSession):
+		writeError(w, http.StatusUnauthorized, "unauthorized", "a session is required to impersonate a user")
+	default:
+		writeInternalError(w, "failed to handle impersonation request", err)
+	}
+}
@@ -0,0 +1,221 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/accesstoken"
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/webauthn"
+	"github.com/matthewpi/cosmos/passkey"
+	"github.com/matthewpi/cosmos/privacy"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/twofactor"
+	"github.com/matthewpi/cosmos/user"
+	"github.com/matthewpi/cosmos/user/usertest"
+)
+
+func TestImpersonation(t *testing.T) {
+	ts := newTestServer(
+		func(ts *testServer) func(chi.Router) {
+			return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail).Routes
+		},
+		func(*testServer) func(chi.Router) {
+			return func(r chi.Router) {
+				r.Get("/whoami", func(w http.ResponseWriter, r *http.Request) {
+					u, _ := user.FromContext(r.Context())
+					_, _ = w.Write([]byte(u.ID.String()))
+				})
+			}
+		},
+	)
+	support := &role.Role{ID: snowflake.New(), Name: "Support", SortID: 1, Permissions: role.Permissions{user.PermissionImpersonate}}
+	admin, _ := user.New("admin@example.com", []byte("password123"))
+	admin.Role = support
+	_ = ts.users.Create(context.Background(), admin)
+	target, _ := user.New("user@example.com", nil)
+	target.ID = snowflake.New()
+	_ = ts.users.Create(context.Background(), target)
+	peer, _ := user.New("peer@example.com", nil)
+	peer.ID = snowflake.New()
+	peer.Role = support
+	_ = ts.users.Create(context.Background(), peer)
+
+	cookie := ts.do(http.MethodPost, "/auth/login", map[string]string{
+		"email":    "admin@example.com",
+		"password": "password123",
+	}).Result().Cookies()[0]
+
+	for i, tc := range []struct {
+		UserID string
+		Status int
+	}{
+		{UserID: "1", Status: http.StatusNotFound},
+		{UserID: admin.ID.String(), Status: http.StatusForbidden},
+		{UserID: peer.ID.String(), Status: http.StatusForbidden},
+	} {
+		if w := ts.do(http.MethodPost, "/auth/impersonation", map[string]string{"user_id": tc.UserID}, cookie); w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+		}
+	}
+
+	w := ts.do(http.MethodPost, "/auth/impersonation", map[string]string{"user_id": target.ID.String()}, cookie)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+
+	w = ts.do(http.MethodGet, "/whoami", nil, cookies...)
+	if w.Body.String() != target.ID.String() {
+		t.Errorf("Expected \"%s\", but got \"%s\"", target.ID, w.Body.String())
+	}
+	if v := w.Header().Get(session.ImpersonatorHeader); v != admin.ID.String() {
+		t.Errorf("Expected \"%s\", but got \"%s\"", admin.ID, v)
+	}
+	if w := ts.do(http.MethodPost, "/auth/impersonation", map[string]string{"user_id": target.ID.String()}, cookies...); w.Code != http.StatusForbidden {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusForbidden, w.Code)
+	}
+
+	w = ts.do(http.MethodDelete, "/auth/impersonation", nil, cookies...)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	var restored *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == cookie.Name && c.MaxAge > 0 {
+			restored = c
+		}
+	}
+	if restored == nil || restored.Value != cookie.Value {
+		t.Fatalf("Expected the impersonator's session to be restored")
+	}
+	if w := ts.do(http.MethodGet, "/whoami", nil, restored); w.Body.String() != admin.ID.String() {
+		t.Errorf("Expected \"%s\", but got \"%s\"", admin.ID, w.Body.String())
+	}
+
+	expected := []string{
+		audit.TypeImpersonationStarted,
+		audit.TypeImpersonatedRequest,
+		// Rejected requests are recorded too.
+		audit.TypeImpersonatedRequest,
+		audit.TypeImpersonationStopped,
+		audit.TypeImpersonatedRequest,
+	}
+	types := ts.audit.types(target.ID)
+	if len(types) != len(expected) {
+		t.Fatalf("Expected \"%v\", but got \"%v\"", expected, types)
+	}
+	for i, v := range expected {
+		if types[i] != v {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, v, types[i])
+		}
+	}
+}
+
+func TestImpersonation_StoredRoles(t *testing.T) {
+	db := usertest.New()
+	ts := newStoreTestServer(db,
+		func(ts *testServer) func(chi.Router) {
+			passkeys := passkey.NewManager(newMemoryPasskeys(), ts.db.Users(), &webauthn.Config{
+				RPID:    "example.com",
+				RPName:  "Example",
+				Origins: []string{"https://example.com"},
+				Timeout: time.Minute,
+			})
+			return api.NewAuth(ts.config, ts.db.Users(), ts.manager, ts.tokens, ts.mail,
+				api.WithPasskeys(passkeys),
+				api.WithTwoFactor(twofactor.NewManager(newMemoryTwoFactor(), nil)),
+			).Routes
+		},
+		func(ts *testServer) func(chi.Router) {
+			return api.NewAccessTokens(accesstoken.NewManager(newMemoryAccessTokens(), ts.db.Users())).Routes
+		},
+		func(ts *testServer) func(chi.Router) {
+			return api.NewPrivacy(privacy.NewManager(nil, ts.db.Users(), ts.manager, ts.log, nil, nil, nil), nil).Routes
+		},
+	)
+	staff := &role.Role{ID: snowflake.New(), Name: "Staff", SortID: 0, Permissions: role.Permissions{role.All}}
+	support := &role.Role{ID: snowflake.New(), Name: "Support", SortID: 1, Permissions: role.Permissions{user.PermissionImpersonate}}
+	member := &role.Role{ID: snowflake.New(), Name: "Member", SortID: 2}
+	createRoles(t, db, staff, support, member)
+	createUser(t, db, "admin@example.com", support)
+	top := createUser(t, db, "staff@example.com", staff)
+	peer := createUser(t, db, "peer@example.com", support)
+	target := createUser(t, db, "user@example.com", member)
+	cookie := ts.do(http.MethodPost, "/auth/login", map[string]string{
+		"email":    "admin@example.com",
+		"password": "password123",
+	}).Result().Cookies()[0]
+
+	// Only users ranked below the impersonator's role may be impersonated.
+	for i, tc := range []struct {
+		User   *user.User
+		Status int
+	}{
+		{User: top, Status: http.StatusForbidden},
+		{User: peer, Status: http.StatusForbidden},
+	} {
+		if w := ts.do(http.MethodPost, "/auth/impersonation", map[string]string{"user_id": tc.User.ID.String()}, cookie); w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+		}
+	}
+	w := ts.do(http.MethodPost, "/auth/impersonation", map[string]string{"user_id": target.ID.String()}, cookie)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+
+	// The impersonator can not change how the user signs in or what
+	// happens to their account.
+	for i, tc := range []struct {
+		Method string
+		Path   string
+		Body   interface{}
+		Status int
+	}{
+		{Method: http.MethodGet, Path: "/auth/passkeys", Status: http.StatusOK},
+		{Method: http.MethodGet, Path: "/auth/sessions", Status: http.StatusOK},
+		{Method: http.MethodDelete, Path: "/auth/sessions", Status: http.StatusForbidden},
+		{Method: http.MethodDelete, Path: "/auth/sessions/other", Status: http.StatusForbidden},
+		{Method: http.MethodPost, Path: "/auth/tokens", Body: map[string]interface{}{"name": "CI", "scopes": []string{}}, Status: http.StatusForbidden},
+		{Method: http.MethodPost, Path: "/auth/passkeys/register/begin", Status: http.StatusForbidden},
+		{Method: http.MethodPost, Path: "/auth/passkeys/register/finish", Body: map[string]interface{}{}, Status: http.StatusForbidden},
+		{Method: http.MethodPost, Path: "/auth/2fa/totp", Status: http.StatusForbidden},
+		{Method: http.MethodDelete, Path: "/auth/2fa/totp", Body: map[string]string{"password": "password123"}, Status: http.StatusForbidden},
+		{Method: http.MethodPost, Path: "/auth/email", Body: map[string]string{"email": "new@example.com"}, Status: http.StatusForbidden},
+		{Method: http.MethodPost, Path: "/auth/account/deletion", Body: map[string]string{}, Status: http.StatusForbidden},
+	} {
+		if w := ts.do(tc.Method, tc.Path, tc.Body, cookies...); w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\": %s", i, tc.Status, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,143 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/invite"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// Invites serves the invites API.
+type Invites struct {
+	invites *invite.Manager
+}
+
+// NewInvites returns a new Invites.
+func NewInvites(m *invite.Manager) *Invites {
+	return &Invites{invites: m}
+}
+
+// Routes registers the invites API's routes.
+func (h *Invites) Routes(r chi.Router) {
+	r.Route("/invites", func(r chi.Router) {
+		r.With(server.RequirePermission(invite.PermissionRead)).Get("/", h.list)
+		r.With(server.RequirePermission(invite.PermissionCreate)).Post("/", h.create)
+		r.With(server.RequirePermission(invite.PermissionDelete)).Delete("/{id}", h.revoke)
+	})
+}
+
+func (h *Invites) list(w http.ResponseWriter, r *http.Request) {
+	invites, err := h.invites.List(r.Context())
+	if err != nil {
+		writeInternalError(w, "failed to list invites", err)
+		return
+	}
+	if invites == nil {
+		invites = []*invite.Invite{}
+	}
+	writeJSON(w, http.StatusOK, invites)
+}
+
+// create creates an invite, the code is only ever included in this response.
+// max_uses defaults to a single use.
+func (h *Invites) create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email     string              `json:"email"`
+		RoleID    snowflake.Snowflake `json:"role_id"`
+		MaxUses   int                 `json:"max_uses"`
+		ExpiresAt *time.Time          `json:"expires_at"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Email != "" {
+		email, err := normalizeEmail(req.Email)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_email", "invalid email address")
+			return
+		}
+		req.Email = email
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	u, _ := user.FromContext(r.Context())
+	inv, code, err := h.invites.Create(r.Context(), u, req.Email, req.RoleID, req.MaxUses, req.ExpiresAt)
+	if err != nil {
+		writeInviteError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeInviteCreated, snowflake.Nil, map[string]interface{}{
+		"id":       inv.ID,
+		"email":    inv.Email,
+		"role_id":  inv.RoleID,
+		"max_uses": inv.MaxUses,
+	})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"invite": inv,
+		"code":   code,
+	})
+}
+
+func (h *Invites) revoke(w http.ResponseWriter, r *http.Request) {
+	id := snowflake.Parse(chi.URLParam(r, "id"))
+	if !id.Valid() {
+		writeError(w, http.StatusNotFound, "not_found", "invite not found")
+		return
+	}
+	if err := h.invites.Revoke(r.Context(), id); err != nil {
+		writeInviteError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeInviteRevoked, snowflake.Nil, map[string]interface{}{"id": id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeInviteError writes the response for an error returned by
+// invite.Manager.
+func writeInviteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, invite.ErrInvalidUses):
+		writeError(w, http.StatusBadRequest, "invalid", "max_uses must be between 1 and 1000, and 1 for an invite bound to an email address")
+	case errors.Is(err, invite.ErrInvalidExpiry):
+		writeError(w, http.StatusBadRequest, "invalid", "expires_at must be in the future")
+	case errors.Is(err, invite.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not_found", "invite not found")
+	case errors.Is(err, role.ErrNotFound),
+		errors.Is(err, role.ErrForbidden),
+		errors.Is(err, role.ErrInsufficientRank):
+		writeRoleError(w, err)
+	default:
+		writeInternalError(w, "failed to handle invite request", err)
+	}
+}
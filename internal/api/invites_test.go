@@ -0,0 +1,230 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/invite"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// memoryInvites is an in-memory invite.Store used for testing, redeemed
+// invites create users in users.
+type memoryInvites struct {
+	mu      sync.Mutex
+	invites map[snowflake.Snowflake]invite.Invite
+	roles   memoryRoleFinder
+	users   user.Store
+}
+
+func (s *memoryInvites) Create(_ context.Context, i *invite.Invite) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.invites[i.ID] = *i
+	return nil
+}
+
+func (s *memoryInvites) All(_ context.Context) ([]*invite.Invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var invites []*invite.Invite
+	for _, i := range s.invites {
+		i := i
+		invites = append(invites, &i)
+	}
+	return invites, nil
+}
+
+func (s *memoryInvites) Redeem(ctx context.Context, hash string, now time.Time, u *user.User) (*invite.Invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, i := range s.invites {
+		if i.Hash != hash {
+			continue
+		}
+		if !i.Valid(u.Email, now) {
+			return nil, invite.ErrInvalid
+		}
+		if i.RoleID.Valid() {
+			u.Role, _ = s.roles.ByID(ctx, i.RoleID)
+		}
+		u.InvitedBy = i.CreatedBy
+		if err := s.users.Create(ctx, u); err != nil {
+			return nil, err
+		}
+		i.Uses++
+		s.invites[id] = i
+		return &i, nil
+	}
+	return nil, invite.ErrNotFound
+}
+
+func (s *memoryInvites) Delete(_ context.Context, id snowflake.Snowflake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.invites[id]; !ok {
+		return invite.ErrNotFound
+	}
+	delete(s.invites, id)
+	return nil
+}
+
+// memoryRoleFinder is an in-memory invite.Roles used for testing.
+type memoryRoleFinder map[snowflake.Snowflake]*role.Role
+
+func (s memoryRoleFinder) ByID(_ context.Context, id snowflake.Snowflake) (*role.Role, error) {
+	r, ok := s[id]
+	if !ok {
+		return nil, role.ErrNotFound
+	}
+	return r, nil
+}
+
+func TestInvites(t *testing.T) {
+	roles := memoryRoleFinder{
+		1: {ID: 1, Name: "Admin", SortID: 1, Permissions: role.Permissions{"invites.*", "roles.assign"}},
+		2: {ID: 2, Name: "Member", SortID: 2},
+	}
+	var m *invite.Manager
+	ts := newTestServer(
+		func(ts *testServer) func(chi.Router) {
+			m = invite.NewManager(&memoryInvites{
+				invites: make(map[snowflake.Snowflake]invite.Invite),
+				roles:   roles,
+				users:   ts.users,
+			}, roles)
+			return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail, api.WithInvites(m)).Routes
+		},
+		func(ts *testServer) func(chi.Router) {
+			return api.NewInvites(m).Routes
+		},
+	)
+	ts.config.InviteOnly = true
+	admin, _ := user.New("admin@example.com", []byte("password123"))
+	admin.Role = roles[1]
+	_ = ts.users.Create(context.Background(), admin)
+	cookie := ts.do(http.MethodPost, "/auth/login", map[string]string{
+		"email":    "admin@example.com",
+		"password": "password123",
+	}).Result().Cookies()[0]
+
+	create := func(body interface{}) (int, string) {
+		w := ts.do(http.MethodPost, "/invites", body, cookie)
+		var created struct {
+			Code string `json:"code"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &created)
+		return w.Code, created.Code
+	}
+	status, bound := create(map[string]interface{}{"email": "Bound@Example.com", "role_id": "2"})
+	if status != http.StatusCreated || bound == "" {
+		t.Fatalf("Expected \"%d\", but got \"%d\"", http.StatusCreated, status)
+	}
+	_, multi := create(map[string]interface{}{"max_uses": 2})
+	for i, tc := range []struct {
+		Body   interface{}
+		Status int
+	}{
+		{Body: map[string]interface{}{"email": "user@example.com", "max_uses": 2}, Status: http.StatusBadRequest},
+		{Body: map[string]interface{}{"email": "invalid"}, Status: http.StatusBadRequest},
+		{Body: map[string]interface{}{"role_id": "1"}, Status: http.StatusForbidden},
+		{Body: map[string]interface{}{"role_id": "3"}, Status: http.StatusNotFound},
+	} {
+		if status, _ := create(tc.Body); status != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, status)
+		}
+	}
+
+	for i, tc := range []struct {
+		Email  string
+		Invite string
+		Status int
+		Code   string
+	}{
+		{Email: "open@example.com", Status: http.StatusForbidden, Code: "invite_required"},
+		{Email: "other@example.com", Invite: bound, Status: http.StatusForbidden, Code: "invalid_invite"},
+		{Email: "bound@example.com", Invite: bound, Status: http.StatusAccepted},
+		{Email: "bound@example.com", Invite: bound, Status: http.StatusForbidden, Code: "invalid_invite"},
+		// A taken email address responds the same without using the invite.
+		{Email: "admin@example.com", Invite: multi, Status: http.StatusAccepted},
+		{Email: "first@example.com", Invite: multi, Status: http.StatusAccepted},
+		{Email: "second@example.com", Invite: multi, Status: http.StatusAccepted},
+		{Email: "third@example.com", Invite: multi, Status: http.StatusForbidden, Code: "invalid_invite"},
+		{Email: "fourth@example.com", Invite: "unknown", Status: http.StatusForbidden, Code: "invalid_invite"},
+	} {
+		w := ts.do(http.MethodPost, "/auth/register", map[string]string{
+			"email":    tc.Email,
+			"password": "password123",
+			"invite":   tc.Invite,
+		})
+		if w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\": %s", i, tc.Status, w.Code, w.Body.String())
+			continue
+		}
+		if tc.Code != "" {
+			if code := errorCode(t, w); code != tc.Code {
+				t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Code, code)
+			}
+		}
+	}
+
+	u, err := ts.users.ByEmail(context.Background(), "bound@example.com")
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if u.Role == nil || u.Role.ID != 2 || u.InvitedBy != admin.ID {
+		t.Errorf("Expected the invite's role and inviter to be recorded, but got \"%v\" and \"%v\"", u.Role, u.InvitedBy)
+	}
+	if types := ts.audit.types(u.ID); len(types) != 1 || types[0] != audit.TypeInviteRedeemed {
+		t.Errorf("Expected \"%v\", but got \"%v\"", []string{audit.TypeInviteRedeemed}, types)
+	}
+
+	var invites []invite.Invite
+	if err := json.Unmarshal(ts.do(http.MethodGet, "/invites", nil, cookie).Body.Bytes(), &invites); err != nil || len(invites) != 2 {
+		t.Fatalf("Expected two invites, but got \"%v\"", invites)
+	}
+	for i, tc := range []struct {
+		ID     string
+		Status int
+	}{
+		{ID: invites[0].ID.String(), Status: http.StatusNoContent},
+		{ID: invites[0].ID.String(), Status: http.StatusNotFound},
+		{ID: "invalid", Status: http.StatusNotFound},
+	} {
+		if w := ts.do(http.MethodDelete, "/invites/"+tc.ID, nil, cookie); w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/mail"
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/lockout"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// purposeUnlock is the purpose of tokens used by users to unlock their
+// account.
+const purposeUnlock token.Purpose = "unlock"
+
+// checkLockout writes an error response and returns false if login attempts
+// for the email address or from the client's IP address are being delayed.
+// l is optional, if nil attempts are never delayed.
+func checkLockout(w http.ResponseWriter, r *http.Request, l *lockout.Manager, email string) bool {
+	if l == nil {
+		return true
+	}
+	wait, err := l.Check(r.Context(), lockoutEmail(email), server.ClientIP(r))
+	if err != nil {
+		writeInternalError(w, "failed to check failed logins", err)
+		return false
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, "too_many_attempts", "too many failed login attempts, try again later")
+		return false
+	}
+	return true
+}
+
+// recordFailure records a failed login using factor, writing an error
+// response and returning false if it could not be recorded.  u is the
+// account's user, nil if there is no account with the email address.  l is
+// optional, if nil the failure is only audited.
+func recordFailure(w http.ResponseWriter, r *http.Request, l *lockout.Manager, email string, u *user.User, factor string) bool {
+	target := snowflake.Nil
+	if u != nil {
+		target = u.ID
+	}
+	email = lockoutEmail(email)
+	audit.RecordAs(r.Context(), audit.TypeLoginFailed, snowflake.Nil, target, map[string]string{
+		"email":  email,
+		"factor": factor,
+	})
+	if l == nil {
+		return true
+	}
+	locked, err := l.Fail(r.Context(), email, server.ClientIP(r))
+	if err != nil {
+		writeInternalError(w, "failed to record failed login", err)
+		return false
+	}
+	if locked {
+		audit.RecordAs(r.Context(), audit.TypeLocked, snowflake.Nil, target, map[string]string{"reason": "failed_logins"})
+	}
+	return true
+}
+
+func (h *Auth) requestUnlock(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	// The response is the same whether or not the email belongs to a locked
+	// account.
+	if email, err := normalizeEmail(req.Email); err == nil {
+		u, err := h.users.ByEmail(r.Context(), email)
+		switch {
+		case err == nil:
+			if err := h.sendUnlock(r.Context(), u); err != nil {
+				writeInternalError(w, "failed to send unlock email", err)
+				return
+			}
+		case !errors.Is(err, user.ErrNotFound):
+			writeInternalError(w, "failed to send unlock email", err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"message": "if the account exists and is locked, an unlock email has been sent",
+	})
+}
+
+// sendUnlock emails a link to unlock their account to a user, if their
+// account was locked by failed logins.
+func (h *Auth) sendUnlock(ctx context.Context, u *user.User) error {
+	if !u.Locked {
+		return nil
+	}
+	lockedOut, err := h.lockout.LockedOut(ctx, u.Email)
+	if err != nil || !lockedOut {
+		return err
+	}
+	throttled, err := h.tokens.IssuedSince(ctx, purposeUnlock, u.ID, h.tokens.Now().Add(-h.config.ResendInterval))
+	if err != nil || throttled {
+		return err
+	}
+	if err := h.tokens.Revoke(ctx, purposeUnlock, u.ID); err != nil {
+		return err
+	}
+	secret, err := h.tokens.Issue(ctx, purposeUnlock, u.ID, h.config.ResetTTL, u.Email)
+	if err != nil {
+		return err
+	}
+	return h.mailer.Send(ctx, &mail.Message{
+		To:      u.Email,
+		Subject: "Unlock your account",
+		Body: "Your account was locked after too many failed login attempts.  " +
+			"Unlock it by opening the link below.\n\n" +
+			h.link("/unlock", secret) + "\n\n" +
+			"If you did not try to login, someone may be trying to guess your password.\n",
+	})
+}
+
+func (h *Auth) unlock(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	t, err := h.tokens.Consume(r.Context(), purposeUnlock, req.Token)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	u, err := h.users.ByID(r.Context(), t.UserID)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	if u.Email != t.Data {
+		writeTokenError(w, token.ErrInvalid)
+		return
+	}
+	// An account locked by an administrator can not be unlocked by its
+	// owner.
+	lockedOut, err := h.lockout.LockedOut(r.Context(), u.Email)
+	if err != nil {
+		writeInternalError(w, "failed to unlock user", err)
+		return
+	}
+	if !u.Locked || !lockedOut {
+		writeTokenError(w, token.ErrInvalid)
+		return
+	}
+	if err := h.lockout.Unlock(r.Context(), u.ID, u.Email, u.ID, server.ClientIP(r)); err != nil {
+		writeInternalError(w, "failed to unlock user", err)
+		return
+	}
+	audit.RecordAs(r.Context(), audit.TypeUnlocked, u.ID, u.ID, map[string]string{"method": "email"})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Auth) unlockUser(w http.ResponseWriter, r *http.Request) {
+	id := snowflake.Parse(chi.URLParam(r, "id"))
+	if !id.Valid() {
+		writeError(w, http.StatusNotFound, "not_found", "user not found")
+		return
+	}
+	u, err := h.users.ByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "user not found")
+			return
+		}
+		writeInternalError(w, "failed to get user", err)
+		return
+	}
+	actor, _ := user.FromContext(r.Context())
+	if err := h.lockout.Unlock(r.Context(), u.ID, u.Email, actor.ID, server.ClientIP(r)); err != nil {
+		writeInternalError(w, "failed to unlock user", err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeUnlocked, u.ID, map[string]string{"method": "administrator"})
+	u.Locked = false
+	writeJSON(w, http.StatusOK, u.As(user.ViewAdmin))
+}
+
+// lockoutEmail returns the email address failed logins are tracked by.
+// Invalid addresses are tracked as given, so they are delayed the same as
+// valid ones.
+func lockoutEmail(v string) string {
+	if email, err := normalizeEmail(v); err == nil {
+		return email
+	}
+	return strings.ToLower(strings.TrimSpace(v))
+}
@@ -0,0 +1,260 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/lockout"
+	"github.com/matthewpi/cosmos/privacy"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// memoryLockout is an in-memory lockout.Store used for testing, it locks
+// accounts in a memoryUsers.
+type memoryLockout struct {
+	mu       sync.Mutex
+	users    *memoryUsers
+	failures map[string]lockout.Failures
+}
+
+func (s *memoryLockout) Get(_ context.Context, key string) (*lockout.Failures, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.failures[key]
+	if !ok {
+		return nil, lockout.ErrNotFound
+	}
+	return &f, nil
+}
+
+func (s *memoryLockout) Fail(_ context.Context, key string, t, since time.Time) (*lockout.Failures, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.failures[key]
+	if f.LastFailedAt.Before(since) {
+		f.Failures = 0
+	}
+	f.Key = key
+	f.Failures++
+	f.LastFailedAt = t
+	s.failures[key] = f
+	return &f, nil
+}
+
+func (s *memoryLockout) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, key)
+	return nil
+}
+
+func (s *memoryLockout) Lock(ctx context.Context, email string, e *lockout.Event) (bool, error) {
+	u, err := s.users.ByEmail(ctx, email)
+	if err != nil || u.Locked {
+		return false, nil
+	}
+	u.Locked = true
+	return true, s.users.Update(ctx, u)
+}
+
+func (s *memoryLockout) Unlock(ctx context.Context, key string, e *lockout.Event) error {
+	u, err := s.users.ByID(ctx, e.UserID)
+	if err != nil {
+		return err
+	}
+	u.Locked = false
+	if err := s.users.Update(ctx, u); err != nil {
+		return err
+	}
+	return s.Reset(ctx, key)
+}
+
+func TestAuth_Lockout(t *testing.T) {
+	now := time.Now()
+	ts := newTestServer(func(ts *testServer) func(chi.Router) {
+		m := lockout.NewManager(&memoryLockout{
+			users:    ts.users,
+			failures: make(map[string]lockout.Failures),
+		}, &lockout.Config{
+			Threshold:      3,
+			FreeAttempts:   1,
+			IPFreeAttempts: 100,
+			Delay:          time.Second,
+			MaxDelay:       time.Minute,
+			Factor:         2,
+			ResetAfter:     time.Hour,
+		})
+		m.Now = func() time.Time { return now }
+		return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail, api.WithLockout(m)).Routes
+	})
+	credentials := map[string]string{"email": "user@example.com", "password": "password123"}
+	wrong := map[string]string{"email": "user@example.com", "password": "wrong-password"}
+	ts.do(http.MethodPost, "/auth/register", credentials)
+
+	for i, tc := range []struct {
+		Body   map[string]string
+		Wait   time.Duration
+		Status int
+		Code   string
+	}{
+		{Body: wrong, Status: http.StatusUnauthorized, Code: "invalid_credentials"},
+		{Body: wrong, Status: http.StatusUnauthorized, Code: "invalid_credentials"},
+		// The correct password is rejected while attempts are delayed.
+		{Body: credentials, Status: http.StatusTooManyRequests, Code: "too_many_attempts"},
+		{Body: wrong, Wait: 2 * time.Second, Status: http.StatusUnauthorized, Code: "invalid_credentials"},
+		{Body: credentials, Wait: 4 * time.Second, Status: http.StatusForbidden, Code: "account_locked"},
+	} {
+		now = now.Add(tc.Wait)
+		w := ts.do(http.MethodPost, "/auth/login", tc.Body)
+		if w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+			continue
+		}
+		if code := errorCode(t, w); code != tc.Code {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, tc.Code, code)
+		}
+		if tc.Status == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "2" {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, "2", w.Header().Get("Retry-After"))
+		}
+	}
+
+	// The owner of the account can unlock it by email.
+	ts.do(http.MethodPost, "/auth/unlock", map[string]string{"email": "user@example.com"})
+	secret := ts.mail.linkToken(t, "user@example.com")
+	for i, status := range []int{http.StatusNoContent, http.StatusBadRequest} {
+		if w := ts.do(http.MethodPost, "/auth/unlock/confirm", map[string]string{"token": secret}); w.Code != status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, status, w.Code)
+		}
+	}
+	if w := ts.do(http.MethodPost, "/auth/login", credentials); w.Code != http.StatusOK {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusOK, w.Code)
+	}
+}
+
+func TestAuth_LockoutReauthentication(t *testing.T) {
+	now := time.Now()
+	var m *lockout.Manager
+	ts := newTestServer(
+		func(ts *testServer) func(chi.Router) {
+			m = lockout.NewManager(&memoryLockout{
+				users:    ts.users,
+				failures: make(map[string]lockout.Failures),
+			}, &lockout.Config{
+				Threshold:      3,
+				FreeAttempts:   1,
+				IPFreeAttempts: 100,
+				Delay:          time.Second,
+				MaxDelay:       time.Minute,
+				Factor:         2,
+				ResetAfter:     time.Hour,
+			})
+			m.Now = func() time.Time { return now }
+			return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail, api.WithLockout(m)).Routes
+		},
+		func(ts *testServer) func(chi.Router) {
+			return api.NewPrivacy(privacy.NewManager(nil, ts.users, ts.manager, ts.log, nil, nil, nil), m).Routes
+		},
+	)
+	credentials := map[string]string{"email": "user@example.com", "password": "password123"}
+	ts.do(http.MethodPost, "/auth/register", credentials)
+	cookie := ts.do(http.MethodPost, "/auth/login", credentials).Result().Cookies()[0]
+
+	// Wrong passwords confirming sensitive changes count as failed logins.
+	changeEmail := map[string]string{"email": "new@example.com", "password": "password123"}
+	wrong := map[string]string{"password": "wrong-password"}
+	for i, tc := range []struct {
+		Path   string
+		Body   map[string]string
+		Wait   time.Duration
+		Status int
+		Code   string
+	}{
+		{Path: "/auth/email", Body: wrong, Status: http.StatusUnauthorized, Code: "invalid_credentials"},
+		{Path: "/auth/account/deletion", Body: wrong, Status: http.StatusUnauthorized, Code: "invalid_credentials"},
+		// The correct password is rejected while attempts are delayed.
+		{Path: "/auth/email", Body: changeEmail, Status: http.StatusTooManyRequests, Code: "too_many_attempts"},
+		{Path: "/auth/account/deletion", Body: wrong, Wait: 2 * time.Second, Status: http.StatusUnauthorized, Code: "invalid_credentials"},
+	} {
+		now = now.Add(tc.Wait)
+		w := ts.do(http.MethodPost, tc.Path, tc.Body, cookie)
+		if w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+			continue
+		}
+		if code := errorCode(t, w); code != tc.Code {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, tc.Code, code)
+		}
+	}
+
+	now = now.Add(4 * time.Second)
+	w := ts.do(http.MethodPost, "/auth/login", credentials)
+	if code := errorCode(t, w); w.Code != http.StatusForbidden || code != "account_locked" {
+		t.Errorf("Expected \"%s\", but got \"%s\"", "account_locked", code)
+	}
+}
+
+func TestAuth_UnlockUser(t *testing.T) {
+	ts := newTestServer(func(ts *testServer) func(chi.Router) {
+		m := lockout.NewManager(&memoryLockout{
+			users:    ts.users,
+			failures: make(map[string]lockout.Failures),
+		}, nil)
+		return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail, api.WithLockout(m)).Routes
+	})
+	admin, _ := user.New("admin@example.com", []byte("password123"))
+	admin.Role = &role.Role{ID: 1, Permissions: role.Permissions{lockout.PermissionUnlock}}
+	locked, _ := user.New("locked@example.com", []byte("password123"))
+	locked.Locked = true
+	_ = ts.users.Create(context.Background(), admin)
+	_ = ts.users.Create(context.Background(), locked)
+
+	// An account locked by an administrator can not be unlocked by email.
+	ts.do(http.MethodPost, "/auth/unlock", map[string]string{"email": "locked@example.com"})
+	if msg := ts.mail.last("locked@example.com"); msg != nil {
+		t.Errorf("Expected no email to be sent, but got \"%s\"", msg.Subject)
+	}
+
+	path := "/api/v1/users/" + locked.ID.String() + "/unlock"
+	if w := ts.do(http.MethodPost, path, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusUnauthorized, w.Code)
+	}
+	cookie := ts.do(http.MethodPost, "/auth/login", map[string]string{
+		"email":    "admin@example.com",
+		"password": "password123",
+	}).Result().Cookies()[0]
+	if w := ts.do(http.MethodPost, path, nil, cookie); w.Code != http.StatusOK {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusOK, w.Code)
+	}
+	if u, _ := ts.users.ByID(context.Background(), locked.ID); u.Locked {
+		t.Errorf("Expected the account to be unlocked")
+	}
+}
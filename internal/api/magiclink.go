@@ -0,0 +1,139 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/matthewpi/cosmos/internal/mail"
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/magiclink"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// magicLinkCookie is the name of the cookie binding a magic link to the
+// browser that requested it.
+const magicLinkCookie = "cosmos_magic_link"
+
+func (h *Auth) requestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_email", "invalid email address")
+		return
+	}
+	secret, nonce, u, err := h.magicLinks.Request(r.Context(), email, server.ClientIP(r))
+	if err != nil {
+		writeMagicLinkError(w, err, h.magicLinks.Config())
+		return
+	}
+	// The cookie is set whether or not the account exists, so the response
+	// does not reveal it.
+	http.SetCookie(w, h.sessions.Cookie(magicLinkCookie, nonce, h.magicLinks.Config().TTL))
+	if u != nil && !u.Locked {
+		if err := h.sendMagicLink(r.Context(), u, secret); err != nil {
+			writeInternalError(w, "failed to send magic link", err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"message": "if an account exists with the email address, a login link has been sent",
+	})
+}
+
+// sendMagicLink emails a magic link to a user.
+func (h *Auth) sendMagicLink(ctx context.Context, u *user.User, secret string) error {
+	return h.mailer.Send(ctx, &mail.Message{
+		To:      u.Email,
+		Subject: "Your login link",
+		Body: "Login to your account by opening the link below in the browser you requested it from.  " +
+			"The link expires in " + h.magicLinks.Config().TTL.String() + " and can only be used once.\n\n" +
+			h.link("/magic-link", secret) + "\n\n" +
+			"If you did not request this link, you can ignore this email.\n",
+	})
+}
+
+func (h *Auth) loginMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	var nonce string
+	if c, err := r.Cookie(magicLinkCookie); err == nil {
+		nonce = c.Value
+	}
+	u, err := h.magicLinks.Redeem(r.Context(), req.Token, nonce)
+	if err != nil {
+		writeMagicLinkError(w, err, h.magicLinks.Config())
+		return
+	}
+	http.SetCookie(w, h.sessions.Cookie(magicLinkCookie, "", -1))
+	if u.Locked {
+		writeError(w, http.StatusForbidden, "account_locked", "account is locked")
+		return
+	}
+	// Opening the link proves the user owns the email address.
+	if !u.Confirmed {
+		if err := h.users.Confirm(r.Context(), u.ID); err != nil {
+			writeInternalError(w, "failed to confirm user", err)
+			return
+		}
+		u.Confirmed = true
+	}
+	if h.twoFactor != nil {
+		enabled, err := h.twoFactor.Enabled(r.Context(), u.ID)
+		if err != nil {
+			writeInternalError(w, "failed to check two-factor authentication", err)
+			return
+		}
+		if enabled {
+			h.challengeTwoFactor(w, r, u)
+			return
+		}
+	}
+	h.startSession(w, r, u, "magic_link")
+}
+
+// writeMagicLinkError writes the response for an error returned by
+// magiclink.Manager.
+func writeMagicLinkError(w http.ResponseWriter, err error, c *magiclink.Config) {
+	switch {
+	case errors.Is(err, magiclink.ErrInvalid):
+		writeError(w, http.StatusBadRequest, "invalid_token", "invalid or expired link")
+	case errors.Is(err, magiclink.ErrThrottled):
+		w.Header().Set("Retry-After", strconv.Itoa(int(c.Window.Seconds())))
+		writeError(w, http.StatusTooManyRequests, "too_many_requests", "too many login links requested, try again later")
+	default:
+		writeInternalError(w, "failed to handle magic link", err)
+	}
+}
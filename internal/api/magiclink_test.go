@@ -0,0 +1,239 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/magiclink"
+	"github.com/matthewpi/cosmos/twofactor"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// memoryMagicLinks is an in-memory magiclink.Store used for testing.
+type memoryMagicLinks struct {
+	mu    sync.Mutex
+	links map[string]magiclink.Link
+	used  map[string]bool
+}
+
+func (s *memoryMagicLinks) Create(_ context.Context, l *magiclink.Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.links[l.ID] = *l
+	return nil
+}
+
+func (s *memoryMagicLinks) Consume(_ context.Context, id, nonce string, _ time.Time) (*magiclink.Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[id]
+	if !ok || l.Nonce != nonce || s.used[id] {
+		return nil, magiclink.ErrNotFound
+	}
+	s.used[id] = true
+	return &l, nil
+}
+
+func (s *memoryMagicLinks) CountByEmail(_ context.Context, email string, t time.Time) (int, error) {
+	return s.count(func(l magiclink.Link) bool { return l.Email == email && l.CreatedAt.After(t) }), nil
+}
+
+func (s *memoryMagicLinks) CountByIP(_ context.Context, ip string, t time.Time) (int, error) {
+	return s.count(func(l magiclink.Link) bool { return l.IP == ip && l.CreatedAt.After(t) }), nil
+}
+
+func (s *memoryMagicLinks) Prune(context.Context, time.Time, time.Time) error {
+	return nil
+}
+
+func (s *memoryMagicLinks) count(f func(l magiclink.Link) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, l := range s.links {
+		if f(l) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestAuth_MagicLink(t *testing.T) {
+	ts := newTestServer(func(ts *testServer) func(chi.Router) {
+		m := magiclink.NewManager(&memoryMagicLinks{
+			links: make(map[string]magiclink.Link),
+			used:  make(map[string]bool),
+		}, ts.users, nil)
+		return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail, api.WithMagicLinks(m)).Routes
+	})
+	ts.config.RequireConfirmation = true
+	u, _ := user.New("user@example.com", nil)
+	_ = ts.users.Create(context.Background(), u)
+
+	request := func(email string) *http.Cookie {
+		w := ts.do(http.MethodPost, "/auth/magic-link", map[string]string{"email": email})
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusAccepted, w.Code, w.Body.String())
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Value == "" {
+			t.Fatalf("Expected a magic link cookie to be set")
+		}
+		return cookies[0]
+	}
+
+	// Requesting a link for an unknown email address looks the same, but
+	// nothing is sent.
+	request("other@example.com")
+	if ts.mail.last("other@example.com") != nil {
+		t.Errorf("Expected no email to be sent for an unknown email address")
+	}
+
+	cookie := request("User@Example.com")
+	secret := ts.mail.linkToken(t, "user@example.com")
+	for i, tc := range []struct {
+		Token   string
+		Cookies []*http.Cookie
+	}{
+		{Token: secret},
+		{Token: secret, Cookies: []*http.Cookie{{Name: cookie.Name, Value: "other"}}},
+		{Token: "invalid", Cookies: []*http.Cookie{cookie}},
+	} {
+		w := ts.do(http.MethodPost, "/auth/magic-link/login", map[string]string{"token": tc.Token}, tc.Cookies...)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, http.StatusBadRequest, w.Code)
+			continue
+		}
+		if code := errorCode(t, w); code != "invalid_token" {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, "invalid_token", code)
+		}
+	}
+
+	w := ts.do(http.MethodPost, "/auth/magic-link/login", map[string]string{"token": secret}, cookie)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var started, cleared bool
+	for _, c := range w.Result().Cookies() {
+		switch {
+		case c.Name == cookie.Name:
+			cleared = c.MaxAge < 0
+		case c.Value != "":
+			started = true
+		}
+	}
+	if !started || !cleared {
+		t.Errorf("Expected a session to be started and the magic link cookie to be cleared")
+	}
+	if got, _ := ts.users.ByID(context.Background(), u.ID); !got.Confirmed {
+		t.Errorf("Expected logging in with a magic link to confirm the email address")
+	}
+	if types := ts.audit.types(u.ID); len(types) != 1 || types[0] != audit.TypeLogin {
+		t.Errorf("Expected \"%v\", but got \"%v\"", []string{audit.TypeLogin}, types)
+	}
+	if w := ts.do(http.MethodPost, "/auth/magic-link/login", map[string]string{"token": secret}, cookie); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a magic link to only be usable once, but got \"%d\"", w.Code)
+	}
+
+	// The default configuration allows 3 links per email address an hour.
+	request("user@example.com")
+	request("user@example.com")
+	w = ts.do(http.MethodPost, "/auth/magic-link", map[string]string{"email": "user@example.com"})
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected \"%d\", but got \"%d\"", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a Retry-After header")
+	}
+}
+
+func TestAuth_MagicLink_Reauthenticate(t *testing.T) {
+	ts := newTestServer(func(ts *testServer) func(chi.Router) {
+		m := magiclink.NewManager(&memoryMagicLinks{
+			links: make(map[string]magiclink.Link),
+			used:  make(map[string]bool),
+		}, ts.users, nil)
+		return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail,
+			api.WithMagicLinks(m),
+			api.WithTwoFactor(twofactor.NewManager(newMemoryTwoFactor(), nil)),
+		).Routes
+	})
+	now := time.Now()
+	ts.manager.Now = func() time.Time { return now }
+	u, _ := user.New("user@example.com", nil)
+	_ = ts.users.Create(context.Background(), u)
+
+	// login logs in with a new magic link, returning the session cookie.
+	login := func() *http.Cookie {
+		link := ts.do(http.MethodPost, "/auth/magic-link", map[string]string{"email": "user@example.com"}).Result().Cookies()[0]
+		w := ts.do(http.MethodPost, "/auth/magic-link/login", map[string]string{"token": ts.mail.linkToken(t, "user@example.com")}, link)
+		for _, c := range w.Result().Cookies() {
+			if c.Name != link.Name {
+				return c
+			}
+		}
+		t.Fatalf("Expected a session to be started, but got \"%d\": %s", w.Code, w.Body.String())
+		return nil
+	}
+
+	// Users without a password confirm their identity by having logged in
+	// recently.
+	cookie := login()
+	if w := ts.do(http.MethodPost, "/auth/email", map[string]string{"email": "new@example.com"}, cookie); w.Code != http.StatusAccepted {
+		t.Errorf("Expected \"%d\", but got \"%d\": %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+
+	now = now.Add(ts.config.ReauthenticationTTL + time.Second)
+	for i, tc := range []struct {
+		Method string
+		Path   string
+		Body   interface{}
+	}{
+		{Method: http.MethodPost, Path: "/auth/email", Body: map[string]string{"email": "other@example.com"}},
+		{Method: http.MethodDelete, Path: "/auth/2fa/totp", Body: map[string]string{}},
+		{Method: http.MethodPost, Path: "/auth/2fa/recovery-codes", Body: map[string]string{}},
+	} {
+		w := ts.do(tc.Method, tc.Path, tc.Body, cookie)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, http.StatusUnauthorized, w.Code)
+			continue
+		}
+		if code := errorCode(t, w); code != "reauthentication_required" {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, "reauthentication_required", code)
+		}
+	}
+
+	// Logging in again with a new link confirms their identity.
+	if w := ts.do(http.MethodDelete, "/auth/2fa/totp", map[string]string{}, login()); w.Code != http.StatusNoContent {
+		t.Errorf("Expected \"%d\", but got \"%d\": %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+}
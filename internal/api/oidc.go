@@ -0,0 +1,135 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/matthewpi/cosmos/identity"
+	"github.com/matthewpi/cosmos/internal/oidc"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// stateCookie is the name of the cookie binding a login with an OpenID
+// provider to the browser that started it.
+const stateCookie = "cosmos_oidc_state"
+
+func (h *Auth) beginOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	state, u, err := h.identities.Begin(r.Context())
+	if err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+	http.SetCookie(w, h.sessions.Cookie(stateCookie, state, h.identities.Config().StateTTL))
+	writeJSON(w, http.StatusOK, map[string]string{"url": u})
+}
+
+func (h *Auth) finishOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		State string `json:"state"`
+		Code  string `json:"code"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	// The state must have been issued to this browser, otherwise an
+	// attacker could log a victim in to the attacker's account by sending
+	// them the attacker's redirect.
+	c, err := r.Cookie(stateCookie)
+	if err != nil || req.State == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(req.State)) != 1 {
+		writeError(w, http.StatusBadRequest, "invalid_state", "invalid or expired state")
+		return
+	}
+	http.SetCookie(w, h.sessions.Cookie(stateCookie, "", -1))
+
+	u, err := h.identities.Finish(r.Context(), req.State, req.Code)
+	if err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+	if u.Locked {
+		writeError(w, http.StatusForbidden, "account_locked", "account is locked")
+		return
+	}
+	if h.config.RequireConfirmation && !u.Confirmed {
+		writeError(w, http.StatusForbidden, "email_unconfirmed", "email address has not been confirmed")
+		return
+	}
+	// The provider's own second factor can not be verified, so a user's
+	// two-factor authentication still applies.
+	if h.twoFactor != nil {
+		enabled, err := h.twoFactor.Enabled(r.Context(), u.ID)
+		if err != nil {
+			writeInternalError(w, "failed to check two-factor authentication", err)
+			return
+		}
+		if enabled {
+			h.challengeTwoFactor(w, r, u)
+			return
+		}
+	}
+	h.startSession(w, r, u, "oidc")
+}
+
+func (h *Auth) listIdentities(w http.ResponseWriter, r *http.Request) {
+	u, _ := user.FromContext(r.Context())
+	identities, err := h.identities.List(r.Context(), u.ID)
+	if err != nil {
+		writeInternalError(w, "failed to list identities", err)
+		return
+	}
+	if identities == nil {
+		identities = []*identity.Identity{}
+	}
+	writeJSON(w, http.StatusOK, identities)
+}
+
+// writeOIDCError writes the response for an error returned by
+// identity.Manager.
+func writeOIDCError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, identity.ErrInvalidState):
+		writeError(w, http.StatusBadRequest, "invalid_state", "invalid or expired state")
+	case errors.Is(err, identity.ErrEmailUnverified):
+		writeError(w, http.StatusForbidden, "email_unverified", "email address has not been verified by the identity provider")
+	case errors.Is(err, identity.ErrNoAccount):
+		writeError(w, http.StatusForbidden, "no_account", "no account with the email address")
+	case errors.Is(err, oidc.ErrDiscovery):
+		writeError(w, http.StatusBadGateway, "provider_unavailable", "identity provider is unavailable")
+	case errors.Is(err, oidc.ErrExchange):
+		writeError(w, http.StatusBadRequest, "invalid_code", "invalid or expired authorization code")
+	case errors.Is(err, oidc.ErrInvalidToken),
+		errors.Is(err, oidc.ErrUnsupportedAlgorithm),
+		errors.Is(err, oidc.ErrUnknownKey),
+		errors.Is(err, oidc.ErrInvalidSignature),
+		errors.Is(err, oidc.ErrInvalidIssuer),
+		errors.Is(err, oidc.ErrInvalidAudience),
+		errors.Is(err, oidc.ErrExpired),
+		errors.Is(err, oidc.ErrInvalidNonce):
+		writeError(w, http.StatusUnauthorized, "invalid_credentials", "invalid id token")
+	default:
+		writeInternalError(w, "failed to handle identity provider login", err)
+	}
+}
@@ -0,0 +1,207 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/identity"
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/oidc"
+	"github.com/matthewpi/cosmos/internal/oidc/oidctest"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// memoryIdentities is an in-memory identity.Store used for testing.
+type memoryIdentities struct {
+	mu         sync.Mutex
+	identities map[string]identity.Identity
+	states     map[string]*identity.State
+}
+
+func newMemoryIdentities() *memoryIdentities {
+	return &memoryIdentities{
+		identities: make(map[string]identity.Identity),
+		states:     make(map[string]*identity.State),
+	}
+}
+
+func (s *memoryIdentities) Create(_ context.Context, i *identity.Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identities[i.ID] = *i
+	return nil
+}
+
+func (s *memoryIdentities) ByID(_ context.Context, id string) (*identity.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.identities[id]
+	if !ok {
+		return nil, identity.ErrNotFound
+	}
+	return &i, nil
+}
+
+func (s *memoryIdentities) ByUser(_ context.Context, userID snowflake.Snowflake) ([]*identity.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var identities []*identity.Identity
+	for _, i := range s.identities {
+		if i.UserID == userID {
+			i := i
+			identities = append(identities, &i)
+		}
+	}
+	return identities, nil
+}
+
+func (s *memoryIdentities) CreateState(_ context.Context, st *identity.State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[st.ID] = st
+	return nil
+}
+
+func (s *memoryIdentities) ConsumeState(_ context.Context, id string) (*identity.State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.states[id]
+	if !ok {
+		return nil, identity.ErrNotFound
+	}
+	delete(s.states, id)
+	return st, nil
+}
+
+// cookie returns the cookie with the given name set by a response.
+func cookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestAuth_OIDC(t *testing.T) {
+	iss, err := oidctest.NewIssuer("https://idp.example.com", "client", "secret", "https://example.com/login/oidc")
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	ts := newTestServer(func(ts *testServer) func(chi.Router) {
+		c := oidc.DefaultConfig()
+		c.Issuer = iss.URL
+		c.ClientID = iss.ClientID
+		c.ClientSecret = iss.ClientSecret
+		c.RedirectURL = iss.RedirectURL
+		m := identity.NewManager(newMemoryIdentities(), ts.users, oidc.NewProvider(c, iss.Client()))
+		return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail, api.WithOIDC(m)).Routes
+	})
+	ts.do(http.MethodPost, "/auth/register", map[string]string{"email": "user@example.com", "password": "password123"})
+
+	// begin starts a login, returning the state cookie and the redirect
+	// back from the provider.
+	begin := func(id oidctest.Identity) (*http.Cookie, map[string]string) {
+		w := ts.do(http.MethodPost, "/auth/oidc/login", nil)
+		var body struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+		state := cookie(w.Result().Cookies(), "cosmos_oidc_state")
+		if state == nil || !state.HttpOnly {
+			t.Fatalf("Expected an HttpOnly state cookie, but got \"%v\"", state)
+		}
+		redirect, err := iss.Authorize(body.URL, id)
+		if err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+		return state, map[string]string{
+			"state": redirect.Query().Get("state"),
+			"code":  redirect.Query().Get("code"),
+		}
+	}
+
+	id := oidctest.Identity{Subject: "1234", Email: "user@example.com", EmailVerified: true}
+	state, callback := begin(id)
+
+	// The callback must come from the browser that started the login.
+	if w := ts.do(http.MethodPost, "/auth/oidc/callback", callback); w.Code != http.StatusBadRequest || errorCode(t, w) != "invalid_state" {
+		t.Errorf("Expected \"%s\", but got \"%d\": %s", "invalid_state", w.Code, w.Body.String())
+	}
+	other, _ := begin(id)
+	if w := ts.do(http.MethodPost, "/auth/oidc/callback", callback, other); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusBadRequest, w.Code)
+	}
+
+	w := ts.do(http.MethodPost, "/auth/oidc/callback", callback, state)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	session := cookie(w.Result().Cookies(), "cosmos_session")
+	if session == nil {
+		t.Fatalf("Expected a session to be created")
+	}
+	if c := cookie(w.Result().Cookies(), "cosmos_oidc_state"); c == nil || c.MaxAge >= 0 {
+		t.Errorf("Expected the state cookie to be cleared, but got \"%v\"", c)
+	}
+	u, _ := ts.users.ByEmail(context.Background(), "user@example.com")
+	if types := ts.audit.types(u.ID); len(types) == 0 || types[len(types)-1] != "login" {
+		t.Errorf("Expected \"%s\", but got \"%v\"", "login", types)
+	}
+
+	// The state can not be used again.
+	if w := ts.do(http.MethodPost, "/auth/oidc/callback", callback, state); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusBadRequest, w.Code)
+	}
+
+	var identities []identity.Identity
+	if err := json.Unmarshal(ts.do(http.MethodGet, "/auth/oidc/identities", nil, session).Body.Bytes(), &identities); err != nil || len(identities) != 1 {
+		t.Fatalf("Expected a single identity, but got \"%v\"", identities)
+	}
+	if identities[0].Subject != "1234" {
+		t.Errorf("Expected \"%s\", but got \"%s\"", "1234", identities[0].Subject)
+	}
+
+	for i, tc := range []struct {
+		Identity oidctest.Identity
+		Code     string
+	}{
+		{Identity: oidctest.Identity{Subject: "5678", Email: "user@example.com"}, Code: "email_unverified"},
+		{Identity: oidctest.Identity{Subject: "5678", Email: "other@example.com", EmailVerified: true}, Code: "no_account"},
+	} {
+		state, callback := begin(tc.Identity)
+		w := ts.do(http.MethodPost, "/auth/oidc/callback", callback, state)
+		if w.Code != http.StatusForbidden || errorCode(t, w) != tc.Code {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%d\": %s", i, tc.Code, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,369 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/organization"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// Organizations serves the organizations API.
+type Organizations struct {
+	organizations *organization.Manager
+}
+
+// NewOrganizations returns a new Organizations.
+func NewOrganizations(m *organization.Manager) *Organizations {
+	return &Organizations{organizations: m}
+}
+
+// Routes registers the organizations API's routes.  Routes under
+// "/organizations/{organization}" are served with the organization active,
+// see organization.NewContext.
+//
+// Organizations can be read with an access token, but changing them requires
+// a session, and an impersonator can not delete or transfer an organization
+// nor change its members.
+func (h *Organizations) Routes(r chi.Router) {
+	r.Route("/organizations", func(r chi.Router) {
+		r.Use(server.RequireAuthentication)
+		r.Get("/", h.list)
+		r.With(session.RequireSession).Post("/", h.create)
+		r.With(session.RequireSession).Post("/invitations/accept", h.accept)
+		r.Route("/{organization}", func(r chi.Router) {
+			r.Use(h.tenant)
+			r.Get("/", h.get)
+			r.With(session.RequireSession).Patch("/", h.update)
+			r.With(session.RequireSession, session.RejectImpersonation).Delete("/", h.delete)
+			r.With(session.RequireSession, session.RejectImpersonation).Post("/transfer", h.transfer)
+			r.Get("/members", h.members)
+			r.With(session.RequireSession, session.RejectImpersonation).Patch("/members/{user}", h.setRole)
+			r.With(session.RequireSession, session.RejectImpersonation).Delete("/members/{user}", h.removeMember)
+			r.Get("/invitations", h.invitations)
+			r.With(session.RequireSession).Post("/invitations", h.invite)
+			r.With(session.RequireSession).Delete("/invitations/{id}", h.revokeInvitation)
+		})
+	})
+}
+
+// tenant is a middleware that makes the organization in the URL the active
+// organization, responding as if it does not exist unless the authenticated
+// user is a member.
+func (h *Organizations) tenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := user.FromContext(r.Context())
+		id := snowflake.Parse(chi.URLParam(r, "organization"))
+		if !id.Valid() {
+			writeError(w, http.StatusNotFound, "not_found", "organization not found")
+			return
+		}
+		o, m, err := h.organizations.Load(r.Context(), id, u.ID)
+		if err != nil {
+			writeOrganizationError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(organization.NewContext(r.Context(), o, m)))
+	})
+}
+
+// memberView is a membership as listed to the members of an organization.
+type memberView struct {
+	*organization.Membership
+
+	// Role is the member's role within the organization, if any.
+	Role *role.Role `json:"role"`
+}
+
+// viewMember returns the memberView of a membership.
+func viewMember(m *organization.Membership) memberView {
+	return memberView{Membership: m, Role: m.Role}
+}
+
+func (h *Organizations) list(w http.ResponseWriter, r *http.Request) {
+	u, _ := user.FromContext(r.Context())
+	organizations, err := h.organizations.List(r.Context(), u.ID)
+	if err != nil {
+		writeInternalError(w, "failed to list organizations", err)
+		return
+	}
+	if organizations == nil {
+		organizations = []*organization.Organization{}
+	}
+	writeJSON(w, http.StatusOK, organizations)
+}
+
+func (h *Organizations) create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	o, err := h.organizations.Create(r.Context(), u, req.Name)
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeOrganizationCreated, snowflake.Nil, map[string]interface{}{
+		"organization_id": o.ID,
+		"name":            o.Name,
+	})
+	writeJSON(w, http.StatusCreated, o)
+}
+
+// accept accepts an invitation on behalf of the authenticated user.
+func (h *Organizations) accept(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	o, m, err := h.organizations.Accept(r.Context(), u, req.Code)
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeMemberJoined, u.ID, map[string]interface{}{
+		"organization_id": o.ID,
+		"role_id":         m.RoleID,
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"organization": o,
+		"membership":   viewMember(m),
+	})
+}
+
+// get returns the active organization and the authenticated user's
+// membership of it.
+func (h *Organizations) get(w http.ResponseWriter, r *http.Request) {
+	o, m, _ := organization.FromContext(r.Context())
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"organization": o,
+		"membership":   viewMember(m),
+	})
+}
+
+func (h *Organizations) update(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	o, err := h.organizations.Rename(r.Context(), req.Name)
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeOrganizationUpdated, snowflake.Nil, map[string]interface{}{
+		"organization_id": o.ID,
+		"name":            o.Name,
+	})
+	writeJSON(w, http.StatusOK, o)
+}
+
+func (h *Organizations) delete(w http.ResponseWriter, r *http.Request) {
+	o, _, _ := organization.FromContext(r.Context())
+	if err := h.organizations.Delete(r.Context()); err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeOrganizationDeleted, snowflake.Nil, map[string]interface{}{
+		"organization_id": o.ID,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// transfer transfers ownership of the active organization to another member.
+func (h *Organizations) transfer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID snowflake.Snowflake `json:"user_id"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	o, _, _ := organization.FromContext(r.Context())
+	if err := h.organizations.Transfer(r.Context(), req.UserID); err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeOrganizationTransferred, req.UserID, map[string]interface{}{
+		"organization_id": o.ID,
+		"previous_owner":  o.OwnerID,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Organizations) members(w http.ResponseWriter, r *http.Request) {
+	members, err := h.organizations.Members(r.Context())
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	views := make([]memberView, len(members))
+	for i, m := range members {
+		views[i] = viewMember(m)
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// setRole changes a member's role within the active organization, a null
+// role_id removes their role.
+func (h *Organizations) setRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoleID snowflake.Snowflake `json:"role_id"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	o, _, _ := organization.FromContext(r.Context())
+	m, err := h.organizations.SetRole(r.Context(), snowflake.Parse(chi.URLParam(r, "user")), req.RoleID)
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeMemberRoleChanged, m.UserID, map[string]interface{}{
+		"organization_id": o.ID,
+		"role_id":         m.RoleID,
+	})
+	writeJSON(w, http.StatusOK, viewMember(m))
+}
+
+// removeMember removes a member from the active organization, members may
+// remove themselves to leave it.
+func (h *Organizations) removeMember(w http.ResponseWriter, r *http.Request) {
+	o, _, _ := organization.FromContext(r.Context())
+	id := snowflake.Parse(chi.URLParam(r, "user"))
+	if err := h.organizations.RemoveMember(r.Context(), id); err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeMemberRemoved, id, map[string]interface{}{
+		"organization_id": o.ID,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Organizations) invitations(w http.ResponseWriter, r *http.Request) {
+	invitations, err := h.organizations.Invitations(r.Context())
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	if invitations == nil {
+		invitations = []*organization.Invitation{}
+	}
+	writeJSON(w, http.StatusOK, invitations)
+}
+
+// invite invites a user to the active organization by email address, the
+// code is only ever included in this response.
+func (h *Organizations) invite(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email  string              `json:"email"`
+		RoleID snowflake.Snowflake `json:"role_id"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_email", "invalid email address")
+		return
+	}
+	i, code, err := h.organizations.Invite(r.Context(), email, req.RoleID)
+	if err != nil {
+		writeOrganizationError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeMemberInvited, snowflake.Nil, map[string]interface{}{
+		"organization_id": i.OrganizationID,
+		"id":              i.ID,
+		"email":           i.Email,
+		"role_id":         i.RoleID,
+	})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"invitation": i,
+		"code":       code,
+	})
+}
+
+func (h *Organizations) revokeInvitation(w http.ResponseWriter, r *http.Request) {
+	o, _, _ := organization.FromContext(r.Context())
+	id := snowflake.Parse(chi.URLParam(r, "id"))
+	if !id.Valid() {
+		writeError(w, http.StatusNotFound, "not_found", "invitation not found")
+		return
+	}
+	if err := h.organizations.RevokeInvitation(r.Context(), id); err != nil {
+		if errors.Is(err, organization.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "invitation not found")
+			return
+		}
+		writeOrganizationError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeInvitationRevoked, snowflake.Nil, map[string]interface{}{
+		"organization_id": o.ID,
+		"id":              id,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeOrganizationError writes the response for an error returned by
+// organization.Manager.
+func writeOrganizationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, organization.ErrInvalidName):
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+	case errors.Is(err, organization.ErrNotFound),
+		errors.Is(err, organization.ErrNoOrganization):
+		writeError(w, http.StatusNotFound, "not_found", "organization not found")
+	case errors.Is(err, organization.ErrNotMember):
+		writeError(w, http.StatusNotFound, "not_found", "member not found")
+	case errors.Is(err, organization.ErrInvalidInvitation):
+		writeError(w, http.StatusBadRequest, "invalid_invitation", "invalid or expired invitation")
+	case errors.Is(err, organization.ErrAlreadyMember),
+		errors.Is(err, organization.ErrOwner):
+		writeError(w, http.StatusConflict, "conflict", err.Error())
+	case errors.Is(err, role.ErrNotFound),
+		errors.Is(err, role.ErrForbidden),
+		errors.Is(err, role.ErrInsufficientRank):
+		writeRoleError(w, err)
+	default:
+		writeInternalError(w, "failed to handle organization request", err)
+	}
+}
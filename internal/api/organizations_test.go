@@ -0,0 +1,472 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/accesstoken"
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/organization"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// memoryOrganizations is an in-memory organization.Store used for testing,
+// scoped methods filter by the active organization like the PostgreSQL
+// store.
+type memoryOrganizations struct {
+	mu            sync.Mutex
+	organizations map[snowflake.Snowflake]organization.Organization
+	members       map[snowflake.Snowflake]map[snowflake.Snowflake]organization.Membership
+	invitations   map[snowflake.Snowflake]organization.Invitation
+}
+
+func newMemoryOrganizations() *memoryOrganizations {
+	return &memoryOrganizations{
+		organizations: make(map[snowflake.Snowflake]organization.Organization),
+		members:       make(map[snowflake.Snowflake]map[snowflake.Snowflake]organization.Membership),
+		invitations:   make(map[snowflake.Snowflake]organization.Invitation),
+	}
+}
+
+// scope returns the ID of the active organization.
+func (s *memoryOrganizations) scope(ctx context.Context) (snowflake.Snowflake, error) {
+	o, _, ok := organization.FromContext(ctx)
+	if !ok {
+		return snowflake.Nil, organization.ErrNoOrganization
+	}
+	return o.ID, nil
+}
+
+func (s *memoryOrganizations) Create(_ context.Context, o *organization.Organization, owner *organization.Membership) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.organizations[o.ID] = *o
+	s.members[o.ID] = map[snowflake.Snowflake]organization.Membership{owner.UserID: *owner}
+	return nil
+}
+
+func (s *memoryOrganizations) ByID(_ context.Context, id snowflake.Snowflake) (*organization.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.organizations[id]
+	if !ok {
+		return nil, organization.ErrNotFound
+	}
+	return &o, nil
+}
+
+func (s *memoryOrganizations) ByUser(_ context.Context, userID snowflake.Snowflake) ([]*organization.Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var organizations []*organization.Organization
+	for id, members := range s.members {
+		if _, ok := members[userID]; ok {
+			o := s.organizations[id]
+			organizations = append(organizations, &o)
+		}
+	}
+	return organizations, nil
+}
+
+func (s *memoryOrganizations) Membership(_ context.Context, organizationID, userID snowflake.Snowflake) (*organization.Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.members[organizationID][userID]
+	if !ok {
+		return nil, organization.ErrNotFound
+	}
+	return &m, nil
+}
+
+func (s *memoryOrganizations) Update(ctx context.Context, o *organization.Organization) error {
+	id, err := s.scope(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.organizations[id]
+	v.Name = o.Name
+	s.organizations[id] = v
+	return nil
+}
+
+func (s *memoryOrganizations) Delete(ctx context.Context) error {
+	id, err := s.scope(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.organizations, id)
+	delete(s.members, id)
+	return nil
+}
+
+func (s *memoryOrganizations) Transfer(ctx context.Context, userID snowflake.Snowflake) error {
+	id, err := s.scope(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.members[id][userID]; !ok {
+		return organization.ErrNotMember
+	}
+	v := s.organizations[id]
+	v.OwnerID = userID
+	s.organizations[id] = v
+	return nil
+}
+
+func (s *memoryOrganizations) Members(ctx context.Context) ([]*organization.Membership, error) {
+	id, err := s.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var members []*organization.Membership
+	for _, m := range s.members[id] {
+		m := m
+		members = append(members, &m)
+	}
+	return members, nil
+}
+
+func (s *memoryOrganizations) Member(ctx context.Context, userID snowflake.Snowflake) (*organization.Membership, error) {
+	id, err := s.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Membership(ctx, id, userID)
+}
+
+func (s *memoryOrganizations) SetRole(ctx context.Context, userID, roleID snowflake.Snowflake) error {
+	id, err := s.scope(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.members[id][userID]
+	if !ok {
+		return organization.ErrNotFound
+	}
+	m.RoleID = roleID
+	s.members[id][userID] = m
+	return nil
+}
+
+func (s *memoryOrganizations) RemoveMember(ctx context.Context, userID snowflake.Snowflake) error {
+	id, err := s.scope(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.members[id][userID]; !ok {
+		return organization.ErrNotFound
+	}
+	delete(s.members[id], userID)
+	return nil
+}
+
+func (s *memoryOrganizations) CreateInvitation(ctx context.Context, i *organization.Invitation) error {
+	id, err := s.scope(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i.OrganizationID = id
+	s.invitations[i.ID] = *i
+	return nil
+}
+
+func (s *memoryOrganizations) Invitations(ctx context.Context) ([]*organization.Invitation, error) {
+	id, err := s.scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var invitations []*organization.Invitation
+	for _, i := range s.invitations {
+		if i.OrganizationID == id {
+			i := i
+			invitations = append(invitations, &i)
+		}
+	}
+	return invitations, nil
+}
+
+func (s *memoryOrganizations) DeleteInvitation(ctx context.Context, id snowflake.Snowflake) error {
+	organizationID, err := s.scope(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.invitations[id]; !ok || i.OrganizationID != organizationID {
+		return organization.ErrNotFound
+	}
+	delete(s.invitations, id)
+	return nil
+}
+
+func (s *memoryOrganizations) AcceptInvitation(_ context.Context, hash string, now time.Time, u *user.User) (*organization.Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, i := range s.invitations {
+		if i.Hash != hash {
+			continue
+		}
+		if !i.Valid(u.Email, now) {
+			return nil, organization.ErrInvalidInvitation
+		}
+		if _, ok := s.members[i.OrganizationID][u.ID]; ok {
+			return nil, organization.ErrAlreadyMember
+		}
+		m := organization.Membership{
+			OrganizationID: i.OrganizationID,
+			UserID:         u.ID,
+			RoleID:         i.RoleID,
+			CreatedAt:      now,
+		}
+		s.members[i.OrganizationID][u.ID] = m
+		delete(s.invitations, id)
+		return &m, nil
+	}
+	return nil, organization.ErrNotFound
+}
+
+func TestOrganizations(t *testing.T) {
+	roles := memoryRoleFinder{
+		1: {ID: 1, Name: "Manager", SortID: 1, Permissions: role.Permissions{"organization.members.*"}},
+		2: {ID: 2, Name: "Member", SortID: 2},
+	}
+	m := organization.NewManager(newMemoryOrganizations(), roles)
+	ts := newTestServer(
+		func(ts *testServer) func(chi.Router) {
+			return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail).Routes
+		},
+		func(*testServer) func(chi.Router) {
+			return api.NewOrganizations(m).Routes
+		},
+	)
+	login := func(email string) (*user.User, *http.Cookie) {
+		u, _ := user.New(email, []byte("password123"))
+		u.ID = snowflake.New()
+		_ = ts.users.Create(context.Background(), u)
+		return u, ts.do(http.MethodPost, "/auth/login", map[string]string{
+			"email":    email,
+			"password": "password123",
+		}).Result().Cookies()[0]
+	}
+	create := func(name string, cookie *http.Cookie) string {
+		var o organization.Organization
+		w := ts.do(http.MethodPost, "/organizations", map[string]string{"name": name}, cookie)
+		if err := json.Unmarshal(w.Body.Bytes(), &o); err != nil || w.Code != http.StatusCreated {
+			t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusCreated, w.Code, w.Body.String())
+		}
+		return "/organizations/" + o.ID.String()
+	}
+	owner, ownerCookie := login("owner@example.com")
+	invited, invitedCookie := login("invited@example.com")
+	_, outsiderCookie := login("outsider@example.com")
+	org := create("Example", ownerCookie)
+	other := create("Other", outsiderCookie)
+
+	var invitation struct {
+		Code string `json:"code"`
+	}
+	w := ts.do(http.MethodPost, org+"/invitations", map[string]string{"email": "Invited@Example.com", "role_id": "2"}, ownerCookie)
+	if err := json.Unmarshal(w.Body.Bytes(), &invitation); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	for i, tc := range []struct {
+		Cookie *http.Cookie
+		Code   string
+		Status int
+	}{
+		{Cookie: outsiderCookie, Code: invitation.Code, Status: http.StatusBadRequest},
+		{Cookie: invitedCookie, Code: "unknown", Status: http.StatusBadRequest},
+		{Cookie: invitedCookie, Code: invitation.Code, Status: http.StatusOK},
+		{Cookie: invitedCookie, Code: invitation.Code, Status: http.StatusBadRequest},
+	} {
+		if w := ts.do(http.MethodPost, "/organizations/invitations/accept", map[string]string{"code": tc.Code}, tc.Cookie); w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\": %s", i, tc.Status, w.Code, w.Body.String())
+		}
+	}
+	if types := ts.audit.types(invited.ID); len(types) != 2 || types[1] != audit.TypeMemberJoined {
+		t.Errorf("Expected \"%v\", but got \"%v\"", []string{audit.TypeLogin, audit.TypeMemberJoined}, types)
+	}
+
+	for i, tc := range []struct {
+		Method string
+		Path   string
+		Body   interface{}
+		Cookie *http.Cookie
+		Status int
+	}{
+		// Organizations are hidden from users outside of them.
+		{Method: http.MethodGet, Path: org, Cookie: outsiderCookie, Status: http.StatusNotFound},
+		{Method: http.MethodGet, Path: other + "/members", Cookie: ownerCookie, Status: http.StatusNotFound},
+		{Method: http.MethodGet, Path: "/organizations/invalid", Cookie: ownerCookie, Status: http.StatusNotFound},
+		{Method: http.MethodGet, Path: org, Cookie: invitedCookie, Status: http.StatusOK},
+		{Method: http.MethodPatch, Path: org, Body: map[string]string{"name": "Renamed"}, Cookie: invitedCookie, Status: http.StatusForbidden},
+		{Method: http.MethodGet, Path: org + "/members", Cookie: invitedCookie, Status: http.StatusForbidden},
+		{Method: http.MethodDelete, Path: org + "/members/" + owner.ID.String(), Cookie: invitedCookie, Status: http.StatusConflict},
+		{Method: http.MethodPatch, Path: org, Body: map[string]string{"name": "Renamed"}, Cookie: ownerCookie, Status: http.StatusOK},
+		{Method: http.MethodPatch, Path: org + "/members/" + invited.ID.String(), Body: map[string]string{"role_id": "1"}, Cookie: ownerCookie, Status: http.StatusOK},
+		{Method: http.MethodGet, Path: org + "/members", Cookie: invitedCookie, Status: http.StatusOK},
+		{Method: http.MethodPost, Path: org + "/transfer", Body: map[string]string{"user_id": invited.ID.String()}, Cookie: ownerCookie, Status: http.StatusNoContent},
+		{Method: http.MethodDelete, Path: org, Cookie: ownerCookie, Status: http.StatusForbidden},
+		{Method: http.MethodDelete, Path: org + "/members/" + owner.ID.String(), Cookie: ownerCookie, Status: http.StatusNoContent},
+		{Method: http.MethodGet, Path: org, Cookie: ownerCookie, Status: http.StatusNotFound},
+		{Method: http.MethodDelete, Path: org, Cookie: invitedCookie, Status: http.StatusNoContent},
+	} {
+		if w := ts.do(tc.Method, tc.Path, tc.Body, tc.Cookie); w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\": %s", i, tc.Status, w.Code, w.Body.String())
+		}
+	}
+
+	var organizations []organization.Organization
+	if err := json.Unmarshal(ts.do(http.MethodGet, "/organizations", nil, outsiderCookie).Body.Bytes(), &organizations); err != nil || len(organizations) != 1 {
+		t.Errorf("Expected one organization, but got \"%v\"", organizations)
+	}
+}
+
+func TestOrganizations_Delegated(t *testing.T) {
+	m := organization.NewManager(newMemoryOrganizations(), memoryRoleFinder{})
+	ts := newTestServer(func(ts *testServer) func(chi.Router) {
+		tokens := accesstoken.NewManager(newMemoryAccessTokens(), ts.users)
+		return func(r chi.Router) {
+			r.Group(func(r chi.Router) {
+				r.Use(tokens.Middleware)
+				api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail).Routes(r)
+				api.NewAccessTokens(tokens).Routes(r)
+				api.NewOrganizations(m).Routes(r)
+			})
+		}
+	})
+	admin, _ := user.New("admin@example.com", []byte("password123"))
+	admin.ID = snowflake.New()
+	admin.Role = &role.Role{ID: 1, SortID: 0, Permissions: role.Permissions{user.PermissionImpersonate}}
+	owner, _ := user.New("owner@example.com", []byte("password123"))
+	owner.ID = snowflake.New()
+	owner.Role = &role.Role{ID: 2, SortID: 1, Permissions: role.Permissions{"users.read"}}
+	_ = ts.users.Create(context.Background(), admin)
+	_ = ts.users.Create(context.Background(), owner)
+	login := func(email string) *http.Cookie {
+		return ts.do(http.MethodPost, "/auth/login", map[string]string{
+			"email":    email,
+			"password": "password123",
+		}).Result().Cookies()[0]
+	}
+	ownerCookie := login("owner@example.com")
+
+	var o organization.Organization
+	w := ts.do(http.MethodPost, "/organizations", map[string]string{"name": "Example"}, ownerCookie)
+	if err := json.Unmarshal(w.Body.Bytes(), &o); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	org := "/organizations/" + o.ID.String()
+
+	var created struct {
+		Token string `json:"token"`
+	}
+	w = ts.do(http.MethodPost, "/auth/tokens", map[string]interface{}{"name": "CI", "scopes": []string{"users.read"}}, ownerCookie)
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	w = ts.do(http.MethodPost, "/auth/impersonation", map[string]string{"user_id": owner.ID.String()}, login("admin@example.com"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	impersonating := w.Result().Cookies()
+
+	// do performs a request as the owner, either with the access token or
+	// while impersonated.
+	do := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		if token == "" {
+			return ts.do(method, path, body, impersonating...)
+		}
+		var buf bytes.Buffer
+		if body != nil {
+			_ = json.NewEncoder(&buf).Encode(body)
+		}
+		r := httptest.NewRequest(method, path, &buf)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		ts.router.ServeHTTP(w, r)
+		return w
+	}
+	members := org + "/members/" + owner.ID.String()
+	for i, tc := range []struct {
+		Method string
+		Path   string
+		Token  string
+		Body   interface{}
+		Status int
+	}{
+		// An access token can read organizations, but not change them.
+		{Method: http.MethodGet, Path: org, Token: created.Token, Status: http.StatusOK},
+		{Method: http.MethodGet, Path: org + "/members", Token: created.Token, Status: http.StatusOK},
+		{Method: http.MethodPost, Path: "/organizations", Token: created.Token, Body: map[string]string{"name": "Other"}, Status: http.StatusForbidden},
+		{Method: http.MethodPatch, Path: org, Token: created.Token, Body: map[string]string{"name": "Renamed"}, Status: http.StatusForbidden},
+		{Method: http.MethodPost, Path: org + "/invitations", Token: created.Token, Body: map[string]string{"email": "user@example.com"}, Status: http.StatusForbidden},
+		{Method: http.MethodPost, Path: org + "/transfer", Token: created.Token, Body: map[string]string{"user_id": admin.ID.String()}, Status: http.StatusForbidden},
+		{Method: http.MethodDelete, Path: org, Token: created.Token, Status: http.StatusForbidden},
+
+		// An impersonator can change the organization, but not delete or
+		// transfer it, nor change its members.
+		{Method: http.MethodPatch, Path: org, Body: map[string]string{"name": "Renamed"}, Status: http.StatusOK},
+		{Method: http.MethodPost, Path: org + "/transfer", Body: map[string]string{"user_id": admin.ID.String()}, Status: http.StatusForbidden},
+		{Method: http.MethodPatch, Path: members, Body: map[string]string{"role_id": "1"}, Status: http.StatusForbidden},
+		{Method: http.MethodDelete, Path: members, Status: http.StatusForbidden},
+		{Method: http.MethodDelete, Path: org, Status: http.StatusForbidden},
+	} {
+		if w := do(tc.Method, tc.Path, tc.Token, tc.Body); w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\": %s", i, tc.Status, w.Code, w.Body.String())
+		}
+	}
+
+	if w := ts.do(http.MethodGet, org, nil, ownerCookie); w.Code != http.StatusOK {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusOK, w.Code)
+	}
+}
@@ -0,0 +1,177 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/internal/webauthn"
+	"github.com/matthewpi/cosmos/passkey"
+	"github.com/matthewpi/cosmos/user"
+)
+
+func (h *Auth) beginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	u, _ := user.FromContext(r.Context())
+	ceremony, o, err := h.passkeys.BeginRegistration(r.Context(), u)
+	if err != nil {
+		writeInternalError(w, "failed to begin passkey registration", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ceremony": ceremony,
+		"options":  o,
+	})
+}
+
+func (h *Auth) finishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Ceremony   string                        `json:"ceremony"`
+		Name       string                        `json:"name"`
+		Credential *webauthn.AttestationResponse `json:"credential"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Credential == nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "missing credential")
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	p, err := h.passkeys.FinishRegistration(r.Context(), u, req.Ceremony, req.Name, req.Credential)
+	if err != nil {
+		writePasskeyError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, p)
+}
+
+func (h *Auth) beginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	ceremony, o, err := h.passkeys.BeginLogin(r.Context())
+	if err != nil {
+		writeInternalError(w, "failed to begin passkey login", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ceremony": ceremony,
+		"options":  o,
+	})
+}
+
+func (h *Auth) finishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Ceremony   string                      `json:"ceremony"`
+		Credential *webauthn.AssertionResponse `json:"credential"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Credential == nil {
+		writeError(w, http.StatusBadRequest, "invalid_body", "missing credential")
+		return
+	}
+	u, err := h.passkeys.FinishLogin(r.Context(), req.Ceremony, req.Credential)
+	if err != nil {
+		writePasskeyError(w, err)
+		return
+	}
+	if u.Locked {
+		writeError(w, http.StatusForbidden, "account_locked", "account is locked")
+		return
+	}
+	if h.config.RequireConfirmation && !u.Confirmed {
+		writeError(w, http.StatusForbidden, "email_unconfirmed", "email address has not been confirmed")
+		return
+	}
+	// A passkey is possession of a device combined with the device's own
+	// user verification, so it is not followed by a second factor unless the
+	// device did not verify the user.
+	if h.twoFactor != nil && !req.Credential.UserVerified() {
+		enabled, err := h.twoFactor.Enabled(r.Context(), u.ID)
+		if err != nil {
+			writeInternalError(w, "failed to check two-factor authentication", err)
+			return
+		}
+		if enabled {
+			h.challengeTwoFactor(w, r, u)
+			return
+		}
+	}
+	h.startSession(w, r, u, "passkey")
+}
+
+func (h *Auth) listPasskeys(w http.ResponseWriter, r *http.Request) {
+	u, _ := user.FromContext(r.Context())
+	passkeys, err := h.passkeys.List(r.Context(), u.ID)
+	if err != nil {
+		writeInternalError(w, "failed to list passkeys", err)
+		return
+	}
+	if passkeys == nil {
+		passkeys = []*passkey.Passkey{}
+	}
+	writeJSON(w, http.StatusOK, passkeys)
+}
+
+func (h *Auth) deletePasskey(w http.ResponseWriter, r *http.Request) {
+	id, err := base64.RawURLEncoding.DecodeString(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "not_found", "passkey not found")
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	if err := h.passkeys.Delete(r.Context(), u.ID, id); err != nil {
+		writePasskeyError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writePasskeyError writes the response for an error returned by
+// passkey.Manager.
+func writePasskeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, passkey.ErrInvalidCeremony):
+		writeError(w, http.StatusBadRequest, "invalid_token", "invalid or expired ceremony")
+	case errors.Is(err, passkey.ErrInvalidName):
+		writeError(w, http.StatusBadRequest, "invalid", "name must be between 1 and 64 characters")
+	case errors.Is(err, passkey.ErrAlreadyRegistered):
+		writeError(w, http.StatusConflict, "conflict", "passkey is already registered")
+	case errors.Is(err, passkey.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not_found", "passkey not found")
+	case errors.Is(err, passkey.ErrUnknownCredential),
+		errors.Is(err, webauthn.ErrInvalidSignature),
+		errors.Is(err, webauthn.ErrSignCount):
+		writeError(w, http.StatusUnauthorized, "invalid_credentials", "invalid passkey")
+	case errors.Is(err, webauthn.ErrInvalidClientData),
+		errors.Is(err, webauthn.ErrInvalidAuthenticatorData),
+		errors.Is(err, webauthn.ErrInvalidAttestation),
+		errors.Is(err, webauthn.ErrUnsupportedAttestation):
+		writeError(w, http.StatusBadRequest, "invalid_credential", err.Error())
+	default:
+		writeInternalError(w, "failed to handle passkey request", err)
+	}
+}
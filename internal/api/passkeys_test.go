@@ -0,0 +1,315 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/totp"
+	"github.com/matthewpi/cosmos/internal/webauthn"
+	"github.com/matthewpi/cosmos/internal/webauthn/webauthntest"
+	"github.com/matthewpi/cosmos/passkey"
+	"github.com/matthewpi/cosmos/twofactor"
+)
+
+// memoryPasskeys is an in-memory passkey.Store used for testing.
+type memoryPasskeys struct {
+	mu         sync.Mutex
+	passkeys   map[string]passkey.Passkey
+	challenges map[string]*passkey.Challenge
+}
+
+func newMemoryPasskeys() *memoryPasskeys {
+	return &memoryPasskeys{
+		passkeys:   make(map[string]passkey.Passkey),
+		challenges: make(map[string]*passkey.Challenge),
+	}
+}
+
+func (s *memoryPasskeys) Create(_ context.Context, p *passkey.Passkey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.passkeys[p.ID.String()]; ok {
+		return passkey.ErrAlreadyRegistered
+	}
+	s.passkeys[p.ID.String()] = *p
+	return nil
+}
+
+func (s *memoryPasskeys) ByID(_ context.Context, id []byte) (*passkey.Passkey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.passkeys[webauthn.Bytes(id).String()]
+	if !ok {
+		return nil, passkey.ErrNotFound
+	}
+	return &p, nil
+}
+
+func (s *memoryPasskeys) ByUser(_ context.Context, userID snowflake.Snowflake) ([]*passkey.Passkey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var passkeys []*passkey.Passkey
+	for _, p := range s.passkeys {
+		if p.UserID == userID {
+			p := p
+			passkeys = append(passkeys, &p)
+		}
+	}
+	return passkeys, nil
+}
+
+func (s *memoryPasskeys) Use(_ context.Context, id []byte, signCount uint32, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.passkeys[webauthn.Bytes(id).String()]
+	p.SignCount = signCount
+	p.LastUsedAt = &t
+	s.passkeys[p.ID.String()] = p
+	return nil
+}
+
+func (s *memoryPasskeys) Delete(_ context.Context, userID snowflake.Snowflake, id []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.passkeys[webauthn.Bytes(id).String()]
+	if !ok || p.UserID != userID {
+		return passkey.ErrNotFound
+	}
+	delete(s.passkeys, p.ID.String())
+	return nil
+}
+
+func (s *memoryPasskeys) CreateChallenge(_ context.Context, c *passkey.Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.challenges[c.ID] = c
+	return nil
+}
+
+func (s *memoryPasskeys) ConsumeChallenge(_ context.Context, id string) (*passkey.Challenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.challenges[id]
+	if !ok {
+		return nil, passkey.ErrNotFound
+	}
+	delete(s.challenges, id)
+	return c, nil
+}
+
+// ceremony is the response body used to begin a passkey ceremony.
+type ceremony struct {
+	Ceremony string          `json:"ceremony"`
+	Options  json.RawMessage `json:"options"`
+}
+
+func TestAuth_Passkeys(t *testing.T) {
+	ts := newTestServer(func(ts *testServer) func(chi.Router) {
+		m := passkey.NewManager(newMemoryPasskeys(), ts.users, &webauthn.Config{
+			RPID:    "example.com",
+			RPName:  "Example",
+			Origins: []string{"https://example.com"},
+			Timeout: time.Minute,
+		})
+		return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail, api.WithPasskeys(m)).Routes
+	})
+	credentials := map[string]string{"email": "user@example.com", "password": "password123"}
+	ts.do(http.MethodPost, "/auth/register", credentials)
+	cookie := ts.do(http.MethodPost, "/auth/login", credentials).Result().Cookies()[0]
+
+	if w := ts.do(http.MethodPost, "/auth/passkeys/register/begin", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusUnauthorized, w.Code)
+	}
+
+	// Register a passkey.
+	a := webauthntest.NewAuthenticator("https://example.com")
+	var c ceremony
+	if err := json.Unmarshal(ts.do(http.MethodPost, "/auth/passkeys/register/begin", nil, cookie).Body.Bytes(), &c); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	var creation webauthn.CreationOptions
+	if err := json.Unmarshal(c.Options, &creation); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	attestation, err := a.Create(&creation)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	w := ts.do(http.MethodPost, "/auth/passkeys/register/finish", map[string]interface{}{
+		"ceremony":   c.Ceremony,
+		"name":       "Laptop",
+		"credential": attestation,
+	}, cookie)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	// Login with the passkey, without providing an email address.
+	login := func() *webauthn.AssertionResponse {
+		var c ceremony
+		if err := json.Unmarshal(ts.do(http.MethodPost, "/auth/passkeys/login/begin", nil).Body.Bytes(), &c); err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+		var request webauthn.RequestOptions
+		if err := json.Unmarshal(c.Options, &request); err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+		r, err := a.Get(&request)
+		if err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+		w := ts.do(http.MethodPost, "/auth/passkeys/login/finish", map[string]interface{}{
+			"ceremony":   c.Ceremony,
+			"credential": r,
+		})
+		if w.Code != http.StatusOK || len(w.Result().Cookies()) != 1 {
+			t.Fatalf("Expected a session to be created, but got \"%d\": %s", w.Code, w.Body.String())
+		}
+		return r
+	}
+	replay := login()
+
+	// An assertion can not be replayed against another ceremony.
+	var other ceremony
+	_ = json.Unmarshal(ts.do(http.MethodPost, "/auth/passkeys/login/begin", nil).Body.Bytes(), &other)
+	w = ts.do(http.MethodPost, "/auth/passkeys/login/finish", map[string]interface{}{
+		"ceremony":   other.Ceremony,
+		"credential": replay,
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusBadRequest, w.Code)
+	}
+
+	var passkeys []passkey.Passkey
+	if err := json.Unmarshal(ts.do(http.MethodGet, "/auth/passkeys", nil, cookie).Body.Bytes(), &passkeys); err != nil || len(passkeys) != 1 {
+		t.Fatalf("Expected a single passkey, but got \"%v\"", passkeys)
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(passkeys[0].ID)
+	for i, status := range []int{http.StatusNoContent, http.StatusNotFound} {
+		if w := ts.do(http.MethodDelete, "/auth/passkeys/"+id, nil, cookie); w.Code != status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, status, w.Code)
+		}
+	}
+}
+
+func TestAuth_PasskeysTwoFactor(t *testing.T) {
+	now := time.Now()
+	tf := twofactor.NewManager(newMemoryTwoFactor(), nil)
+	tf.Now = func() time.Time { return now }
+	ts := newTestServer(func(ts *testServer) func(chi.Router) {
+		m := passkey.NewManager(newMemoryPasskeys(), ts.users, &webauthn.Config{
+			RPID:    "example.com",
+			RPName:  "Example",
+			Origins: []string{"https://example.com"},
+			Timeout: time.Minute,
+		})
+		return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail, api.WithPasskeys(m), api.WithTwoFactor(tf)).Routes
+	})
+	credentials := map[string]string{"email": "user@example.com", "password": "password123"}
+	ts.do(http.MethodPost, "/auth/register", credentials)
+	cookie := ts.do(http.MethodPost, "/auth/login", credentials).Result().Cookies()[0]
+
+	var enrollment twofactor.Enrollment
+	if err := json.Unmarshal(ts.do(http.MethodPost, "/auth/2fa/totp", nil, cookie).Body.Bytes(), &enrollment); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	key, _ := totp.DecodeSecret(enrollment.Secret)
+	if w := ts.do(http.MethodPost, "/auth/2fa/totp/confirm", map[string]string{"code": totp.Generate(key, totp.Counter(now), totp.Digits)}, cookie); w.Code != http.StatusOK {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	a := webauthntest.NewAuthenticator("https://example.com")
+	var c ceremony
+	if err := json.Unmarshal(ts.do(http.MethodPost, "/auth/passkeys/register/begin", nil, cookie).Body.Bytes(), &c); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	var creation webauthn.CreationOptions
+	if err := json.Unmarshal(c.Options, &creation); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	attestation, err := a.Create(&creation)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	w := ts.do(http.MethodPost, "/auth/passkeys/register/finish", map[string]interface{}{
+		"ceremony":   c.Ceremony,
+		"name":       "Security key",
+		"credential": attestation,
+	}, cookie)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	// Only passkeys that verified the user skip the second factor, a
+	// passkey only proving the user's presence is a single factor.
+	for i, tc := range []struct {
+		UserVerified bool
+		TwoFactor    bool
+	}{
+		{UserVerified: true, TwoFactor: false},
+		{UserVerified: false, TwoFactor: true},
+	} {
+		a.UserVerified = tc.UserVerified
+		var c ceremony
+		if err := json.Unmarshal(ts.do(http.MethodPost, "/auth/passkeys/login/begin", nil).Body.Bytes(), &c); err != nil {
+			t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+		var request webauthn.RequestOptions
+		if err := json.Unmarshal(c.Options, &request); err != nil {
+			t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+		assertion, err := a.Get(&request)
+		if err != nil {
+			t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+		w := ts.do(http.MethodPost, "/auth/passkeys/login/finish", map[string]interface{}{
+			"ceremony":   c.Ceremony,
+			"credential": assertion,
+		})
+		var body struct {
+			TwoFactorRequired bool `json:"two_factor_required"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\": %s", i, http.StatusOK, w.Code, w.Body.String())
+			continue
+		}
+		if body.TwoFactorRequired != tc.TwoFactor {
+			t.Errorf("Test #%d: Expected \"%t\", but got \"%t\"", i, tc.TwoFactor, body.TwoFactorRequired)
+		}
+		if session := len(w.Result().Cookies()) == 1; session == tc.TwoFactor {
+			t.Errorf("Test #%d: Expected a session to be created: \"%t\", but got \"%t\"", i, !tc.TwoFactor, session)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/mail"
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// purposeReset is the purpose of password reset tokens.
+const purposeReset token.Purpose = "reset"
+
+func (h *Auth) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	// The response is the same whether or not the email belongs to an
+	// account.
+	if email, err := normalizeEmail(req.Email); err == nil {
+		u, err := h.users.ByEmail(r.Context(), email)
+		switch {
+		case err == nil:
+			throttled, err := h.tokens.IssuedSince(r.Context(), purposeReset, u.ID, h.tokens.Now().Add(-h.config.ResendInterval))
+			if err != nil {
+				writeInternalError(w, "failed to send password reset", err)
+				return
+			}
+			if throttled {
+				break
+			}
+			if err := h.sendReset(r.Context(), u); err != nil {
+				writeInternalError(w, "failed to send password reset", err)
+				return
+			}
+		case !errors.Is(err, user.ErrNotFound):
+			writeInternalError(w, "failed to send password reset", err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"message": "if the account exists, a password reset email has been sent",
+	})
+}
+
+func (h *Auth) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	// The password is validated first so an invalid password doesn't use up
+	// the token, except for the email check which needs the user.
+	if err := h.policy.CheckLength([]byte(req.Password)); err != nil {
+		writePasswordError(w, h.policy, err)
+		return
+	}
+	if err := h.policy.CheckBreached([]byte(req.Password)); err != nil {
+		writePasswordError(w, h.policy, err)
+		return
+	}
+	t, err := h.tokens.Consume(r.Context(), purposeReset, req.Token)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	u, err := h.users.ByID(r.Context(), t.UserID)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	if u.Email != t.Data {
+		writeTokenError(w, token.ErrInvalid)
+		return
+	}
+	if err := h.policy.CheckEmail([]byte(req.Password), u.Email); err != nil {
+		writePasswordError(w, h.policy, err)
+		return
+	}
+	if err := u.SetPassword([]byte(req.Password)); err != nil {
+		writeInternalError(w, "failed to reset password", err)
+		return
+	}
+	if err := h.users.UpdatePassword(r.Context(), u); err != nil {
+		writeInternalError(w, "failed to reset password", err)
+		return
+	}
+	// Receiving the token proves ownership of the email address.
+	if !u.Confirmed {
+		if err := h.users.Confirm(r.Context(), u.ID); err != nil {
+			writeInternalError(w, "failed to confirm user", err)
+			return
+		}
+	}
+	audit.Record(r.Context(), audit.TypePasswordChanged, u.ID, map[string]string{"method": "reset"})
+	if err := h.tokens.Revoke(r.Context(), purposeReset, u.ID); err != nil {
+		writeInternalError(w, "failed to revoke password reset tokens", err)
+		return
+	}
+	if err := h.sessions.RevokeAll(r.Context(), u.ID); err != nil {
+		writeInternalError(w, "failed to revoke sessions", err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeSessionsRevoked, u.ID, map[string]string{"reason": "password_reset"})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendReset issues a password reset token to a user and emails it to them,
+// revoking any previously issued tokens.
+func (h *Auth) sendReset(ctx context.Context, u *user.User) error {
+	if err := h.tokens.Revoke(ctx, purposeReset, u.ID); err != nil {
+		return err
+	}
+	secret, err := h.tokens.Issue(ctx, purposeReset, u.ID, h.config.ResetTTL, u.Email)
+	if err != nil {
+		return err
+	}
+	return h.mailer.Send(ctx, &mail.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: "Reset your password by opening the link below, the link expires in " + h.config.ResetTTL.String() + ".\n\n" +
+			h.link("/reset-password", secret) + "\n\n" +
+			"If you did not request a password reset, you can ignore this email.\n",
+	})
+}
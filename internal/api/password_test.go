@@ -0,0 +1,70 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAuth_ResetPassword(t *testing.T) {
+	ts := newAuthServer()
+	ts.do(http.MethodPost, "/auth/register", map[string]string{"email": "user@example.com", "password": "password123"})
+	login := ts.do(http.MethodPost, "/auth/login", map[string]string{"email": "user@example.com", "password": "password123"})
+	if login.Code != http.StatusOK {
+		t.Fatalf("Expected \"%d\", but got \"%d\"", http.StatusOK, login.Code)
+	}
+
+	known := ts.do(http.MethodPost, "/auth/password/forgot", map[string]string{"email": "user@example.com"})
+	unknown := ts.do(http.MethodPost, "/auth/password/forgot", map[string]string{"email": "other@example.com"})
+	if known.Code != unknown.Code || known.Body.String() != unknown.Body.String() {
+		t.Errorf("Expected identical responses for known and unknown emails")
+	}
+	secret := ts.mail.linkToken(t, "user@example.com")
+
+	for i, tc := range []struct {
+		Token    string
+		Password string
+		Status   int
+	}{
+		{Token: secret, Password: "short", Status: http.StatusBadRequest},
+		{Token: "invalid", Password: "password456", Status: http.StatusBadRequest},
+		{Token: secret, Password: "password456", Status: http.StatusNoContent},
+		{Token: secret, Password: "password789", Status: http.StatusBadRequest},
+	} {
+		w := ts.do(http.MethodPost, "/auth/password/reset", map[string]string{"token": tc.Token, "password": tc.Password})
+		if w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+		}
+	}
+
+	if len(ts.sessions.sessions) != 0 {
+		t.Errorf("Expected all sessions to be revoked after a password reset")
+	}
+	if w := ts.do(http.MethodPost, "/auth/login", map[string]string{"email": "user@example.com", "password": "password123"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected old password to be rejected, but got \"%d\"", w.Code)
+	}
+	if w := ts.do(http.MethodPost, "/auth/login", map[string]string{"email": "user@example.com", "password": "password456"}); w.Code != http.StatusOK {
+		t.Errorf("Expected new password to be accepted, but got \"%d\"", w.Code)
+	}
+}
@@ -0,0 +1,127 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/lockout"
+	"github.com/matthewpi/cosmos/privacy"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// Privacy serves the data export and account deletion API.
+type Privacy struct {
+	privacy *privacy.Manager
+
+	// lockout is optional, if nil failed password confirmations are not
+	// tracked.
+	lockout *lockout.Manager
+}
+
+// NewPrivacy returns a new Privacy, l is optional, see Auth.
+func NewPrivacy(m *privacy.Manager, l *lockout.Manager) *Privacy {
+	return &Privacy{privacy: m, lockout: l}
+}
+
+// Routes registers the data export and account deletion API's routes.
+func (h *Privacy) Routes(r chi.Router) {
+	r.Route("/auth/account", func(r chi.Router) {
+		r.Use(session.RequireSession)
+		r.Get("/export", h.export)
+		r.With(session.RejectImpersonation).Post("/deletion", h.requestDeletion)
+		r.With(session.RejectImpersonation).Delete("/deletion", h.cancelDeletion)
+	})
+}
+
+// export responds with a ZIP archive of the data held about the user.  The
+// archive is built before responding so an error can still be reported.
+func (h *Privacy) export(w http.ResponseWriter, r *http.Request) {
+	u, _ := user.FromContext(r.Context())
+	var b bytes.Buffer
+	if err := h.privacy.Export(r.Context(), &b, u); err != nil {
+		writeInternalError(w, "failed to export user data", err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeDataExported, u.ID, nil)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="cosmos-`+u.ID.String()+`.zip"`)
+	w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = b.WriteTo(w)
+}
+
+// requestDeletion schedules the user's account to be deleted, users with a
+// password must confirm it.  The user can still login and cancel the
+// deletion until the cooling-off period has passed.
+func (h *Privacy) requestDeletion(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	if u.HasPassword() && !verifyPassword(w, r, h.lockout, u, req.Password) {
+		return
+	}
+	if err := h.privacy.RequestDeletion(r.Context(), u); err != nil {
+		writePrivacyError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeDeletionRequested, u.ID, map[string]interface{}{"delete_after": u.DeleteAfter})
+	writeJSON(w, http.StatusOK, u.As(user.ViewSelf))
+}
+
+func (h *Privacy) cancelDeletion(w http.ResponseWriter, r *http.Request) {
+	u, _ := user.FromContext(r.Context())
+	if err := h.privacy.CancelDeletion(r.Context(), u); err != nil {
+		writePrivacyError(w, err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeDeletionCancelled, u.ID, nil)
+	writeJSON(w, http.StatusOK, u.As(user.ViewSelf))
+}
+
+// writePrivacyError writes the response for an error returned by
+// privacy.Manager.
+func writePrivacyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, privacy.ErrDeletionScheduled):
+		writeError(w, http.StatusConflict, "deletion_scheduled", "account deletion is already scheduled")
+	case errors.Is(err, privacy.ErrDeletionNotScheduled):
+		writeError(w, http.StatusConflict, "deletion_not_scheduled", "account deletion is not scheduled")
+	case errors.Is(err, privacy.ErrTransferRequired):
+		writeError(w, http.StatusConflict, "transfer_required", "organizations owned by the account must be transferred first")
+	default:
+		writeInternalError(w, "failed to handle account deletion request", err)
+	}
+}
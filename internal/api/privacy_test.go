@@ -0,0 +1,207 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/privacy"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// memoryErasure is an in-memory privacy.Store used for testing, erased users
+// are deleted from a user.Store.
+type memoryErasure struct {
+	mu     sync.Mutex
+	users  user.Store
+	erased []snowflake.Snowflake
+}
+
+func (s *memoryErasure) Erase(ctx context.Context, u *user.User) error {
+	if err := s.users.Delete(ctx, u.ID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.erased = append(s.erased, u.ID)
+	return nil
+}
+
+func (s *memoryErasure) AvatarUsed(context.Context, string) (bool, error) {
+	return false, nil
+}
+
+// newPrivacy returns a privacy.Manager erasing users from users.
+func newPrivacy(ts *testServer, users user.Store) *privacy.Manager {
+	return privacy.NewManager(&memoryErasure{users: users}, users, ts.manager, ts.log, nil, nil, nil)
+}
+
+func TestPrivacy(t *testing.T) {
+	ts := newTestServer(
+		func(ts *testServer) func(chi.Router) {
+			return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail).Routes
+		},
+		func(ts *testServer) func(chi.Router) {
+			return api.NewPrivacy(privacy.NewManager(nil, ts.users, ts.manager, ts.log, nil, nil, nil), nil).Routes
+		},
+	)
+	u, _ := user.New("user@example.com", []byte("password123"))
+	u.Confirmed = true
+	_ = ts.users.Create(context.Background(), u)
+	cookie := ts.do(http.MethodPost, "/auth/login", map[string]string{
+		"email":    "user@example.com",
+		"password": "password123",
+	}).Result().Cookies()[0]
+
+	w := ts.do(http.MethodGet, "/auth/account/export", nil, cookie)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	z, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	var names []string
+	for _, f := range z.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	if expect := []string{"audit_events.json", "sessions.json", "user.json"}; len(names) != 3 || names[0] != expect[0] || names[1] != expect[1] || names[2] != expect[2] {
+		t.Errorf("Expected \"%v\", but got \"%v\"", expect, names)
+	}
+
+	for i, tc := range []struct {
+		Method string
+		Body   interface{}
+		Status int
+		Code   string
+	}{
+		{Method: http.MethodDelete, Status: http.StatusConflict, Code: "deletion_not_scheduled"},
+		{Method: http.MethodPost, Body: map[string]string{"password": "wrong-password"}, Status: http.StatusUnauthorized, Code: "invalid_credentials"},
+		{Method: http.MethodPost, Body: map[string]string{"password": "password123"}, Status: http.StatusOK},
+		{Method: http.MethodPost, Body: map[string]string{"password": "password123"}, Status: http.StatusConflict, Code: "deletion_scheduled"},
+		{Method: http.MethodDelete, Status: http.StatusOK},
+	} {
+		w := ts.do(tc.Method, "/auth/account/deletion", tc.Body, cookie)
+		if w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\": %s", i, tc.Status, w.Code, w.Body.String())
+			continue
+		}
+		if tc.Code != "" {
+			if code := errorCode(t, w); code != tc.Code {
+				t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Code, code)
+			}
+		}
+		if tc.Method == http.MethodPost && tc.Status == http.StatusOK {
+			if v, _ := ts.users.ByID(context.Background(), u.ID); v.DeleteAfter.IsZero() {
+				t.Errorf("Test #%d: Expected the deletion to be scheduled", i)
+			}
+		}
+	}
+	if v, _ := ts.users.ByID(context.Background(), u.ID); !v.DeleteAfter.IsZero() {
+		t.Errorf("Expected the deletion to be cancelled, but got \"%v\"", v.DeleteAfter)
+	}
+
+	types := ts.audit.types(u.ID)
+	for _, typ := range []string{audit.TypeDataExported, audit.TypeDeletionRequested, audit.TypeDeletionCancelled} {
+		var found bool
+		for _, v := range types {
+			found = found || v == typ
+		}
+		if !found {
+			t.Errorf("Expected a \"%s\" event, but got \"%v\"", typ, types)
+		}
+	}
+}
+
+// owners is a privacy.Owners reporting every user in it as owning an
+// organization.
+type owners map[snowflake.Snowflake]bool
+
+func (o owners) Owns(_ context.Context, userID snowflake.Snowflake) (bool, error) {
+	return o[userID], nil
+}
+
+func TestPrivacy_Owner(t *testing.T) {
+	u, _ := user.New("user@example.com", []byte("password123"))
+	u.Confirmed = true
+	ts := newTestServer(
+		func(ts *testServer) func(chi.Router) {
+			return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail).Routes
+		},
+		func(ts *testServer) func(chi.Router) {
+			d := privacy.NewManager(&memoryErasure{users: ts.users}, ts.users, ts.manager, ts.log, owners{u.ID: true}, nil, nil)
+			return func(r chi.Router) {
+				api.NewPrivacy(d, nil).Routes(r)
+				api.NewUsers(ts.users, ts.manager, d, nil).Routes(r)
+			}
+		},
+	)
+	admin, _ := user.New("admin@example.com", []byte("password123"))
+	admin.ID = u.ID + 1
+	admin.Role = &role.Role{ID: 1, SortID: 1, Permissions: role.Permissions{"users.*"}}
+	_ = ts.users.Create(context.Background(), u)
+	_ = ts.users.Create(context.Background(), admin)
+	login := func(email string) *http.Cookie {
+		return ts.do(http.MethodPost, "/auth/login", map[string]string{
+			"email":    email,
+			"password": "password123",
+		}).Result().Cookies()[0]
+	}
+
+	// Neither the owner nor an administrator can delete an account owning an
+	// organization.
+	for i, tc := range []struct {
+		Method string
+		Path   string
+		Body   interface{}
+		Cookie *http.Cookie
+	}{
+		{Method: http.MethodPost, Path: "/auth/account/deletion", Body: map[string]string{"password": "password123"}, Cookie: login("user@example.com")},
+		{Method: http.MethodDelete, Path: "/api/v1/users/" + u.ID.String(), Cookie: login("admin@example.com")},
+	} {
+		w := ts.do(tc.Method, tc.Path, tc.Body, tc.Cookie)
+		if w.Code != http.StatusConflict {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\": %s", i, http.StatusConflict, w.Code, w.Body.String())
+			continue
+		}
+		if code := errorCode(t, w); code != "transfer_required" {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, "transfer_required", code)
+		}
+	}
+	if v, err := ts.users.ByID(context.Background(), u.ID); err != nil || !v.DeleteAfter.IsZero() {
+		t.Errorf("Expected the user to be kept, but got \"%v\" (%v)", v, err)
+	}
+}
@@ -0,0 +1,207 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// Roles serves the roles API.
+type Roles struct {
+	roles *role.Manager
+	users user.Store
+}
+
+// NewRoles returns a new Roles.
+func NewRoles(roles *role.Manager, users user.Store) *Roles {
+	return &Roles{
+		roles: roles,
+		users: users,
+	}
+}
+
+// Routes registers the roles API's routes.
+func (h *Roles) Routes(r chi.Router) {
+	r.Route("/api/v1/roles", func(r chi.Router) {
+		r.With(server.RequirePermission(role.PermissionRead)).Get("/", h.list)
+		r.With(server.RequirePermission(role.PermissionCreate)).Post("/", h.create)
+		r.With(server.RequirePermission(role.PermissionRead)).Get("/{id}", h.get)
+		r.With(server.RequirePermission(role.PermissionUpdate)).Patch("/{id}", h.update)
+		r.With(server.RequirePermission(role.PermissionDelete)).Delete("/{id}", h.delete)
+	})
+	r.With(server.RequirePermission(role.PermissionAssign)).Put("/api/v1/users/{id}/role", h.assign)
+}
+
+// roleRequest is the request body used to create or update a role, fields
+// that are omitted are left unchanged when updating.
+type roleRequest struct {
+	Name        *string           `json:"name"`
+	Description *string           `json:"description"`
+	Permissions *role.Permissions `json:"permissions"`
+	SortID      *int              `json:"sort_id"`
+}
+
+// apply applies the fields set in the request to r.
+func (req *roleRequest) apply(r *role.Role) {
+	if req.Name != nil {
+		r.Name = *req.Name
+	}
+	if req.Description != nil {
+		r.Description = *req.Description
+	}
+	if req.Permissions != nil {
+		r.Permissions = *req.Permissions
+	}
+	if req.SortID != nil {
+		r.SortID = *req.SortID
+	}
+}
+
+func (h *Roles) list(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.roles.All(r.Context(), actor(r))
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	if roles == nil {
+		roles = []*role.Role{}
+	}
+	writeJSON(w, http.StatusOK, roles)
+}
+
+func (h *Roles) create(w http.ResponseWriter, r *http.Request) {
+	var req roleRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	v := &role.Role{}
+	req.apply(v)
+	if err := h.roles.Create(r.Context(), actor(r), v); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, v)
+}
+
+func (h *Roles) get(w http.ResponseWriter, r *http.Request) {
+	v, err := h.roles.ByID(r.Context(), actor(r), snowflake.Parse(chi.URLParam(r, "id")))
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (h *Roles) update(w http.ResponseWriter, r *http.Request) {
+	var req roleRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	v, err := h.roles.ByID(r.Context(), actor(r), snowflake.Parse(chi.URLParam(r, "id")))
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	req.apply(v)
+	if err := h.roles.Update(r.Context(), actor(r), v); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (h *Roles) delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.roles.Delete(r.Context(), actor(r), snowflake.Parse(chi.URLParam(r, "id"))); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Roles) assign(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RoleID snowflake.Snowflake `json:"role_id"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	u, err := h.users.ByID(r.Context(), snowflake.Parse(chi.URLParam(r, "id")))
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "user not found")
+			return
+		}
+		writeRoleError(w, err)
+		return
+	}
+	if err := h.roles.Assign(r.Context(), actor(r), u.ID, u.Role, req.RoleID); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	from := snowflake.Nil
+	if u.Role != nil {
+		from = u.Role.ID
+	}
+	audit.Record(r.Context(), audit.TypeRoleChanged, u.ID, map[string]snowflake.Snowflake{
+		"from": from,
+		"to":   req.RoleID,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// actor returns the role of the user making the request.
+func actor(r *http.Request) *role.Role {
+	u, ok := user.FromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	return u.Role
+}
+
+// writeRoleError writes the response for an error returned by role.Manager.
+func writeRoleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, role.ErrInvalid):
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+	case errors.Is(err, role.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not_found", "role not found")
+	case errors.Is(err, role.ErrForbidden),
+		errors.Is(err, role.ErrInsufficientRank),
+		errors.Is(err, role.ErrEscalation):
+		writeError(w, http.StatusForbidden, "forbidden", err.Error())
+	case errors.Is(err, role.ErrNameTaken),
+		errors.Is(err, role.ErrLastAdministrator):
+		writeError(w, http.StatusConflict, "conflict", err.Error())
+	default:
+		writeInternalError(w, "failed to handle role request", err)
+	}
+}
@@ -0,0 +1,130 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+	"github.com/matthewpi/cosmos/user/usertest"
+)
+
+// createUser creates a user with a role in db.
+func createUser(t *testing.T, db *usertest.DB, email string, r *role.Role) *user.User {
+	t.Helper()
+	u, err := user.New(email, []byte("password123"))
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	u.ID = snowflake.New()
+	u.Role = r
+	if err := db.Users().Create(context.Background(), u); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	return u
+}
+
+// createRoles creates roles in db.
+func createRoles(t *testing.T, db *usertest.DB, roles ...*role.Role) {
+	t.Helper()
+	for _, r := range roles {
+		if err := db.Roles().Create(context.Background(), r); err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+	}
+}
+
+func TestRoles_Assign(t *testing.T) {
+	db := usertest.New()
+	ts := newStoreTestServer(db,
+		func(ts *testServer) func(chi.Router) {
+			return api.NewAuth(ts.config, ts.db.Users(), ts.manager, ts.tokens, ts.mail).Routes
+		},
+		func(ts *testServer) func(chi.Router) {
+			return api.NewRoles(role.NewManager(ts.db.Roles()), ts.db.Users()).Routes
+		},
+		func(ts *testServer) func(chi.Router) {
+			return api.NewUsers(ts.db.Users(), ts.manager, newPrivacy(ts, ts.db.Users()), nil).Routes
+		},
+	)
+	owner := &role.Role{ID: snowflake.New(), Name: "Owner", SortID: 0, Permissions: role.Permissions{"*"}}
+	admin := &role.Role{ID: snowflake.New(), Name: "Administrator", SortID: 1, Permissions: role.Permissions{"roles.*"}}
+	member := &role.Role{ID: snowflake.New(), Name: "Member", SortID: 2}
+	createRoles(t, db, owner, admin, member)
+	createUser(t, db, "admin@example.com", admin)
+	top := createUser(t, db, "owner@example.com", owner)
+	u := createUser(t, db, "user@example.com", member)
+	cookie := ts.do(http.MethodPost, "/auth/login", map[string]string{
+		"email":    "admin@example.com",
+		"password": "password123",
+	}).Result().Cookies()[0]
+
+	for i, tc := range []struct {
+		User   *user.User
+		RoleID snowflake.Snowflake
+		Status int
+	}{
+		// Users and roles ranking above the actor are out of reach.
+		{User: top, RoleID: member.ID, Status: http.StatusForbidden},
+		{User: u, RoleID: owner.ID, Status: http.StatusForbidden},
+		{User: u, RoleID: admin.ID, Status: http.StatusForbidden},
+
+		// Users ranking below the actor can be reassigned.
+		{User: u, RoleID: snowflake.Nil, Status: http.StatusNoContent},
+		{User: u, RoleID: member.ID, Status: http.StatusNoContent},
+	} {
+		body := map[string]interface{}{"role_id": tc.RoleID}
+		if w := ts.do(http.MethodPut, "/api/v1/users/"+tc.User.ID.String()+"/role", body, cookie); w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+		}
+	}
+
+	// The users API shares the /api/v1/users prefix with role assignment.
+	if w := ts.do(http.MethodGet, "/api/v1/users/"+u.ID.String(), nil, cookie); w.Code != http.StatusForbidden {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusForbidden, w.Code)
+	}
+
+	for i, tc := range []struct {
+		User   *user.User
+		RoleID snowflake.Snowflake
+	}{
+		{User: top, RoleID: owner.ID},
+		{User: u, RoleID: member.ID},
+	} {
+		got, err := db.Users().ByID(context.Background(), tc.User.ID)
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		if got.Role == nil || got.Role.ID != tc.RoleID {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.RoleID, got.Role)
+		}
+	}
+}
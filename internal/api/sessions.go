@@ -0,0 +1,156 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/mail"
+	"github.com/matthewpi/cosmos/internal/outbox"
+	"github.com/matthewpi/cosmos/internal/useragent"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// sessionView is a session as listed to its user.
+type sessionView struct {
+	*session.Session
+
+	// Device is the browser and operating system of the session.
+	Device useragent.Agent `json:"device"`
+
+	// Current is true for the session the request was made with.
+	Current bool `json:"current"`
+}
+
+// listSessions lists the active sessions of the authenticated user, showing
+// where they are logged in.
+func (h *Auth) listSessions(w http.ResponseWriter, r *http.Request) {
+	current, ok := session.FromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+		return
+	}
+	sessions, err := h.sessions.List(r.Context(), current.UserID)
+	if err != nil {
+		writeInternalError(w, "failed to list sessions", err)
+		return
+	}
+	views := make([]sessionView, len(sessions))
+	for i, s := range sessions {
+		views[i] = sessionView{Session: s, Device: s.Device(), Current: s.ID == current.ID}
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// revokeSession signs out one of the authenticated user's sessions, revoking
+// the current session is the same as logging out.
+func (h *Auth) revokeSession(w http.ResponseWriter, r *http.Request) {
+	current, ok := session.FromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if id == current.ID {
+		h.logout(w, r)
+		return
+	}
+	sessions, err := h.sessions.List(r.Context(), current.UserID)
+	if err != nil {
+		writeInternalError(w, "failed to list sessions", err)
+		return
+	}
+	var s *session.Session
+	for _, v := range sessions {
+		if v.ID == id {
+			s = v
+			break
+		}
+	}
+	if s == nil {
+		writeError(w, http.StatusNotFound, "not_found", "session not found")
+		return
+	}
+	if err := h.sessions.Revoke(r.Context(), s.ID); err != nil {
+		writeInternalError(w, "failed to revoke session", err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeSessionRevoked, s.UserID, map[string]string{"device": s.Device().String()})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeOtherSessions signs out every session of the authenticated user
+// except the current one.
+func (h *Auth) revokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	current, ok := session.FromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+		return
+	}
+	if err := h.sessions.RevokeOthers(r.Context(), current); err != nil {
+		writeInternalError(w, "failed to revoke sessions", err)
+		return
+	}
+	audit.Record(r.Context(), audit.TypeSessionsRevoked, current.UserID, map[string]string{"reason": "signed_out_others"})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// NewDeviceNotifier returns an outbox.Publisher for session.EventNewDevice
+// events, emailing users when their account is logged in to from a new
+// device.  mailer must send synchronously, such as one returned by
+// mail.Config.Mailer rather than mail.Async, so failed sends are retried.
+func NewDeviceNotifier(users user.Store, mailer mail.Mailer) outbox.Publisher {
+	return outbox.PublisherFunc(func(ctx context.Context, e *outbox.Event) error {
+		var v session.NewDeviceEvent
+		if err := e.Unmarshal(&v); err != nil {
+			return err
+		}
+		u, err := users.ByID(ctx, v.UserID)
+		if err != nil {
+			// The user may have been deleted since.
+			if errors.Is(err, user.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		from := v.Device.String()
+		if v.IP != "" {
+			from += " (" + v.IP + ")"
+		}
+		return mailer.Send(ctx, &mail.Message{
+			To:      u.Email,
+			Subject: "New login to your account",
+			Body: "Your account was logged in to from a new device, " + from +
+				", at " + v.At.UTC().Format(time.RFC1123) + ".\n\n" +
+				"If this was you, there is nothing you need to do.  Otherwise change " +
+				"your password and sign out of your other sessions.\n",
+		})
+	})
+}
@@ -0,0 +1,185 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/outbox"
+	"github.com/matthewpi/cosmos/internal/useragent"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/user"
+)
+
+const (
+	firefoxWindows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:118.0) Gecko/20100101 Firefox/118.0"
+	chromeAndroid  = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36"
+)
+
+func TestAuth_Sessions(t *testing.T) {
+	ts := newAuthServer()
+	u, _ := user.New("user@example.com", []byte("password123"))
+	_ = ts.users.Create(context.Background(), u)
+
+	login := func(email, ua string) *http.Cookie {
+		r := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email":"`+email+`","password":"password123"}`))
+		r.Header.Set("User-Agent", ua)
+		w := httptest.NewRecorder()
+		ts.router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected \"%d\", but got \"%d\": %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		return w.Result().Cookies()[0]
+	}
+	list := func(c *http.Cookie) []map[string]interface{} {
+		w := ts.do(http.MethodGet, "/auth/sessions", nil, c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected \"%d\", but got \"%d\"", http.StatusOK, w.Code)
+		}
+		var v []map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+		return v
+	}
+
+	// Logging in from the first device, or a device the user has logged in
+	// from before, is not notified.
+	current := login("user@example.com", firefoxWindows)
+	other := login("user@example.com", firefoxWindows)
+	if len(ts.sessions.events) != 0 {
+		t.Errorf("Expected no new device events, but got %d", len(ts.sessions.events))
+	}
+	mobile := login("user@example.com", chromeAndroid)
+	if len(ts.sessions.events) != 1 {
+		t.Fatalf("Expected a new device event, but got %d", len(ts.sessions.events))
+	}
+	if e := ts.sessions.events[0]; e.UserID != u.ID || e.Device != (useragent.Agent{Browser: "Chrome", Version: "118", OS: "Android"}) || e.IP != "192.0.2.1" {
+		t.Errorf("Expected a new device event for Chrome on Android, but got \"%v\"", e)
+	}
+
+	sessions := list(current)
+	if len(sessions) != 3 {
+		t.Fatalf("Expected 3 sessions, but got %d", len(sessions))
+	}
+	var currentID, otherID string
+	for _, s := range sessions {
+		if s["ip"] != "192.0.2.1" {
+			t.Errorf("Expected \"%s\", but got \"%v\"", "192.0.2.1", s["ip"])
+		}
+		if s["current"] == true {
+			currentID = s["id"].(string)
+		} else if s["device"].(map[string]interface{})["os"] == "Windows" {
+			otherID = s["id"].(string)
+		}
+	}
+	if currentID == "" || otherID == "" {
+		t.Fatalf("Expected the current session and another Windows session, but got \"%v\"", sessions)
+	}
+
+	// Sessions of other users cannot be revoked.
+	peer, _ := user.New("peer@example.com", []byte("password123"))
+	_ = ts.users.Create(context.Background(), peer)
+	peerID := list(login("peer@example.com", firefoxWindows))[0]["id"].(string)
+	for i, tc := range []struct {
+		ID     string
+		Status int
+	}{
+		{ID: "unknown", Status: http.StatusNotFound},
+		{ID: peerID, Status: http.StatusNotFound},
+		{ID: otherID, Status: http.StatusNoContent},
+		{ID: otherID, Status: http.StatusNotFound},
+	} {
+		if w := ts.do(http.MethodDelete, "/auth/sessions/"+tc.ID, nil, current); w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+		}
+	}
+	if w := ts.do(http.MethodGet, "/auth/sessions", nil, other); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked session to be rejected, but got \"%d\"", w.Code)
+	}
+
+	if w := ts.do(http.MethodDelete, "/auth/sessions", nil, current); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected \"%d\", but got \"%d\"", http.StatusNoContent, w.Code)
+	}
+	if w := ts.do(http.MethodGet, "/auth/sessions", nil, mobile); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected revoked session to be rejected, but got \"%d\"", w.Code)
+	}
+	if sessions := list(current); len(sessions) != 1 || sessions[0]["id"] != currentID {
+		t.Errorf("Expected only the current session to remain, but got \"%v\"", sessions)
+	}
+	if len(list(login("peer@example.com", firefoxWindows))) != 2 {
+		t.Errorf("Expected sessions of other users to be kept")
+	}
+
+	types := ts.audit.types(u.ID)
+	if types[len(types)-2] != audit.TypeSessionRevoked || types[len(types)-1] != audit.TypeSessionsRevoked {
+		t.Errorf("Expected \"%v\", but got \"%v\"", []string{audit.TypeSessionRevoked, audit.TypeSessionsRevoked}, types)
+	}
+
+	// Revoking the current session logs out.
+	w := ts.do(http.MethodDelete, "/auth/sessions/"+currentID, nil, current)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected \"%d\", but got \"%d\"", http.StatusNoContent, w.Code)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("Expected session cookie to be cleared")
+	}
+}
+
+func TestNewDeviceNotifier(t *testing.T) {
+	ts := newAuthServer()
+	u, _ := user.New("user@example.com", nil)
+	_ = ts.users.Create(context.Background(), u)
+	p := api.NewDeviceNotifier(ts.users, ts.mail)
+
+	payload, _ := json.Marshal(&session.NewDeviceEvent{
+		UserID: u.ID,
+		Device: useragent.Parse(chromeAndroid),
+		IP:     "192.0.2.1",
+		At:     time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err := p.Publish(context.Background(), &outbox.Event{Topic: session.EventNewDevice, Payload: payload}); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	msg := ts.mail.last("user@example.com")
+	if msg == nil {
+		t.Fatalf("Expected a new device email to be sent")
+	}
+	if !strings.Contains(msg.Body, "Chrome 118 on Android (192.0.2.1)") {
+		t.Errorf("Expected email to describe the device, but got \"%s\"", msg.Body)
+	}
+
+	// Events for users that no longer exist are dropped.
+	payload, _ = json.Marshal(&session.NewDeviceEvent{UserID: 1})
+	if err := p.Publish(context.Background(), &outbox.Event{Topic: session.EventNewDevice, Payload: payload}); err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+	}
+}
@@ -0,0 +1,209 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/lockout"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/twofactor"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// purposeTwoFactor is the purpose of tokens issued after a user with
+// two-factor authentication enabled provides their password.
+const purposeTwoFactor token.Purpose = "2fa"
+
+// challengeTwoFactor responds to a login by a user with two-factor
+// authentication enabled, issuing a token that must be provided along with
+// their second factor to /auth/login/2fa.
+func (h *Auth) challengeTwoFactor(w http.ResponseWriter, r *http.Request, u *user.User) {
+	secret, err := h.tokens.Issue(r.Context(), purposeTwoFactor, u.ID, h.config.TwoFactorTTL, "")
+	if err != nil {
+		writeInternalError(w, "failed to issue two-factor token", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"two_factor_required": true,
+		"token":               secret,
+	})
+}
+
+func (h *Auth) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token        string `json:"token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	// The token is consumed even if the code is wrong, so every guess
+	// requires the user's password.
+	t, err := h.tokens.Consume(r.Context(), purposeTwoFactor, req.Token)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	u, err := h.users.ByID(r.Context(), t.UserID)
+	if err != nil {
+		writeTokenError(w, err)
+		return
+	}
+	if u.Locked {
+		writeError(w, http.StatusForbidden, "account_locked", "account is locked")
+		return
+	}
+	if req.RecoveryCode != "" {
+		err = h.twoFactor.Recover(r.Context(), u.ID, req.RecoveryCode)
+	} else {
+		err = h.twoFactor.Verify(r.Context(), u.ID, req.Code)
+	}
+	if err != nil {
+		if errors.Is(err, twofactor.ErrInvalidCode) && !recordFailure(w, r, h.lockout, u.Email, u, "two_factor") {
+			return
+		}
+		writeTwoFactorError(w, err)
+		return
+	}
+	h.startSession(w, r, u, "two_factor")
+}
+
+func (h *Auth) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	u, _ := user.FromContext(r.Context())
+	e, err := h.twoFactor.Enroll(r.Context(), u)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+func (h *Auth) enableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	u, _ := user.FromContext(r.Context())
+	codes, err := h.twoFactor.Enable(r.Context(), u.ID, req.Code)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+func (h *Auth) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.reauthenticate(w, r)
+	if !ok {
+		return
+	}
+	if err := h.twoFactor.Disable(r.Context(), u.ID); err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Auth) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.reauthenticate(w, r)
+	if !ok {
+		return
+	}
+	codes, err := h.twoFactor.RegenerateRecoveryCodes(r.Context(), u.ID)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// reauthenticate requires the authenticated user to confirm their identity,
+// providing their password in the request body if they have one, used before
+// sensitive changes.
+func (h *Auth) reauthenticate(w http.ResponseWriter, r *http.Request) (*user.User, bool) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if !decode(w, r, &req) {
+		return nil, false
+	}
+	u, _ := user.FromContext(r.Context())
+	if !h.verifyIdentity(w, r, u, req.Password) {
+		return nil, false
+	}
+	return u, true
+}
+
+// verifyIdentity writes an error response and returns false unless the user
+// has confirmed their identity.  Users with a password must provide it,
+// users without one must have logged in within ReauthenticationTTL, for
+// example with a new magic link or a passkey.
+func (h *Auth) verifyIdentity(w http.ResponseWriter, r *http.Request, u *user.User, password string) bool {
+	if u.HasPassword() {
+		return verifyPassword(w, r, h.lockout, u, password)
+	}
+	s, ok := session.FromContext(r.Context())
+	if !ok || h.sessions.Now().Sub(s.CreatedAt) > h.config.ReauthenticationTTL {
+		writeError(w, http.StatusUnauthorized, "reauthentication_required", "log in again to confirm your identity")
+		return false
+	}
+	return true
+}
+
+// verifyPassword writes an error response and returns false if password is
+// not the user's password.  Wrong passwords count as failed logins, so
+// confirming a password can not be used to guess it without being locked out.
+func verifyPassword(w http.ResponseWriter, r *http.Request, l *lockout.Manager, u *user.User, password string) bool {
+	if !checkLockout(w, r, l, u.Email) {
+		return false
+	}
+	if err := u.VerifyPassword([]byte(password)); err != nil {
+		if recordFailure(w, r, l, u.Email, u, "reauthentication") {
+			writeError(w, http.StatusUnauthorized, "invalid_credentials", "invalid password")
+		}
+		return false
+	}
+	return true
+}
+
+// writeTwoFactorError writes the response for an error returned by
+// twofactor.Manager.
+func writeTwoFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, twofactor.ErrInvalidCode):
+		writeError(w, http.StatusUnauthorized, "invalid_code", "invalid two-factor code")
+	case errors.Is(err, twofactor.ErrNotEnrolled),
+		errors.Is(err, twofactor.ErrNotEnabled):
+		writeError(w, http.StatusConflict, "two_factor_disabled", "two-factor authentication is not enabled")
+	case errors.Is(err, twofactor.ErrAlreadyEnabled):
+		writeError(w, http.StatusConflict, "two_factor_enabled", "two-factor authentication is already enabled")
+	default:
+		writeInternalError(w, "failed to handle two-factor request", err)
+	}
+}
@@ -0,0 +1,204 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/totp"
+	"github.com/matthewpi/cosmos/twofactor"
+)
+
+// memoryTwoFactor is an in-memory twofactor.Store used for testing.
+type memoryTwoFactor struct {
+	mu      sync.Mutex
+	secrets map[snowflake.Snowflake]twofactor.TOTP
+	codes   map[snowflake.Snowflake][]*twofactor.RecoveryCode
+}
+
+func newMemoryTwoFactor() *memoryTwoFactor {
+	return &memoryTwoFactor{
+		secrets: make(map[snowflake.Snowflake]twofactor.TOTP),
+		codes:   make(map[snowflake.Snowflake][]*twofactor.RecoveryCode),
+	}
+}
+
+func (s *memoryTwoFactor) TOTP(_ context.Context, userID snowflake.Snowflake) (*twofactor.TOTP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.secrets[userID]
+	if !ok {
+		return nil, twofactor.ErrNotFound
+	}
+	return &t, nil
+}
+
+func (s *memoryTwoFactor) SaveTOTP(_ context.Context, t *twofactor.TOTP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[t.UserID] = *t
+	return nil
+}
+
+func (s *memoryTwoFactor) Enable(_ context.Context, userID snowflake.Snowflake, counter int64, codes []*twofactor.RecoveryCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.secrets[userID]
+	t.Confirmed = true
+	t.LastCounter = counter
+	s.secrets[userID] = t
+	s.codes[userID] = codes
+	return nil
+}
+
+func (s *memoryTwoFactor) UseCounter(_ context.Context, userID snowflake.Snowflake, counter int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.secrets[userID]
+	if t.LastCounter >= counter {
+		return false, nil
+	}
+	t.LastCounter = counter
+	s.secrets[userID] = t
+	return true, nil
+}
+
+func (s *memoryTwoFactor) RecoveryCodes(_ context.Context, userID snowflake.Snowflake) ([]*twofactor.RecoveryCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.codes[userID], nil
+}
+
+func (s *memoryTwoFactor) ReplaceRecoveryCodes(_ context.Context, userID snowflake.Snowflake, codes []*twofactor.RecoveryCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[userID] = codes
+	return nil
+}
+
+func (s *memoryTwoFactor) UseRecoveryCode(_ context.Context, id snowflake.Snowflake) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for userID, codes := range s.codes {
+		for i, c := range codes {
+			if c.ID == id {
+				s.codes[userID] = append(codes[:i:i], codes[i+1:]...)
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryTwoFactor) Disable(_ context.Context, userID snowflake.Snowflake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.secrets, userID)
+	delete(s.codes, userID)
+	return nil
+}
+
+func TestAuth_TwoFactor(t *testing.T) {
+	now := time.Now()
+	m := twofactor.NewManager(newMemoryTwoFactor(), nil)
+	m.Now = func() time.Time { return now }
+	ts := newTestServer(func(ts *testServer) func(chi.Router) {
+		return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail, api.WithTwoFactor(m)).Routes
+	})
+	credentials := map[string]string{"email": "user@example.com", "password": "password123"}
+	ts.do(http.MethodPost, "/auth/register", credentials)
+	cookie := ts.do(http.MethodPost, "/auth/login", credentials).Result().Cookies()[0]
+
+	if w := ts.do(http.MethodPost, "/auth/2fa/totp", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusUnauthorized, w.Code)
+	}
+	w := ts.do(http.MethodPost, "/auth/2fa/totp", nil, cookie)
+	var enrollment twofactor.Enrollment
+	if err := json.Unmarshal(w.Body.Bytes(), &enrollment); err != nil || enrollment.Secret == "" {
+		t.Fatalf("Expected an enrollment, but got \"%s\"", w.Body.String())
+	}
+	key, _ := totp.DecodeSecret(enrollment.Secret)
+	code := func() string {
+		return totp.Generate(key, totp.Counter(now), totp.Digits)
+	}
+
+	w = ts.do(http.MethodPost, "/auth/2fa/totp/confirm", map[string]string{"code": code()}, cookie)
+	var recovery struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &recovery); err != nil || len(recovery.RecoveryCodes) == 0 {
+		t.Fatalf("Expected recovery codes, but got \"%s\"", w.Body.String())
+	}
+
+	// Logging in now requires a second step.
+	challenge := func() string {
+		w := ts.do(http.MethodPost, "/auth/login", credentials)
+		var body struct {
+			TwoFactorRequired bool   `json:"two_factor_required"`
+			Token             string `json:"token"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || !body.TwoFactorRequired || len(w.Result().Cookies()) != 0 {
+			t.Fatalf("Expected a two-factor challenge, but got \"%s\"", w.Body.String())
+		}
+		return body.Token
+	}
+
+	now = now.Add(totp.Period * time.Second)
+	for i, tc := range []struct {
+		Body   map[string]string
+		Status int
+	}{
+		{Body: map[string]string{"token": challenge(), "code": "000000"}, Status: http.StatusUnauthorized},
+		{Body: map[string]string{"token": "invalid", "code": code()}, Status: http.StatusBadRequest},
+		{Body: map[string]string{"token": challenge(), "code": code()}, Status: http.StatusOK},
+		// Replayed code.
+		{Body: map[string]string{"token": challenge(), "code": code()}, Status: http.StatusUnauthorized},
+		{Body: map[string]string{"token": challenge(), "recovery_code": recovery.RecoveryCodes[0]}, Status: http.StatusOK},
+		{Body: map[string]string{"token": challenge(), "recovery_code": recovery.RecoveryCodes[0]}, Status: http.StatusUnauthorized},
+	} {
+		w := ts.do(http.MethodPost, "/auth/login/2fa", tc.Body)
+		if w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+			continue
+		}
+		if tc.Status == http.StatusOK && len(w.Result().Cookies()) != 1 {
+			t.Errorf("Test #%d: Expected a session cookie to be set", i)
+		}
+	}
+
+	if w := ts.do(http.MethodDelete, "/auth/2fa/totp", map[string]string{"password": "wrong"}, cookie); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusUnauthorized, w.Code)
+	}
+	if w := ts.do(http.MethodDelete, "/auth/2fa/totp", map[string]string{"password": "password123"}, cookie); w.Code != http.StatusNoContent {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusNoContent, w.Code)
+	}
+}
@@ -0,0 +1,290 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/password"
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/privacy"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// Users serves the users API, used to manage other users' accounts.  Users
+// are returned in the user.ViewAdmin view.
+type Users struct {
+	users    user.Store
+	sessions *session.Manager
+	privacy  *privacy.Manager
+	policy   *password.Policy
+}
+
+// NewUsers returns a new Users, deleted users are erased by d.  If p is nil
+// password.DefaultPolicy is used.
+func NewUsers(users user.Store, sessions *session.Manager, d *privacy.Manager, p *password.Policy) *Users {
+	if p == nil {
+		p = password.DefaultPolicy()
+	}
+	return &Users{
+		users:    users,
+		sessions: sessions,
+		privacy:  d,
+		policy:   p,
+	}
+}
+
+// Routes registers the users API's routes.
+func (h *Users) Routes(r chi.Router) {
+	r.Route("/api/v1/users", func(r chi.Router) {
+		r.With(server.RequirePermission(user.PermissionRead)).Get("/", h.list)
+		r.With(server.RequirePermission(user.PermissionCreate)).Post("/", h.create)
+		r.With(server.RequirePermission(user.PermissionRead)).Get("/{id}", h.get)
+		r.With(server.RequirePermission(user.PermissionUpdate)).Patch("/{id}", h.update)
+		r.With(server.RequirePermission(user.PermissionDelete)).Delete("/{id}", h.delete)
+	})
+}
+
+// list returns a page of users ordered by when they were created, oldest
+// first unless sort is "-created_at".  Users can be filtered with confirmed,
+// locked and email_prefix.  The next page is requested by passing the
+// returned next cursor as after.
+func (h *Users) list(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+	v := r.URL.Query()
+	q := &user.Query{
+		EmailPrefix: strings.ToLower(strings.TrimSpace(v.Get("email_prefix"))),
+		Limit:       limit,
+	}
+	switch v.Get("sort") {
+	case "", "created_at":
+	case "-created_at":
+		q.Descending = true
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_query", "sort must be either created_at or -created_at")
+		return
+	}
+	if s := v.Get("after"); s != "" {
+		if q.After = snowflake.Parse(s); !q.After.Valid() {
+			writeError(w, http.StatusBadRequest, "invalid_query", "invalid after")
+			return
+		}
+	}
+	if q.Confirmed, ok = queryBool(w, r, "confirmed"); !ok {
+		return
+	}
+	if q.Locked, ok = queryBool(w, r, "locked"); !ok {
+		return
+	}
+
+	users, err := h.users.List(r.Context(), q)
+	if err != nil {
+		writeInternalError(w, "failed to list users", err)
+		return
+	}
+	res := struct {
+		Users []user.Viewed       `json:"users"`
+		Next  snowflake.Snowflake `json:"next,omitempty"`
+	}{Users: user.AsAll(users, user.ViewAdmin)}
+	if len(users) == q.Limit {
+		res.Next = users[len(users)-1].ID
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// userRequest is the request body used to create or update a user, fields
+// that are omitted are left unchanged when updating.
+type userRequest struct {
+	Email     *string `json:"email"`
+	Password  *string `json:"password"`
+	Confirmed *bool   `json:"confirmed"`
+}
+
+// apply validates the request and applies it to u, writing an error response
+// and returning false if the request is invalid.
+func (h *Users) apply(w http.ResponseWriter, req *userRequest, u *user.User) bool {
+	if req.Email != nil {
+		email, err := normalizeEmail(*req.Email)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_email", "invalid email address")
+			return false
+		}
+		u.Email = email
+	}
+	if req.Confirmed != nil {
+		u.Confirmed = *req.Confirmed
+	}
+	if req.Password != nil {
+		if err := h.policy.Check([]byte(*req.Password), u.Email); err != nil {
+			writePasswordError(w, h.policy, err)
+			return false
+		}
+		if err := u.SetPassword([]byte(*req.Password)); err != nil {
+			writeInternalError(w, "failed to set password", err)
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Users) create(w http.ResponseWriter, r *http.Request) {
+	var req userRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Email == nil {
+		writeError(w, http.StatusBadRequest, "invalid_email", "invalid email address")
+		return
+	}
+	u, err := user.New("", nil)
+	if err != nil {
+		writeInternalError(w, "failed to create user", err)
+		return
+	}
+	if !h.apply(w, &req, u) {
+		return
+	}
+	if err := h.users.Create(r.Context(), u); err != nil {
+		writeUserError(w, "failed to create user", err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, u.As(user.ViewAdmin))
+}
+
+func (h *Users) get(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.user(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, u.As(user.ViewAdmin))
+}
+
+func (h *Users) update(w http.ResponseWriter, r *http.Request) {
+	var req userRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	u, ok := h.managed(w, r)
+	if !ok || !h.apply(w, &req, u) {
+		return
+	}
+	if err := h.users.Update(r.Context(), u); err != nil {
+		writeUserError(w, "failed to update user", err)
+		return
+	}
+	if req.Password != nil {
+		audit.Record(r.Context(), audit.TypePasswordChanged, u.ID, map[string]string{"method": "administrator"})
+		if err := h.sessions.RevokeAll(r.Context(), u.ID); err != nil {
+			writeInternalError(w, "failed to revoke sessions", err)
+			return
+		}
+		audit.Record(r.Context(), audit.TypeSessionsRevoked, u.ID, map[string]string{"reason": "password_changed"})
+	}
+	writeJSON(w, http.StatusOK, u.As(user.ViewAdmin))
+}
+
+func (h *Users) delete(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.managed(w, r)
+	if !ok {
+		return
+	}
+	// Deleting an account erases it as if its deletion was due, so it is
+	// anonymised rather than deleted when other rows still reference it.
+	if err := h.privacy.Erase(r.Context(), u); err != nil {
+		if errors.Is(err, privacy.ErrTransferRequired) {
+			writePrivacyError(w, err)
+			return
+		}
+		writeUserError(w, "failed to delete user", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// user returns the user identified by the request's URL, writing an error
+// response and returning false if it does not exist.
+func (h *Users) user(w http.ResponseWriter, r *http.Request) (*user.User, bool) {
+	id := snowflake.Parse(chi.URLParam(r, "id"))
+	if !id.Valid() {
+		writeError(w, http.StatusNotFound, "not_found", "user not found")
+		return nil, false
+	}
+	u, err := h.users.ByID(r.Context(), id)
+	if err != nil {
+		writeUserError(w, "failed to get user", err)
+		return nil, false
+	}
+	return u, true
+}
+
+// managed is like user, but only returns users the actor is allowed to
+// manage, that is users whose role ranks below the actor's.
+func (h *Users) managed(w http.ResponseWriter, r *http.Request) (*user.User, bool) {
+	u, ok := h.user(w, r)
+	if !ok {
+		return nil, false
+	}
+	if u.Role != nil && !actor(r).Outranks(u.Role) {
+		writeError(w, http.StatusForbidden, "forbidden", "user's role must rank below your own")
+		return nil, false
+	}
+	return u, true
+}
+
+// queryBool returns the value of a boolean query parameter, nil if it is not
+// set, writing an error response and returning false if it is invalid.
+func queryBool(w http.ResponseWriter, r *http.Request, name string) (*bool, bool) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return nil, true
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_query", "invalid "+name+", expected true or false")
+		return nil, false
+	}
+	return &v, true
+}
+
+// writeUserError writes the response for an error returned by user.Store.
+func writeUserError(w http.ResponseWriter, msg string, err error) {
+	switch {
+	case errors.Is(err, user.ErrNotFound):
+		writeError(w, http.StatusNotFound, "not_found", "user not found")
+	case errors.Is(err, user.ErrEmailTaken):
+		writeError(w, http.StatusConflict, "email_taken", "email address is already in use")
+	default:
+		writeInternalError(w, msg, err)
+	}
+}
@@ -0,0 +1,318 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/internal/api"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/privacy"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+	"github.com/matthewpi/cosmos/user/usertest"
+)
+
+func TestUsers_List(t *testing.T) {
+	ts := newTestServer(
+		func(ts *testServer) func(chi.Router) {
+			return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail).Routes
+		},
+		func(ts *testServer) func(chi.Router) {
+			return api.NewUsers(ts.users, ts.manager, newPrivacy(ts, ts.users), nil).Routes
+		},
+	)
+	admin, _ := user.New("admin@example.com", []byte("password123"))
+	admin.ID = 1
+	admin.Role = &role.Role{ID: 1, Permissions: role.Permissions{"users.*"}}
+	_ = ts.users.Create(context.Background(), admin)
+	for i, email := range []string{"a1@example.com", "a2@example.com", "b1@example.com", "b2@example.com"} {
+		u, _ := user.New(email, nil)
+		u.ID = snowflake.Snowflake(10 + i)
+		u.Confirmed = i%2 == 0
+		u.Locked = i == 3
+		_ = ts.users.Create(context.Background(), u)
+	}
+	cookie := ts.do(http.MethodPost, "/auth/login", map[string]string{
+		"email":    "admin@example.com",
+		"password": "password123",
+	}).Result().Cookies()[0]
+
+	var next snowflake.Snowflake
+	for i, tc := range []struct {
+		Query  func() string
+		Status int
+		Emails []string
+	}{
+		{
+			Query:  func() string { return "?limit=2" },
+			Status: http.StatusOK,
+			Emails: []string{"admin@example.com", "a1@example.com"},
+		},
+		{
+			Query:  func() string { return "?limit=2&after=" + next.String() },
+			Status: http.StatusOK,
+			Emails: []string{"a2@example.com", "b1@example.com"},
+		},
+		{
+			Query:  func() string { return "?sort=-created_at&limit=2" },
+			Status: http.StatusOK,
+			Emails: []string{"b2@example.com", "b1@example.com"},
+		},
+		{
+			Query:  func() string { return "?sort=-created_at&after=" + next.String() },
+			Status: http.StatusOK,
+			Emails: []string{"a2@example.com", "a1@example.com", "admin@example.com"},
+		},
+		{
+			Query:  func() string { return "?confirmed=true" },
+			Status: http.StatusOK,
+			Emails: []string{"a1@example.com", "b1@example.com"},
+		},
+		{
+			Query:  func() string { return "?locked=true" },
+			Status: http.StatusOK,
+			Emails: []string{"b2@example.com"},
+		},
+		{
+			Query:  func() string { return "?email_prefix=B&confirmed=false" },
+			Status: http.StatusOK,
+			Emails: []string{"b2@example.com"},
+		},
+		{Query: func() string { return "?sort=email" }, Status: http.StatusBadRequest},
+		{Query: func() string { return "?locked=maybe" }, Status: http.StatusBadRequest},
+		{Query: func() string { return "?after=abc" }, Status: http.StatusBadRequest},
+	} {
+		w := ts.do(http.MethodGet, "/api/v1/users"+tc.Query(), nil, cookie)
+		if w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+			continue
+		}
+		if tc.Status != http.StatusOK {
+			continue
+		}
+		var body struct {
+			Users []*user.User        `json:"users"`
+			Next  snowflake.Snowflake `json:"next"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		emails := []string{}
+		for _, u := range body.Users {
+			emails = append(emails, u.Email)
+		}
+		if !reflect.DeepEqual(emails, tc.Emails) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Emails, emails)
+		}
+		next = body.Next
+	}
+}
+
+func TestUsers_Manage(t *testing.T) {
+	var erasure *memoryErasure
+	ts := newTestServer(
+		func(ts *testServer) func(chi.Router) {
+			return api.NewAuth(ts.config, ts.users, ts.manager, ts.tokens, ts.mail).Routes
+		},
+		func(ts *testServer) func(chi.Router) {
+			erasure = &memoryErasure{users: ts.users}
+			d := privacy.NewManager(erasure, ts.users, ts.manager, ts.log, nil, nil, nil)
+			return api.NewUsers(ts.users, ts.manager, d, nil).Routes
+		},
+	)
+	admin, _ := user.New("admin@example.com", []byte("password123"))
+	admin.Role = &role.Role{ID: 1, SortID: 1, Permissions: role.Permissions{"users.*"}}
+	peer, _ := user.New("peer@example.com", []byte("password123"))
+	peer.ID = admin.ID + 1
+	peer.Role = admin.Role
+	_ = ts.users.Create(context.Background(), admin)
+	_ = ts.users.Create(context.Background(), peer)
+	login := func(email string) *http.Cookie {
+		return ts.do(http.MethodPost, "/auth/login", map[string]string{
+			"email":    email,
+			"password": "password123",
+		}).Result().Cookies()[0]
+	}
+	cookie := login("admin@example.com")
+
+	// Users see themselves in the self view, administrators see the admin
+	// view.
+	for i, tc := range []struct {
+		Response *httptest.ResponseRecorder
+		Expect   map[string]bool
+	}{
+		{
+			Response: ts.do(http.MethodPost, "/auth/login", map[string]string{"email": "peer@example.com", "password": "password123"}),
+			Expect:   map[string]bool{"email": true, "locked": false, "role": false, "last_login_at": false},
+		},
+		{
+			Response: ts.do(http.MethodGet, "/api/v1/users/"+peer.ID.String(), nil, cookie),
+			Expect:   map[string]bool{"email": true, "locked": true, "role": true, "last_login_at": true},
+		},
+	} {
+		var body map[string]interface{}
+		if err := json.Unmarshal(tc.Response.Body.Bytes(), &body); err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		for key, expect := range tc.Expect {
+			if _, ok := body[key]; ok != expect {
+				t.Errorf("Test #%d: Expected \"%s\" to be included: \"%t\", but got \"%t\"", i, key, expect, ok)
+			}
+		}
+	}
+
+	// Creating a user.
+	for i, tc := range []struct {
+		Body   map[string]interface{}
+		Status int
+	}{
+		{Body: map[string]interface{}{"email": "User@Example.com", "password": "password123"}, Status: http.StatusCreated},
+		{Body: map[string]interface{}{"email": "user@example.com"}, Status: http.StatusConflict},
+		{Body: map[string]interface{}{"email": "other@example.com", "password": "short"}, Status: http.StatusBadRequest},
+		{Body: map[string]interface{}{"password": "password123"}, Status: http.StatusBadRequest},
+	} {
+		if w := ts.do(http.MethodPost, "/api/v1/users", tc.Body, cookie); w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+		}
+	}
+	u, err := ts.users.ByEmail(context.Background(), "user@example.com")
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	userCookie := login("user@example.com")
+	path := "/api/v1/users/" + u.ID.String()
+
+	// Users without permission can not manage users.
+	if w := ts.do(http.MethodGet, path, nil, userCookie); w.Code != http.StatusForbidden {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusForbidden, w.Code)
+	}
+
+	// Changing a user's password revokes their sessions.
+	body := map[string]interface{}{"confirmed": true, "password": "new-password123"}
+	if w := ts.do(http.MethodPatch, path, body, cookie); w.Code != http.StatusOK {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusOK, w.Code)
+	}
+	if u, _ := ts.users.ByID(context.Background(), u.ID); !u.Confirmed || u.VerifyPassword([]byte("new-password123")) != nil {
+		t.Errorf("Expected the user to be updated")
+	}
+	if w := ts.do(http.MethodGet, "/api/v1/users", nil, userCookie); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusUnauthorized, w.Code)
+	}
+
+	// Users with a role that does not rank below the actor's can not be
+	// managed.
+	peerPath := "/api/v1/users/" + peer.ID.String()
+	if w := ts.do(http.MethodGet, peerPath, nil, cookie); w.Code != http.StatusOK {
+		t.Errorf("Expected \"%d\", but got \"%d\"", http.StatusOK, w.Code)
+	}
+	for i, method := range []string{http.MethodPatch, http.MethodDelete} {
+		if w := ts.do(method, peerPath, map[string]interface{}{}, cookie); w.Code != http.StatusForbidden {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, http.StatusForbidden, w.Code)
+		}
+	}
+
+	for i, status := range []int{http.StatusNoContent, http.StatusNotFound} {
+		if w := ts.do(http.MethodDelete, path, nil, cookie); w.Code != status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, status, w.Code)
+		}
+	}
+	// Deleted users are erased like accounts whose deletion is due.
+	if len(erasure.erased) != 1 || erasure.erased[0] != u.ID {
+		t.Errorf("Expected \"%v\", but got \"%v\"", []snowflake.Snowflake{u.ID}, erasure.erased)
+	}
+}
+
+func TestUsers_ManageRank(t *testing.T) {
+	db := usertest.New()
+	ts := newStoreTestServer(db,
+		func(ts *testServer) func(chi.Router) {
+			return api.NewAuth(ts.config, ts.db.Users(), ts.manager, ts.tokens, ts.mail).Routes
+		},
+		func(ts *testServer) func(chi.Router) {
+			return api.NewUsers(ts.db.Users(), ts.manager, newPrivacy(ts, ts.db.Users()), nil).Routes
+		},
+	)
+	owner := &role.Role{ID: snowflake.New(), Name: "Owner", SortID: 0, Permissions: role.Permissions{"*"}}
+	admin := &role.Role{ID: snowflake.New(), Name: "Administrator", SortID: 1, Permissions: role.Permissions{"users.*"}}
+	member := &role.Role{ID: snowflake.New(), Name: "Member", SortID: 2}
+	createRoles(t, db, owner, admin, member)
+	createUser(t, db, "admin@example.com", admin)
+	top := createUser(t, db, "owner@example.com", owner)
+	peer := createUser(t, db, "peer@example.com", admin)
+	u := createUser(t, db, "user@example.com", member)
+	cookie := ts.do(http.MethodPost, "/auth/login", map[string]string{
+		"email":    "admin@example.com",
+		"password": "password123",
+	}).Result().Cookies()[0]
+
+	// Users ranking above or alongside the actor can not have their
+	// password reset or be deleted, users ranking below can.
+	for i, tc := range []struct {
+		Method string
+		User   *user.User
+		Status int
+	}{
+		{Method: http.MethodPatch, User: top, Status: http.StatusForbidden},
+		{Method: http.MethodDelete, User: top, Status: http.StatusForbidden},
+		{Method: http.MethodPatch, User: peer, Status: http.StatusForbidden},
+		{Method: http.MethodDelete, User: peer, Status: http.StatusForbidden},
+		{Method: http.MethodPatch, User: u, Status: http.StatusOK},
+		{Method: http.MethodDelete, User: u, Status: http.StatusNoContent},
+	} {
+		body := map[string]interface{}{"password": "new-password123"}
+		if w := ts.do(tc.Method, "/api/v1/users/"+tc.User.ID.String(), body, cookie); w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+		}
+	}
+
+	for i, tc := range []struct {
+		User     *user.User
+		Password string
+	}{
+		{User: top, Password: "password123"},
+		{User: peer, Password: "password123"},
+	} {
+		got, err := db.Users().ByID(context.Background(), tc.User.ID)
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		if err := got.VerifyPassword([]byte(tc.Password)); err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+	}
+	if _, err := db.Users().ByID(context.Background(), u.ID); !errors.Is(err, user.ErrNotFound) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", user.ErrNotFound, err)
+	}
+}
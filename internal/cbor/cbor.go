@@ -0,0 +1,322 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package cbor implements the subset of CBOR (RFC 8949) used by WebAuthn.
+//
+// Only definite length items are supported.  Decoded values are one of
+// uint64, int64 (negative integers only), []byte, string, []interface{},
+// map[interface{}]interface{}, bool or nil.  Map keys are always either
+// int64, uint64 or string.
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	majorUnsigned = 0
+	majorNegative = 1
+	majorBytes    = 2
+	majorText     = 3
+	majorArray    = 4
+	majorMap      = 5
+	majorTag      = 6
+	majorSimple   = 7
+)
+
+// maxDepth is the maximum nesting depth of decoded items.
+const maxDepth = 16
+
+var (
+	// ErrUnexpectedEOF is returned when the input ends in the middle of an
+	// item.
+	ErrUnexpectedEOF = errors.New("cbor: unexpected end of input")
+
+	// ErrUnsupported is returned when the input uses a CBOR feature that is
+	// not supported, such as indefinite lengths or floating point numbers.
+	ErrUnsupported = errors.New("cbor: unsupported item")
+)
+
+// Decode decodes a single item, returning it and the remaining input.
+func Decode(data []byte) (interface{}, []byte, error) {
+	d := decoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, d.data, nil
+}
+
+// Unmarshal decodes a single item, returning an error if there is trailing
+// input.
+func Unmarshal(data []byte) (interface{}, error) {
+	v, rest, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("cbor: %d bytes of trailing data", len(rest))
+	}
+	return v, nil
+}
+
+type decoder struct {
+	data []byte
+}
+
+func (d *decoder) next(n uint64) ([]byte, error) {
+	if uint64(len(d.data)) < n {
+		return nil, ErrUnexpectedEOF
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+// head decodes the head of an item, returning its major type and argument.
+func (d *decoder) head() (byte, uint64, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		b, err := d.next(1)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(b[0]), nil
+	case info == 25:
+		b, err := d.next(2)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(binary.BigEndian.Uint16(b)), nil
+	case info == 26:
+		b, err := d.next(4)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(binary.BigEndian.Uint32(b)), nil
+	case info == 27:
+		b, err := d.next(8)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, binary.BigEndian.Uint64(b), nil
+	default:
+		return 0, 0, ErrUnsupported
+	}
+}
+
+func (d *decoder) decode(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("cbor: maximum depth exceeded")
+	}
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case majorUnsigned:
+		return arg, nil
+	case majorNegative:
+		if arg > math.MaxInt64 {
+			return nil, ErrUnsupported
+		}
+		return -1 - int64(arg), nil
+	case majorBytes:
+		b, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case majorText:
+		b, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case majorArray:
+		// Every item is at least a byte long.
+		if arg > uint64(len(d.data)) {
+			return nil, ErrUnexpectedEOF
+		}
+		v := make([]interface{}, arg)
+		for i := range v {
+			if v[i], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case majorMap:
+		if arg > uint64(len(d.data))/2 {
+			return nil, ErrUnexpectedEOF
+		}
+		v := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case uint64, int64, string:
+			default:
+				return nil, ErrUnsupported
+			}
+			if _, ok := v[k]; ok {
+				return nil, fmt.Errorf("cbor: duplicate map key %v", k)
+			}
+			if v[k], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case majorTag:
+		// Tags are ignored, only the tagged item is returned.
+		return d.decode(depth + 1)
+	default:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		default:
+			return nil, ErrUnsupported
+		}
+	}
+}
+
+// Marshal encodes a value using the canonical CBOR encoding described by
+// CTAP2, map keys are sorted by their encoded form.
+//
+// Supported types are the types returned by Decode, int and map[string]
+// interface{}.
+func Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := encode(&b, v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func encodeHead(b *bytes.Buffer, major byte, arg uint64) {
+	major <<= 5
+	switch {
+	case arg < 24:
+		b.WriteByte(major | byte(arg))
+	case arg <= math.MaxUint8:
+		b.WriteByte(major | 24)
+		b.WriteByte(byte(arg))
+	case arg <= math.MaxUint16:
+		b.WriteByte(major | 25)
+		_ = binary.Write(b, binary.BigEndian, uint16(arg))
+	case arg <= math.MaxUint32:
+		b.WriteByte(major | 26)
+		_ = binary.Write(b, binary.BigEndian, uint32(arg))
+	default:
+		b.WriteByte(major | 27)
+		_ = binary.Write(b, binary.BigEndian, arg)
+	}
+}
+
+func encode(b *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		b.WriteByte(majorSimple<<5 | 22)
+	case bool:
+		if v {
+			b.WriteByte(majorSimple<<5 | 21)
+		} else {
+			b.WriteByte(majorSimple<<5 | 20)
+		}
+	case int:
+		return encode(b, int64(v))
+	case int64:
+		if v < 0 {
+			encodeHead(b, majorNegative, uint64(-1-v))
+		} else {
+			encodeHead(b, majorUnsigned, uint64(v))
+		}
+	case uint64:
+		encodeHead(b, majorUnsigned, v)
+	case []byte:
+		encodeHead(b, majorBytes, uint64(len(v)))
+		b.Write(v)
+	case string:
+		encodeHead(b, majorText, uint64(len(v)))
+		b.WriteString(v)
+	case []interface{}:
+		encodeHead(b, majorArray, uint64(len(v)))
+		for _, item := range v {
+			if err := encode(b, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for k, item := range v {
+			m[k] = item
+		}
+		return encode(b, m)
+	case map[interface{}]interface{}:
+		type entry struct {
+			key   []byte
+			value interface{}
+		}
+		entries := make([]entry, 0, len(v))
+		for k, item := range v {
+			key, err := Marshal(k)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{key: key, value: item})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			a, b := entries[i].key, entries[j].key
+			if len(a) != len(b) {
+				return len(a) < len(b)
+			}
+			return bytes.Compare(a, b) < 0
+		})
+		encodeHead(b, majorMap, uint64(len(entries)))
+		for _, e := range entries {
+			b.Write(e.key)
+			if err := encode(b, e.value); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: unsupported type %T", v)
+	}
+	return nil
+}
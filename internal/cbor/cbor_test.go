@@ -0,0 +1,109 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package cbor_test
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/matthewpi/cosmos/internal/cbor"
+)
+
+func TestUnmarshal(t *testing.T) {
+	// RFC 8949 Appendix A
+	for i, tc := range []struct {
+		Input  string
+		Expect interface{}
+	}{
+		{Input: "00", Expect: uint64(0)},
+		{Input: "17", Expect: uint64(23)},
+		{Input: "1818", Expect: uint64(24)},
+		{Input: "1903e8", Expect: uint64(1000)},
+		{Input: "1b000000e8d4a51000", Expect: uint64(1000000000000)},
+		{Input: "20", Expect: int64(-1)},
+		{Input: "3903e7", Expect: int64(-1000)},
+		{Input: "4401020304", Expect: []byte{1, 2, 3, 4}},
+		{Input: "6449455446", Expect: "IETF"},
+		{Input: "f4", Expect: false},
+		{Input: "f5", Expect: true},
+		{Input: "f6", Expect: nil},
+		{Input: "8301820203820405", Expect: []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+		{Input: "a201020304", Expect: map[interface{}]interface{}{uint64(1): uint64(2), uint64(3): uint64(4)}},
+		{Input: "a26161016162820203", Expect: map[interface{}]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}},
+		{Input: "c11a514b67b0", Expect: uint64(1363896240)},
+	} {
+		input, _ := hex.DecodeString(tc.Input)
+		v, err := cbor.Unmarshal(input)
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, tc.Expect) {
+			t.Errorf("Test #%d: Expected \"%#v\", but got \"%#v\"", i, tc.Expect, v)
+		}
+	}
+}
+
+func TestUnmarshal_Invalid(t *testing.T) {
+	for i, input := range []string{
+		"",
+		"18",
+		"4401",
+		"5f42010243030405ff", // indefinite length
+		"f97c00",             // float
+		"a20102",
+		"a201020103", // duplicate key
+		"0000",       // trailing data
+		"9bffffffffffffffff",
+	} {
+		b, _ := hex.DecodeString(input)
+		if _, err := cbor.Unmarshal(b); err == nil {
+			t.Errorf("Test #%d: Expected an error", i)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	for i, tc := range []struct {
+		Input  interface{}
+		Expect string
+	}{
+		{Input: 1000, Expect: "1903e8"},
+		{Input: -1000, Expect: "3903e7"},
+		{Input: []byte{1, 2, 3, 4}, Expect: "4401020304"},
+		{Input: "IETF", Expect: "6449455446"},
+		{Input: []interface{}{1, []interface{}{2, 3}}, Expect: "8201820203"},
+		// Keys are sorted by their encoded form.
+		{Input: map[interface{}]interface{}{-1: 1, 3: 2, 1: 3, "a": 4}, Expect: "a4010303022001616104"},
+	} {
+		b, err := cbor.Marshal(tc.Input)
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		if v := hex.EncodeToString(b); v != tc.Expect {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, tc.Expect, v)
+		}
+	}
+}
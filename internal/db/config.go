@@ -0,0 +1,70 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package db
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/matthewpi/cosmos/internal/config/lexer"
+)
+
+// Config represents the configuration for a database connection.
+type Config struct {
+	// DSN is the connection string used to connect to PostgreSQL.
+	DSN string `json:"dsn"`
+
+	// MaxConns is the maximum size of the connection pool, if zero the pgx
+	// default is used.
+	MaxConns int32 `json:"max_conns"`
+}
+
+// FromLexer .
+func FromLexer(b lexer.Block) (*Config, error) {
+	c := &Config{}
+	for _, s := range b.Segments {
+		d := s.Directive()
+		switch d {
+		case "dsn":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after dsn directive")
+			}
+			c.DSN = s[1].Text
+		case "max_conns":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after max_conns directive")
+			}
+			v, err := strconv.ParseInt(s[1].Text, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid max_conns: %w", err)
+			}
+			c.MaxConns = int32(v)
+		default:
+			return nil, fmt.Errorf("unknown directive: \"" + d + "\"")
+		}
+	}
+	if c.DSN == "" {
+		return nil, fmt.Errorf("missing dsn directive")
+	}
+	return c, nil
+}
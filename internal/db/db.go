@@ -87,5 +87,12 @@ func (db *database) DropIfExists(name string) error {
 }
 
 func (db *database) Table(name string, f func(Table)) error {
+	t := &table{
+		name:    name,
+		columns: make(map[string]*column),
+	}
+	f(t)
+
+	fmt.Println(t.buildAlter() + "\n")
 	return nil
 }
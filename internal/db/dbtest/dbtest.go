@@ -0,0 +1,353 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package dbtest provides a db.Querier for testing stores without a
+// database.
+//
+// Queries are answered by handlers matched against their SQL, and rows are
+// keyed by the expressions they are selected with, so a store's SELECT and
+// its scan function are exercised together.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/matthewpi/pgconn"
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/internal/db"
+)
+
+// Row is a row of values keyed by the expressions they are selected with,
+// for example "users.id".  A row with a single value answers a query
+// selecting a single expression regardless of its key.
+type Row map[string]interface{}
+
+// Handler answers a query, returning the rows it selects.  For Exec the
+// number of rows is the number of rows affected.
+type Handler func(s Statement) ([]Row, error)
+
+// Statement is a query or a statement executed with Exec.
+type Statement struct {
+	SQL  string
+	Args []interface{}
+}
+
+type handler struct {
+	contains string
+	f        Handler
+}
+
+// Querier is a db.Querier answering queries with handlers.  Transactions
+// are not isolated and are never rolled back.
+type Querier struct {
+	mu         sync.Mutex
+	handlers   []handler
+	statements []Statement
+}
+
+var _ db.Querier = (*Querier)(nil)
+
+// New returns a new Querier.
+func New() *Querier {
+	return &Querier{}
+}
+
+// Handle registers a handler for queries containing contains, handlers
+// registered later take precedence.  Exec statements without a handler
+// affect no rows, other queries without a handler return an error.
+func (q *Querier) Handle(contains string, f Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers = append(q.handlers, handler{contains: contains, f: f})
+}
+
+// Statements returns the statements executed with Exec containing
+// contains, in order.
+func (q *Querier) Statements(contains string) []Statement {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var statements []Statement
+	for _, s := range q.statements {
+		if strings.Contains(s.SQL, contains) {
+			statements = append(statements, s)
+		}
+	}
+	return statements
+}
+
+func (q *Querier) Exec(_ context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	q.mu.Lock()
+	q.statements = append(q.statements, Statement{SQL: sql, Args: args})
+	q.mu.Unlock()
+	f := q.handler(sql)
+	if f == nil {
+		return pgconn.CommandTag("0"), nil
+	}
+	rows, err := f(Statement{SQL: sql, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	return pgconn.CommandTag(strconv.Itoa(len(rows))), nil
+}
+
+func (q *Querier) Query(_ context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	f := q.handler(sql)
+	if f == nil {
+		return nil, fmt.Errorf("dbtest: unexpected query: %s", sql)
+	}
+	rows, err := f(Statement{SQL: sql, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	return &result{columns: Columns(sql), rows: rows, cursor: -1}, nil
+}
+
+func (q *Querier) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return &row{err: err}
+	}
+	r := rows.(*result)
+	if !r.Next() {
+		return &row{err: db.ErrNoRows}
+	}
+	return &row{result: r}
+}
+
+func (q *Querier) BeginFunc(ctx context.Context, f func(pgx.Tx) error) error {
+	return f(&tx{q: q})
+}
+
+// handler returns the handler for a query, or nil.
+func (q *Querier) handler(sql string) Handler {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := len(q.handlers) - 1; i >= 0; i-- {
+		if strings.Contains(sql, q.handlers[i].contains) {
+			return q.handlers[i].f
+		}
+	}
+	return nil
+}
+
+// Columns returns the expressions selected by a query, or the columns
+// returned by a RETURNING clause.
+func Columns(sql string) []string {
+	list := sql
+	if i := topLevel(sql, " RETURNING "); i >= 0 {
+		list = sql[i+len(" RETURNING "):]
+	} else if i := strings.Index(sql, "SELECT "); i >= 0 {
+		list = sql[i+len("SELECT "):]
+		if j := topLevel(list, " FROM "); j >= 0 {
+			list = list[:j]
+		}
+	}
+	var columns []string
+	var depth, start int
+	for i, c := range list {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				columns = append(columns, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(columns, strings.TrimSpace(list[start:]))
+}
+
+// Inserted returns the values of an INSERT statement keyed by column.
+func Inserted(s Statement) Row {
+	open := strings.Index(s.SQL, "(")
+	end := strings.Index(s.SQL, ")")
+	if open < 0 || end < open {
+		return nil
+	}
+	r := Row{}
+	for i, c := range strings.Split(s.SQL[open+1:end], ",") {
+		if i < len(s.Args) {
+			r[strings.TrimSpace(c)] = s.Args[i]
+		}
+	}
+	return r
+}
+
+// topLevel returns the index of the first occurrence of substr outside of
+// parentheses, or -1.
+func topLevel(s, substr string) int {
+	var depth int
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		default:
+			if depth == 0 && strings.HasPrefix(s[i:], substr) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// result is the pgx.Rows of a query, methods other than those used by
+// stores are not implemented.
+type result struct {
+	pgx.Rows
+
+	columns []string
+	rows    []Row
+	cursor  int
+	err     error
+}
+
+func (r *result) Close() {}
+
+func (r *result) Err() error {
+	return r.err
+}
+
+func (r *result) Next() bool {
+	if r.err != nil || r.cursor+1 >= len(r.rows) {
+		return false
+	}
+	r.cursor++
+	return true
+}
+
+func (r *result) Scan(dest ...interface{}) error {
+	if len(dest) != len(r.columns) {
+		return fmt.Errorf("dbtest: scanning %d columns into %d destinations", len(r.columns), len(dest))
+	}
+	current := r.rows[r.cursor]
+	for i, d := range dest {
+		v, ok := current[r.columns[i]]
+		if !ok && len(current) == 1 && len(r.columns) == 1 {
+			for _, v = range current {
+				ok = true
+			}
+		}
+		if !ok {
+			return fmt.Errorf("dbtest: no value for %q", r.columns[i])
+		}
+		if err := assign(d, v); err != nil {
+			return fmt.Errorf("dbtest: scanning %q: %w", r.columns[i], err)
+		}
+	}
+	return nil
+}
+
+// row is the pgx.Row of a query.
+type row struct {
+	result *result
+	err    error
+}
+
+func (r *row) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.result.Scan(dest...)
+}
+
+// assign assigns a value to a scan destination.
+func assign(dest, v interface{}) error {
+	if s, ok := dest.(sql.Scanner); ok {
+		if valuer, ok := v.(driver.Valuer); ok {
+			var err error
+			if v, err = valuer.Value(); err != nil {
+				return err
+			}
+		}
+		return s.Scan(v)
+	}
+	d := reflect.ValueOf(dest)
+	if d.Kind() != reflect.Ptr || d.IsNil() {
+		return errors.New("destination is not a pointer")
+	}
+	d = d.Elem()
+	if v == nil {
+		d.Set(reflect.Zero(d.Type()))
+		return nil
+	}
+	value := reflect.ValueOf(v)
+	if d.Kind() == reflect.Ptr && value.Kind() != reflect.Ptr {
+		p := reflect.New(d.Type().Elem())
+		if err := assign(p.Interface(), v); err != nil {
+			return err
+		}
+		d.Set(p)
+		return nil
+	}
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			d.Set(reflect.Zero(d.Type()))
+			return nil
+		}
+		if !value.Type().AssignableTo(d.Type()) {
+			value = value.Elem()
+		}
+	}
+	if !value.Type().ConvertibleTo(d.Type()) {
+		return fmt.Errorf("cannot assign %T to %s", v, d.Type())
+	}
+	d.Set(value.Convert(d.Type()))
+	return nil
+}
+
+// tx is a pgx.Tx using its Querier, methods other than those used by
+// stores are not implemented.
+type tx struct {
+	pgx.Tx
+
+	q *Querier
+}
+
+func (t *tx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return t.q.Exec(ctx, sql, args...)
+}
+
+func (t *tx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return t.q.Query(ctx, sql, args...)
+}
+
+func (t *tx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return t.q.QueryRow(ctx, sql, args...)
+}
+
+func (t *tx) BeginFunc(ctx context.Context, f func(pgx.Tx) error) error {
+	return f(t)
+}
@@ -0,0 +1,65 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package migrations
+
+import (
+	"github.com/matthewpi/cosmos/internal/db"
+)
+
+func init() {
+	addMigration(&M2026101810CreateAuditEventsTable{})
+}
+
+type M2026101810CreateAuditEventsTable struct{}
+
+var _ db.Migration = (*M2026101810CreateAuditEventsTable)(nil)
+
+func (m *M2026101810CreateAuditEventsTable) Up(d db.DB) error {
+	return d.Create("audit_events", func(t db.Table) {
+		t.BigInt("id").
+			Primary()
+		t.VarChar("type", 64).
+			Index()
+		// actor_id and target_id deliberately do not reference users, the
+		// trail must outlive the accounts it mentions.
+		t.BigInt("actor_id").
+			Nullable().
+			Index()
+		t.BigInt("target_id").
+			Nullable().
+			Index()
+		t.VarChar("ip", 45)
+		t.Text("user_agent")
+		// The payload is stored as text rather than jsonb so it is kept
+		// byte-for-byte, as it is part of the event's hash.
+		t.Text("payload")
+		t.TimestampTZ("created_at").
+			Index()
+		t.VarChar("prev_hash", 64)
+		t.VarChar("hash", 64)
+	})
+}
+
+func (m *M2026101810CreateAuditEventsTable) Down(d db.DB) error {
+	return d.DropIfExists("audit_events")
+}
@@ -0,0 +1,48 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package migrations
+
+import (
+	"github.com/matthewpi/cosmos/internal/db"
+)
+
+func init() {
+	addMigration(&M2026101811AddUsersLastLoginAtColumn{})
+}
+
+type M2026101811AddUsersLastLoginAtColumn struct{}
+
+var _ db.Migration = (*M2026101811AddUsersLastLoginAtColumn)(nil)
+
+func (m *M2026101811AddUsersLastLoginAtColumn) Up(d db.DB) error {
+	return d.Table("users", func(t db.Table) {
+		t.TimestampTZ("last_login_at").
+			Nullable()
+	})
+}
+
+func (m *M2026101811AddUsersLastLoginAtColumn) Down(d db.DB) error {
+	return d.Table("users", func(t db.Table) {
+		t.DropColumns("last_login_at")
+	})
+}
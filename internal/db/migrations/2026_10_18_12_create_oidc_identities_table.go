@@ -0,0 +1,58 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package migrations
+
+import (
+	"github.com/matthewpi/cosmos/internal/db"
+)
+
+func init() {
+	addMigration(&M2026101812CreateOIDCIdentitiesTable{})
+}
+
+type M2026101812CreateOIDCIdentitiesTable struct{}
+
+var _ db.Migration = (*M2026101812CreateOIDCIdentitiesTable)(nil)
+
+func (m *M2026101812CreateOIDCIdentitiesTable) Up(d db.DB) error {
+	return d.Create("oidc_identities", func(t db.Table) {
+		// id is the hash of the issuer and subject, which together identify
+		// an account at a provider.
+		t.VarChar("id", 64).
+			Primary()
+		t.BigInt("user_id").
+			Index().
+			References("users", "id").
+			OnDelete(db.Cascade).
+			OnUpdate(db.Cascade)
+		t.VarChar("issuer", 255)
+		t.VarChar("subject", 255)
+		t.VarChar("email", 320)
+		t.TimestampTZ("created_at").
+			Default("now()")
+	})
+}
+
+func (m *M2026101812CreateOIDCIdentitiesTable) Down(d db.DB) error {
+	return d.DropIfExists("oidc_identities")
+}
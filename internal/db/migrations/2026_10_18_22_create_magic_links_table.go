@@ -0,0 +1,62 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package migrations
+
+import (
+	"github.com/matthewpi/cosmos/internal/db"
+)
+
+func init() {
+	addMigration(&M2026101822CreateMagicLinksTable{})
+}
+
+type M2026101822CreateMagicLinksTable struct{}
+
+var _ db.Migration = (*M2026101822CreateMagicLinksTable)(nil)
+
+func (m *M2026101822CreateMagicLinksTable) Up(d db.DB) error {
+	return d.Create("magic_links", func(t db.Table) {
+		t.VarChar("id", 64).
+			Primary()
+		t.BigInt("user_id").
+			Nullable().
+			Index().
+			References("users", "id").
+			OnDelete(db.Cascade).
+			OnUpdate(db.Cascade)
+		t.VarChar("email", 255).
+			Index()
+		t.VarChar("ip", 45).
+			Index()
+		t.VarChar("nonce", 64)
+		t.TimestampTZ("created_at").
+			Default("now()")
+		t.TimestampTZ("expires_at")
+		t.TimestampTZ("used_at").
+			Nullable()
+	})
+}
+
+func (m *M2026101822CreateMagicLinksTable) Down(d db.DB) error {
+	return d.DropIfExists("magic_links")
+}
@@ -0,0 +1,56 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package migrations
+
+import (
+	"github.com/matthewpi/cosmos/internal/db"
+)
+
+func init() {
+	addMigration(&M202610185CreateTOTPSecretsTable{})
+}
+
+type M202610185CreateTOTPSecretsTable struct{}
+
+var _ db.Migration = (*M202610185CreateTOTPSecretsTable)(nil)
+
+func (m *M202610185CreateTOTPSecretsTable) Up(d db.DB) error {
+	return d.Create("totp_secrets", func(t db.Table) {
+		t.BigInt("user_id").
+			Primary().
+			References("users", "id").
+			OnDelete(db.Cascade).
+			OnUpdate(db.Cascade)
+		t.VarChar("secret", 64)
+		t.Bool("confirmed").
+			Default("false")
+		t.BigInt("last_counter").
+			Default("0")
+		t.TimestampTZ("created_at").
+			Default("now()")
+	})
+}
+
+func (m *M202610185CreateTOTPSecretsTable) Down(d db.DB) error {
+	return d.DropIfExists("totp_secrets")
+}
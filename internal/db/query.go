@@ -0,0 +1,65 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package db
+
+import (
+	"context"
+
+	"github.com/matthewpi/pgconn"
+	"github.com/matthewpi/pgx/v4"
+	"github.com/matthewpi/pgx/v4/pgxpool"
+)
+
+// ErrNoRows is returned by Row.Scan when a query returned no rows.
+var ErrNoRows = pgx.ErrNoRows
+
+// Querier is implemented by both *pgxpool.Pool and pgx.Tx, allowing queries
+// to be written once and executed either directly against the pool or as part
+// of a transaction.
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+
+	// BeginFunc starts a transaction (or a savepoint if the Querier is already
+	// a transaction) and calls f.  If f does not return an error the
+	// transaction is committed, otherwise it is rolled back.
+	BeginFunc(ctx context.Context, f func(pgx.Tx) error) error
+}
+
+var (
+	_ Querier = (*pgxpool.Pool)(nil)
+	_ Querier = (pgx.Tx)(nil)
+)
+
+// Connect opens a new connection pool using the provided config.
+func Connect(ctx context.Context, c *Config) (*pgxpool.Pool, error) {
+	pc, err := pgxpool.ParseConfig(c.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxConns > 0 {
+		pc.MaxConns = c.MaxConns
+	}
+	return pgxpool.ConnectConfig(ctx, pc)
+}
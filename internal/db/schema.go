@@ -0,0 +1,140 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package db
+
+import (
+	"sort"
+)
+
+// ForeignKey is a column that references another table.
+type ForeignKey struct {
+	// Table and Column are the referencing table and column.
+	Table  string
+	Column string
+
+	// OnDelete is the action taken when the referenced row is deleted.
+	OnDelete ReferentialAction
+}
+
+// Schema is a DB that records the tables created by migrations rather than
+// executing them, allowing the resulting schema to be inspected.
+type Schema struct {
+	tables map[string]*table
+}
+
+var _ DB = (*Schema)(nil)
+
+// Inspect returns the schema resulting from running the migrations in order.
+func Inspect(migrations []Migration) (*Schema, error) {
+	s := &Schema{tables: make(map[string]*table)}
+	for _, m := range migrations {
+		if err := m.Up(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Schema) Create(name string, f func(Table)) error {
+	t := &table{
+		name:    name,
+		columns: make(map[string]*column),
+	}
+	f(t)
+	s.tables[name] = t
+	return nil
+}
+
+func (s *Schema) CreateIfExists(name string, f func(Table)) error {
+	if _, ok := s.tables[name]; ok {
+		return nil
+	}
+	return s.Create(name, f)
+}
+
+func (s *Schema) Drop(name string) error {
+	delete(s.tables, name)
+	return nil
+}
+
+func (s *Schema) DropIfExists(name string) error {
+	return s.Drop(name)
+}
+
+func (s *Schema) Table(name string, f func(Table)) error {
+	alter := &table{
+		name:    name,
+		columns: make(map[string]*column),
+	}
+	f(alter)
+
+	t, ok := s.tables[name]
+	if !ok {
+		t = &table{
+			name:    name,
+			columns: make(map[string]*column),
+		}
+		s.tables[name] = t
+	}
+	offset := len(t.columns)
+	for name, c := range alter.columns {
+		c.id += offset
+		t.columns[name] = c
+	}
+	for _, name := range alter.drops {
+		delete(t.columns, name)
+	}
+	for _, r := range alter.renames {
+		if c, ok := t.columns[r[0]]; ok {
+			delete(t.columns, r[0])
+			c.name = r[1]
+			t.columns[r[1]] = c
+		}
+	}
+	return nil
+}
+
+// References returns the foreign keys referencing a table, ordered by table
+// and column.
+func (s *Schema) References(name string) []ForeignKey {
+	var keys []ForeignKey
+	for _, t := range s.tables {
+		for _, c := range t.columns {
+			if c.reference == nil || c.reference.table != name {
+				continue
+			}
+			keys = append(keys, ForeignKey{
+				Table:    t.name,
+				Column:   c.name,
+				OnDelete: c.reference.onDelete,
+			})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Table != keys[j].Table {
+			return keys[i].Table < keys[j].Table
+		}
+		return keys[i].Column < keys[j].Column
+	})
+	return keys
+}
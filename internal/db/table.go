@@ -62,6 +62,9 @@ type Table interface {
 type table struct {
 	name    string
 	columns map[string]*column
+
+	drops   []string
+	renames [][2]string
 }
 
 var _ Table = (*table)(nil)
@@ -345,11 +348,11 @@ func (t *table) UUID(name string) Column {
 }
 
 func (t *table) DropColumns(columns ...string) {
-
+	t.drops = append(t.drops, columns...)
 }
 
 func (t *table) RenameColumn(old, new string) {
-
+	t.renames = append(t.renames, [2]string{old, new})
 }
 
 func (t *table) build(ifNotExists bool) string {
@@ -361,14 +364,7 @@ func (t *table) build(ifNotExists bool) string {
 	b.WriteString(t.name)
 	b.WriteString(" (\n")
 
-	var columns []*column
-	for _, c := range t.columns {
-		columns = append(columns, c)
-	}
-	sort.Slice(columns, func(i, j int) bool {
-		return columns[i].id < columns[j].id
-	})
-
+	columns := t.sortedColumns()
 	columnCount := len(columns) - 1
 	for i, c := range columns {
 		b.WriteString("\t")
@@ -383,3 +379,54 @@ func (t *table) build(ifNotExists bool) string {
 	b.WriteString(");")
 	return b.String()
 }
+
+// buildAlter builds the statements required to alter an existing table.
+//
+// PostgreSQL does not allow RENAME COLUMN to be combined with other actions,
+// so renames are emitted as their own statements after the other changes.
+func (t *table) buildAlter() string {
+	var actions []string
+	for _, c := range t.sortedColumns() {
+		var b strings.Builder
+		b.WriteString("ADD COLUMN ")
+		c.build(&b)
+		actions = append(actions, b.String())
+	}
+	for _, name := range t.drops {
+		actions = append(actions, "DROP COLUMN "+name)
+	}
+
+	var b strings.Builder
+	if len(actions) > 0 {
+		b.WriteString("ALTER TABLE ")
+		b.WriteString(t.name)
+		b.WriteString("\n\t")
+		b.WriteString(strings.Join(actions, ",\n\t"))
+		b.WriteString(";")
+	}
+	for _, r := range t.renames {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("ALTER TABLE ")
+		b.WriteString(t.name)
+		b.WriteString(" RENAME COLUMN ")
+		b.WriteString(r[0])
+		b.WriteString(" TO ")
+		b.WriteString(r[1])
+		b.WriteString(";")
+	}
+	return b.String()
+}
+
+// sortedColumns returns the table's columns in the order they were defined.
+func (t *table) sortedColumns() []*column {
+	var columns []*column
+	for _, c := range t.columns {
+		columns = append(columns, c)
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		return columns[i].id < columns[j].id
+	})
+	return columns
+}
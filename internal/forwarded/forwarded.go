@@ -137,6 +137,12 @@ func Parse(header string) ([]Proxy, error) {
 				text = append(text, c)
 				continue
 			}
+			// The last pair of an element is terminated by the comma, so it
+			// must be stored before the element is.
+			keys[strings.ToLower(string(key))] = string(text)
+			key = key[:0]
+			text = text[:0]
+			content = false
 			proxies = append(proxies, Proxy{
 				By:    keys["by"],
 				For:   keys["for"],
@@ -144,7 +150,6 @@ func Parse(header string) ([]Proxy, error) {
 				Proto: Proto(keys["proto"]),
 			})
 			keys = map[string]string{}
-			fallthrough
 		case c == ';':
 			if inQuotes {
 				text = append(text, c)
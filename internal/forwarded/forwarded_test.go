@@ -124,6 +124,20 @@ func TestParse(t *testing.T) {
 			},
 			expectErr: nil,
 		},
+		{
+			header: "for=192.0.2.43;proto=https, for=198.51.100.17;by=203.0.113.60",
+			expect: []forwarded.Proxy{
+				{
+					For:   "192.0.2.43",
+					Proto: forwarded.HTTPS,
+				},
+				{
+					By:  "203.0.113.60",
+					For: "198.51.100.17",
+				},
+			},
+			expectErr: nil,
+		},
 	} {
 		result, err := forwarded.Parse(tc.header)
 		if tc.expectErr != nil && err == nil {
@@ -0,0 +1,93 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package forwarded
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the IP address of the client that made a request.
+//
+// The Forwarded header can be set by anyone, so it is only used when the
+// request was made by one of the trusted proxies.  The header is read from
+// right to left, skipping each address belonging to a trusted proxy, the
+// first untrusted address is the client.  If every address is trusted the
+// left-most address is returned.
+func ClientIP(r *http.Request, trusted []*net.IPNet) net.IP {
+	ip := ParseNode(r.RemoteAddr)
+	if ip == nil || !contains(trusted, ip) {
+		return ip
+	}
+	proxies, err := Get(r)
+	if err != nil {
+		return ip
+	}
+	for i := len(proxies) - 1; i >= 0; i-- {
+		v := ParseNode(proxies[i].For)
+		if v == nil {
+			// An obfuscated or unknown node, nothing to the left of it
+			// can be trusted.
+			break
+		}
+		ip = v
+		if !contains(trusted, v) {
+			break
+		}
+	}
+	return ip
+}
+
+// ParseNode parses the IP address of a node identifier, such as
+// "192.0.2.43:47011" or "[2001:db8:cafe::17]:4711", returning nil if the node
+// is obfuscated or "unknown".
+//
+// https://tools.ietf.org/html/rfc7239#section-6
+func ParseNode(v string) net.IP {
+	if strings.HasPrefix(v, "[") {
+		end := strings.IndexByte(v, ']')
+		if end < 0 {
+			return nil
+		}
+		return net.ParseIP(v[1:end])
+	}
+	if ip := net.ParseIP(v); ip != nil {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(v)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// contains returns true if ip is in any of the networks.
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
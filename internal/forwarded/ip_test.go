@@ -0,0 +1,62 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package forwarded_test
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matthewpi/cosmos/internal/forwarded"
+)
+
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{proxies}
+
+	for i, tc := range []struct {
+		remote    string
+		forwarded string
+		expect    string
+	}{
+		{remote: "192.0.2.1:1234", expect: "192.0.2.1"},
+		// Untrusted clients can not spoof their address.
+		{remote: "192.0.2.1:1234", forwarded: "for=198.51.100.7", expect: "192.0.2.1"},
+		{remote: "10.0.0.1:1234", forwarded: "for=198.51.100.7", expect: "198.51.100.7"},
+		{remote: "10.0.0.1:1234", forwarded: "for=\"[2001:db8:cafe::17]:4711\"", expect: "2001:db8:cafe::17"},
+		// Only the address added by the trusted proxy is used.
+		{remote: "10.0.0.1:1234", forwarded: "for=203.0.113.9, for=198.51.100.7", expect: "198.51.100.7"},
+		{remote: "10.0.0.1:1234", forwarded: "for=203.0.113.9, for=198.51.100.7:80, for=10.0.0.2", expect: "198.51.100.7"},
+		{remote: "10.0.0.1:1234", forwarded: "for=203.0.113.9, for=_hidden", expect: "10.0.0.1"},
+		{remote: "10.0.0.1:1234", expect: "10.0.0.1"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			r.Header.Set("Forwarded", tc.forwarded)
+		}
+		if ip := forwarded.ClientIP(r, trusted); ip.String() != tc.expect {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, tc.expect, ip)
+		}
+	}
+}
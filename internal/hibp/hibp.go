@@ -0,0 +1,116 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package hibp checks passwords against a local copy of the Have I Been Pwned
+// Pwned Passwords corpus.
+//
+// The corpus is stored in the range format served by the Pwned Passwords API,
+// a directory with a file for each 5 character prefix of the uppercase hex
+// encoded SHA-1 hash (00000 to FFFFF), named either "ABCDE" or "ABCDE.txt".
+// Each line of a file contains the remaining 35 characters of a hash and the
+// number of times it has been seen, separated by a colon.
+//
+// https://haveibeenpwned.com/API/v3#SearchingPwnedPasswordsByRange
+package hibp
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// prefixLength is the length of the hash prefix ranges are split by.
+const prefixLength = 5
+
+// ErrMissingRange is returned when the file for a range does not exist.
+var ErrMissingRange = errors.New("hibp: missing range file")
+
+// Corpus is a local copy of the Pwned Passwords corpus.
+type Corpus struct {
+	dir string
+}
+
+// Open returns the Corpus stored in a directory.
+func Open(dir string) (*Corpus, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("hibp: %s is not a directory", dir)
+	}
+	return &Corpus{dir: dir}, nil
+}
+
+// Count returns the number of times a password has been seen in breaches,
+// zero if it has not been seen.
+func (c *Corpus) Count(password []byte) (int, error) {
+	sum := sha1.Sum(password)
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	f, err := c.open(hash[:prefixLength])
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return Search(f, hash[prefixLength:])
+}
+
+// open opens the file for a range.
+func (c *Corpus) open(prefix string) (*os.File, error) {
+	for _, name := range []string{prefix + ".txt", prefix} {
+		f, err := os.Open(filepath.Join(c.dir, name))
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrMissingRange, prefix)
+}
+
+// Search searches a range for the suffix of a hash, returning the number of
+// times it has been seen.  Padding entries with a count of zero, as added by
+// the API, are never matched.
+func Search(r io.Reader, suffix string) (int, error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		i := strings.IndexByte(line, ':')
+		if i < 0 || !strings.EqualFold(line[:i], suffix) {
+			continue
+		}
+		count, err := strconv.Atoi(line[i+1:])
+		if err != nil {
+			return 0, fmt.Errorf("hibp: invalid count: %w", err)
+		}
+		return count, nil
+	}
+	return 0, s.Err()
+}
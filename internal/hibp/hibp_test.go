@@ -0,0 +1,85 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package hibp_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matthewpi/cosmos/internal/hibp"
+)
+
+func TestSearch(t *testing.T) {
+	r := "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n" +
+		"00D4F6E8FA6EECAD2A3AA415EEC418D38EC:2\r\n" +
+		"011053FD0102E94D6AE2F8B83D76FAF94F6:0\r\n"
+	for i, tc := range []struct {
+		suffix string
+		expect int
+	}{
+		{suffix: "0018A45C4D1DEF81644B54AB7F969B88D65", expect: 1},
+		{suffix: "00d4f6e8fa6eecad2a3aa415eec418d38ec", expect: 2},
+		{suffix: "011053FD0102E94D6AE2F8B83D76FAF94F6", expect: 0},
+		{suffix: "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF", expect: 0},
+	} {
+		count, err := hibp.Search(strings.NewReader(r), tc.suffix)
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		if count != tc.expect {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.expect, count)
+		}
+	}
+}
+
+func TestCorpus_Count(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hibp")
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// SHA-1("password") = 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	if err := ioutil.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte("1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\n"), 0o644); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	c, err := hibp.Open(dir)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	count, err := c.Count([]byte("password"))
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if count != 9545824 {
+		t.Errorf("Expected \"%d\", but got \"%d\"", 9545824, count)
+	}
+	if _, err := c.Count([]byte("correct horse battery staple")); err == nil {
+		t.Errorf("Expected an error for a missing range")
+	}
+}
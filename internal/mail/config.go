@@ -0,0 +1,83 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package mail
+
+import (
+	"fmt"
+
+	"github.com/matthewpi/cosmos/internal/config/lexer"
+)
+
+// Config represents the configuration for sending email.
+type Config struct {
+	// From is the address email is sent from.
+	From string `json:"from"`
+
+	// SMTP is the address of the SMTP server, if empty email is written to
+	// the log instead.
+	SMTP string `json:"smtp"`
+
+	// Username is the username used to authenticate with the SMTP server.
+	Username string `json:"username"`
+
+	// Password is the password used to authenticate with the SMTP server.
+	Password string `json:"-"`
+}
+
+// FromLexer .
+func FromLexer(b lexer.Block) (*Config, error) {
+	c := &Config{}
+	for _, s := range b.Segments {
+		d := s.Directive()
+		switch d {
+		case "from", "smtp", "username", "password":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after %s directive", d)
+			}
+			switch d {
+			case "from":
+				c.From = s[1].Text
+			case "smtp":
+				c.SMTP = s[1].Text
+			case "username":
+				c.Username = s[1].Text
+			case "password":
+				c.Password = s[1].Text
+			}
+		default:
+			return nil, fmt.Errorf("unknown directive: \"" + d + "\"")
+		}
+	}
+	if c.SMTP != "" && c.From == "" {
+		return nil, fmt.Errorf("missing from directive")
+	}
+	return c, nil
+}
+
+// Mailer returns the Mailer described by the config.
+func (c *Config) Mailer() Mailer {
+	if c.SMTP == "" {
+		return Log
+	}
+	return NewSMTP(c.SMTP, c.From, c.Username, c.Password)
+}
@@ -0,0 +1,92 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package mail sends email to users.
+package mail
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/matthewpi/cosmos"
+)
+
+// Message is a plain text email.
+type Message struct {
+	// To is the address of the recipient.
+	To string
+
+	// Subject is the subject of the email.
+	Subject string
+
+	// Body is the plain text body of the email.
+	Body string
+}
+
+// Mailer sends email.
+type Mailer interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// MailerFunc is an adapter to allow the use of ordinary functions as Mailers.
+type MailerFunc func(ctx context.Context, m *Message) error
+
+// Send calls f(ctx, m).
+func (f MailerFunc) Send(ctx context.Context, m *Message) error {
+	return f(ctx, m)
+}
+
+// Log is a Mailer that writes messages to the log instead of sending them,
+// it is only intended for development.
+var Log Mailer = MailerFunc(func(_ context.Context, m *Message) error {
+	cosmos.Log().Info(
+		"mail",
+		zap.String("to", m.To),
+		zap.String("subject", m.Subject),
+		zap.String("body", m.Body),
+	)
+	return nil
+})
+
+// sendTimeout is the maximum amount of time Async spends sending a message.
+const sendTimeout = 30 * time.Second
+
+// Async returns a Mailer that sends messages in the background, returning
+// immediately.  Failures are logged.
+//
+// This keeps the time taken to respond to a request the same regardless of
+// whether an email was sent, which would otherwise reveal information such as
+// whether an account exists.
+func Async(m Mailer) Mailer {
+	return MailerFunc(func(_ context.Context, msg *Message) error {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			defer cancel()
+			if err := m.Send(ctx, msg); err != nil {
+				cosmos.Log().Error("failed to send mail", zap.String("subject", msg.Subject), zap.Error(err))
+			}
+		}()
+		return nil
+	})
+}
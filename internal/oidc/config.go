@@ -0,0 +1,124 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package oidc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/config/lexer"
+)
+
+// Config represents the configuration of a relying party.
+type Config struct {
+	// Issuer is the URL of the OpenID provider, if empty OpenID Connect is
+	// disabled.
+	Issuer string `json:"issuer"`
+
+	// ClientID and ClientSecret are the credentials issued by the provider,
+	// ClientSecret is empty for public clients.
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"-"`
+
+	// RedirectURL is the URL the provider redirects back to after the user
+	// authenticates, it must be registered with the provider.
+	RedirectURL string `json:"redirect_url"`
+
+	// Scopes are the requested scopes, "openid" is always requested.
+	Scopes []string `json:"scopes"`
+
+	// Register allows creating an account for a verified email address that
+	// does not have one yet.
+	Register bool `json:"register"`
+
+	// StateTTL is how long a login may take.
+	StateTTL time.Duration `json:"state_ttl"`
+}
+
+// DefaultConfig returns the default relying party configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		Scopes:   []string{"openid", "email", "profile"},
+		StateTTL: 10 * time.Minute,
+	}
+}
+
+// Enabled returns true if an issuer is configured.
+func (c *Config) Enabled() bool {
+	return c.Issuer != ""
+}
+
+// FromLexer .
+func FromLexer(b lexer.Block) (*Config, error) {
+	c := DefaultConfig()
+	for _, s := range b.Segments {
+		d := s.Directive()
+		switch d {
+		case "issuer", "client_id", "client_secret", "redirect_url":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after %s directive", d)
+			}
+			switch d {
+			case "issuer":
+				c.Issuer = strings.TrimSuffix(s[1].Text, "/")
+			case "client_id":
+				c.ClientID = s[1].Text
+			case "client_secret":
+				c.ClientSecret = s[1].Text
+			case "redirect_url":
+				c.RedirectURL = s[1].Text
+			}
+		case "scopes":
+			if len(s) < 2 {
+				return nil, fmt.Errorf("expected at least one argument after scopes directive")
+			}
+			c.Scopes = []string{"openid"}
+			for _, t := range s[1:] {
+				if t.Text != "openid" {
+					c.Scopes = append(c.Scopes, t.Text)
+				}
+			}
+		case "register":
+			if len(s) != 1 {
+				return nil, fmt.Errorf("unexpected argument after register directive")
+			}
+			c.Register = true
+		case "state_ttl":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after state_ttl directive")
+			}
+			v, err := time.ParseDuration(s[1].Text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid state_ttl: %w", err)
+			}
+			c.StateTTL = v
+		default:
+			return nil, fmt.Errorf("unknown directive: \"" + d + "\"")
+		}
+	}
+	if c.Enabled() && (c.ClientID == "" || c.RedirectURL == "") {
+		return nil, fmt.Errorf("missing client_id or redirect_url directive")
+	}
+	return c, nil
+}
@@ -0,0 +1,174 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Signature algorithms supported for ID tokens.
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+)
+
+// jwt is a parsed, but not yet verified, JSON Web Token (RFC 7519) using the
+// JWS compact serialization.
+type jwt struct {
+	header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	payload   []byte
+	signed    []byte
+	signature []byte
+}
+
+// parseJWT parses a compact JWT without verifying it.
+func parseJWT(raw string) (*jwt, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	t := &jwt{signed: []byte(parts[0] + "." + parts[1])}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := json.Unmarshal(header, &t.header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if t.payload, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if t.signature, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return nil, ErrInvalidToken
+	}
+	return t, nil
+}
+
+// verify verifies the token's signature with key.  The algorithm named by the
+// token must match the type of key, so a token can not choose how it is
+// verified.
+func (t *jwt) verify(key crypto.PublicKey) error {
+	hash := sha256.Sum256(t.signed)
+	switch t.header.Alg {
+	case AlgRS256:
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidSignature
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], t.signature); err != nil {
+			return ErrInvalidSignature
+		}
+	case AlgES256:
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(t.signature) != 64 {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(t.signature[:32])
+		s := new(big.Int).SetBytes(t.signature[32:])
+		if !ecdsa.Verify(k, hash[:], r, s) {
+			return ErrInvalidSignature
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+	return nil
+}
+
+// Claims are the claims of a verified ID token.
+type Claims struct {
+	Issuer   string
+	Subject  string
+	Audience []string
+	Expiry   time.Time
+	IssuedAt time.Time
+	Nonce    string
+
+	// Email is the user's email address, it must only be trusted if
+	// EmailVerified is true.
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// rawClaims is the JSON encoding of an ID token's claims.
+type rawClaims struct {
+	Issuer          string          `json:"iss"`
+	Subject         string          `json:"sub"`
+	Audience        json.RawMessage `json:"aud"`
+	AuthorizedParty string          `json:"azp"`
+	Expiry          float64         `json:"exp"`
+	IssuedAt        float64         `json:"iat"`
+	Nonce           string          `json:"nonce"`
+	Email           string          `json:"email"`
+	EmailVerified   interface{}     `json:"email_verified"`
+	Name            string          `json:"name"`
+}
+
+// claims converts the raw claims, the audience may be either a string or an
+// array of strings.
+func (c *rawClaims) claims() (*Claims, error) {
+	v := &Claims{
+		Issuer:   c.Issuer,
+		Subject:  c.Subject,
+		Expiry:   numericDate(c.Expiry),
+		IssuedAt: numericDate(c.IssuedAt),
+		Nonce:    c.Nonce,
+		Email:    c.Email,
+		Name:     c.Name,
+	}
+	var aud string
+	if err := json.Unmarshal(c.Audience, &aud); err == nil {
+		v.Audience = []string{aud}
+	} else if err := json.Unmarshal(c.Audience, &v.Audience); err != nil {
+		return nil, ErrInvalidAudience
+	}
+	// Some providers encode email_verified as a string.
+	switch ev := c.EmailVerified.(type) {
+	case bool:
+		v.EmailVerified = ev
+	case string:
+		v.EmailVerified = ev == "true"
+	}
+	return v, nil
+}
+
+// numericDate converts a JWT NumericDate, seconds since the Unix epoch, to a
+// time.
+func numericDate(v float64) time.Time {
+	if v <= 0 {
+		return time.Time{}
+	}
+	sec := int64(v)
+	return time.Unix(sec, int64((v-float64(sec))*float64(time.Second)))
+}
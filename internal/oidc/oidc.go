@@ -0,0 +1,369 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package oidc implements an OpenID Connect relying party using the
+// authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrDiscovery is returned when the provider's metadata can not be
+	// retrieved or is invalid.
+	ErrDiscovery = errors.New("oidc: discovery failed")
+
+	// ErrExchange is returned when the token endpoint rejects an
+	// authorization code.
+	ErrExchange = errors.New("oidc: failed to exchange authorization code")
+
+	// ErrInvalidToken is returned when an ID token is malformed or its
+	// claims are invalid.
+	ErrInvalidToken = errors.New("oidc: invalid id token")
+
+	// ErrUnsupportedAlgorithm is returned when an ID token is signed with an
+	// algorithm other than RS256 or ES256.
+	ErrUnsupportedAlgorithm = errors.New("oidc: unsupported signature algorithm")
+
+	// ErrUnknownKey is returned when an ID token is signed by a key that is
+	// not in the provider's key set.
+	ErrUnknownKey = errors.New("oidc: unknown signing key")
+
+	// ErrInvalidSignature is returned when an ID token's signature does not
+	// verify.
+	ErrInvalidSignature = errors.New("oidc: invalid signature")
+
+	// ErrInvalidIssuer is returned when an ID token was issued by another
+	// provider.
+	ErrInvalidIssuer = errors.New("oidc: invalid issuer")
+
+	// ErrInvalidAudience is returned when an ID token was issued to another
+	// client.
+	ErrInvalidAudience = errors.New("oidc: invalid audience")
+
+	// ErrExpired is returned when an ID token has expired.
+	ErrExpired = errors.New("oidc: id token has expired")
+
+	// ErrInvalidNonce is returned when an ID token's nonce does not match the
+	// nonce of the authorization request.
+	ErrInvalidNonce = errors.New("oidc: invalid nonce")
+)
+
+const (
+	// leeway is the allowed clock skew between us and the provider.
+	leeway = time.Minute
+
+	// keyRefreshInterval is the minimum time between refreshing the key set
+	// because of an unknown key ID, so forged tokens can not be used to make
+	// us hammer the provider.
+	keyRefreshInterval = time.Minute
+
+	// maxResponseSize is the maximum size of a response from the provider.
+	maxResponseSize = 1 << 20
+)
+
+// Metadata is the subset of the provider's metadata used by a relying party,
+// see OpenID Connect Discovery 1.0.
+type Metadata struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+}
+
+// Token is a successful response from the token endpoint.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+}
+
+// Provider is an OpenID provider.  The provider's metadata is discovered on
+// first use, so an unavailable provider does not prevent startup.
+type Provider struct {
+	config *Config
+	client *http.Client
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+
+	mu            sync.Mutex
+	metadata      *Metadata
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// NewProvider returns a new Provider, if client is nil a client with a 10
+// second timeout is used.
+func NewProvider(c *Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{
+		config: c,
+		client: client,
+		Now:    time.Now,
+	}
+}
+
+// Config returns the relying party's configuration.
+func (p *Provider) Config() *Config {
+	return p.config
+}
+
+// Metadata returns the provider's metadata, discovering it if required.
+func (p *Provider) Metadata(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.discover(ctx)
+}
+
+// discover returns the provider's metadata, discovering it if required, p.mu
+// must be held.
+func (p *Provider) discover(ctx context.Context) (*Metadata, error) {
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	var m Metadata
+	if err := p.get(ctx, p.config.Issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	// The issuer must match exactly, otherwise a compromised metadata
+	// document could impersonate another provider.
+	if m.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("%w: issuer \"%s\" does not match \"%s\"", ErrDiscovery, m.Issuer, p.config.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: missing endpoints", ErrDiscovery)
+	}
+	if m.CodeChallengeMethodsSupported != nil && !contains(m.CodeChallengeMethodsSupported, "S256") {
+		return nil, fmt.Errorf("%w: provider does not support S256 code challenges", ErrDiscovery)
+	}
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+// AuthCodeURL returns the URL of the authorization endpoint the user is sent
+// to.  state, nonce and verifier must be random values generated by
+// NewVerifier for each login and remembered until the user returns.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", p.config.RedirectURL)
+	q.Set("scope", strings.Join(scopes(p.config.Scopes), " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", Challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange exchanges an authorization code for tokens.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		_ = json.Unmarshal(body, &e)
+		return nil, fmt.Errorf("%w: %d %s %s", ErrExchange, res.StatusCode, e.Error, e.Description)
+	}
+	var t Token
+	if err := json.Unmarshal(body, &t); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	if t.IDToken == "" {
+		return nil, fmt.Errorf("%w: missing id_token", ErrExchange)
+	}
+	return &t, nil
+}
+
+// Verify verifies an ID token's signature and claims, the token must have
+// been issued to this client for the authorization request with the nonce.
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (*Claims, error) {
+	t, err := parseJWT(raw)
+	if err != nil {
+		return nil, err
+	}
+	if t.header.Alg != AlgRS256 && t.header.Alg != AlgES256 {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	key, err := p.key(ctx, t.header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.verify(key); err != nil {
+		return nil, err
+	}
+
+	var rc rawClaims
+	if err := json.Unmarshal(t.payload, &rc); err != nil {
+		return nil, ErrInvalidToken
+	}
+	c, err := rc.claims()
+	if err != nil {
+		return nil, err
+	}
+	now := p.Now()
+	switch {
+	case c.Issuer != p.config.Issuer:
+		return nil, ErrInvalidIssuer
+	case !contains(c.Audience, p.config.ClientID):
+		return nil, ErrInvalidAudience
+	case (len(c.Audience) > 1 || rc.AuthorizedParty != "") && rc.AuthorizedParty != p.config.ClientID:
+		return nil, ErrInvalidAudience
+	case c.Expiry.IsZero() || !now.Before(c.Expiry.Add(leeway)):
+		return nil, ErrExpired
+	case c.IssuedAt.After(now.Add(leeway)):
+		return nil, ErrInvalidToken
+	case c.Subject == "":
+		return nil, ErrInvalidToken
+	case subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1:
+		return nil, ErrInvalidNonce
+	}
+	return c, nil
+}
+
+// key returns the provider's signing key with the ID.  The key set is
+// refreshed when the key is unknown, as providers rotate their keys.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if p.keys != nil && p.Now().Sub(p.keysFetchedAt) < keyRefreshInterval {
+		return nil, ErrUnknownKey
+	}
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var body json.RawMessage
+	if err := p.get(ctx, m.JWKSURI, &body); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	keys, err := parseKeySet(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	p.keys = keys
+	p.keysFetchedAt = p.Now()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// get fetches a JSON document from the provider.
+func (p *Provider) get(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", res.StatusCode, u)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(v)
+}
+
+// scopes returns the scopes with "openid" first.
+func scopes(v []string) []string {
+	s := []string{"openid"}
+	for _, scope := range v {
+		if scope != "openid" {
+			s = append(s, scope)
+		}
+	}
+	return s
+}
+
+func contains(v []string, s string) bool {
+	for _, x := range v {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package oidc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/oidc"
+	"github.com/matthewpi/cosmos/internal/oidc/oidctest"
+)
+
+const (
+	issuerURL   = "https://idp.example.com"
+	redirectURL = "https://app.example.com/callback"
+)
+
+func newProvider(t *testing.T) (*oidctest.Issuer, *oidc.Provider) {
+	iss, err := oidctest.NewIssuer(issuerURL, "client", "secret", redirectURL)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	c := oidc.DefaultConfig()
+	c.Issuer = issuerURL
+	c.ClientID = "client"
+	c.ClientSecret = "secret"
+	c.RedirectURL = redirectURL
+	return iss, oidc.NewProvider(c, iss.Client())
+}
+
+// login runs the authorization code flow, returning the ID token.
+func login(t *testing.T, iss *oidctest.Issuer, p *oidc.Provider, nonce string) string {
+	verifier, _ := oidc.NewVerifier()
+	u, err := p.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	redirect, err := iss.Authorize(u, oidctest.Identity{Subject: "1234", Email: "user@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if state := redirect.Query().Get("state"); state != "state" {
+		t.Errorf("Expected \"%s\", but got \"%s\"", "state", state)
+	}
+	tok, err := p.Exchange(context.Background(), redirect.Query().Get("code"), verifier)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	return tok.IDToken
+}
+
+func TestProvider_Verify(t *testing.T) {
+	for i, tc := range []struct {
+		Claims func(claims map[string]interface{})
+		Token  func(raw string) string
+		Nonce  string
+		Expect error
+	}{
+		{},
+		{Nonce: "other", Expect: oidc.ErrInvalidNonce},
+		{
+			Claims: func(claims map[string]interface{}) { claims["iss"] = "https://evil.example.com" },
+			Expect: oidc.ErrInvalidIssuer,
+		},
+		{
+			Claims: func(claims map[string]interface{}) { claims["aud"] = "other" },
+			Expect: oidc.ErrInvalidAudience,
+		},
+		{
+			// Multiple audiences require the authorized party to be us.
+			Claims: func(claims map[string]interface{}) { claims["aud"] = []string{"client", "other"} },
+			Expect: oidc.ErrInvalidAudience,
+		},
+		{
+			Claims: func(claims map[string]interface{}) {
+				claims["aud"] = []string{"client", "other"}
+				claims["azp"] = "client"
+			},
+		},
+		{
+			Claims: func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+			Expect: oidc.ErrExpired,
+		},
+		{
+			Claims: func(claims map[string]interface{}) { claims["email_verified"] = "true" },
+		},
+		{
+			Token: func(raw string) string {
+				parts := strings.Split(raw, ".")
+				return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+			},
+			Expect: oidc.ErrUnsupportedAlgorithm,
+		},
+		{
+			Token: func(raw string) string {
+				parts := strings.Split(raw, ".")
+				payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"other"}`))
+				return parts[0] + "." + payload + "." + parts[2]
+			},
+			Expect: oidc.ErrInvalidSignature,
+		},
+		{Token: func(string) string { return "not-a-token" }, Expect: oidc.ErrInvalidToken},
+	} {
+		iss, p := newProvider(t)
+		iss.Claims = tc.Claims
+		raw := login(t, iss, p, "nonce")
+		if tc.Token != nil {
+			raw = tc.Token(raw)
+		}
+		nonce := "nonce"
+		if tc.Nonce != "" {
+			nonce = tc.Nonce
+		}
+		c, err := p.Verify(context.Background(), raw, nonce)
+		if !errors.Is(err, tc.Expect) || (err != nil) != (tc.Expect != nil) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if c.Subject != "1234" || c.Email != "user@example.com" || !c.EmailVerified {
+			t.Errorf("Test #%d: Unexpected claims \"%+v\"", i, c)
+		}
+	}
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	iss, p := newProvider(t)
+	verifier, _ := oidc.NewVerifier()
+	u, _ := p.AuthCodeURL(context.Background(), "state", "nonce", verifier)
+	redirect, err := iss.Authorize(u, oidctest.Identity{Subject: "1234"})
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	code := redirect.Query().Get("code")
+
+	// The code is bound to the verifier and is single-use.
+	other, _ := oidc.NewVerifier()
+	for i, tc := range []struct {
+		Verifier string
+		Expect   error
+	}{
+		{Verifier: other, Expect: oidc.ErrExchange},
+		{Verifier: verifier, Expect: oidc.ErrExchange},
+	} {
+		if _, err := p.Exchange(context.Background(), code, tc.Verifier); !errors.Is(err, tc.Expect) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+		}
+	}
+}
+
+func TestProvider_KeyRotation(t *testing.T) {
+	iss, p := newProvider(t)
+	now := time.Now()
+	p.Now = func() time.Time { return now }
+	if _, err := p.Verify(context.Background(), login(t, iss, p, "nonce"), "nonce"); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if err := iss.RotateKey(); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	// The key set is not refreshed again straight away.
+	raw := login(t, iss, p, "nonce")
+	if _, err := p.Verify(context.Background(), raw, "nonce"); err != oidc.ErrUnknownKey {
+		t.Errorf("Expected \"%v\", but got \"%v\"", oidc.ErrUnknownKey, err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := p.Verify(context.Background(), raw, "nonce"); err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+	}
+}
+
+func TestProvider_Discovery(t *testing.T) {
+	iss, _ := newProvider(t)
+	c := oidc.DefaultConfig()
+	c.Issuer = issuerURL + "/tenant"
+	p := oidc.NewProvider(c, iss.Client())
+	if _, err := p.Metadata(context.Background()); !errors.Is(err, oidc.ErrDiscovery) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", oidc.ErrDiscovery, err)
+	}
+}
@@ -0,0 +1,341 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package oidctest provides an in-process OpenID provider for testing
+// relying parties without network access.
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/oidc"
+)
+
+// ErrInvalidRequest is returned by Issuer.Authorize when the authorization
+// request is invalid.
+var ErrInvalidRequest = errors.New("oidctest: invalid authorization request")
+
+// Identity is the user authenticated by the Issuer.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// grant is an issued authorization code.
+type grant struct {
+	identity    Identity
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+// Issuer is an OpenID provider serving discovery, a key set and a token
+// endpoint, signing ID tokens with ES256.  Users are authenticated by calling
+// Authorize rather than through a login page.
+type Issuer struct {
+	// URL is the issuer identifier, requests are never sent to it, see
+	// Client.
+	URL string
+
+	// ClientID, ClientSecret and RedirectURL are the registered client.
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	// Claims, if set, is called with the claims of every ID token before it
+	// is signed, used to issue invalid tokens.
+	Claims func(claims map[string]interface{})
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+
+	mu    sync.Mutex
+	key   *ecdsa.PrivateKey
+	kid   string
+	codes map[string]*grant
+}
+
+// NewIssuer returns a new Issuer with a random signing key.
+func NewIssuer(issuer, clientID, clientSecret, redirectURL string) (*Issuer, error) {
+	i := &Issuer{
+		URL:          issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Now:          time.Now,
+		codes:        make(map[string]*grant),
+	}
+	if err := i.RotateKey(); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// RotateKey replaces the signing key, previously issued tokens no longer
+// verify.
+func (i *Issuer) RotateKey() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	kid, err := random()
+	if err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.key = key
+	i.kid = kid
+	return nil
+}
+
+// Client returns an http.Client whose requests are served by the Issuer
+// in-process.
+func (i *Issuer) Client() *http.Client {
+	return &http.Client{Transport: transport{i}}
+}
+
+// transport serves requests with an Issuer.
+type transport struct {
+	issuer *Issuer
+}
+
+func (t transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	if !strings.HasPrefix(r.URL.String(), t.issuer.URL+"/") {
+		w.WriteHeader(http.StatusNotFound)
+	} else {
+		t.issuer.ServeHTTP(w, r)
+	}
+	return w.Result(), nil
+}
+
+// Authorize authenticates the user for an authorization request, as if they
+// had logged in to the provider, returning the URL they are redirected back
+// to.
+func (i *Issuer) Authorize(authURL string, id Identity) (*url.URL, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	switch {
+	case !strings.HasPrefix(authURL, i.URL+"/authorize?"),
+		q.Get("response_type") != "code",
+		q.Get("client_id") != i.ClientID,
+		q.Get("redirect_uri") != i.RedirectURL,
+		!strings.Contains(" "+q.Get("scope")+" ", " openid "),
+		q.Get("code_challenge_method") != "S256",
+		q.Get("code_challenge") == "",
+		q.Get("state") == "":
+		return nil, ErrInvalidRequest
+	}
+	code, err := random()
+	if err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	i.codes[code] = &grant{
+		identity:    id,
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		redirectURI: q.Get("redirect_uri"),
+	}
+	i.mu.Unlock()
+
+	redirect, err := url.Parse(i.RedirectURL)
+	if err != nil {
+		return nil, err
+	}
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+	return redirect, nil
+}
+
+// ServeHTTP serves the discovery document, key set and token endpoint.
+func (i *Issuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJSON(w, http.StatusOK, &oidc.Metadata{
+			Issuer:                        i.URL,
+			AuthorizationEndpoint:         i.URL + "/authorize",
+			TokenEndpoint:                 i.URL + "/token",
+			JWKSURI:                       i.URL + "/jwks",
+			CodeChallengeMethodsSupported: []string{"S256"},
+		})
+	case "/jwks":
+		i.mu.Lock()
+		key, kid := i.key, i.kid
+		i.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "EC",
+				"kid": kid,
+				"use": "sig",
+				"alg": oidc.AlgES256,
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(pad(key.X.Bytes())),
+				"y":   base64.RawURLEncoding.EncodeToString(pad(key.Y.Bytes())),
+			}},
+		})
+	case "/token":
+		i.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// token serves the token endpoint, exchanging an authorization code.
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id = r.PostForm.Get("client_id")
+	}
+	if id != i.ClientID || secret != i.ClientSecret {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	// Codes are single-use, even if the exchange fails.
+	i.mu.Lock()
+	code := r.PostForm.Get("code")
+	g, ok := i.codes[code]
+	delete(i.codes, code)
+	key, kid := i.key, i.kid
+	i.mu.Unlock()
+	if !ok || g.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	if oidc.Challenge(r.PostForm.Get("code_verifier")) != g.challenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := i.Now()
+	claims := map[string]interface{}{
+		"iss":            i.URL,
+		"sub":            g.identity.Subject,
+		"aud":            i.ClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"email":          g.identity.Email,
+		"email_verified": g.identity.EmailVerified,
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	if i.Claims != nil {
+		i.Claims(claims)
+	}
+	idToken, err := sign(key, kid, claims)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	accessToken, err := random()
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, &oidc.Token{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   3600,
+		IDToken:     idToken,
+	})
+}
+
+// sign returns a compact JWT of the claims signed with ES256.
+func sign(key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": oidc.AlgES256, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return "", err
+	}
+	sig := append(pad(r.Bytes()), pad(s.Bytes())...)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// random returns a random hex string.
+func random() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// pad left pads a P-256 coordinate or signature component to 32 bytes.
+func pad(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
@@ -0,0 +1,148 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package outbox implements the transactional outbox pattern.
+//
+// Events are written to the "outbox" table using the same transaction as the
+// business write that caused them, guaranteeing that an event is recorded if
+// and only if the write is committed.  A Relay then reads pending events in
+// order and hands them to a Publisher, marking them as delivered once they
+// have been published successfully.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// Event represents an event stored in the outbox.
+type Event struct {
+	// ID is the event's unique identifier, events are published in ID order.
+	ID snowflake.Snowflake `json:"id"`
+
+	// Topic is used by a Publisher to determine where the event should go.
+	Topic string `json:"topic"`
+
+	// Payload is the JSON encoded payload of the event.
+	Payload json.RawMessage `json:"payload"`
+
+	// Attempts is the number of failed attempts to publish the event.
+	Attempts int `json:"attempts"`
+
+	// CreatedAt is a timestamp of when the event was enqueued.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Unmarshal decodes the event's payload into v.
+func (e *Event) Unmarshal(v interface{}) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// Enqueue adds an event to the outbox.
+//
+// q should be the transaction used for the business write the event is for,
+// that way the event is only ever stored if the transaction is committed.
+func Enqueue(ctx context.Context, q db.Querier, topic string, payload interface{}) (*Event, error) {
+	p, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	e := &Event{
+		ID:        snowflake.New(),
+		Topic:     topic,
+		Payload:   p,
+		CreatedAt: now,
+	}
+	if _, err := q.Exec(
+		ctx,
+		"INSERT INTO outbox (id, topic, payload, created_at) VALUES ($1, $2, $3, $4)",
+		e.ID, e.Topic, []byte(e.Payload), e.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Publisher publishes events from the outbox.
+//
+// Events are delivered at-least-once, if a Publisher returns an error the
+// event will be retried, so implementations should be idempotent.
+type Publisher interface {
+	Publish(ctx context.Context, e *Event) error
+}
+
+// PublisherFunc allows a function to be used as a Publisher.
+type PublisherFunc func(ctx context.Context, e *Event) error
+
+var _ Publisher = (PublisherFunc)(nil)
+
+// Publish satisfies Publisher.
+func (f PublisherFunc) Publish(ctx context.Context, e *Event) error {
+	return f(ctx, e)
+}
+
+// Mux is a Publisher that routes events to other publishers by their topic.
+//
+// Events with a topic that has no publisher registered are discarded.
+type Mux struct {
+	mu         sync.RWMutex
+	publishers map[string]Publisher
+}
+
+var _ Publisher = (*Mux)(nil)
+
+// NewMux returns a new Mux.
+func NewMux() *Mux {
+	return &Mux{
+		publishers: make(map[string]Publisher),
+	}
+}
+
+// Handle registers a publisher for a topic, replacing any existing publisher.
+func (m *Mux) Handle(topic string, p Publisher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.publishers[topic] = p
+}
+
+// HandleFunc registers a function as a publisher for a topic.
+func (m *Mux) HandleFunc(topic string, f func(ctx context.Context, e *Event) error) {
+	m.Handle(topic, PublisherFunc(f))
+}
+
+// Publish satisfies Publisher.
+func (m *Mux) Publish(ctx context.Context, e *Event) error {
+	m.mu.RLock()
+	p, ok := m.publishers[e.Topic]
+	m.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return p.Publish(ctx, e)
+}
@@ -0,0 +1,149 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/matthewpi/cosmos"
+	"github.com/matthewpi/cosmos/internal/backoff"
+)
+
+// ErrNoAttempts is returned when a Relay's backoff does not allow a single
+// attempt to publish an event.
+var ErrNoAttempts = errors.New("outbox: backoff allowed no attempts")
+
+// Relay publishes pending events from the outbox.
+type Relay struct {
+	store     Store
+	publisher Publisher
+
+	// Interval is how often the outbox is polled for pending events.
+	Interval time.Duration
+	// BatchSize is the maximum number of events read from the outbox at once.
+	BatchSize int
+
+	// NewBackoff returns the backoff used when retrying a single event.  Once
+	// the backoff has been exhausted the relay will give up until the next
+	// poll, at which point it starts again from the same event.
+	NewBackoff func() *backoff.Backoff
+}
+
+// NewRelay returns a new Relay.
+func NewRelay(s Store, p Publisher) *Relay {
+	return &Relay{
+		store:     s,
+		publisher: p,
+
+		Interval:  time.Second,
+		BatchSize: 100,
+
+		NewBackoff: func() *backoff.Backoff {
+			return backoff.New(5, 2, 250*time.Millisecond, 10*time.Second)
+		},
+	}
+}
+
+// Run publishes pending events until the context is cancelled.
+//
+// Run blocks until it has acquired the outbox lock, so it is safe to run a
+// Relay on every instance; only one of them will be publishing at a time.
+func (r *Relay) Run(ctx context.Context) error {
+	unlock, err := r.store.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	t := time.NewTicker(r.Interval)
+	defer t.Stop()
+	for {
+		if err := r.Flush(ctx); err != nil && ctx.Err() == nil {
+			cosmos.Log().Error("failed to flush outbox", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Flush publishes pending events until either the outbox is empty or an event
+// could not be published.
+//
+// Events are published strictly in order, if an event fails to publish after
+// exhausting its backoff then no further events will be published by this
+// call.
+func (r *Relay) Flush(ctx context.Context) error {
+	for {
+		events, err := r.store.Pending(ctx, r.BatchSize)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			if err := r.publish(ctx, e); err != nil {
+				return err
+			}
+			if err := r.store.MarkDelivered(ctx, e.ID); err != nil {
+				return err
+			}
+		}
+		if len(events) < r.BatchSize {
+			return nil
+		}
+	}
+}
+
+// publish attempts to publish a single event, retrying on failure.
+func (r *Relay) publish(ctx context.Context, e *Event) error {
+	var err error
+	for b := r.NewBackoff(); b.Next(ctx); {
+		if err = r.publisher.Publish(ctx, e); err == nil {
+			return nil
+		}
+		e.Attempts++
+		cosmos.Log().Warn(
+			"failed to publish outbox event",
+			zap.Stringer("id", e.ID),
+			zap.String("topic", e.Topic),
+			zap.Int("attempts", e.Attempts),
+			zap.Error(err),
+		)
+		if err := r.store.MarkFailed(ctx, e.ID, err); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ErrNoAttempts
+}
@@ -0,0 +1,193 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/backoff"
+	"github.com/matthewpi/cosmos/internal/outbox"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// memoryStore is an in-memory outbox.Store used for testing.
+type memoryStore struct {
+	mu        sync.Mutex
+	events    []*outbox.Event
+	delivered map[snowflake.Snowflake]bool
+	failures  map[snowflake.Snowflake]int
+}
+
+func newMemoryStore(topics ...string) *memoryStore {
+	s := &memoryStore{
+		delivered: make(map[snowflake.Snowflake]bool),
+		failures:  make(map[snowflake.Snowflake]int),
+	}
+	for i, topic := range topics {
+		s.events = append(s.events, &outbox.Event{
+			ID:    snowflake.Snowflake(i + 1),
+			Topic: topic,
+		})
+	}
+	return s
+}
+
+func (s *memoryStore) Lock(context.Context) (func(), error) {
+	return func() {}, nil
+}
+
+func (s *memoryStore) Pending(_ context.Context, limit int) ([]*outbox.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []*outbox.Event
+	for _, e := range s.events {
+		if s.delivered[e.ID] {
+			continue
+		}
+		if len(events) == limit {
+			break
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (s *memoryStore) MarkDelivered(_ context.Context, id snowflake.Snowflake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delivered[id] = true
+	return nil
+}
+
+func (s *memoryStore) MarkFailed(_ context.Context, id snowflake.Snowflake, _ error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[id]++
+	return nil
+}
+
+func newTestRelay(s outbox.Store, p outbox.Publisher) *outbox.Relay {
+	r := outbox.NewRelay(s, p)
+	r.BatchSize = 2
+	r.NewBackoff = func() *backoff.Backoff {
+		return backoff.New(3, 2, time.Nanosecond, time.Nanosecond)
+	}
+	return r
+}
+
+func TestRelay_Flush(t *testing.T) {
+	s := newMemoryStore("a", "b", "c", "d", "e")
+
+	var published []string
+	r := newTestRelay(s, outbox.PublisherFunc(func(_ context.Context, e *outbox.Event) error {
+		published = append(published, e.Topic)
+		return nil
+	}))
+	if err := r.Flush(context.Background()); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		return
+	}
+
+	expect := []string{"a", "b", "c", "d", "e"}
+	if len(published) != len(expect) {
+		t.Fatalf("Expected %d events to be published, but got %d", len(expect), len(published))
+		return
+	}
+	for i, topic := range expect {
+		if published[i] != topic {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, topic, published[i])
+		}
+	}
+}
+
+func TestRelay_Flush_Retry(t *testing.T) {
+	s := newMemoryStore("a", "b")
+
+	var calls int
+	r := newTestRelay(s, outbox.PublisherFunc(func(_ context.Context, e *outbox.Event) error {
+		calls++
+		if e.Topic == "a" && calls < 3 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	}))
+	if err := r.Flush(context.Background()); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		return
+	}
+	if s.failures[1] != 2 {
+		t.Errorf("Expected 2 failures to be recorded, but got %d", s.failures[1])
+	}
+	if !s.delivered[1] || !s.delivered[2] {
+		t.Errorf("Expected all events to be delivered")
+	}
+}
+
+func TestRelay_Flush_Order(t *testing.T) {
+	s := newMemoryStore("a", "b")
+
+	failure := errors.New("permanent failure")
+	r := newTestRelay(s, outbox.PublisherFunc(func(_ context.Context, e *outbox.Event) error {
+		if e.Topic == "a" {
+			return failure
+		}
+		return nil
+	}))
+	if err := r.Flush(context.Background()); err != failure {
+		t.Fatalf("Expected \"%v\", but got \"%v\"", failure, err)
+		return
+	}
+	if s.failures[1] != 3 {
+		t.Errorf("Expected 3 failures to be recorded, but got %d", s.failures[1])
+	}
+	if s.delivered[1] || s.delivered[2] {
+		t.Errorf("Expected no events to be delivered after a failure")
+	}
+}
+
+func TestMux_Publish(t *testing.T) {
+	m := outbox.NewMux()
+
+	var got string
+	m.HandleFunc("user.created", func(_ context.Context, e *outbox.Event) error {
+		got = e.Topic
+		return nil
+	})
+
+	if err := m.Publish(context.Background(), &outbox.Event{Topic: "unknown"}); err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+	}
+	if got != "" {
+		t.Errorf("Expected unknown topic to be discarded, but got \"%s\"", got)
+	}
+	if err := m.Publish(context.Background(), &outbox.Event{Topic: "user.created"}); err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+	}
+	if got != "user.created" {
+		t.Errorf("Expected \"user.created\", but got \"%s\"", got)
+	}
+}
@@ -0,0 +1,119 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/matthewpi/pgx/v4/pgxpool"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// lockKey is the key of the advisory lock held by the active Relay.
+const lockKey = 0x636f736d6f73 // "cosmos"
+
+// Store is used by a Relay to read and update events in the outbox.
+type Store interface {
+	// Lock blocks until the caller holds an exclusive lock on the outbox.
+	// Only a single Relay can hold the lock at once, ensuring events are
+	// published in order even when multiple instances are running.
+	Lock(ctx context.Context) (unlock func(), err error)
+
+	// Pending returns up to limit undelivered events, ordered by ID.
+	Pending(ctx context.Context, limit int) ([]*Event, error)
+
+	// MarkDelivered marks an event as delivered.
+	MarkDelivered(ctx context.Context, id snowflake.Snowflake) error
+
+	// MarkFailed records a failed attempt to publish an event.
+	MarkFailed(ctx context.Context, id snowflake.Snowflake, cause error) error
+}
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	pool *pgxpool.Pool
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "outbox" table.
+func NewStore(pool *pgxpool.Pool) Store {
+	return &store{pool: pool}
+}
+
+func (s *store) Lock(ctx context.Context) (func(), error) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", int64(lockKey)); err != nil {
+		conn.Release()
+		return nil, err
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, _ = conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", int64(lockKey))
+		conn.Release()
+	}, nil
+}
+
+func (s *store) Pending(ctx context.Context, limit int) ([]*Event, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		"SELECT id, topic, payload, attempts, created_at FROM outbox WHERE delivered_at IS NULL ORDER BY id LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*Event
+	for rows.Next() {
+		e := &Event{}
+		var payload []byte
+		if err := rows.Scan(&e.ID, &e.Topic, &payload, &e.Attempts, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Payload = payload
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *store) MarkDelivered(ctx context.Context, id snowflake.Snowflake) error {
+	_, err := s.pool.Exec(ctx, "UPDATE outbox SET delivered_at = now() WHERE id = $1", id)
+	return err
+}
+
+func (s *store) MarkFailed(ctx context.Context, id snowflake.Snowflake, cause error) error {
+	_, err := s.pool.Exec(
+		ctx,
+		"UPDATE outbox SET attempts = attempts + 1, last_error = $2 WHERE id = $1",
+		id, cause.Error(),
+	)
+	return err
+}
@@ -0,0 +1,101 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package password
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/matthewpi/cosmos/internal/config/lexer"
+	"github.com/matthewpi/cosmos/internal/hibp"
+)
+
+// Config represents the configuration for the password policy.
+type Config struct {
+	// MinLength is the minimum number of characters in a password.
+	MinLength int `json:"min_length"`
+
+	// MaxLength is the maximum number of bytes in a password.
+	MaxLength int `json:"max_length"`
+
+	// BreachedCorpus is the path to a directory containing the Pwned
+	// Passwords corpus, if empty passwords are not checked.
+	BreachedCorpus string `json:"breached_corpus"`
+}
+
+// FromLexer .
+func FromLexer(b lexer.Block) (*Config, error) {
+	p := DefaultPolicy()
+	c := &Config{
+		MinLength: p.MinLength,
+		MaxLength: p.MaxLength,
+	}
+	for _, s := range b.Segments {
+		d := s.Directive()
+		switch d {
+		case "min_length", "max_length":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after %s directive", d)
+			}
+			v, err := strconv.Atoi(s[1].Text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", d, err)
+			}
+			if v < 1 || v > Limit {
+				return nil, fmt.Errorf("%s must be between 1 and %d", d, Limit)
+			}
+			if d == "min_length" {
+				c.MinLength = v
+			} else {
+				c.MaxLength = v
+			}
+		case "breached_corpus":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after breached_corpus directive")
+			}
+			c.BreachedCorpus = s[1].Text
+		default:
+			return nil, fmt.Errorf("unknown directive: \"" + d + "\"")
+		}
+	}
+	if c.MinLength > c.MaxLength {
+		return nil, fmt.Errorf("min_length must not be greater than max_length")
+	}
+	return c, nil
+}
+
+// Policy returns the Policy described by the config.
+func (c *Config) Policy() (*Policy, error) {
+	p := &Policy{
+		MinLength: c.MinLength,
+		MaxLength: c.MaxLength,
+	}
+	if c.BreachedCorpus != "" {
+		corpus, err := hibp.Open(c.BreachedCorpus)
+		if err != nil {
+			return nil, err
+		}
+		p.Corpus = corpus
+	}
+	return p, nil
+}
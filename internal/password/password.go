@@ -0,0 +1,136 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package password enforces a policy on the passwords users choose.
+package password
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/matthewpi/cosmos/internal/hibp"
+)
+
+var (
+	// ErrTooShort is returned when a password is shorter than the policy's
+	// minimum length.
+	ErrTooShort = errors.New("password: too short")
+
+	// ErrTooLong is returned when a password is longer than the policy's
+	// maximum length.
+	ErrTooLong = errors.New("password: too long")
+
+	// ErrContainsEmail is returned when a password contains the local part
+	// of the user's email address.
+	ErrContainsEmail = errors.New("password: contains email address")
+
+	// ErrBreached is returned when a password appears in the breached
+	// password corpus.
+	ErrBreached = errors.New("password: found in a data breach")
+)
+
+// Limit is the largest maximum length a Policy may have.  Passwords longer
+// than it can be rejected without being hashed, no matter the policy.
+const Limit = 4096
+
+// minLocalPartLength is the minimum length of an email address' local part
+// for it to be checked, shorter local parts are too likely to appear in a
+// password by chance.
+const minLocalPartLength = 3
+
+// Policy is a password policy.
+type Policy struct {
+	// MinLength is the minimum number of characters in a password.
+	MinLength int
+
+	// MaxLength is the maximum number of bytes in a password, limiting the
+	// amount of data that gets hashed.
+	MaxLength int
+
+	// Corpus is checked for breached passwords, if nil passwords are not
+	// checked.
+	Corpus *hibp.Corpus
+}
+
+// DefaultPolicy returns the default password policy, which does not check
+// for breached passwords.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MinLength: 8,
+		MaxLength: 256,
+	}
+}
+
+// Check checks a password against the policy, if email is empty the password
+// is not checked against it.
+func (p *Policy) Check(password []byte, email string) error {
+	if err := p.CheckLength(password); err != nil {
+		return err
+	}
+	if err := p.CheckEmail(password, email); err != nil {
+		return err
+	}
+	return p.CheckBreached(password)
+}
+
+// CheckLength checks the length of a password.
+func (p *Policy) CheckLength(password []byte) error {
+	if len(password) > p.MaxLength {
+		return ErrTooLong
+	}
+	if utf8.RuneCount(password) < p.MinLength {
+		return ErrTooShort
+	}
+	return nil
+}
+
+// CheckEmail checks that a password does not contain the local part of an
+// email address.
+func (p *Policy) CheckEmail(password []byte, email string) error {
+	i := strings.LastIndexByte(email, '@')
+	if i < minLocalPartLength {
+		return nil
+	}
+	local := bytes.ToLower([]byte(email[:i]))
+	if bytes.Contains(bytes.ToLower(password), local) {
+		return ErrContainsEmail
+	}
+	return nil
+}
+
+// CheckBreached checks that a password does not appear in the breached
+// password corpus.
+func (p *Policy) CheckBreached(password []byte) error {
+	if p.Corpus == nil {
+		return nil
+	}
+	count, err := p.Corpus.Count(password)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrBreached
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package password_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matthewpi/cosmos/internal/hibp"
+	"github.com/matthewpi/cosmos/internal/password"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hibp")
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// SHA-1("password1") = E38AD214943DAAD1D64C102FAEC29DE4AFE9DA3D
+	// SHA-1("correct horse battery staple") = ABF7AAD6438836DBE526AA231ABDE2D0EEF74D42
+	for name, data := range map[string]string{
+		"E38AD.txt": "214943DAAD1D64C102FAEC29DE4AFE9DA3D:2418984\n",
+		"ABF7A":     "0000000000000000000000000000000000A:1\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+	}
+	corpus, err := hibp.Open(dir)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	p := password.DefaultPolicy()
+	p.Corpus = corpus
+
+	for i, tc := range []struct {
+		password string
+		email    string
+		expect   error
+	}{
+		{password: "correct horse battery staple", email: "user@example.com"},
+		{password: "short", expect: password.ErrTooShort},
+		// Length is measured in characters, not bytes.
+		{password: "ñññññññ", expect: password.ErrTooShort},
+		{password: strings.Repeat("a", 257), expect: password.ErrTooLong},
+		{password: "password1", expect: password.ErrBreached},
+		{password: "Matthew.Penner2021", email: "matthew.penner@example.com", expect: password.ErrContainsEmail},
+		// Short local parts are not checked.
+		{password: "correct horse battery staple", email: "co@example.com"},
+	} {
+		if err := p.Check([]byte(tc.password), tc.email); err != tc.expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.expect, err)
+		}
+	}
+}
@@ -23,7 +23,11 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
+	"strings"
+	"time"
 
 	"github.com/matthewpi/cosmos/internal/config/lexer"
 	"github.com/matthewpi/cosmos/internal/server/listener"
@@ -36,7 +40,7 @@ type Config struct {
 }
 
 // FromLexer .
-func FromLexer(b lexer.Block) (*Server, error) {
+func FromLexer(b lexer.Block, extra ...Opt) (*Server, error) {
 	var opts []Opt
 
 	var tokens []lexer.Token
@@ -70,6 +74,23 @@ func FromLexer(b lexer.Block) (*Server, error) {
 					for d.NextArg() {
 						return nil, fmt.Errorf("unexpected argument after metrics directive")
 					}
+				case "trusted_proxy":
+					var found bool
+					for d.NextArg() {
+						n, err := parseNetwork(d.Val())
+						if err != nil {
+							return nil, err
+						}
+						l.TrustedProxies = append(l.TrustedProxies, n)
+						found = true
+					}
+					if !found {
+						return nil, fmt.Errorf("missing argument after trusted_proxy directive")
+					}
+				case "tls":
+					if err := parseTLS(d, &l); err != nil {
+						return nil, err
+					}
 				case "{":
 					return nil, fmt.Errorf("unexpected start of block")
 				case "}":
@@ -84,5 +105,124 @@ func FromLexer(b lexer.Block) (*Server, error) {
 		}
 	}
 
-	return New(opts...)
+	return New(append(opts, extra...)...)
+}
+
+// parseTLS parses a tls sub-directive, its certificate and key paths and its
+// block:
+//
+//	tls <cert> <key> {
+//		min_version 1.2
+//		max_version 1.3
+//		ciphers TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 ...
+//		client_ca <bundle> [required|optional]
+//		reload_interval 1m
+//	}
+func parseTLS(d *lexer.Dispenser, l *listener.Listener) error {
+	if !d.AllArgs(&l.CertPath, &l.KeyPath) || l.CertPath == "" || l.KeyPath == "" {
+		return fmt.Errorf("expected a certificate and key path after tls directive")
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		subdir := d.Val()
+		args := d.RemainingArgs()
+		switch subdir {
+		case "min_version", "max_version":
+			if len(args) != 1 {
+				return fmt.Errorf("expected a single argument after %s directive", subdir)
+			}
+			v, ok := tlsVersions[args[0]]
+			if !ok {
+				return fmt.Errorf("invalid %s: \"%s\"", subdir, args[0])
+			}
+			if subdir == "min_version" {
+				l.MinTLSVersion = v
+			} else {
+				l.MaxTLSVersion = v
+			}
+		case "ciphers":
+			if len(args) < 1 {
+				return fmt.Errorf("missing argument after ciphers directive")
+			}
+			l.CipherSuites = nil
+			for _, v := range args {
+				id, ok := cipherSuite(v)
+				if !ok {
+					return fmt.Errorf("invalid cipher suite: \"%s\"", v)
+				}
+				l.CipherSuites = append(l.CipherSuites, id)
+			}
+		case "client_ca":
+			if len(args) < 1 || len(args) > 2 {
+				return fmt.Errorf("expected a path and an optional mode after client_ca directive")
+			}
+			l.ClientCAPath = args[0]
+			l.ClientAuth = tls.RequireAndVerifyClientCert
+			if len(args) == 2 {
+				switch args[1] {
+				case "required":
+				case "optional":
+					l.ClientAuth = tls.VerifyClientCertIfGiven
+				default:
+					return fmt.Errorf("invalid client_ca mode: \"%s\"", args[1])
+				}
+			}
+		case "reload_interval":
+			if len(args) != 1 {
+				return fmt.Errorf("expected a single argument after %s directive", subdir)
+			}
+			v, err := time.ParseDuration(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", subdir, err)
+			}
+			if v <= 0 {
+				return fmt.Errorf("%s must be positive", subdir)
+			}
+			l.ReloadInterval = v
+		default:
+			return fmt.Errorf("unknown sub-directive: \"" + subdir + "\"")
+		}
+	}
+	if l.MinTLSVersion != 0 && l.MaxTLSVersion != 0 && l.MinTLSVersion > l.MaxTLSVersion {
+		return fmt.Errorf("min_version must not be greater than max_version")
+	}
+	return nil
+}
+
+// tlsVersions are the TLS versions accepted by min_version and max_version.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuite returns the ID of a cipher suite by its name, insecure cipher
+// suites are not accepted.
+func cipherSuite(name string) (uint16, bool) {
+	for _, c := range tls.CipherSuites() {
+		if c.Name == name {
+			return c.ID, true
+		}
+	}
+	return 0, false
+}
+
+// parseNetwork parses a CIDR, or a single IP address.
+func parseNetwork(v string) (*net.IPNet, error) {
+	if !strings.Contains(v, "/") {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid trusted_proxy: \"%s\"", v)
+		}
+		bits := 8 * net.IPv6len
+		if v4 := ip.To4(); v4 != nil {
+			ip, bits = v4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted_proxy: %w", err)
+	}
+	return n, nil
 }
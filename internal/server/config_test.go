@@ -0,0 +1,58 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package server_test
+
+import (
+	"testing"
+
+	"github.com/matthewpi/cosmos/internal/config/lexer"
+	"github.com/matthewpi/cosmos/internal/server"
+)
+
+func TestFromLexer_TLS(t *testing.T) {
+	for i, tc := range []struct {
+		Input string
+		Err   bool
+	}{
+		{Input: "tls cert.pem key.pem"},
+		{Input: "tls cert.pem key.pem {\n min_version 1.2\n max_version 1.3\n ciphers TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256\n client_ca ca.pem optional\n reload_interval 30s\n }"},
+		{Input: "tls cert.pem key.pem {\n client_ca ca.pem required\n }\n metrics"},
+		{Input: "tls cert.pem", Err: true},
+		{Input: "tls cert.pem key.pem extra", Err: true},
+		{Input: "tls cert.pem key.pem {\n min_version 1.4\n }", Err: true},
+		{Input: "tls cert.pem key.pem {\n min_version 1.3\n max_version 1.2\n }", Err: true},
+		{Input: "tls cert.pem key.pem {\n ciphers TLS_RSA_WITH_RC4_128_SHA\n }", Err: true},
+		{Input: "tls cert.pem key.pem {\n client_ca ca.pem sometimes\n }", Err: true},
+		{Input: "tls cert.pem key.pem {\n client_ca\n }", Err: true},
+		{Input: "tls cert.pem key.pem {\n reload_interval -1s\n }", Err: true},
+		{Input: "tls cert.pem key.pem {\n unknown\n }", Err: true},
+	} {
+		blocks, err := lexer.Parse("Cosmosfile", []byte("http {\n listen 127.0.0.1:0 {\n "+tc.Input+"\n }\n}"))
+		if err != nil {
+			t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+		if _, err := server.FromLexer(blocks[0]); (err != nil) != tc.Err {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Err, err)
+		}
+	}
+}
@@ -24,6 +24,7 @@
 package listener
 
 import (
+	"crypto/tls"
 	"net"
 	"time"
 )
@@ -59,8 +60,32 @@ type Listener struct {
 	// KeyPath is a path to a SSL private key.
 	KeyPath string
 
+	// MinTLSVersion and MaxTLSVersion, if not zero, override the minimum and
+	// maximum TLS versions.
+	MinTLSVersion uint16
+	MaxTLSVersion uint16
+
+	// CipherSuites, if not empty, override the cipher suites used for TLS
+	// 1.0 - 1.2, TLS 1.3 cipher suites are not configurable.
+	CipherSuites []uint16
+
+	// ClientCAPath is a path to a bundle of CA certificates used to verify
+	// client certificates, client certificates are not requested if empty.
+	ClientCAPath string
+	// ClientAuth is the policy for client certificates, only used with
+	// ClientCAPath.
+	ClientAuth tls.ClientAuthType
+
+	// ReloadInterval is how often the certificate, key and client CAs are
+	// checked for changes, see Certificates.
+	ReloadInterval time.Duration
+
 	// Metrics determines if a metrics endpoint will be exposed on this listener.
 	Metrics string
+
+	// TrustedProxies are the networks of proxies whose Forwarded headers are
+	// trusted when determining a client's IP address.
+	TrustedProxies []*net.IPNet
 }
 
 // TCPKeepAliveListener is a TCPListener with a keep alive.
@@ -0,0 +1,207 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package listener
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/matthewpi/cosmos"
+)
+
+// DefaultReloadInterval is how often a listener's certificate files are
+// checked for changes if Listener.ReloadInterval is not set.
+const DefaultReloadInterval = time.Minute
+
+// ErrNoClientCAs is returned when a client CA bundle contains no
+// certificates.
+var ErrNoClientCAs = errors.New("listener: no certificates found in client CA bundle")
+
+// TLS returns true if the listener serves TLS.
+func (l Listener) TLS() bool {
+	return l.CertPath != "" && l.KeyPath != ""
+}
+
+// Certificates holds the TLS configuration of a listener, reloading its
+// certificate, key and client CAs from disk when the files change so renewed
+// certificates are served without a restart.
+//
+// Files are checked for changes at most once per Listener.ReloadInterval,
+// during a handshake.  If reloading fails the previous configuration is kept
+// and the error is logged.
+type Certificates struct {
+	l    Listener
+	base *tls.Config
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	checked time.Time
+	stamp   string
+	config  *tls.Config
+}
+
+// NewCertificates loads the certificate, key and client CAs of a listener,
+// base is the configuration the listener's overrides are applied to.
+func NewCertificates(l Listener, base *tls.Config) (*Certificates, error) {
+	c := &Certificates{
+		l:    l,
+		base: base,
+		Now:  time.Now,
+	}
+	stamp, err := c.modified()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(stamp); err != nil {
+		return nil, err
+	}
+	c.checked = c.Now()
+	return c, nil
+}
+
+// Config returns the tls.Config to serve the listener with, the
+// configuration is chosen for each handshake so reloads take effect for new
+// connections.
+func (c *Certificates) Config() *tls.Config {
+	cfg := c.current().Clone()
+	cfg.Certificates = nil
+	cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		current, err := c.GetConfigForClient(hello)
+		if err != nil {
+			return nil, err
+		}
+		return &current.Certificates[0], nil
+	}
+	cfg.GetConfigForClient = c.GetConfigForClient
+	return cfg
+}
+
+// GetConfigForClient returns the current configuration, reloading it first if
+// the files have changed, see tls.Config.GetConfigForClient.
+func (c *Certificates) GetConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	interval := c.l.ReloadInterval
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	if now := c.Now(); now.Sub(c.checked) >= interval {
+		c.checked = now
+		if err := c.reload(); err != nil {
+			cosmos.Log().Warn("failed to reload tls certificates", zap.String("listener", c.l.Address), zap.Error(err))
+		}
+	}
+	return c.config, nil
+}
+
+// Reload reloads the certificate, key and client CAs if the files have
+// changed since they were last loaded.
+func (c *Certificates) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reload()
+}
+
+// current returns the current configuration without checking for changes.
+func (c *Certificates) current() *tls.Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config
+}
+
+// reload must be called with c.mu held.
+func (c *Certificates) reload() error {
+	stamp, err := c.modified()
+	if err != nil {
+		return err
+	}
+	if stamp == c.stamp {
+		return nil
+	}
+	return c.load(stamp)
+}
+
+// load loads the files, replacing the configuration only if every file is
+// valid.
+func (c *Certificates) load(stamp string) error {
+	cert, err := tls.LoadX509KeyPair(c.l.CertPath, c.l.KeyPath)
+	if err != nil {
+		return err
+	}
+	cfg := c.base.Clone()
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cfg.Certificates = []tls.Certificate{cert}
+	if c.l.MinTLSVersion != 0 {
+		cfg.MinVersion = c.l.MinTLSVersion
+	}
+	if c.l.MaxTLSVersion != 0 {
+		cfg.MaxVersion = c.l.MaxTLSVersion
+	}
+	if len(c.l.CipherSuites) > 0 {
+		cfg.CipherSuites = c.l.CipherSuites
+	}
+	if c.l.ClientCAPath != "" {
+		b, err := ioutil.ReadFile(c.l.ClientCAPath)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return ErrNoClientCAs
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = c.l.ClientAuth
+	}
+	c.config = cfg
+	c.stamp = stamp
+	return nil
+}
+
+// modified returns a stamp of the size and modification time of each of the
+// listener's files, which changes when any of them are replaced.
+func (c *Certificates) modified() (string, error) {
+	var stamp string
+	for _, path := range []string{c.l.CertPath, c.l.KeyPath, c.l.ClientCAPath} {
+		if path == "" {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("listener: %w", err)
+		}
+		stamp += fmt.Sprintf("%d:%d;", fi.Size(), fi.ModTime().UnixNano())
+	}
+	return stamp, nil
+}
@@ -0,0 +1,238 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package listener_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/server/listener"
+)
+
+// issue creates a certificate with the common name, signed by parent or
+// self-signed if parent is nil.
+func issue(t *testing.T, name string, parent *tls.Certificate) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// write writes a certificate and its key to the directory as PEM files,
+// returning their paths.
+func write(t *testing.T, dir string, c *tls.Certificate) (string, string) {
+	key, err := x509.MarshalECPrivateKey(c.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	for path, block := range map[string]*pem.Block{
+		certPath: {Type: "CERTIFICATE", Bytes: c.Certificate[0]},
+		keyPath:  {Type: "EC PRIVATE KEY", Bytes: key},
+	} {
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+	}
+	return certPath, keyPath
+}
+
+// handshake performs a TLS handshake between a server using cfg and a
+// client presenting cert, if not nil, returning the server's certificate.
+func handshake(cfg *tls.Config, roots *x509.CertPool, cert *tls.Certificate) (*x509.Certificate, error) {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer c.Close()
+		errs <- c.(*tls.Conn).Handshake()
+	}()
+	clientCfg := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	if cert != nil {
+		// Always present the certificate, even if the server does not
+		// accept its issuer.
+		clientCfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert, nil
+		}
+	}
+	c, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", l.Addr().String(), clientCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	return c.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestCertificates_Reload(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "ca", nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+
+	first := issue(t, "localhost", ca)
+	certPath, keyPath := write(t, dir, first)
+	c, err := listener.NewCertificates(listener.Listener{
+		CertPath:      certPath,
+		KeyPath:       keyPath,
+		MinTLSVersion: tls.VersionTLS13,
+	}, &tls.Config{MinVersion: tls.VersionTLS12})
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	now := time.Now()
+	c.Now = func() time.Time { return now }
+	cfg := c.Config()
+
+	second := issue(t, "localhost", ca)
+	write(t, dir, second)
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(certPath, future, future); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	for i, tc := range []struct {
+		After time.Duration
+		Want  *tls.Certificate
+	}{
+		// The files are not checked again until the reload interval has
+		// passed.
+		{After: time.Second, Want: first},
+		{After: listener.DefaultReloadInterval, Want: second},
+	} {
+		now = now.Add(tc.After)
+		got, err := handshake(cfg, roots, nil)
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		if !got.Equal(tc.Want.Leaf) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Want.Leaf.SerialNumber, got.SerialNumber)
+		}
+	}
+	if v, _ := c.GetConfigForClient(nil); v.MinVersion != tls.VersionTLS13 {
+		t.Errorf("Expected \"%v\", but got \"%v\"", tls.VersionTLS13, v.MinVersion)
+	}
+
+	// An invalid certificate is not loaded, the previous one is kept.
+	if err := ioutil.WriteFile(certPath, []byte("invalid"), 0o600); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if err := c.Reload(); err == nil {
+		t.Errorf("Expected an error, but got \"%v\"", err)
+	}
+	if got, err := handshake(cfg, roots, nil); err != nil || !got.Equal(second.Leaf) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", second.Leaf.SerialNumber, err)
+	}
+}
+
+func TestCertificates_ClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "ca", nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	certPath, keyPath := write(t, dir, issue(t, "localhost", ca))
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0o600); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	client := issue(t, "client", ca)
+	untrusted := issue(t, "client", issue(t, "other", nil))
+
+	for i, tc := range []struct {
+		Auth   tls.ClientAuthType
+		Client *tls.Certificate
+		Err    bool
+	}{
+		{Auth: tls.RequireAndVerifyClientCert, Client: client},
+		{Auth: tls.RequireAndVerifyClientCert, Err: true},
+		{Auth: tls.RequireAndVerifyClientCert, Client: untrusted, Err: true},
+		{Auth: tls.VerifyClientCertIfGiven, Client: client},
+		{Auth: tls.VerifyClientCertIfGiven},
+		{Auth: tls.VerifyClientCertIfGiven, Client: untrusted, Err: true},
+	} {
+		c, err := listener.NewCertificates(listener.Listener{
+			CertPath:     certPath,
+			KeyPath:      keyPath,
+			ClientCAPath: caPath,
+			ClientAuth:   tc.Auth,
+		}, nil)
+		if err != nil {
+			t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+		if _, err := handshake(c.Config(), roots, tc.Client); (err != nil) != tc.Err {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Err, err)
+		}
+	}
+
+	if _, err := listener.NewCertificates(listener.Listener{
+		CertPath:     certPath,
+		KeyPath:      keyPath,
+		ClientCAPath: keyPath,
+	}, nil); err != listener.ErrNoClientCAs {
+		t.Errorf("Expected \"%v\", but got \"%v\"", listener.ErrNoClientCAs, err)
+	}
+}
@@ -0,0 +1,66 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// RequireAuthentication is a middleware that rejects requests without an
+// authenticated User with 401 Unauthorized.
+func RequireAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := user.FromContext(r.Context()); !ok {
+			WriteError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission returns a middleware that only allows requests made by a
+// User whose role grants the permission.
+//
+// The User is read from the request's context, so this middleware must be
+// used after the middleware responsible for authenticating the request.
+// Unauthenticated requests are rejected with 401 Unauthorized, authenticated
+// requests lacking the permission are rejected with 403 Forbidden.
+func RequirePermission(p role.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, ok := user.FromContext(r.Context())
+			if !ok {
+				WriteError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+				return
+			}
+			if !u.Can(p) {
+				WriteError(w, http.StatusForbidden, "forbidden", "missing permission \""+string(p)+"\"")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
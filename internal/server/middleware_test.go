@@ -0,0 +1,80 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+func TestRequirePermission(t *testing.T) {
+	for i, tc := range []struct {
+		user   *user.User
+		expect int
+	}{
+		{
+			user:   nil,
+			expect: http.StatusUnauthorized,
+		},
+		{
+			user:   &user.User{},
+			expect: http.StatusForbidden,
+		},
+		{
+			user: &user.User{
+				Role: &role.Role{Permissions: role.Permissions{"users.read"}},
+			},
+			expect: http.StatusForbidden,
+		},
+		{
+			user: &user.User{
+				Role: &role.Role{Permissions: role.Permissions{"roles.*"}},
+			},
+			expect: http.StatusOK,
+		},
+	} {
+		r := chi.NewRouter()
+		r.With(server.RequirePermission("roles.update")).Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.user != nil {
+			req = req.WithContext(user.NewContext(req.Context(), tc.user))
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != tc.expect {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.expect, rec.Code)
+			continue
+		}
+	}
+}
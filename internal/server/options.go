@@ -23,6 +23,10 @@
 package server
 
 import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
 	"github.com/matthewpi/cosmos/internal/server/listener"
 )
 
@@ -44,3 +48,21 @@ func WithListener(l listener.Listener) Opt {
 		return nil
 	}
 }
+
+// WithRoutes registers a function that adds routes to the router of every
+// listener.
+func WithRoutes(f func(r chi.Router)) Opt {
+	return func(s *Server) error {
+		s.routes = append(s.routes, f)
+		return nil
+	}
+}
+
+// WithMiddleware registers middleware that runs on every request, after the
+// server's own logging and recovery middleware.
+func WithMiddleware(middlewares ...func(http.Handler) http.Handler) Opt {
+	return func(s *Server) error {
+		s.middlewares = append(s.middlewares, middlewares...)
+		return nil
+	}
+}
@@ -96,27 +96,37 @@ type Server struct {
 	listeners []net.Listener
 	servers   []*http.Server
 
-	newRouter func(l listener.Listener) *chi.Mux
+	// certificates holds the TLS configuration of each listener, nil for
+	// listeners that do not serve TLS.
+	certificates []*listener.Certificates
+
+	newRouter   func(l listener.Listener) *chi.Mux
+	routes      []func(chi.Router)
+	middlewares []func(http.Handler) http.Handler
 }
 
 // New .
 func New(ops ...Opt) (*Server, error) {
 	s := &Server{
 		config: &Config{},
-
-		newRouter: func(l listener.Listener) *chi.Mux {
-			r := chi.NewRouter()
-			r.Use(loggingAndRecovery)
-			r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(http.StatusOK)
+	}
+	s.newRouter = func(l listener.Listener) *chi.Mux {
+		r := chi.NewRouter()
+		r.Use(loggingAndRecovery)
+		r.Use(clientIP(l.TrustedProxies))
+		r.Use(s.middlewares...)
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		if l.Metrics != "" {
+			r.Get(l.Metrics, func(w http.ResponseWriter, r *http.Request) {
+				metrics.WritePrometheus(w, true)
 			})
-			if l.Metrics != "" {
-				r.Get(l.Metrics, func(w http.ResponseWriter, r *http.Request) {
-					metrics.WritePrometheus(w, true)
-				})
-			}
-			return r
-		},
+		}
+		for _, f := range s.routes {
+			f(r)
+		}
+		return r
 	}
 	for _, op := range ops {
 		if err := op(s); err != nil {
@@ -130,6 +140,14 @@ func New(ops ...Opt) (*Server, error) {
 func (s *Server) Listen(ctx context.Context) []error {
 	var errs []error
 	for _, lc := range s.config.Listeners {
+		var certs *listener.Certificates
+		if lc.TLS() {
+			var err error
+			if certs, err = listener.NewCertificates(lc, defaultTLSConfig); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
 		var lc2 net.ListenConfig
 		lc2.KeepAlive = lc.KeepAlive
 		l, err := lc2.Listen(ctx, lc.Network.String(), lc.Address)
@@ -139,6 +157,7 @@ func (s *Server) Listen(ctx context.Context) []error {
 		}
 		cosmos.Log().Info("listening on " + l.Addr().String())
 		s.listeners = append(s.listeners, l)
+		s.certificates = append(s.certificates, certs)
 	}
 	return errs
 }
@@ -157,6 +176,7 @@ func (s *Server) Serve(ctx context.Context) error {
 	defer func() {
 		s.servers = nil
 		s.listeners = nil
+		s.certificates = nil
 	}()
 
 	stdLog := zap.NewStdLog(cosmos.Log())
@@ -182,7 +202,7 @@ func (s *Server) Serve(ctx context.Context) error {
 		s.servers = append(s.servers, hs)
 		cosmos.Log().Info("serving on " + addr)
 
-		if certPath, keyPath := lc.CertPath, lc.KeyPath; certPath == "" || keyPath == "" {
+		if certs := s.certificates[i]; certs == nil {
 			g.Go(func() error {
 				if err := hs.Serve(l); err != nil &&
 					err != http.ErrServerClosed &&
@@ -192,9 +212,9 @@ func (s *Server) Serve(ctx context.Context) error {
 				return nil
 			})
 		} else {
-			hs.TLSConfig = defaultTLSConfig
+			hs.TLSConfig = certs.Config()
 			g.Go(func() error {
-				if err := hs.ServeTLS(l, certPath, keyPath); err != nil &&
+				if err := hs.ServeTLS(l, "", ""); err != nil &&
 					err != http.ErrServerClosed &&
 					!strings.HasSuffix(err.Error(), " use of closed network connection") {
 					return err
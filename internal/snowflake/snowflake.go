@@ -24,6 +24,8 @@
 package snowflake
 
 import (
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -61,6 +63,8 @@ type Snowflake uint64
 var _ fmt.Stringer = (*Snowflake)(nil)
 var _ json.Marshaler = (*Snowflake)(nil)
 var _ json.Unmarshaler = (*Snowflake)(nil)
+var _ sql.Scanner = (*Snowflake)(nil)
+var _ driver.Valuer = (*Snowflake)(nil)
 
 // New returns a new snowflake.
 func New() Snowflake {
@@ -133,3 +137,28 @@ func (s *Snowflake) UnmarshalJSON(v []byte) error {
 	*s = Parse(strings.Trim(string(v), `"`))
 	return nil
 }
+
+// Scan satisfies sql.Scanner.
+func (s *Snowflake) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*s = Nil
+	case int64:
+		*s = Snowflake(v)
+	case string:
+		*s = Parse(v)
+	case []byte:
+		*s = Parse(string(v))
+	default:
+		return fmt.Errorf("snowflake: cannot scan value of type %T", src)
+	}
+	return nil
+}
+
+// Value satisfies driver.Valuer, invalid snowflakes are stored as NULL.
+func (s Snowflake) Value() (driver.Value, error) {
+	if !s.Valid() {
+		return nil, nil
+	}
+	return int64(s), nil
+}
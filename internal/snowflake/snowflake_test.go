@@ -221,3 +221,66 @@ func TestSnowflake_UnmarshalJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestSnowflake_Scan(t *testing.T) {
+	for i, tc := range []struct {
+		src       interface{}
+		expect    snowflake.Snowflake
+		expectErr bool
+	}{
+		{
+			src:    int64(52466462028201985),
+			expect: snowflake.Snowflake(52466462028201985),
+		},
+		{
+			src:    []byte("52466462028201985"),
+			expect: snowflake.Snowflake(52466462028201985),
+		},
+		{
+			src:    nil,
+			expect: snowflake.Nil,
+		},
+		{
+			src:       1.5,
+			expectErr: true,
+		},
+	} {
+		result := snowflake.Snowflake(0)
+		err := result.Scan(tc.src)
+
+		if tc.expectErr && err == nil {
+			t.Errorf("Test #%d: Expected error return value, but got \"%v\"", i, err)
+			continue
+		}
+		if !tc.expectErr && err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		if !tc.expectErr && tc.expect != result {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, tc.expect, result)
+			continue
+		}
+	}
+}
+
+func TestSnowflake_Value(t *testing.T) {
+	v, err := snowflake.Snowflake(52466462028201985).Value()
+	if err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+		return
+	}
+	if v != int64(52466462028201985) {
+		t.Errorf("Expected \"%d\", but got \"%v\"", int64(52466462028201985), v)
+		return
+	}
+
+	v, err = snowflake.Nil.Value()
+	if err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+		return
+	}
+	if v != nil {
+		t.Errorf("Expected nil, but got \"%v\"", v)
+		return
+	}
+}
@@ -0,0 +1,114 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package token
+
+import (
+	"context"
+	"errors"
+
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// ErrNotFound is returned by a Store when a Token does not exist.
+var ErrNotFound = errors.New("token: not found")
+
+// Store persists Tokens.
+type Store interface {
+	// Create stores a new Token.
+	Create(ctx context.Context, t *Token) error
+
+	// Consume deletes and returns the Token with the given purpose and ID.
+	Consume(ctx context.Context, p Purpose, id string) (*Token, error)
+
+	// Latest returns the most recently issued Token for a purpose and user.
+	Latest(ctx context.Context, p Purpose, userID snowflake.Snowflake) (*Token, error)
+
+	// DeleteByUser deletes every Token for a purpose and user.
+	DeleteByUser(ctx context.Context, p Purpose, userID snowflake.Snowflake) error
+}
+
+// columns are the columns expected by scan.
+const columns = "id, purpose, user_id, data, created_at, expires_at"
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db db.Querier
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "tokens" table.
+func NewStore(q db.Querier) Store {
+	return &store{db: q}
+}
+
+func (s *store) Create(ctx context.Context, t *Token) error {
+	var data interface{}
+	if t.Data != "" {
+		data = t.Data
+	}
+	_, err := s.db.Exec(
+		ctx,
+		"INSERT INTO tokens ("+columns+") VALUES ($1, $2, $3, $4, $5, $6)",
+		t.ID, string(t.Purpose), t.UserID, data, t.CreatedAt, t.ExpiresAt,
+	)
+	return err
+}
+
+func (s *store) Consume(ctx context.Context, p Purpose, id string) (*Token, error) {
+	return scan(s.db.QueryRow(ctx, "DELETE FROM tokens WHERE id = $1 AND purpose = $2 RETURNING "+columns, id, string(p)))
+}
+
+func (s *store) Latest(ctx context.Context, p Purpose, userID snowflake.Snowflake) (*Token, error) {
+	return scan(s.db.QueryRow(
+		ctx,
+		"SELECT "+columns+" FROM tokens WHERE user_id = $1 AND purpose = $2 ORDER BY created_at DESC LIMIT 1",
+		userID, string(p),
+	))
+}
+
+func (s *store) DeleteByUser(ctx context.Context, p Purpose, userID snowflake.Snowflake) error {
+	_, err := s.db.Exec(ctx, "DELETE FROM tokens WHERE user_id = $1 AND purpose = $2", userID, string(p))
+	return err
+}
+
+// scan scans a row into a Token.
+func scan(row pgx.Row) (*Token, error) {
+	t := &Token{}
+	var purpose string
+	var data *string
+	if err := row.Scan(&t.ID, &purpose, &t.UserID, &data, &t.CreatedAt, &t.ExpiresAt); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	t.Purpose = Purpose(purpose)
+	if data != nil {
+		t.Data = *data
+	}
+	return t, nil
+}
@@ -0,0 +1,148 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package token issues single-use, expiring tokens that are sent to users,
+// such as email confirmation and password reset tokens.
+//
+// Only the SHA-256 hash of a token is stored, the token itself is only known
+// to whoever it was sent to.
+package token
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/uuid"
+)
+
+// ErrInvalid is returned by Manager.Consume when a token does not exist, has
+// already been used or has expired.
+var ErrInvalid = errors.New("token: invalid or expired token")
+
+// Purpose is what a token may be used for, a token issued for one purpose
+// can never be consumed for another.
+type Purpose string
+
+// Token represents an issued token.
+type Token struct {
+	// ID is the SHA-256 hash of the token.
+	ID string
+
+	// Purpose is what the token may be used for.
+	Purpose Purpose
+
+	// UserID is the ID of the user the token was issued to.
+	UserID snowflake.Snowflake
+
+	// Data is optional data bound to the token, such as an email address.
+	Data string
+
+	// CreatedAt is a timestamp of when the token was issued.
+	CreatedAt time.Time
+
+	// ExpiresAt is a timestamp of when the token expires.
+	ExpiresAt time.Time
+}
+
+// Manager issues and consumes tokens.
+type Manager struct {
+	store Store
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+}
+
+// NewManager returns a new Manager.
+func NewManager(s Store) *Manager {
+	return &Manager{
+		store: s,
+		Now:   time.Now,
+	}
+}
+
+// Issue issues a new token, returning the secret that should be sent to the
+// user.
+func (m *Manager) Issue(ctx context.Context, p Purpose, userID snowflake.Snowflake, ttl time.Duration, data string) (string, error) {
+	id, err := uuid.New()
+	if err != nil {
+		return "", err
+	}
+	secret := id.String()
+	now := m.Now()
+	if err := m.store.Create(ctx, &Token{
+		ID:        Hash(secret),
+		Purpose:   p,
+		UserID:    userID,
+		Data:      data,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Consume consumes a token, a token can only be consumed once.
+func (m *Manager) Consume(ctx context.Context, p Purpose, secret string) (*Token, error) {
+	if secret == "" {
+		return nil, ErrInvalid
+	}
+	t, err := m.store.Consume(ctx, p, Hash(secret))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalid
+		}
+		return nil, err
+	}
+	if !m.Now().Before(t.ExpiresAt) {
+		return nil, ErrInvalid
+	}
+	return t, nil
+}
+
+// IssuedSince returns true if a token for the purpose was issued to the user
+// after t, used to throttle issuing tokens.
+func (m *Manager) IssuedSince(ctx context.Context, p Purpose, userID snowflake.Snowflake, t time.Time) (bool, error) {
+	latest, err := m.store.Latest(ctx, p, userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return latest.CreatedAt.After(t), nil
+}
+
+// Revoke revokes every token for the purpose issued to the user.
+func (m *Manager) Revoke(ctx context.Context, p Purpose, userID snowflake.Snowflake) error {
+	return m.store.DeleteByUser(ctx, p, userID)
+}
+
+// Hash returns the hex encoded SHA-256 hash of a token.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,139 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package token_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/token"
+)
+
+// memoryStore is an in-memory token.Store used for testing.
+type memoryStore map[string]token.Token
+
+func (s memoryStore) Create(_ context.Context, t *token.Token) error {
+	s[t.ID] = *t
+	return nil
+}
+
+func (s memoryStore) Consume(_ context.Context, p token.Purpose, id string) (*token.Token, error) {
+	t, ok := s[id]
+	if !ok || t.Purpose != p {
+		return nil, token.ErrNotFound
+	}
+	delete(s, id)
+	return &t, nil
+}
+
+func (s memoryStore) Latest(_ context.Context, p token.Purpose, userID snowflake.Snowflake) (*token.Token, error) {
+	var latest *token.Token
+	for _, t := range s {
+		if t.Purpose == p && t.UserID == userID && (latest == nil || t.CreatedAt.After(latest.CreatedAt)) {
+			t := t
+			latest = &t
+		}
+	}
+	if latest == nil {
+		return nil, token.ErrNotFound
+	}
+	return latest, nil
+}
+
+func (s memoryStore) DeleteByUser(_ context.Context, p token.Purpose, userID snowflake.Snowflake) error {
+	for id, t := range s {
+		if t.Purpose == p && t.UserID == userID {
+			delete(s, id)
+		}
+	}
+	return nil
+}
+
+func TestManager_Consume(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, tc := range []struct {
+		Purpose token.Purpose
+		Secret  func(secret string) string
+		Elapsed time.Duration
+		Expect  error
+	}{
+		{Purpose: "confirm"},
+		{Purpose: "reset", Expect: token.ErrInvalid},
+		{Purpose: "confirm", Secret: func(string) string { return "invalid" }, Expect: token.ErrInvalid},
+		{Purpose: "confirm", Secret: func(string) string { return "" }, Expect: token.ErrInvalid},
+		{Purpose: "confirm", Elapsed: time.Hour, Expect: token.ErrInvalid},
+	} {
+		store := memoryStore{}
+		m := token.NewManager(store)
+		m.Now = func() time.Time { return now }
+
+		secret, err := m.Issue(context.Background(), "confirm", 1, time.Hour, "user@example.com")
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		if _, ok := store[secret]; ok {
+			t.Errorf("Test #%d: Expected token to be stored hashed", i)
+		}
+		if tc.Secret != nil {
+			secret = tc.Secret(secret)
+		}
+		m.Now = func() time.Time { return now.Add(tc.Elapsed) }
+
+		v, err := m.Consume(context.Background(), tc.Purpose, secret)
+		if err != tc.Expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if v.UserID != 1 || v.Data != "user@example.com" {
+			t.Errorf("Test #%d: Expected token to be bound to the user and data", i)
+		}
+		if _, err := m.Consume(context.Background(), tc.Purpose, secret); err != token.ErrInvalid {
+			t.Errorf("Test #%d: Expected token to be single-use, but got \"%v\"", i, err)
+		}
+	}
+}
+
+func TestManager_IssuedSince(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := token.NewManager(memoryStore{})
+	m.Now = func() time.Time { return now }
+
+	if ok, _ := m.IssuedSince(context.Background(), "confirm", 1, now.Add(-time.Minute)); ok {
+		t.Errorf("Expected no token to have been issued")
+	}
+	if _, err := m.Issue(context.Background(), "confirm", 1, time.Hour, ""); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if ok, _ := m.IssuedSince(context.Background(), "confirm", 1, now.Add(-time.Minute)); !ok {
+		t.Errorf("Expected a token to have been issued")
+	}
+	if ok, _ := m.IssuedSince(context.Background(), "reset", 1, now.Add(-time.Minute)); ok {
+		t.Errorf("Expected purposes to be tracked separately")
+	}
+}
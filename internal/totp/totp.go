@@ -0,0 +1,127 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package totp implements time-based one-time passwords as described by
+// RFC 6238, compatible with common authenticator apps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is the number of seconds each code is valid for.
+	Period = 30
+
+	// Digits is the number of digits in a code.
+	Digits = 6
+
+	// secretSize is the size of a generated secret in bytes, as recommended
+	// by RFC 4226.
+	secretSize = 20
+)
+
+// encoding is the encoding used for secrets, authenticator apps expect
+// base32 without padding.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a new base32 encoded secret.
+func NewSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// DecodeSecret decodes a base32 encoded secret.
+func DecodeSecret(secret string) ([]byte, error) {
+	return encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+}
+
+// Counter returns the counter for a point in time.
+func Counter(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Generate generates the code for a key and counter as described by RFC 4226.
+func Generate(key []byte, counter int64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	code := strconv.FormatUint(uint64(v%mod), 10)
+	return strings.Repeat("0", digits-len(code)) + code
+}
+
+// Validate checks a code against a key at time t, allowing for up to skew
+// periods of clock drift in either direction.  The counter the code matched
+// is returned so callers can reject codes that have already been used.
+func Validate(key []byte, code string, t time.Time, skew uint) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+	now := Counter(t)
+	for i := -int64(skew); i <= int64(skew); i++ {
+		counter := now + i
+		if subtle.ConstantTimeCompare([]byte(Generate(key, counter, Digits)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns an otpauth:// URI for a secret, usually displayed as a QR code
+// to be scanned by an authenticator app.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", strconv.Itoa(Digits))
+	v.Set("period", strconv.Itoa(Period))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
@@ -0,0 +1,104 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package totp_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/totp"
+)
+
+// key is the SHA-1 key used by the RFC 4226 and RFC 6238 test vectors.
+var key = []byte("12345678901234567890")
+
+func TestGenerate(t *testing.T) {
+	// RFC 4226 Appendix D
+	for i, expect := range []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	} {
+		if code := totp.Generate(key, int64(i), 6); code != expect {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, expect, code)
+		}
+	}
+
+	// RFC 6238 Appendix B
+	for i, tc := range []struct {
+		Time   int64
+		Expect string
+	}{
+		{Time: 59, Expect: "94287082"},
+		{Time: 1111111109, Expect: "07081804"},
+		{Time: 1111111111, Expect: "14050471"},
+		{Time: 1234567890, Expect: "89005924"},
+		{Time: 2000000000, Expect: "69279037"},
+		{Time: 20000000000, Expect: "65353130"},
+	} {
+		if code := totp.Generate(key, totp.Counter(time.Unix(tc.Time, 0)), 8); code != tc.Expect {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, tc.Expect, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	counter := totp.Counter(now)
+	for i, tc := range []struct {
+		Code   string
+		Skew   uint
+		Expect bool
+	}{
+		{Code: totp.Generate(key, counter, 6), Expect: true},
+		{Code: totp.Generate(key, counter-1, 6), Expect: false},
+		{Code: totp.Generate(key, counter-1, 6), Skew: 1, Expect: true},
+		{Code: totp.Generate(key, counter+1, 6), Skew: 1, Expect: true},
+		{Code: totp.Generate(key, counter+2, 6), Skew: 1, Expect: false},
+		{Code: "", Skew: 1, Expect: false},
+		{Code: totp.Generate(key, counter, 8), Expect: false},
+	} {
+		if _, ok := totp.Validate(key, tc.Code, now, tc.Skew); ok != tc.Expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, ok)
+		}
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	b, err := totp.DecodeSecret(secret)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if len(b) != 20 {
+		t.Errorf("Expected \"%d\", but got \"%d\"", 20, len(b))
+	}
+
+	uri := totp.URI("Cosmos", "user@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Cosmos:user@example.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("Unexpected URI \"%s\"", uri)
+	}
+}
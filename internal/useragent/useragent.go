@@ -0,0 +1,135 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package useragent parses the browser and operating system from a
+// User-Agent header.
+//
+// Parsing is best-effort and only recognises common browsers, it is meant for
+// showing users where they are logged in rather than for detecting features.
+package useragent
+
+import (
+	"strings"
+)
+
+// Agent is a parsed User-Agent header.
+type Agent struct {
+	// Browser is the name of the browser, empty if it was not recognised.
+	Browser string `json:"browser"`
+
+	// Version is the major version of the browser, if known.
+	Version string `json:"version"`
+
+	// OS is the name of the operating system, empty if it was not
+	// recognised.
+	OS string `json:"os"`
+}
+
+// browsers are matched in order, as most browsers also claim to be the
+// browsers they are based on.
+var browsers = []struct {
+	token   string
+	name    string
+	version string
+}{
+	{token: "Edg/", name: "Edge"},
+	{token: "EdgA/", name: "Edge"},
+	{token: "EdgiOS/", name: "Edge"},
+	{token: "OPR/", name: "Opera"},
+	{token: "SamsungBrowser/", name: "Samsung Internet"},
+	{token: "Firefox/", name: "Firefox"},
+	{token: "FxiOS/", name: "Firefox"},
+	{token: "CriOS/", name: "Chrome"},
+	{token: "Chrome/", name: "Chrome"},
+	{token: "Safari/", name: "Safari", version: "Version/"},
+	{token: "curl/", name: "curl"},
+}
+
+// systems are matched in order, iOS claims to be "like Mac OS X" and Android
+// is also Linux.
+var systems = []struct {
+	token string
+	name  string
+}{
+	{token: "Windows", name: "Windows"},
+	{token: "iPhone", name: "iOS"},
+	{token: "iPad", name: "iOS"},
+	{token: "iPod", name: "iOS"},
+	{token: "Mac OS X", name: "macOS"},
+	{token: "Macintosh", name: "macOS"},
+	{token: "Android", name: "Android"},
+	{token: "CrOS", name: "ChromeOS"},
+	{token: "Linux", name: "Linux"},
+}
+
+// Parse parses a User-Agent header.
+func Parse(ua string) Agent {
+	var a Agent
+	for _, b := range browsers {
+		if !strings.Contains(ua, b.token) {
+			continue
+		}
+		a.Browser = b.name
+		token := b.token
+		if b.version != "" {
+			token = b.version
+		}
+		a.Version = version(ua, token)
+		break
+	}
+	for _, s := range systems {
+		if strings.Contains(ua, s.token) {
+			a.OS = s.name
+			break
+		}
+	}
+	return a
+}
+
+// String returns a description of the agent, such as "Firefox 118 on
+// Windows".
+func (a Agent) String() string {
+	browser := a.Browser
+	if browser == "" {
+		browser = "Unknown browser"
+	} else if a.Version != "" {
+		browser += " " + a.Version
+	}
+	if a.OS == "" {
+		return browser
+	}
+	return browser + " on " + a.OS
+}
+
+// version returns the major version following token in ua.
+func version(ua, token string) string {
+	i := strings.Index(ua, token)
+	if i < 0 {
+		return ""
+	}
+	v := ua[i+len(token):]
+	end := 0
+	for end < len(v) && v[end] >= '0' && v[end] <= '9' {
+		end++
+	}
+	return v[:end]
+}
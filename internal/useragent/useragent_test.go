@@ -0,0 +1,85 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package useragent_test
+
+import (
+	"testing"
+
+	"github.com/matthewpi/cosmos/internal/useragent"
+)
+
+func TestParse(t *testing.T) {
+	for i, tc := range []struct {
+		UserAgent string
+		Expect    useragent.Agent
+		String    string
+	}{
+		{
+			UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:118.0) Gecko/20100101 Firefox/118.0",
+			Expect:    useragent.Agent{Browser: "Firefox", Version: "118", OS: "Windows"},
+			String:    "Firefox 118 on Windows",
+		},
+		{
+			UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36",
+			Expect:    useragent.Agent{Browser: "Chrome", Version: "118", OS: "macOS"},
+			String:    "Chrome 118 on macOS",
+		},
+		{
+			UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 Edg/118.0.2088.46",
+			Expect:    useragent.Agent{Browser: "Edge", Version: "118", OS: "Windows"},
+			String:    "Edge 118 on Windows",
+		},
+		{
+			UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+			Expect:    useragent.Agent{Browser: "Safari", Version: "17", OS: "iOS"},
+			String:    "Safari 17 on iOS",
+		},
+		{
+			UserAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36",
+			Expect:    useragent.Agent{Browser: "Chrome", Version: "118", OS: "Android"},
+			String:    "Chrome 118 on Android",
+		},
+		{
+			UserAgent: "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 OPR/104.0.0.0",
+			Expect:    useragent.Agent{Browser: "Opera", Version: "104", OS: "Linux"},
+			String:    "Opera 104 on Linux",
+		},
+		{
+			UserAgent: "curl/8.4.0",
+			Expect:    useragent.Agent{Browser: "curl", Version: "8"},
+			String:    "curl 8",
+		},
+		{
+			UserAgent: "",
+			String:    "Unknown browser",
+		},
+	} {
+		a := useragent.Parse(tc.UserAgent)
+		if a != tc.Expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, a)
+		}
+		if s := a.String(); s != tc.String {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.String, s)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package webauthn
+
+// VerifyAssertion verifies the response to an authentication ceremony for a
+// credential, returning the new signature counter.
+func (c *Config) VerifyAssertion(challenge []byte, cred *Credential, r *AssertionResponse) (uint32, error) {
+	if err := c.verifyClientData(r.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	authData, err := ParseAuthenticatorData(r.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.verifyAuthenticatorData(authData); err != nil {
+		return 0, err
+	}
+
+	key, err := ParsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	if err := key.Verify(signedData(r.Response.AuthenticatorData, r.Response.ClientDataJSON), r.Response.Signature); err != nil {
+		return 0, err
+	}
+
+	// Authenticators that don't implement a counter always return zero,
+	// otherwise the counter must increase with every assertion.
+	if (authData.SignCount != 0 || cred.SignCount != 0) && authData.SignCount <= cred.SignCount {
+		return 0, ErrSignCount
+	}
+	return authData.SignCount, nil
+}
+
+// UserVerified reports if the authenticator verified the user for the
+// assertion, this can only be relied on once the response has been verified
+// by VerifyAssertion.
+func (r *AssertionResponse) UserVerified() bool {
+	authData, err := ParseAuthenticatorData(r.Response.AuthenticatorData)
+	if err != nil {
+		return false
+	}
+	return authData.Flags&FlagUserVerified != 0
+}
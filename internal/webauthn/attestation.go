@@ -0,0 +1,180 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package webauthn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+
+	"github.com/matthewpi/cosmos/internal/cbor"
+)
+
+// oidAAGUID is the OID of the certificate extension containing the AAGUID of
+// an authenticator.
+var oidAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// VerifyAttestation verifies the response to a registration ceremony,
+// returning the new credential.
+func (c *Config) VerifyAttestation(challenge []byte, r *AttestationResponse) (*Credential, error) {
+	clientDataJSON := r.Response.ClientDataJSON
+	if err := c.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	v, err := cbor.Unmarshal(r.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrInvalidAttestation
+	}
+	obj, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidAttestation
+	}
+	format, _ := obj["fmt"].(string)
+	stmt, _ := obj["attStmt"].(map[interface{}]interface{})
+	rawAuthData, _ := obj["authData"].([]byte)
+	if stmt == nil || rawAuthData == nil {
+		return nil, ErrInvalidAttestation
+	}
+
+	authData, err := ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.verifyAuthenticatorData(authData); err != nil {
+		return nil, err
+	}
+	if authData.Flags&FlagAttestedCredentialData == 0 {
+		return nil, ErrInvalidAuthenticatorData
+	}
+	if !bytes.Equal(authData.CredentialID, r.RawID) {
+		return nil, ErrInvalidAttestation
+	}
+	key, err := ParsePublicKey(authData.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := &Credential{
+		ID:        authData.CredentialID,
+		PublicKey: authData.PublicKey,
+		Algorithm: key.Algorithm,
+		SignCount: authData.SignCount,
+		AAGUID:    authData.AAGUID,
+	}
+	switch format {
+	case "none":
+		if len(stmt) != 0 {
+			return nil, ErrInvalidAttestation
+		}
+		cred.AttestationType = "none"
+	case "packed":
+		t, err := verifyPacked(stmt, signedData(rawAuthData, clientDataJSON), authData, key)
+		if err != nil {
+			return nil, err
+		}
+		cred.AttestationType = t
+	default:
+		return nil, ErrUnsupportedAttestation
+	}
+	return cred, nil
+}
+
+// verifyPacked verifies a packed attestation statement, returning the
+// attestation type.
+func verifyPacked(stmt map[interface{}]interface{}, signed []byte, authData *AuthenticatorData, key *PublicKey) (string, error) {
+	alg, ok := intValue(stmt["alg"])
+	if !ok {
+		return "", ErrInvalidAttestation
+	}
+	sig, _ := stmt["sig"].([]byte)
+	if sig == nil {
+		return "", ErrInvalidAttestation
+	}
+
+	x5c, ok := stmt["x5c"].([]interface{})
+	if !ok {
+		// Self attestation, signed by the credential itself.
+		if _, ok := stmt["x5c"]; ok || alg != key.Algorithm {
+			return "", ErrInvalidAttestation
+		}
+		if err := key.Verify(signed, sig); err != nil {
+			return "", ErrInvalidAttestation
+		}
+		return "self", nil
+	}
+
+	if len(x5c) < 1 {
+		return "", ErrInvalidAttestation
+	}
+	der, _ := x5c[0].([]byte)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return "", ErrInvalidAttestation
+	}
+	var sigAlg x509.SignatureAlgorithm
+	switch alg {
+	case AlgES256:
+		sigAlg = x509.ECDSAWithSHA256
+	case AlgRS256:
+		sigAlg = x509.SHA256WithRSA
+	case AlgEdDSA:
+		sigAlg = x509.PureEd25519
+	default:
+		return "", ErrInvalidAttestation
+	}
+	if err := cert.CheckSignature(sigAlg, signed, sig); err != nil {
+		return "", ErrInvalidAttestation
+	}
+	if err := verifyPackedCertificate(cert, authData.AAGUID); err != nil {
+		return "", err
+	}
+	return "basic", nil
+}
+
+// verifyPackedCertificate checks the requirements of a packed attestation
+// certificate (WebAuthn §8.2.1).
+func verifyPackedCertificate(cert *x509.Certificate, aaguid []byte) error {
+	s := cert.Subject
+	if cert.Version != 3 ||
+		len(s.Country) != 1 ||
+		len(s.Organization) != 1 ||
+		len(s.OrganizationalUnit) != 1 || s.OrganizationalUnit[0] != "Authenticator Attestation" ||
+		s.CommonName == "" ||
+		!cert.BasicConstraintsValid || cert.IsCA {
+		return ErrInvalidAttestation
+	}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidAAGUID) {
+			continue
+		}
+		if ext.Critical {
+			return ErrInvalidAttestation
+		}
+		var v []byte
+		if _, err := asn1.Unmarshal(ext.Value, &v); err != nil || !bytes.Equal(v, aaguid) {
+			return ErrInvalidAttestation
+		}
+	}
+	return nil
+}
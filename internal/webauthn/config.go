@@ -0,0 +1,107 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package webauthn
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/config/lexer"
+)
+
+// Config represents the configuration of a relying party.
+type Config struct {
+	// RPID is the relying party ID, usually the domain of the frontend.
+	RPID string `json:"rp_id"`
+
+	// RPName is the name of the relying party shown by authenticators.
+	RPName string `json:"rp_name"`
+
+	// Origins are the allowed origins of ceremonies.
+	Origins []string `json:"origins"`
+
+	// RequireUserVerification requires the authenticator to verify the user,
+	// such as with a PIN or biometrics, rather than only their presence.
+	RequireUserVerification bool `json:"require_user_verification"`
+
+	// Timeout is how long a ceremony may take.
+	Timeout time.Duration `json:"timeout"`
+}
+
+// DefaultConfig returns the default relying party configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		RPID:    "localhost",
+		RPName:  "Cosmos",
+		Origins: []string{"http://localhost"},
+		Timeout: 5 * time.Minute,
+	}
+}
+
+// FromLexer .
+func FromLexer(b lexer.Block) (*Config, error) {
+	c := DefaultConfig()
+	var origins []string
+	for _, s := range b.Segments {
+		d := s.Directive()
+		switch d {
+		case "rp_id", "rp_name":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after %s directive", d)
+			}
+			if d == "rp_id" {
+				c.RPID = s[1].Text
+			} else {
+				c.RPName = s[1].Text
+			}
+		case "origin":
+			if len(s) < 2 {
+				return nil, fmt.Errorf("expected at least one argument after origin directive")
+			}
+			for _, t := range s[1:] {
+				origins = append(origins, strings.TrimSuffix(t.Text, "/"))
+			}
+		case "require_user_verification":
+			if len(s) != 1 {
+				return nil, fmt.Errorf("unexpected argument after require_user_verification directive")
+			}
+			c.RequireUserVerification = true
+		case "timeout":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after timeout directive")
+			}
+			v, err := time.ParseDuration(s[1].Text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout: %w", err)
+			}
+			c.Timeout = v
+		default:
+			return nil, fmt.Errorf("unknown directive: \"" + d + "\"")
+		}
+	}
+	if origins != nil {
+		c.Origins = origins
+	}
+	return c, nil
+}
@@ -0,0 +1,165 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	"github.com/matthewpi/cosmos/internal/cbor"
+)
+
+// COSE algorithms.
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// COSE key types.
+const (
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+)
+
+// ErrInvalidPublicKey is returned when a COSE key is malformed or uses an
+// unsupported algorithm.
+var ErrInvalidPublicKey = errors.New("webauthn: invalid or unsupported public key")
+
+// PublicKey is a parsed COSE public key.
+type PublicKey struct {
+	// Algorithm is the COSE algorithm of the key.
+	Algorithm int64
+
+	// Key is either an *ecdsa.PublicKey, *rsa.PublicKey or
+	// ed25519.PublicKey.
+	Key crypto.PublicKey
+}
+
+// ParsePublicKey parses a COSE encoded public key.
+func ParsePublicKey(b []byte) (*PublicKey, error) {
+	v, err := cbor.Unmarshal(b)
+	if err != nil {
+		return nil, ErrInvalidPublicKey
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidPublicKey
+	}
+	kty, ok := intValue(get(m, 1))
+	if !ok {
+		return nil, ErrInvalidPublicKey
+	}
+	alg, ok := intValue(get(m, 3))
+	if !ok {
+		return nil, ErrInvalidPublicKey
+	}
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := intValue(get(m, -1))
+		x, _ := get(m, -2).([]byte)
+		y, _ := get(m, -3).([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrInvalidPublicKey
+		}
+		k := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !k.Curve.IsOnCurve(k.X, k.Y) {
+			return nil, ErrInvalidPublicKey
+		}
+		return &PublicKey{Algorithm: alg, Key: k}, nil
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := get(m, -1).([]byte)
+		e, _ := get(m, -2).([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrInvalidPublicKey
+		}
+		return &PublicKey{Algorithm: alg, Key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := intValue(get(m, -1))
+		x, _ := get(m, -2).([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, ErrInvalidPublicKey
+		}
+		return &PublicKey{Algorithm: alg, Key: ed25519.PublicKey(x)}, nil
+	default:
+		return nil, ErrInvalidPublicKey
+	}
+}
+
+// Verify verifies a signature of data.
+func (k *PublicKey) Verify(data, sig []byte) error {
+	hash := sha256.Sum256(data)
+	switch key := k.Key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, hash[:], sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) == nil {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// get returns the value of an integer key in a decoded CBOR map.
+func get(m map[interface{}]interface{}, k int64) interface{} {
+	if k < 0 {
+		return m[k]
+	}
+	return m[uint64(k)]
+}
+
+// intValue converts a decoded CBOR integer to an int64.
+func intValue(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case uint64:
+		if v > 1<<62 {
+			return 0, false
+		}
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,261 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package webauthn implements the relying party side of Web Authentication
+// (https://www.w3.org/TR/webauthn-2/).
+//
+// Only the "none" and "packed" attestation formats are supported.  Packed
+// attestation certificates are validated, but are not chained to a trust
+// anchor as no authenticator metadata is available, the attestation type is
+// recorded so this can be done later.
+package webauthn
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/matthewpi/cosmos/internal/cbor"
+)
+
+var (
+	// ErrInvalidClientData is returned when the client data is malformed or
+	// does not match the ceremony.
+	ErrInvalidClientData = errors.New("webauthn: invalid client data")
+
+	// ErrInvalidAuthenticatorData is returned when the authenticator data is
+	// malformed or does not match the relying party.
+	ErrInvalidAuthenticatorData = errors.New("webauthn: invalid authenticator data")
+
+	// ErrInvalidAttestation is returned when an attestation statement is
+	// malformed or its signature is invalid.
+	ErrInvalidAttestation = errors.New("webauthn: invalid attestation")
+
+	// ErrUnsupportedAttestation is returned for attestation formats other
+	// than "none" and "packed".
+	ErrUnsupportedAttestation = errors.New("webauthn: unsupported attestation format")
+
+	// ErrInvalidSignature is returned when an assertion's signature is
+	// invalid.
+	ErrInvalidSignature = errors.New("webauthn: invalid signature")
+
+	// ErrSignCount is returned when an assertion's signature counter did not
+	// increase, which indicates the authenticator may have been cloned.
+	ErrSignCount = errors.New("webauthn: signature counter did not increase")
+)
+
+// Authenticator data flags.
+const (
+	FlagUserPresent            = 0x01
+	FlagUserVerified           = 0x04
+	FlagAttestedCredentialData = 0x40
+	FlagExtensionData          = 0x80
+)
+
+// challengeSize is the size of generated challenges in bytes.
+const challengeSize = 32
+
+// NewChallenge generates a new random challenge.
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, challengeSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Bytes is a byte slice encoded as unpadded base64url in JSON, the encoding
+// used for binary values by the WebAuthn JSON serialization.
+type Bytes []byte
+
+// MarshalJSON implements json.Marshaler.
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+// String returns the base64url encoding of b.
+func (b Bytes) String() string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Credential is a public key credential registered to a user.
+type Credential struct {
+	// ID is the credential's ID, chosen by the authenticator.
+	ID []byte
+
+	// PublicKey is the COSE encoded public key of the credential.
+	PublicKey []byte
+
+	// Algorithm is the COSE algorithm of the public key.
+	Algorithm int64
+
+	// SignCount is the last signature counter returned by the
+	// authenticator.
+	SignCount uint32
+
+	// AAGUID identifies the model of the authenticator.
+	AAGUID []byte
+
+	// AttestationType is either "none", "self" or "basic".
+	AttestationType string
+}
+
+// clientData is the client data collected by the browser.
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// verifyClientData verifies the client data matches the ceremony.
+func (c *Config) verifyClientData(raw []byte, typ string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return ErrInvalidClientData
+	}
+	if cd.Type != typ || cd.CrossOrigin {
+		return ErrInvalidClientData
+	}
+	got, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(cd.Challenge, "="))
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrInvalidClientData
+	}
+	for _, origin := range c.Origins {
+		if cd.Origin == origin {
+			return nil
+		}
+	}
+	return ErrInvalidClientData
+}
+
+// AuthenticatorData is the data returned by an authenticator.
+type AuthenticatorData struct {
+	// RPIDHash is the SHA-256 hash of the relying party ID.
+	RPIDHash []byte
+
+	// Flags are the authenticator data flags.
+	Flags byte
+
+	// SignCount is the signature counter.
+	SignCount uint32
+
+	// AAGUID identifies the model of the authenticator, only set if
+	// FlagAttestedCredentialData is set.
+	AAGUID []byte
+
+	// CredentialID is the ID of the credential, only set if
+	// FlagAttestedCredentialData is set.
+	CredentialID []byte
+
+	// PublicKey is the COSE encoded public key of the credential, only set
+	// if FlagAttestedCredentialData is set.
+	PublicKey []byte
+}
+
+// ParseAuthenticatorData parses authenticator data.
+func ParseAuthenticatorData(b []byte) (*AuthenticatorData, error) {
+	if len(b) < 37 {
+		return nil, ErrInvalidAuthenticatorData
+	}
+	d := &AuthenticatorData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	rest := b[37:]
+	if d.Flags&FlagAttestedCredentialData != 0 {
+		if len(rest) < 18 {
+			return nil, ErrInvalidAuthenticatorData
+		}
+		d.AAGUID = rest[:16]
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || len(rest) < n {
+			return nil, ErrInvalidAuthenticatorData
+		}
+		d.CredentialID = rest[:n]
+		rest = rest[n:]
+
+		_, after, err := cbor.Decode(rest)
+		if err != nil {
+			return nil, ErrInvalidAuthenticatorData
+		}
+		d.PublicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+	if d.Flags&FlagExtensionData != 0 {
+		_, after, err := cbor.Decode(rest)
+		if err != nil {
+			return nil, ErrInvalidAuthenticatorData
+		}
+		rest = after
+	}
+	if len(rest) > 0 {
+		return nil, ErrInvalidAuthenticatorData
+	}
+	return d, nil
+}
+
+// verifyAuthenticatorData verifies authenticator data was created for the
+// relying party and that the user was present, and verified if required.
+func (c *Config) verifyAuthenticatorData(d *AuthenticatorData) error {
+	hash := sha256.Sum256([]byte(c.RPID))
+	if subtle.ConstantTimeCompare(d.RPIDHash, hash[:]) != 1 {
+		return ErrInvalidAuthenticatorData
+	}
+	if d.Flags&FlagUserPresent == 0 {
+		return ErrInvalidAuthenticatorData
+	}
+	if c.RequireUserVerification && d.Flags&FlagUserVerified == 0 {
+		return ErrInvalidAuthenticatorData
+	}
+	return nil
+}
+
+// signedData returns the data signed by an authenticator, the authenticator
+// data followed by the hash of the client data.
+func signedData(authData, clientDataJSON []byte) []byte {
+	hash := sha256.Sum256(clientDataJSON)
+	signed := make([]byte, 0, len(authData)+len(hash))
+	signed = append(signed, authData...)
+	return append(signed, hash[:]...)
+}
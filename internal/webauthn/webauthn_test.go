@@ -0,0 +1,156 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package webauthn_test
+
+import (
+	"testing"
+
+	"github.com/matthewpi/cosmos/internal/webauthn"
+	"github.com/matthewpi/cosmos/internal/webauthn/webauthntest"
+)
+
+var config = &webauthn.Config{
+	RPID:    "example.com",
+	RPName:  "Example",
+	Origins: []string{"https://example.com"},
+}
+
+func register(t *testing.T, a *webauthntest.Authenticator) (*webauthn.Credential, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	o := config.NewCreationOptions(challenge, webauthn.UserEntity{ID: []byte{1}, Name: "user@example.com"}, nil)
+	r, err := a.Create(o)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	return config.VerifyAttestation(challenge, r)
+}
+
+func TestConfig_VerifyAttestation(t *testing.T) {
+	for i, tc := range []struct {
+		Setup  func(a *webauthntest.Authenticator) error
+		Type   string
+		Expect error
+	}{
+		{Type: "none"},
+		{
+			Setup: func(a *webauthntest.Authenticator) error {
+				a.Format = "packed"
+				return nil
+			},
+			Type: "self",
+		},
+		{
+			Setup: func(a *webauthntest.Authenticator) error {
+				return a.UseBasicAttestation()
+			},
+			Type: "basic",
+		},
+		{
+			Setup: func(a *webauthntest.Authenticator) error {
+				a.Format = "fido-u2f"
+				return nil
+			},
+			Expect: webauthn.ErrUnsupportedAttestation,
+		},
+		{
+			Setup: func(a *webauthntest.Authenticator) error {
+				a.Origin = "https://evil.example.com"
+				return nil
+			},
+			Expect: webauthn.ErrInvalidClientData,
+		},
+	} {
+		a := webauthntest.NewAuthenticator("https://example.com")
+		if tc.Setup != nil {
+			if err := tc.Setup(a); err != nil {
+				t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+				continue
+			}
+		}
+		cred, err := register(t, a)
+		if err != tc.Expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if cred.AttestationType != tc.Type {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, tc.Type, cred.AttestationType)
+		}
+		if cred.Algorithm != webauthn.AlgES256 {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, webauthn.AlgES256, cred.Algorithm)
+		}
+	}
+}
+
+func TestConfig_VerifyAssertion(t *testing.T) {
+	a := webauthntest.NewAuthenticator("https://example.com")
+	cred, err := register(t, a)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	assert := func(challenge []byte) (uint32, error) {
+		o := config.NewRequestOptions(challenge, [][]byte{cred.ID})
+		r, err := a.Get(o)
+		if err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+		return config.VerifyAssertion(o.Challenge, cred, r)
+	}
+
+	challenge, _ := webauthn.NewChallenge()
+	count, err := assert(challenge)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected \"%d\", but got \"%d\"", 1, count)
+	}
+	cred.SignCount = count
+
+	// A response to a different challenge must be rejected.
+	o := config.NewRequestOptions(challenge, nil)
+	r, _ := a.Get(o)
+	other, _ := webauthn.NewChallenge()
+	if _, err := config.VerifyAssertion(other, cred, r); err != webauthn.ErrInvalidClientData {
+		t.Errorf("Expected \"%v\", but got \"%v\"", webauthn.ErrInvalidClientData, err)
+	}
+
+	// A tampered signature must be rejected.
+	r, _ = a.Get(o)
+	r.Response.Signature[len(r.Response.Signature)-1] ^= 0xff
+	if _, err := config.VerifyAssertion(challenge, cred, r); err != webauthn.ErrInvalidSignature {
+		t.Errorf("Expected \"%v\", but got \"%v\"", webauthn.ErrInvalidSignature, err)
+	}
+
+	// A counter that goes backwards indicates a cloned authenticator.
+	cred.SignCount = 100
+	if _, err := assert(challenge); err != webauthn.ErrSignCount {
+		t.Errorf("Expected \"%v\", but got \"%v\"", webauthn.ErrSignCount, err)
+	}
+}
@@ -0,0 +1,300 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package webauthntest provides a software authenticator for testing
+// WebAuthn relying parties without hardware.
+package webauthntest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/cbor"
+	"github.com/matthewpi/cosmos/internal/webauthn"
+)
+
+// ErrNoCredential is returned by Authenticator.Get when the authenticator has
+// no credential for the relying party.
+var ErrNoCredential = errors.New("webauthntest: no credential")
+
+// credential is a credential stored by an Authenticator.
+type credential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	rpID       string
+	userHandle []byte
+	signCount  uint32
+}
+
+// Authenticator is an in-process authenticator that creates ES256
+// credentials.
+type Authenticator struct {
+	// Origin is the origin reported in the client data.
+	Origin string
+
+	// Format is the attestation format, either "none" or "packed".
+	Format string
+
+	// AAGUID identifies the model of the authenticator.
+	AAGUID []byte
+
+	// UserVerified controls if the authenticator reports that the user was
+	// verified.
+	UserVerified bool
+
+	// attestationKey and attestationCert are used for packed attestation,
+	// if nil self attestation is used.
+	attestationKey  *ecdsa.PrivateKey
+	attestationCert []byte
+
+	credentials []*credential
+}
+
+// NewAuthenticator returns a new Authenticator using "none" attestation.
+func NewAuthenticator(origin string) *Authenticator {
+	return &Authenticator{
+		Origin:       origin,
+		Format:       "none",
+		AAGUID:       make([]byte, 16),
+		UserVerified: true,
+	}
+}
+
+// UseBasicAttestation switches the authenticator to "packed" attestation
+// signed by a generated attestation certificate.
+func (a *Authenticator) UseBasicAttestation() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	aaguid, err := asn1.Marshal(a.AAGUID)
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Organization:       []string{"Cosmos"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "Software Authenticator",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		ExtraExtensions: []pkix.Extension{
+			{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}, Value: aaguid},
+		},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	a.Format = "packed"
+	a.attestationKey = key
+	a.attestationCert = cert
+	return nil
+}
+
+// Create creates a new credential, as navigator.credentials.create() would.
+func (a *Authenticator) Create(o *webauthn.CreationOptions) (*webauthn.AttestationResponse, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	c := &credential{
+		id:         id,
+		key:        key,
+		rpID:       o.RP.ID,
+		userHandle: o.User.ID,
+	}
+
+	coseKey, err := cbor.Marshal(map[interface{}]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: pad(key.X.Bytes()),
+		-3: pad(key.Y.Bytes()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var attested bytes.Buffer
+	attested.Write(a.AAGUID)
+	_ = binary.Write(&attested, binary.BigEndian, uint16(len(id)))
+	attested.Write(id)
+	attested.Write(coseKey)
+
+	authData := a.authData(c, webauthn.FlagAttestedCredentialData, attested.Bytes())
+	clientDataJSON, err := a.clientData("webauthn.create", o.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := map[interface{}]interface{}{}
+	if a.Format == "packed" {
+		signed := signedData(authData, clientDataJSON)
+		signer := key
+		if a.attestationKey != nil {
+			signer = a.attestationKey
+		}
+		sig, err := sign(signer, signed)
+		if err != nil {
+			return nil, err
+		}
+		stmt["alg"] = -7
+		stmt["sig"] = sig
+		if a.attestationCert != nil {
+			stmt["x5c"] = []interface{}{a.attestationCert}
+		}
+	}
+	attestationObject, err := cbor.Marshal(map[interface{}]interface{}{
+		"fmt":      a.Format,
+		"attStmt":  stmt,
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.credentials = append(a.credentials, c)
+	r := &webauthn.AttestationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(id),
+		RawID: id,
+		Type:  "public-key",
+	}
+	r.Response.ClientDataJSON = clientDataJSON
+	r.Response.AttestationObject = attestationObject
+	return r, nil
+}
+
+// Get creates an assertion, as navigator.credentials.get() would.  If the
+// options don't list any credentials, the most recently created credential
+// for the relying party is used.
+func (a *Authenticator) Get(o *webauthn.RequestOptions) (*webauthn.AssertionResponse, error) {
+	c := a.find(o)
+	if c == nil {
+		return nil, ErrNoCredential
+	}
+	c.signCount++
+
+	authData := a.authData(c, 0, nil)
+	clientDataJSON, err := a.clientData("webauthn.get", o.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sign(c.key, signedData(authData, clientDataJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &webauthn.AssertionResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(c.id),
+		RawID: c.id,
+		Type:  "public-key",
+	}
+	r.Response.ClientDataJSON = clientDataJSON
+	r.Response.AuthenticatorData = authData
+	r.Response.Signature = sig
+	r.Response.UserHandle = c.userHandle
+	return r, nil
+}
+
+// SetSignCount overrides the signature counter of every credential, used to
+// simulate a cloned authenticator.
+func (a *Authenticator) SetSignCount(n uint32) {
+	for _, c := range a.credentials {
+		c.signCount = n
+	}
+}
+
+func (a *Authenticator) find(o *webauthn.RequestOptions) *credential {
+	for i := len(a.credentials) - 1; i >= 0; i-- {
+		c := a.credentials[i]
+		if c.rpID != o.RPID {
+			continue
+		}
+		if len(o.AllowCredentials) == 0 {
+			return c
+		}
+		for _, d := range o.AllowCredentials {
+			if bytes.Equal(d.ID, c.id) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) authData(c *credential, flags byte, attested []byte) []byte {
+	flags |= webauthn.FlagUserPresent
+	if a.UserVerified {
+		flags |= webauthn.FlagUserVerified
+	}
+	hash := sha256.Sum256([]byte(c.rpID))
+	var b bytes.Buffer
+	b.Write(hash[:])
+	b.WriteByte(flags)
+	_ = binary.Write(&b, binary.BigEndian, c.signCount)
+	b.Write(attested)
+	return b.Bytes()
+}
+
+func (a *Authenticator) clientData(typ string, challenge []byte) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":        typ,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+}
+
+func signedData(authData, clientDataJSON []byte) []byte {
+	hash := sha256.Sum256(clientDataJSON)
+	return append(append([]byte{}, authData...), hash[:]...)
+}
+
+func sign(key *ecdsa.PrivateKey, data []byte) ([]byte, error) {
+	hash := sha256.Sum256(data)
+	return ecdsa.SignASN1(rand.Reader, key, hash[:])
+}
+
+// pad left pads a P-256 coordinate to 32 bytes.
+func pad(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
@@ -0,0 +1,217 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package invite provides invites, which allow registering while
+// registration is limited to invited users.
+//
+// An invite is redeemed with a code of which only the SHA-256 hash is
+// stored.  Invites may be used a limited number of times, may expire, may be
+// bound to a single email address and may assign a role to the users who
+// redeem them.
+package invite
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// Permissions required to manage invites.
+const (
+	PermissionRead   role.Permission = "invites.read"
+	PermissionCreate role.Permission = "invites.create"
+	PermissionDelete role.Permission = "invites.delete"
+)
+
+var (
+	// ErrInvalid is returned by Manager.Redeem when an invite does not
+	// exist, has been revoked, has expired, has been used up or is bound to
+	// another email address.
+	ErrInvalid = errors.New("invite: invalid or expired invite")
+
+	// ErrInvalidUses is returned when creating an invite with a maximum
+	// number of uses outside of the allowed range, or with more than one use
+	// while bound to an email address.
+	ErrInvalidUses = errors.New("invite: invalid maximum number of uses")
+
+	// ErrInvalidExpiry is returned when creating an invite that has already
+	// expired.
+	ErrInvalidExpiry = errors.New("invite: expiry must be in the future")
+)
+
+// maxUses is the maximum number of times an invite may be used.
+const maxUses = 1000
+
+// Invite is an invite to register.
+type Invite struct {
+	// ID is the invite's unique identifier.
+	ID snowflake.Snowflake `json:"id"`
+
+	// Hash is the SHA-256 hash of the invite's code.
+	Hash string `json:"-"`
+
+	// Email, if not empty, is the only email address that may redeem the
+	// invite.
+	Email string `json:"email,omitempty"`
+
+	// RoleID, if valid, is the ID of the role assigned to users who redeem
+	// the invite.
+	RoleID snowflake.Snowflake `json:"role_id"`
+
+	// CreatedBy is the ID of the user who created the invite, it is invalid
+	// if they have since been deleted.
+	CreatedBy snowflake.Snowflake `json:"created_by"`
+
+	// MaxUses is the number of times the invite may be redeemed.
+	MaxUses int `json:"max_uses"`
+
+	// Uses is the number of times the invite has been redeemed.
+	Uses int `json:"uses"`
+
+	// CreatedAt is a timestamp of when the invite was created.
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt is a timestamp of when the invite expires, if nil the invite
+	// never expires.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Valid returns true if a user with the email address may redeem the invite
+// at now.
+func (i *Invite) Valid(email string, now time.Time) bool {
+	if i.Uses >= i.MaxUses {
+		return false
+	}
+	if i.ExpiresAt != nil && !now.Before(*i.ExpiresAt) {
+		return false
+	}
+	return i.Email == "" || strings.EqualFold(i.Email, email)
+}
+
+// Roles is used by a Manager to load the role an invite assigns, it is
+// satisfied by role.Store.
+type Roles interface {
+	ByID(ctx context.Context, id snowflake.Snowflake) (*role.Role, error)
+}
+
+// Manager creates, redeems and revokes invites.
+type Manager struct {
+	store Store
+	roles Roles
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+}
+
+// NewManager returns a new Manager.
+func NewManager(s Store, roles Roles) *Manager {
+	return &Manager{
+		store: s,
+		roles: roles,
+		Now:   time.Now,
+	}
+}
+
+// Create creates a new invite on behalf of actor, returning the code that
+// must be given to the invitee, it can not be retrieved again.  If email is
+// not empty only that address may redeem the invite, if roleID is valid the
+// role is assigned to users who redeem it, in which case the actor must be
+// allowed to assign the role, see role.Manager.Assign.  If expiresAt is nil
+// the invite never expires.
+func (m *Manager) Create(ctx context.Context, actor *user.User, email string, roleID snowflake.Snowflake, uses int, expiresAt *time.Time) (*Invite, string, error) {
+	if uses < 1 || uses > maxUses || (email != "" && uses > 1) {
+		return nil, "", ErrInvalidUses
+	}
+	now := m.Now()
+	if expiresAt != nil && !now.Before(*expiresAt) {
+		return nil, "", ErrInvalidExpiry
+	}
+	if roleID.Valid() {
+		if !actor.Can(role.PermissionAssign) {
+			return nil, "", role.ErrForbidden
+		}
+		r, err := m.roles.ByID(ctx, roleID)
+		if err != nil {
+			return nil, "", err
+		}
+		if !actor.Role.Outranks(r) {
+			return nil, "", role.ErrInsufficientRank
+		}
+	} else {
+		roleID = snowflake.Nil
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+	i := &Invite{
+		ID:        snowflake.New(),
+		Hash:      token.Hash(code),
+		Email:     email,
+		RoleID:    roleID,
+		CreatedBy: actor.ID,
+		MaxUses:   uses,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	if err := m.store.Create(ctx, i); err != nil {
+		return nil, "", err
+	}
+	return i, code, nil
+}
+
+// Redeem redeems an invite, creating the user u with the role assigned by
+// the invite and recording who invited them.  The user is only created if
+// the invite is valid, and the invite is only used if the user is created.
+func (m *Manager) Redeem(ctx context.Context, code string, u *user.User) (*Invite, error) {
+	if code == "" {
+		return nil, ErrInvalid
+	}
+	i, err := m.store.Redeem(ctx, token.Hash(code), m.Now(), u)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalid
+		}
+		return nil, err
+	}
+	return i, nil
+}
+
+// List returns every invite, newest first.
+func (m *Manager) List(ctx context.Context) ([]*Invite, error) {
+	return m.store.All(ctx)
+}
+
+// Revoke revokes an invite, users who already redeemed it are unaffected.
+func (m *Manager) Revoke(ctx context.Context, id snowflake.Snowflake) error {
+	return m.store.Delete(ctx, id)
+}
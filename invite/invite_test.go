@@ -0,0 +1,284 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package invite_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/invite"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+	"github.com/matthewpi/cosmos/user/usertest"
+)
+
+// memoryStore is an in-memory invite.Store used for testing, redeemed
+// invites create users in users.
+type memoryStore struct {
+	invites map[snowflake.Snowflake]*invite.Invite
+	roles   memoryRoles
+	users   map[string]*user.User
+}
+
+func newMemoryStore(roles memoryRoles) *memoryStore {
+	return &memoryStore{
+		invites: make(map[snowflake.Snowflake]*invite.Invite),
+		roles:   roles,
+		users:   make(map[string]*user.User),
+	}
+}
+
+func (s *memoryStore) Create(_ context.Context, i *invite.Invite) error {
+	v := *i
+	s.invites[i.ID] = &v
+	return nil
+}
+
+func (s *memoryStore) All(_ context.Context) ([]*invite.Invite, error) {
+	var invites []*invite.Invite
+	for _, i := range s.invites {
+		v := *i
+		invites = append(invites, &v)
+	}
+	return invites, nil
+}
+
+func (s *memoryStore) Redeem(ctx context.Context, hash string, now time.Time, u *user.User) (*invite.Invite, error) {
+	for _, i := range s.invites {
+		if i.Hash != hash {
+			continue
+		}
+		if !i.Valid(u.Email, now) {
+			return nil, invite.ErrInvalid
+		}
+		if _, ok := s.users[u.Email]; ok {
+			return nil, user.ErrEmailTaken
+		}
+		if i.RoleID.Valid() {
+			r, err := s.roles.ByID(ctx, i.RoleID)
+			if err != nil {
+				return nil, err
+			}
+			u.Role = r
+		}
+		u.InvitedBy = i.CreatedBy
+		s.users[u.Email] = u
+		i.Uses++
+		v := *i
+		return &v, nil
+	}
+	return nil, invite.ErrNotFound
+}
+
+func (s *memoryStore) Delete(_ context.Context, id snowflake.Snowflake) error {
+	if _, ok := s.invites[id]; !ok {
+		return invite.ErrNotFound
+	}
+	delete(s.invites, id)
+	return nil
+}
+
+// memoryRoles is an in-memory invite.Roles used for testing.
+type memoryRoles map[snowflake.Snowflake]*role.Role
+
+func (s memoryRoles) ByID(_ context.Context, id snowflake.Snowflake) (*role.Role, error) {
+	r, ok := s[id]
+	if !ok {
+		return nil, role.ErrNotFound
+	}
+	return r, nil
+}
+
+// newTestManager returns a Manager with a member role ranked below the
+// returned administrator, and a staff role ranked above it.
+func newTestManager() (*invite.Manager, *memoryStore, *user.User) {
+	roles := memoryRoles{
+		1: {ID: 1, Name: "Staff", SortID: 0, Permissions: role.Permissions{role.All}},
+		2: {ID: 2, Name: "Admin", SortID: 1, Permissions: role.Permissions{"invites.*", "roles.assign"}},
+		3: {ID: 3, Name: "Member", SortID: 2},
+	}
+	s := newMemoryStore(roles)
+	admin := &user.User{ID: 1, Email: "admin@example.com", Role: roles[2]}
+	return invite.NewManager(s, roles), s, admin
+}
+
+func TestInvite_Valid(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	for i, tc := range []struct {
+		Invite invite.Invite
+		Email  string
+		Expect bool
+	}{
+		{Invite: invite.Invite{MaxUses: 1}, Email: "user@example.com", Expect: true},
+		{Invite: invite.Invite{MaxUses: 1, Uses: 1}, Email: "user@example.com"},
+		{Invite: invite.Invite{MaxUses: 5, Uses: 4, ExpiresAt: &future}, Email: "user@example.com", Expect: true},
+		{Invite: invite.Invite{MaxUses: 5, ExpiresAt: &past}, Email: "user@example.com"},
+		{Invite: invite.Invite{MaxUses: 1, ExpiresAt: &now}, Email: "user@example.com"},
+		{Invite: invite.Invite{MaxUses: 1, Email: "user@example.com"}, Email: "User@Example.com", Expect: true},
+		{Invite: invite.Invite{MaxUses: 1, Email: "user@example.com"}, Email: "other@example.com"},
+	} {
+		if v := tc.Invite.Valid(tc.Email, now); v != tc.Expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, v)
+		}
+	}
+}
+
+func TestManager_Create(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	for i, tc := range []struct {
+		Actor     *user.User
+		Email     string
+		RoleID    snowflake.Snowflake
+		Uses      int
+		ExpiresAt *time.Time
+		Expect    error
+	}{
+		{Uses: 1},
+		{Uses: 10, RoleID: 3},
+		{Email: "user@example.com", Uses: 1, RoleID: 3},
+		{Uses: 0, Expect: invite.ErrInvalidUses},
+		{Uses: 1001, Expect: invite.ErrInvalidUses},
+		{Email: "user@example.com", Uses: 2, Expect: invite.ErrInvalidUses},
+		{Uses: 1, ExpiresAt: &past, Expect: invite.ErrInvalidExpiry},
+		{Uses: 1, RoleID: 1, Expect: role.ErrInsufficientRank},
+		{Uses: 1, RoleID: 2, Expect: role.ErrInsufficientRank},
+		{Uses: 1, RoleID: 4, Expect: role.ErrNotFound},
+		// Assigning a role requires the roles.assign permission.
+		{Actor: &user.User{ID: 2, Role: &role.Role{Permissions: role.Permissions{"invites.create"}}}, Uses: 1, RoleID: 3, Expect: role.ErrForbidden},
+	} {
+		m, s, admin := newTestManager()
+		if tc.Actor == nil {
+			tc.Actor = admin
+		}
+		inv, code, err := m.Create(context.Background(), tc.Actor, tc.Email, tc.RoleID, tc.Uses, tc.ExpiresAt)
+		if !errors.Is(err, tc.Expect) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if code == "" || inv.Hash == code || s.invites[inv.ID] == nil {
+			t.Errorf("Test #%d: Expected the invite to be stored with its code hashed", i)
+		}
+		if inv.CreatedBy != tc.Actor.ID {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Actor.ID, inv.CreatedBy)
+		}
+	}
+}
+
+func TestManager_Create_StoredRoles(t *testing.T) {
+	ctx := context.Background()
+	db := usertest.New()
+	staff := &role.Role{ID: snowflake.New(), Name: "Staff", SortID: 0, Permissions: role.Permissions{role.All}}
+	admin := &role.Role{ID: snowflake.New(), Name: "Admin", SortID: 1, Permissions: role.Permissions{"invites.*", "roles.assign"}}
+	member := &role.Role{ID: snowflake.New(), Name: "Member", SortID: 2}
+	for _, r := range []*role.Role{staff, admin, member} {
+		if err := db.Roles().Create(ctx, r); err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+	}
+	u, err := user.New("admin@example.com", nil)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	u.Role = admin
+	if err := db.Users().Create(ctx, u); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	actor, err := db.Users().ByID(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	m := invite.NewManager(newMemoryStore(nil), db.Roles())
+	for i, tc := range []struct {
+		RoleID snowflake.Snowflake
+		Expect error
+	}{
+		{RoleID: staff.ID, Expect: role.ErrInsufficientRank},
+		{RoleID: admin.ID, Expect: role.ErrInsufficientRank},
+		{RoleID: member.ID},
+	} {
+		if _, _, err := m.Create(ctx, actor, "", tc.RoleID, 1, nil); !errors.Is(err, tc.Expect) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+		}
+	}
+}
+
+func TestManager_Redeem(t *testing.T) {
+	m, s, admin := newTestManager()
+	_, single, err := m.Create(context.Background(), admin, "", 3, 1, nil)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	_, bound, err := m.Create(context.Background(), admin, "bound@example.com", snowflake.Nil, 1, nil)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	_, multi, err := m.Create(context.Background(), admin, "", snowflake.Nil, 2, nil)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	for i, tc := range []struct {
+		Code   string
+		Email  string
+		Expect error
+	}{
+		{Code: single, Email: "a@example.com"},
+		{Code: single, Email: "b@example.com", Expect: invite.ErrInvalid},
+		{Code: bound, Email: "c@example.com", Expect: invite.ErrInvalid},
+		{Code: bound, Email: "bound@example.com"},
+		{Code: multi, Email: "d@example.com"},
+		// A taken email address does not use the invite.
+		{Code: multi, Email: "d@example.com", Expect: user.ErrEmailTaken},
+		{Code: multi, Email: "e@example.com"},
+		{Code: multi, Email: "f@example.com", Expect: invite.ErrInvalid},
+		{Code: "", Email: "g@example.com", Expect: invite.ErrInvalid},
+		{Code: "unknown", Email: "g@example.com", Expect: invite.ErrInvalid},
+	} {
+		u := &user.User{ID: snowflake.Snowflake(i + 10), Email: tc.Email}
+		inv, err := m.Redeem(context.Background(), tc.Code, u)
+		if !errors.Is(err, tc.Expect) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if u.InvitedBy != admin.ID || inv.CreatedBy != admin.ID {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, admin.ID, u.InvitedBy)
+		}
+		if inv.RoleID.Valid() != (u.Role != nil) {
+			t.Errorf("Test #%d: Expected the invite's role to be assigned, but got \"%v\"", i, u.Role)
+		}
+	}
+	if u := s.users["a@example.com"]; u == nil || u.Role == nil || u.Role.ID != 3 {
+		t.Errorf("Expected the member role to be assigned")
+	}
+}
@@ -0,0 +1,170 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package invite
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// ErrNotFound is returned when an Invite does not exist.
+var ErrNotFound = errors.New("invite: not found")
+
+// Store persists Invites.
+type Store interface {
+	// Create stores a new Invite.
+	Create(ctx context.Context, i *Invite) error
+
+	// All returns every Invite, newest first.
+	All(ctx context.Context) ([]*Invite, error)
+
+	// Redeem redeems the Invite with the given hash at now, creating the
+	// user u in the same transaction, see Manager.Redeem.  Returns
+	// ErrInvalid if the Invite may not be redeemed by u.
+	Redeem(ctx context.Context, hash string, now time.Time, u *user.User) (*Invite, error)
+
+	// Delete deletes an Invite.
+	Delete(ctx context.Context, id snowflake.Snowflake) error
+}
+
+// selectInvites selects the columns expected by scan.
+const selectInvites = "SELECT id, hash, email, role_id, created_by, max_uses, uses, created_at, expires_at FROM invites"
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db db.Querier
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "invites" table.
+func NewStore(q db.Querier) Store {
+	return &store{db: q}
+}
+
+func (s *store) Create(ctx context.Context, i *Invite) error {
+	_, err := s.db.Exec(
+		ctx,
+		"INSERT INTO invites (id, hash, email, role_id, created_by, max_uses, uses, created_at, expires_at) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		i.ID, i.Hash, nullString(i.Email), i.RoleID, i.CreatedBy, i.MaxUses, i.Uses, i.CreatedAt, i.ExpiresAt,
+	)
+	return err
+}
+
+func (s *store) All(ctx context.Context) ([]*Invite, error) {
+	rows, err := s.db.Query(ctx, selectInvites+" ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []*Invite
+	for rows.Next() {
+		i, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, i)
+	}
+	return invites, rows.Err()
+}
+
+// Redeem locks the invite's row so concurrent registrations can not redeem
+// it more than MaxUses times.
+func (s *store) Redeem(ctx context.Context, hash string, now time.Time, u *user.User) (*Invite, error) {
+	var i *Invite
+	err := s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		var err error
+		i, err = scan(tx.QueryRow(ctx, selectInvites+" WHERE hash = $1 FOR UPDATE", hash))
+		if err != nil {
+			return err
+		}
+		if !i.Valid(u.Email, now) {
+			return ErrInvalid
+		}
+		if i.RoleID.Valid() {
+			r, err := role.NewStore(tx).ByID(ctx, i.RoleID)
+			if err != nil {
+				return err
+			}
+			u.Role = r
+		}
+		u.InvitedBy = i.CreatedBy
+		if err := user.NewStore(tx).Create(ctx, u); err != nil {
+			return err
+		}
+		i.Uses++
+		_, err = tx.Exec(ctx, "UPDATE invites SET uses = uses + 1 WHERE id = $1", i.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (s *store) Delete(ctx context.Context, id snowflake.Snowflake) error {
+	tag, err := s.db.Exec(ctx, "DELETE FROM invites WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// scan scans a row selected by selectInvites into an Invite.
+func scan(row pgx.Row) (*Invite, error) {
+	i := &Invite{}
+	var email *string
+	if err := row.Scan(
+		&i.ID, &i.Hash, &email, &i.RoleID, &i.CreatedBy, &i.MaxUses, &i.Uses, &i.CreatedAt, &i.ExpiresAt,
+	); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if email != nil {
+		i.Email = *email
+	}
+	return i, nil
+}
+
+// nullString returns nil for an empty string, so it is stored as NULL.
+func nullString(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
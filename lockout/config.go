@@ -0,0 +1,142 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package lockout
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/config/lexer"
+)
+
+// Config represents the configuration for account lockout.
+type Config struct {
+	// Threshold is the number of consecutive failed logins after which an
+	// account is locked.
+	Threshold int `json:"threshold"`
+
+	// FreeAttempts is the number of failed logins to an account before
+	// further attempts are delayed.
+	FreeAttempts int `json:"free_attempts"`
+
+	// IPFreeAttempts is the number of failed logins from an IP address
+	// before further attempts are delayed.  It is higher than FreeAttempts
+	// as many users may share an IP address.
+	IPFreeAttempts int `json:"ip_free_attempts"`
+
+	// Delay is the initial delay after the free attempts are used.
+	Delay time.Duration `json:"delay"`
+
+	// MaxDelay is the maximum delay between attempts.
+	MaxDelay time.Duration `json:"max_delay"`
+
+	// Factor is the factor the delay grows by after each failure.
+	Factor float64 `json:"factor"`
+
+	// ResetAfter is how long after the last failure the failures are
+	// forgotten.
+	ResetAfter time.Duration `json:"reset_after"`
+}
+
+// DefaultConfig returns the default account lockout configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		Threshold:      10,
+		FreeAttempts:   3,
+		IPFreeAttempts: 20,
+		Delay:          time.Second,
+		MaxDelay:       15 * time.Minute,
+		Factor:         2,
+		ResetAfter:     24 * time.Hour,
+	}
+}
+
+// FromLexer .
+func FromLexer(b lexer.Block) (*Config, error) {
+	c := DefaultConfig()
+	for _, s := range b.Segments {
+		d := s.Directive()
+		switch d {
+		case "threshold", "free_attempts", "ip_free_attempts":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after %s directive", d)
+			}
+			v, err := strconv.Atoi(s[1].Text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", d, err)
+			}
+			if v < 1 {
+				return nil, fmt.Errorf("%s must be positive", d)
+			}
+			switch d {
+			case "threshold":
+				c.Threshold = v
+			case "free_attempts":
+				c.FreeAttempts = v
+			case "ip_free_attempts":
+				c.IPFreeAttempts = v
+			}
+		case "delay", "max_delay", "reset_after":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after %s directive", d)
+			}
+			v, err := time.ParseDuration(s[1].Text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", d, err)
+			}
+			if v <= 0 {
+				return nil, fmt.Errorf("%s must be positive", d)
+			}
+			switch d {
+			case "delay":
+				c.Delay = v
+			case "max_delay":
+				c.MaxDelay = v
+			case "reset_after":
+				c.ResetAfter = v
+			}
+		case "factor":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after factor directive")
+			}
+			v, err := strconv.ParseFloat(s[1].Text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid factor: %w", err)
+			}
+			if v < 1 {
+				return nil, fmt.Errorf("factor must be at least 1")
+			}
+			c.Factor = v
+		default:
+			return nil, fmt.Errorf("unknown directive: \"" + d + "\"")
+		}
+	}
+	if c.FreeAttempts >= c.Threshold {
+		return nil, fmt.Errorf("free_attempts must be less than threshold")
+	}
+	if c.MaxDelay < c.Delay {
+		return nil, fmt.Errorf("max_delay must not be less than delay")
+	}
+	return c, nil
+}
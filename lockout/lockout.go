@@ -0,0 +1,203 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package lockout protects accounts from brute-force attacks by delaying and
+// eventually locking accounts after repeated failed logins.
+package lockout
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/backoff"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+)
+
+// PermissionUnlock is the permission required to unlock another user's
+// account.
+const PermissionUnlock role.Permission = "users.unlock"
+
+const (
+	// EventLocked is the topic of the outbox event enqueued when an account
+	// is locked.
+	EventLocked = "user.locked"
+
+	// EventUnlocked is the topic of the outbox event enqueued when an
+	// account is unlocked.
+	EventUnlocked = "user.unlocked"
+)
+
+// ErrNotLockedOut is returned when unlocking an account that was not locked
+// by failed logins.
+var ErrNotLockedOut = errors.New("lockout: account is not locked out")
+
+// Event is the payload of EventLocked and EventUnlocked events, recording
+// who locked or unlocked an account and why.
+type Event struct {
+	// UserID is the ID of the user whose account was locked or unlocked.
+	UserID snowflake.Snowflake `json:"user_id"`
+
+	// ActorID is the ID of the user who unlocked the account, it is
+	// snowflake.Nil when an account is locked automatically.
+	ActorID snowflake.Snowflake `json:"actor_id,omitempty"`
+
+	// IP is the IP address of the request that caused the event.
+	IP string `json:"ip,omitempty"`
+
+	// Failures is the number of consecutive failed logins when the account
+	// was locked.
+	Failures int `json:"failures,omitempty"`
+
+	// At is a timestamp of when the event occurred.
+	At time.Time `json:"at"`
+}
+
+// Manager tracks failed logins per account and per IP address.
+//
+// After a number of free attempts each failure delays the next attempt
+// exponentially, once an account reaches the configured threshold it is
+// locked until it is unlocked by an administrator or by its owner.
+type Manager struct {
+	store   Store
+	config  *Config
+	backoff *backoff.Backoff
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+}
+
+// NewManager returns a new Manager, if c is nil DefaultConfig is used.
+func NewManager(s Store, c *Config) *Manager {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	return &Manager{
+		store:   s,
+		config:  c,
+		backoff: backoff.New(0, c.Factor, c.Delay, c.MaxDelay),
+		Now:     time.Now,
+	}
+}
+
+// Check returns how long the client must wait before attempting to login to
+// the account with the email address from the IP address, zero if an
+// attempt is allowed now.
+func (m *Manager) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	account, err := m.wait(ctx, AccountKey(email), m.config.FreeAttempts)
+	if err != nil {
+		return 0, err
+	}
+	address, err := m.wait(ctx, ipKey(ip), m.config.IPFreeAttempts)
+	if err != nil {
+		return 0, err
+	}
+	if address > account {
+		return address, nil
+	}
+	return account, nil
+}
+
+// Fail records a failed login to the account with the email address from the
+// IP address, returning true if the failure caused the account to be locked.
+// The account does not have to exist, so failed logins take the same path
+// whether or not it does.
+func (m *Manager) Fail(ctx context.Context, email, ip string) (bool, error) {
+	now := m.Now()
+	since := now.Add(-m.config.ResetAfter)
+	if _, err := m.store.Fail(ctx, ipKey(ip), now, since); err != nil {
+		return false, err
+	}
+	a, err := m.store.Fail(ctx, AccountKey(email), now, since)
+	if err != nil {
+		return false, err
+	}
+	if a.Failures < m.config.Threshold {
+		return false, nil
+	}
+	return m.store.Lock(ctx, email, &Event{
+		IP:       ip,
+		Failures: a.Failures,
+		At:       now,
+	})
+}
+
+// Succeed resets the failed logins of the account with the email address.
+func (m *Manager) Succeed(ctx context.Context, email string) error {
+	return m.store.Reset(ctx, AccountKey(email))
+}
+
+// LockedOut returns true if the account with the email address was locked
+// because of failed logins, rather than by an administrator.
+func (m *Manager) LockedOut(ctx context.Context, email string) (bool, error) {
+	a, err := m.store.Get(ctx, AccountKey(email))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return a.Failures >= m.config.Threshold, nil
+}
+
+// Unlock unlocks a user's account and resets its failed logins, actorID is
+// the user performing the unlock.
+func (m *Manager) Unlock(ctx context.Context, userID snowflake.Snowflake, email string, actorID snowflake.Snowflake, ip string) error {
+	return m.store.Unlock(ctx, AccountKey(email), &Event{
+		UserID:  userID,
+		ActorID: actorID,
+		IP:      ip,
+		At:      m.Now(),
+	})
+}
+
+// wait returns how long until the next attempt is allowed for a key.
+func (m *Manager) wait(ctx context.Context, key string, free int) (time.Duration, error) {
+	a, err := m.store.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	now := m.Now()
+	if a.Failures <= free || !a.LastFailedAt.After(now.Add(-m.config.ResetAfter)) {
+		return 0, nil
+	}
+	attempt := math.Min(float64(a.Failures-free), 64)
+	if wait := a.LastFailedAt.Add(m.backoff.Duration(attempt)).Sub(now); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// AccountKey returns the key failed logins to an account are tracked by.
+func AccountKey(email string) string {
+	return "account:" + email
+}
+
+// ipKey returns the key failed logins from an IP address are tracked by.
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
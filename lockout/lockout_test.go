@@ -0,0 +1,201 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package lockout_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/lockout"
+)
+
+// memoryStore is an in-memory lockout.Store used for testing.
+type memoryStore struct {
+	failures map[string]lockout.Failures
+	accounts map[string]snowflake.Snowflake
+	locked   map[snowflake.Snowflake]bool
+	events   []string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		failures: make(map[string]lockout.Failures),
+		accounts: map[string]snowflake.Snowflake{"user@example.com": 1},
+		locked:   make(map[snowflake.Snowflake]bool),
+	}
+}
+
+func (s *memoryStore) Get(_ context.Context, key string) (*lockout.Failures, error) {
+	f, ok := s.failures[key]
+	if !ok {
+		return nil, lockout.ErrNotFound
+	}
+	return &f, nil
+}
+
+func (s *memoryStore) Fail(_ context.Context, key string, t, since time.Time) (*lockout.Failures, error) {
+	f := s.failures[key]
+	if f.LastFailedAt.Before(since) {
+		f.Failures = 0
+	}
+	f.Key = key
+	f.Failures++
+	f.LastFailedAt = t
+	s.failures[key] = f
+	return &f, nil
+}
+
+func (s *memoryStore) Reset(_ context.Context, key string) error {
+	delete(s.failures, key)
+	return nil
+}
+
+func (s *memoryStore) Lock(_ context.Context, email string, e *lockout.Event) (bool, error) {
+	id, ok := s.accounts[email]
+	if !ok || s.locked[id] {
+		return false, nil
+	}
+	s.locked[id] = true
+	s.events = append(s.events, lockout.EventLocked)
+	return true, nil
+}
+
+func (s *memoryStore) Unlock(_ context.Context, key string, e *lockout.Event) error {
+	delete(s.locked, e.UserID)
+	delete(s.failures, key)
+	s.events = append(s.events, lockout.EventUnlocked)
+	return nil
+}
+
+func newTestManager() (*lockout.Manager, *memoryStore, *time.Time) {
+	s := newMemoryStore()
+	m := lockout.NewManager(s, &lockout.Config{
+		Threshold:      5,
+		FreeAttempts:   2,
+		IPFreeAttempts: 3,
+		Delay:          time.Second,
+		MaxDelay:       time.Minute,
+		Factor:         2,
+		ResetAfter:     time.Hour,
+	})
+	now := time.Now()
+	m.Now = func() time.Time { return now }
+	return m, s, &now
+}
+
+func TestManager_Check(t *testing.T) {
+	m, _, now := newTestManager()
+	ctx := context.Background()
+
+	for i, expect := range []time.Duration{0, 0, 0, 2 * time.Second, 4 * time.Second} {
+		wait, err := m.Check(ctx, "user@example.com", "192.0.2.1")
+		if err != nil {
+			t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+		if wait != expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, expect, wait)
+		}
+		*now = now.Add(wait)
+		if _, err := m.Fail(ctx, "user@example.com", "192.0.2.1"); err != nil {
+			t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+	}
+
+	// The IP address is delayed for other accounts once its free attempts
+	// are used.
+	if wait, _ := m.Check(ctx, "other@example.com", "192.0.2.1"); wait != 4*time.Second {
+		t.Errorf("Expected \"%v\", but got \"%v\"", 4*time.Second, wait)
+	}
+	if wait, _ := m.Check(ctx, "other@example.com", "192.0.2.2"); wait != 0 {
+		t.Errorf("Expected \"%v\", but got \"%v\"", time.Duration(0), wait)
+	}
+
+	// Failures are forgotten after a period without any.
+	*now = now.Add(time.Hour)
+	if wait, _ := m.Check(ctx, "user@example.com", "192.0.2.1"); wait != 0 {
+		t.Errorf("Expected \"%v\", but got \"%v\"", time.Duration(0), wait)
+	}
+}
+
+func TestManager_Fail(t *testing.T) {
+	m, s, _ := newTestManager()
+	ctx := context.Background()
+
+	for i := 1; i <= 6; i++ {
+		locked, err := m.Fail(ctx, "user@example.com", "192.0.2.1")
+		if err != nil {
+			t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+		// The account is only locked by the failure reaching the threshold.
+		if expect := i == 5; locked != expect {
+			t.Errorf("Test #%d: Expected \"%t\", but got \"%t\"", i, expect, locked)
+		}
+	}
+	if !s.locked[1] {
+		t.Errorf("Expected the account to be locked")
+	}
+	if lockedOut, _ := m.LockedOut(ctx, "user@example.com"); !lockedOut {
+		t.Errorf("Expected the account to be locked out")
+	}
+
+	// Accounts that do not exist are tracked but never locked.
+	for i := 0; i < 5; i++ {
+		if locked, _ := m.Fail(ctx, "unknown@example.com", "192.0.2.1"); locked {
+			t.Errorf("Test #%d: Expected a non-existent account not to be locked", i)
+		}
+	}
+
+	if err := m.Unlock(ctx, 1, "user@example.com", 2, "192.0.2.2"); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if s.locked[1] {
+		t.Errorf("Expected the account to be unlocked")
+	}
+	if lockedOut, _ := m.LockedOut(ctx, "user@example.com"); lockedOut {
+		t.Errorf("Expected the account's failures to be reset")
+	}
+	if len(s.events) != 2 || s.events[0] != lockout.EventLocked || s.events[1] != lockout.EventUnlocked {
+		t.Errorf("Expected a locked and an unlocked event, but got \"%v\"", s.events)
+	}
+}
+
+func TestManager_Succeed(t *testing.T) {
+	m, _, _ := newTestManager()
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		_, _ = m.Fail(ctx, "user@example.com", "192.0.2.1")
+	}
+	if err := m.Succeed(ctx, "user@example.com"); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	// Only the account's failures are reset, the IP address stays delayed.
+	if wait, _ := m.Check(ctx, "user@example.com", "192.0.2.2"); wait != 0 {
+		t.Errorf("Expected \"%v\", but got \"%v\"", time.Duration(0), wait)
+	}
+	if wait, _ := m.Check(ctx, "user@example.com", "192.0.2.1"); wait == 0 {
+		t.Errorf("Expected the IP address to be delayed")
+	}
+}
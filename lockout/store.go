@@ -0,0 +1,149 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package lockout
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/outbox"
+)
+
+// ErrNotFound is returned when there are no failed logins for a key.
+var ErrNotFound = errors.New("lockout: not found")
+
+// Failures are the consecutive failed logins for an account or IP address.
+type Failures struct {
+	// Key identifies the account or IP address.
+	Key string
+
+	// Failures is the number of consecutive failed logins.
+	Failures int
+
+	// LastFailedAt is a timestamp of the most recent failed login.
+	LastFailedAt time.Time
+}
+
+// Store persists failed logins and locks accounts.
+type Store interface {
+	// Get returns the failed logins for a key.
+	Get(ctx context.Context, key string) (*Failures, error)
+
+	// Fail records a failed login for a key at t, failures before since are
+	// forgotten.
+	Fail(ctx context.Context, key string, t, since time.Time) (*Failures, error)
+
+	// Reset forgets the failed logins for a key.
+	Reset(ctx context.Context, key string) error
+
+	// Lock locks the account with the email address, enqueueing an
+	// EventLocked event in the same transaction.  Returns false if there is
+	// no such account or it is already locked.
+	Lock(ctx context.Context, email string, e *Event) (bool, error)
+
+	// Unlock unlocks the account of e.UserID and forgets the failed logins
+	// for key, enqueueing an EventUnlocked event in the same transaction.
+	Unlock(ctx context.Context, key string, e *Event) error
+}
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db db.Querier
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "login_failures" table.
+func NewStore(q db.Querier) Store {
+	return &store{db: q}
+}
+
+func (s *store) Get(ctx context.Context, key string) (*Failures, error) {
+	return scan(s.db.QueryRow(ctx, "SELECT key, failures, last_failed_at FROM login_failures WHERE key = $1", key))
+}
+
+func (s *store) Fail(ctx context.Context, key string, t, since time.Time) (*Failures, error) {
+	return scan(s.db.QueryRow(
+		ctx,
+		"INSERT INTO login_failures (key, failures, last_failed_at) VALUES ($1, 1, $2) "+
+			"ON CONFLICT (key) DO UPDATE SET "+
+			"failures = CASE WHEN login_failures.last_failed_at < $3 THEN 1 ELSE login_failures.failures + 1 END, "+
+			"last_failed_at = $2 "+
+			"RETURNING key, failures, last_failed_at",
+		key, t, since,
+	))
+}
+
+func (s *store) Reset(ctx context.Context, key string) error {
+	_, err := s.db.Exec(ctx, "DELETE FROM login_failures WHERE key = $1", key)
+	return err
+}
+
+func (s *store) Lock(ctx context.Context, email string, e *Event) (bool, error) {
+	var locked bool
+	err := s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(
+			ctx,
+			"UPDATE users SET locked = true, updated_at = now() WHERE email = $1 AND NOT locked RETURNING id",
+			email,
+		).Scan(&e.UserID); err != nil {
+			if errors.Is(err, db.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+		locked = true
+		_, err := outbox.Enqueue(ctx, tx, EventLocked, e)
+		return err
+	})
+	return locked, err
+}
+
+func (s *store) Unlock(ctx context.Context, key string, e *Event) error {
+	return s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "UPDATE users SET locked = false, updated_at = now() WHERE id = $1", e.UserID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM login_failures WHERE key = $1", key); err != nil {
+			return err
+		}
+		_, err := outbox.Enqueue(ctx, tx, EventUnlocked, e)
+		return err
+	})
+}
+
+// scan scans a row from the "login_failures" table.
+func scan(row pgx.Row) (*Failures, error) {
+	f := &Failures{}
+	if err := row.Scan(&f.Key, &f.Failures, &f.LastFailedAt); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
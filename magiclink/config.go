@@ -0,0 +1,106 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package magiclink
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/config/lexer"
+)
+
+// Config represents the configuration for magic link logins.
+type Config struct {
+	// TTL is how long a magic link is valid for.
+	TTL time.Duration `json:"ttl"`
+
+	// Window is the period requests for magic links are throttled over.
+	Window time.Duration `json:"window"`
+
+	// EmailLimit is the number of magic links that may be requested for an
+	// email address within Window.
+	EmailLimit int `json:"email_limit"`
+
+	// IPLimit is the number of magic links that may be requested from an IP
+	// address within Window.  It is higher than EmailLimit as many users may
+	// share an IP address.
+	IPLimit int `json:"ip_limit"`
+}
+
+// DefaultConfig returns the default magic link configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		TTL:        15 * time.Minute,
+		Window:     time.Hour,
+		EmailLimit: 3,
+		IPLimit:    20,
+	}
+}
+
+// FromLexer .
+func FromLexer(b lexer.Block) (*Config, error) {
+	c := DefaultConfig()
+	for _, s := range b.Segments {
+		d := s.Directive()
+		switch d {
+		case "ttl", "window":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after %s directive", d)
+			}
+			v, err := time.ParseDuration(s[1].Text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", d, err)
+			}
+			if v <= 0 {
+				return nil, fmt.Errorf("%s must be positive", d)
+			}
+			switch d {
+			case "ttl":
+				c.TTL = v
+			case "window":
+				c.Window = v
+			}
+		case "email_limit", "ip_limit":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after %s directive", d)
+			}
+			v, err := strconv.Atoi(s[1].Text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", d, err)
+			}
+			if v < 1 {
+				return nil, fmt.Errorf("%s must be positive", d)
+			}
+			switch d {
+			case "email_limit":
+				c.EmailLimit = v
+			case "ip_limit":
+				c.IPLimit = v
+			}
+		default:
+			return nil, fmt.Errorf("unknown directive: \"" + d + "\"")
+		}
+	}
+	return c, nil
+}
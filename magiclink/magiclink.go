@@ -0,0 +1,209 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package magiclink lets users login by opening a link emailed to them,
+// without a password.
+//
+// A link is single-use, expires quickly and only works in the browser that
+// requested it, which holds a nonce in a cookie.  Only the SHA-256 hashes of
+// the link's token and nonce are stored.
+package magiclink
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/internal/uuid"
+	"github.com/matthewpi/cosmos/user"
+)
+
+var (
+	// ErrInvalid is returned by Manager.Redeem when a link does not exist,
+	// was requested by another browser, has already been used or has
+	// expired.
+	ErrInvalid = errors.New("magiclink: invalid or expired link")
+
+	// ErrThrottled is returned by Manager.Request when too many links have
+	// been requested for the email address or from the IP address.
+	ErrThrottled = errors.New("magiclink: too many requests")
+)
+
+// Link is a requested magic link.
+type Link struct {
+	// ID is the SHA-256 hash of the link's token.
+	ID string
+
+	// UserID is the ID of the user with the email address, it is invalid if
+	// there was no such user when the link was requested.
+	UserID snowflake.Snowflake
+
+	// Email is the email address the link was requested for.
+	Email string
+
+	// IP is the IP address the link was requested from.
+	IP string
+
+	// Nonce is the SHA-256 hash of the nonce given to the browser that
+	// requested the link.
+	Nonce string
+
+	// CreatedAt is a timestamp of when the link was requested.
+	CreatedAt time.Time
+
+	// ExpiresAt is a timestamp of when the link expires.
+	ExpiresAt time.Time
+}
+
+// Users is used by a Manager to find the user a link is for.
+type Users interface {
+	ByID(ctx context.Context, id snowflake.Snowflake) (*user.User, error)
+	ByEmail(ctx context.Context, email string) (*user.User, error)
+}
+
+// Manager issues and redeems magic links.
+type Manager struct {
+	store  Store
+	users  Users
+	config *Config
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+}
+
+// NewManager returns a new Manager, if c is nil DefaultConfig is used.
+func NewManager(s Store, users Users, c *Config) *Manager {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	return &Manager{
+		store:  s,
+		users:  users,
+		config: c,
+		Now:    time.Now,
+	}
+}
+
+// Config returns the Manager's configuration.
+func (m *Manager) Config() *Config {
+	return m.config
+}
+
+// Request requests a magic link for the email address from the IP address,
+// returning the secret to email to the user and the nonce to give to the
+// browser that requested it.
+//
+// Links are requested and throttled the same whether or not a user has the
+// email address, in which case u is nil and the link should not be sent.
+func (m *Manager) Request(ctx context.Context, email, ip string) (secret, nonce string, u *user.User, err error) {
+	now := m.Now()
+	since := now.Add(-m.config.Window)
+	if err := m.store.Prune(ctx, now, since); err != nil {
+		return "", "", nil, err
+	}
+	n, err := m.store.CountByEmail(ctx, email, since)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if n >= m.config.EmailLimit {
+		return "", "", nil, ErrThrottled
+	}
+	if n, err = m.store.CountByIP(ctx, ip, since); err != nil {
+		return "", "", nil, err
+	}
+	if n >= m.config.IPLimit {
+		return "", "", nil, ErrThrottled
+	}
+
+	u, err = m.users.ByEmail(ctx, email)
+	if err != nil && !errors.Is(err, user.ErrNotFound) {
+		return "", "", nil, err
+	}
+	userID := snowflake.Nil
+	if u != nil {
+		userID = u.ID
+	}
+	if secret, err = newSecret(); err != nil {
+		return "", "", nil, err
+	}
+	if nonce, err = newSecret(); err != nil {
+		return "", "", nil, err
+	}
+	if err := m.store.Create(ctx, &Link{
+		ID:        token.Hash(secret),
+		UserID:    userID,
+		Email:     email,
+		IP:        ip,
+		Nonce:     token.Hash(nonce),
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.config.TTL),
+	}); err != nil {
+		return "", "", nil, err
+	}
+	return secret, nonce, u, nil
+}
+
+// Redeem redeems a magic link with the nonce held by the browser that
+// requested it, returning the user the link was sent to.
+//
+// A link is only used up once the nonce matches, so opening it in another
+// browser does not prevent the user from opening it in the right one.
+func (m *Manager) Redeem(ctx context.Context, secret, nonce string) (*user.User, error) {
+	if secret == "" || nonce == "" {
+		return nil, ErrInvalid
+	}
+	now := m.Now()
+	l, err := m.store.Consume(ctx, token.Hash(secret), token.Hash(nonce), now)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalid
+		}
+		return nil, err
+	}
+	if !now.Before(l.ExpiresAt) || !l.UserID.Valid() {
+		return nil, ErrInvalid
+	}
+	u, err := m.users.ByID(ctx, l.UserID)
+	if err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return nil, ErrInvalid
+		}
+		return nil, err
+	}
+	// A link proves ownership of the email address it was sent to, which
+	// may no longer be the user's.
+	if u.Email != l.Email {
+		return nil, ErrInvalid
+	}
+	return u, nil
+}
+
+// newSecret returns a new random secret.
+func newSecret() (string, error) {
+	id, err := uuid.New()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
@@ -0,0 +1,252 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package magiclink_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/magiclink"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// memoryStore is an in-memory magiclink.Store used for testing.
+type memoryStore struct {
+	links map[string]magiclink.Link
+	used  map[string]bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		links: make(map[string]magiclink.Link),
+		used:  make(map[string]bool),
+	}
+}
+
+func (s *memoryStore) Create(_ context.Context, l *magiclink.Link) error {
+	s.links[l.ID] = *l
+	return nil
+}
+
+func (s *memoryStore) Consume(_ context.Context, id, nonce string, _ time.Time) (*magiclink.Link, error) {
+	l, ok := s.links[id]
+	if !ok || l.Nonce != nonce || s.used[id] {
+		return nil, magiclink.ErrNotFound
+	}
+	s.used[id] = true
+	return &l, nil
+}
+
+func (s *memoryStore) CountByEmail(_ context.Context, email string, t time.Time) (int, error) {
+	n := 0
+	for _, l := range s.links {
+		if l.Email == email && l.CreatedAt.After(t) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryStore) CountByIP(_ context.Context, ip string, t time.Time) (int, error) {
+	n := 0
+	for _, l := range s.links {
+		if l.IP == ip && l.CreatedAt.After(t) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryStore) Prune(_ context.Context, now, since time.Time) error {
+	for id, l := range s.links {
+		if !l.ExpiresAt.After(now) && !l.CreatedAt.After(since) {
+			delete(s.links, id)
+		}
+	}
+	return nil
+}
+
+// memoryUsers is an in-memory magiclink.Users used for testing.
+type memoryUsers map[snowflake.Snowflake]*user.User
+
+func (m memoryUsers) ByID(_ context.Context, id snowflake.Snowflake) (*user.User, error) {
+	u, ok := m[id]
+	if !ok {
+		return nil, user.ErrNotFound
+	}
+	return u, nil
+}
+
+func (m memoryUsers) ByEmail(_ context.Context, email string) (*user.User, error) {
+	for _, u := range m {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, user.ErrNotFound
+}
+
+// clock is a manually advanced clock.
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.t
+}
+
+func newTestManager(t *testing.T) (*magiclink.Manager, *user.User, *clock) {
+	u, err := user.New("user@example.com", nil)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	c := &clock{t: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := magiclink.NewManager(newMemoryStore(), memoryUsers{u.ID: u}, &magiclink.Config{
+		TTL:        15 * time.Minute,
+		Window:     time.Hour,
+		EmailLimit: 2,
+		IPLimit:    3,
+	})
+	m.Now = c.Now
+	return m, u, c
+}
+
+func TestManager_Request(t *testing.T) {
+	for i, tc := range []struct {
+		Requests [][2]string
+		Expect   error
+	}{
+		{Requests: [][2]string{{"user@example.com", "192.0.2.1"}}},
+		{
+			Requests: [][2]string{
+				{"user@example.com", "192.0.2.1"},
+				{"user@example.com", "192.0.2.2"},
+				{"user@example.com", "192.0.2.3"},
+			},
+			Expect: magiclink.ErrThrottled,
+		},
+		// Email addresses without an account are throttled the same.
+		{
+			Requests: [][2]string{
+				{"other@example.com", "192.0.2.1"},
+				{"other@example.com", "192.0.2.2"},
+				{"other@example.com", "192.0.2.3"},
+			},
+			Expect: magiclink.ErrThrottled,
+		},
+		{
+			Requests: [][2]string{
+				{"a@example.com", "192.0.2.1"},
+				{"b@example.com", "192.0.2.1"},
+				{"c@example.com", "192.0.2.1"},
+				{"d@example.com", "192.0.2.1"},
+			},
+			Expect: magiclink.ErrThrottled,
+		},
+	} {
+		m, _, _ := newTestManager(t)
+		var err error
+		for _, req := range tc.Requests {
+			if _, _, _, err = m.Request(context.Background(), req[0], req[1]); err != nil {
+				break
+			}
+		}
+		if err != tc.Expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+		}
+	}
+
+	// Requests are forgotten once the window has passed.
+	m, u, c := newTestManager(t)
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := m.Request(context.Background(), u.Email, "192.0.2.1"); err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+	}
+	c.t = c.t.Add(time.Hour)
+	_, _, got, err := m.Request(context.Background(), u.Email, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if got == nil || got.ID != u.ID {
+		t.Errorf("Expected the user with the email address to be returned")
+	}
+	if _, _, got, _ := m.Request(context.Background(), "other@example.com", "192.0.2.1"); got != nil {
+		t.Errorf("Expected no user to be returned for an unknown email address")
+	}
+}
+
+func TestManager_Redeem(t *testing.T) {
+	m, u, _ := newTestManager(t)
+	secret, nonce, _, err := m.Request(context.Background(), u.Email, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	// Another browser can not use the link, but does not use it up either.
+	if _, err := m.Redeem(context.Background(), secret, "other"); err != magiclink.ErrInvalid {
+		t.Errorf("Expected \"%v\", but got \"%v\"", magiclink.ErrInvalid, err)
+	}
+	if _, err := m.Redeem(context.Background(), secret, ""); err != magiclink.ErrInvalid {
+		t.Errorf("Expected \"%v\", but got \"%v\"", magiclink.ErrInvalid, err)
+	}
+	got, err := m.Redeem(context.Background(), secret, nonce)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if got.ID != u.ID {
+		t.Errorf("Expected \"%s\", but got \"%s\"", u.ID, got.ID)
+	}
+	if _, err := m.Redeem(context.Background(), secret, nonce); err != magiclink.ErrInvalid {
+		t.Errorf("Expected a link to only be usable once, but got \"%v\"", err)
+	}
+
+	for i, tc := range []struct {
+		Setup func(u *user.User, c *clock)
+	}{
+		// Links expire.
+		{Setup: func(_ *user.User, c *clock) { c.t = c.t.Add(15 * time.Minute) }},
+		// Links are bound to the email address they were sent to.
+		{Setup: func(u *user.User, _ *clock) { u.Email = "changed@example.com" }},
+	} {
+		m, u, c := newTestManager(t)
+		secret, nonce, _, err := m.Request(context.Background(), u.Email, "192.0.2.1")
+		if err != nil {
+			t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+		tc.Setup(u, c)
+		if _, err := m.Redeem(context.Background(), secret, nonce); err != magiclink.ErrInvalid {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, magiclink.ErrInvalid, err)
+		}
+	}
+
+	// Links requested for email addresses without an account never work.
+	secret, nonce, _, err = m.Request(context.Background(), "other@example.com", "192.0.2.3")
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if _, err := m.Redeem(context.Background(), secret, nonce); err != magiclink.ErrInvalid {
+		t.Errorf("Expected \"%v\", but got \"%v\"", magiclink.ErrInvalid, err)
+	}
+}
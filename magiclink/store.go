@@ -0,0 +1,110 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package magiclink
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/db"
+)
+
+// ErrNotFound is returned when a Link does not exist.
+var ErrNotFound = errors.New("magiclink: not found")
+
+// Store persists Links.
+type Store interface {
+	// Create stores a new Link.
+	Create(ctx context.Context, l *Link) error
+
+	// Consume marks the unused Link with the ID and nonce as used at t,
+	// returning it.
+	Consume(ctx context.Context, id, nonce string, t time.Time) (*Link, error)
+
+	// CountByEmail returns the number of Links requested for an email
+	// address since t.
+	CountByEmail(ctx context.Context, email string, t time.Time) (int, error)
+
+	// CountByIP returns the number of Links requested from an IP address
+	// since t.
+	CountByIP(ctx context.Context, ip string, t time.Time) (int, error)
+
+	// Prune deletes Links that expired before now and were requested
+	// before since, so they are no longer needed for throttling.
+	Prune(ctx context.Context, now, since time.Time) error
+}
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db db.Querier
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "magic_links" table.
+func NewStore(q db.Querier) Store {
+	return &store{db: q}
+}
+
+func (s *store) Create(ctx context.Context, l *Link) error {
+	_, err := s.db.Exec(
+		ctx,
+		"INSERT INTO magic_links (id, user_id, email, ip, nonce, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		l.ID, l.UserID, l.Email, l.IP, l.Nonce, l.CreatedAt, l.ExpiresAt,
+	)
+	return err
+}
+
+func (s *store) Consume(ctx context.Context, id, nonce string, t time.Time) (*Link, error) {
+	l := &Link{}
+	if err := s.db.QueryRow(
+		ctx,
+		"UPDATE magic_links SET used_at = $3 WHERE id = $1 AND nonce = $2 AND used_at IS NULL "+
+			"RETURNING id, user_id, email, ip, nonce, created_at, expires_at",
+		id, nonce, t,
+	).Scan(&l.ID, &l.UserID, &l.Email, &l.IP, &l.Nonce, &l.CreatedAt, &l.ExpiresAt); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+func (s *store) CountByEmail(ctx context.Context, email string, t time.Time) (int, error) {
+	var n int
+	err := s.db.QueryRow(ctx, "SELECT count(*) FROM magic_links WHERE email = $1 AND created_at > $2", email, t).Scan(&n)
+	return n, err
+}
+
+func (s *store) CountByIP(ctx context.Context, ip string, t time.Time) (int, error) {
+	var n int
+	err := s.db.QueryRow(ctx, "SELECT count(*) FROM magic_links WHERE ip = $1 AND created_at > $2", ip, t).Scan(&n)
+	return n, err
+}
+
+func (s *store) Prune(ctx context.Context, now, since time.Time) error {
+	_, err := s.db.Exec(ctx, "DELETE FROM magic_links WHERE expires_at <= $1 AND created_at <= $2", now, since)
+	return err
+}
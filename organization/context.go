@@ -0,0 +1,75 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package organization
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// ErrNoOrganization is returned by Scope, and so by every scoped Store
+// method, when the context has no active organization.
+var ErrNoOrganization = errors.New("organization: no active organization")
+
+// contextKey is the context key of the active organization.
+type contextKey struct{}
+
+// tenant is the active organization and the requesting user's membership.
+type tenant struct {
+	organization *Organization
+	membership   *Membership
+}
+
+// NewContext returns a copy of ctx with o as the active organization and m
+// as the requesting user's membership of it.
+func NewContext(ctx context.Context, o *Organization, m *Membership) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenant{organization: o, membership: m})
+}
+
+// FromContext returns the active organization and the requesting user's
+// membership of it, if any.
+func FromContext(ctx context.Context) (*Organization, *Membership, bool) {
+	t, ok := ctx.Value(contextKey{}).(tenant)
+	if !ok || t.organization == nil || t.membership == nil {
+		return nil, nil, false
+	}
+	return t.organization, t.membership, true
+}
+
+// Scope returns a condition restricting column to the ID of the active
+// organization and args with the ID appended, to be used in the WHERE
+// clause of a query with the existing args.  Scope fails closed, returning
+// ErrNoOrganization if ctx has no active organization rather than an
+// unfiltered condition.
+//
+//	cond, args, err := organization.Scope(ctx, "organization_id", []interface{}{id})
+//	// "organization_id = $2", []interface{}{id, organizationID}
+func Scope(ctx context.Context, column string, args []interface{}) (string, []interface{}, error) {
+	o, _, ok := FromContext(ctx)
+	if !ok || !o.ID.Valid() {
+		return "", nil, ErrNoOrganization
+	}
+	args = append(args, o.ID)
+	return column + " = $" + strconv.Itoa(len(args)), args, nil
+}
@@ -0,0 +1,577 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package organization provides organizations, which group users into
+// tenants.
+//
+// Users are members of an organization with a role that only applies within
+// it, reusing the roles and permissions of package role, while the
+// organization's owner is granted every permission.  Users join an
+// organization by accepting an invitation sent to their email address.
+//
+// Requests for an organization carry it in their context, see NewContext.
+// Queries for an organization's data are filtered by the active organization
+// with Scope rather than by an ID taken from the request, so one
+// organization's data can not be read or changed through another's.
+package organization
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// Permissions granted by a member's role within an organization.
+const (
+	PermissionUpdate        role.Permission = "organization.update"
+	PermissionMembersRead   role.Permission = "organization.members.read"
+	PermissionMembersInvite role.Permission = "organization.members.invite"
+	PermissionMembersManage role.Permission = "organization.members.manage"
+)
+
+var (
+	// ErrInvalidName is returned when an organization's name is empty or
+	// too long.
+	ErrInvalidName = errors.New("organization: name must be between 1 and 100 characters")
+
+	// ErrNotMember is returned when a user is not a member of the active
+	// organization.
+	ErrNotMember = errors.New("organization: user is not a member")
+
+	// ErrAlreadyMember is returned when accepting an invitation to an
+	// organization the user is already a member of.
+	ErrAlreadyMember = errors.New("organization: user is already a member")
+
+	// ErrOwner is returned when changing the role of, or removing, the
+	// owner of an organization, ownership must be transferred first.
+	ErrOwner = errors.New("organization: the owner's membership can not be changed")
+
+	// ErrInvalidInvitation is returned when accepting an invitation that
+	// does not exist, has expired or was sent to another email address.
+	ErrInvalidInvitation = errors.New("organization: invalid or expired invitation")
+)
+
+const (
+	// maxName is the maximum length of an organization's name in
+	// characters.
+	maxName = 100
+
+	// invitationTTL is how long an invitation may be accepted for.
+	invitationTTL = 7 * 24 * time.Hour
+)
+
+// Organization is a tenant.
+type Organization struct {
+	// ID is the organization's unique identifier.
+	ID snowflake.Snowflake `json:"id"`
+
+	// Name is the organization's display name.
+	Name string `json:"name"`
+
+	// OwnerID is the ID of the user who owns the organization.
+	OwnerID snowflake.Snowflake `json:"owner_id"`
+
+	// CreatedAt is a timestamp of when the organization was created.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Membership is a user's membership of an organization.
+type Membership struct {
+	OrganizationID snowflake.Snowflake `json:"organization_id"`
+	UserID         snowflake.Snowflake `json:"user_id"`
+
+	// RoleID is the ID of the member's role within the organization, it is
+	// invalid if the member has no role.
+	RoleID snowflake.Snowflake `json:"role_id"`
+
+	// Role is the member's role within the organization, it is loaded by
+	// the Manager and may be nil.
+	Role *role.Role `json:"-"`
+
+	// Owner is true if the member owns the organization.
+	Owner bool `json:"owner"`
+
+	// CreatedAt is a timestamp of when the user joined the organization.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Can returns true if the member is granted the permission within the
+// organization.  The owner is granted every permission.
+func (m *Membership) Can(p role.Permission) bool {
+	if m == nil {
+		return false
+	}
+	return m.Owner || m.Role.Can(p)
+}
+
+// Outranks returns true if the member ranks above o within the
+// organization.  The owner outranks every other member and is outranked by
+// none, otherwise members are ranked by their roles, see role.Role.Outranks.
+func (m *Membership) Outranks(o *Membership) bool {
+	if m == nil || o.Owner {
+		return false
+	}
+	return m.Owner || m.Role.Outranks(o.Role)
+}
+
+// Invitation is an invitation to join an organization.
+type Invitation struct {
+	// ID is the invitation's unique identifier.
+	ID snowflake.Snowflake `json:"id"`
+
+	OrganizationID snowflake.Snowflake `json:"organization_id"`
+
+	// Hash is the SHA-256 hash of the invitation's code.
+	Hash string `json:"-"`
+
+	// Email is the email address of the user who may accept the invitation.
+	Email string `json:"email"`
+
+	// RoleID, if valid, is the ID of the role the invitee is given within
+	// the organization.
+	RoleID snowflake.Snowflake `json:"role_id"`
+
+	// InvitedBy is the ID of the member who sent the invitation, it is
+	// invalid if they have since been deleted.
+	InvitedBy snowflake.Snowflake `json:"invited_by"`
+
+	// CreatedAt is a timestamp of when the invitation was sent.
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt is a timestamp of when the invitation expires.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Valid returns true if a user with the email address may accept the
+// invitation at now.
+func (i *Invitation) Valid(email string, now time.Time) bool {
+	return now.Before(i.ExpiresAt) && strings.EqualFold(i.Email, email)
+}
+
+// Roles is used by a Manager to load the roles of members, it is satisfied
+// by role.Store.
+type Roles interface {
+	ByID(ctx context.Context, id snowflake.Snowflake) (*role.Role, error)
+}
+
+// Manager manages organizations, their members and invitations.
+//
+// Except for Create, List, Load and Accept, the Manager's methods act on
+// the active organization on behalf of the member in the context, see
+// NewContext, and check the member is allowed to.
+type Manager struct {
+	store Store
+	roles Roles
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+}
+
+// NewManager returns a new Manager.
+func NewManager(s Store, roles Roles) *Manager {
+	return &Manager{
+		store: s,
+		roles: roles,
+		Now:   time.Now,
+	}
+}
+
+// Create creates a new organization owned by owner.
+func (m *Manager) Create(ctx context.Context, owner *user.User, name string) (*Organization, error) {
+	name, err := validName(name)
+	if err != nil {
+		return nil, err
+	}
+	now := m.Now()
+	o := &Organization{
+		ID:        snowflake.New(),
+		Name:      name,
+		OwnerID:   owner.ID,
+		CreatedAt: now,
+	}
+	if err := m.store.Create(ctx, o, &Membership{
+		OrganizationID: o.ID,
+		UserID:         owner.ID,
+		Owner:          true,
+		CreatedAt:      now,
+	}); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// List returns the organizations the user is a member of.
+func (m *Manager) List(ctx context.Context, userID snowflake.Snowflake) ([]*Organization, error) {
+	return m.store.ByUser(ctx, userID)
+}
+
+// Owns returns true if the user owns an organization, the owner is always a
+// member.
+func (m *Manager) Owns(ctx context.Context, userID snowflake.Snowflake) (bool, error) {
+	organizations, err := m.store.ByUser(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, o := range organizations {
+		if o.OwnerID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Load loads an organization and the user's membership of it, to be made
+// the active organization with NewContext.  Returns ErrNotFound if the
+// organization does not exist or the user is not a member, so it does not
+// reveal organizations to users outside of them.
+func (m *Manager) Load(ctx context.Context, id, userID snowflake.Snowflake) (*Organization, *Membership, error) {
+	o, err := m.store.ByID(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	mb, err := m.store.Membership(ctx, id, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := m.load(ctx, o, mb); err != nil {
+		return nil, nil, err
+	}
+	return o, mb, nil
+}
+
+// Rename renames the active organization, the member must be granted
+// PermissionUpdate.
+func (m *Manager) Rename(ctx context.Context, name string) (*Organization, error) {
+	o, actor, err := active(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !actor.Can(PermissionUpdate) {
+		return nil, role.ErrForbidden
+	}
+	name, err = validName(name)
+	if err != nil {
+		return nil, err
+	}
+	v := *o
+	v.Name = name
+	if err := m.store.Update(ctx, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Delete deletes the active organization, only its owner may delete it.
+func (m *Manager) Delete(ctx context.Context) error {
+	_, actor, err := active(ctx)
+	if err != nil {
+		return err
+	}
+	if !actor.Owner {
+		return role.ErrForbidden
+	}
+	return m.store.Delete(ctx)
+}
+
+// Transfer transfers ownership of the active organization to another
+// member, only its owner may transfer it.  The previous owner remains a
+// member with the role they were last given, if any.
+func (m *Manager) Transfer(ctx context.Context, userID snowflake.Snowflake) error {
+	_, actor, err := active(ctx)
+	if err != nil {
+		return err
+	}
+	if !actor.Owner {
+		return role.ErrForbidden
+	}
+	if userID == actor.UserID {
+		return ErrOwner
+	}
+	return m.store.Transfer(ctx, userID)
+}
+
+// Members returns the members of the active organization, the member must
+// be granted PermissionMembersRead.
+func (m *Manager) Members(ctx context.Context) ([]*Membership, error) {
+	o, actor, err := active(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !actor.Can(PermissionMembersRead) {
+		return nil, role.ErrForbidden
+	}
+	members, err := m.store.Members(ctx)
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[snowflake.Snowflake]*role.Role)
+	for _, mb := range members {
+		mb.Owner = mb.UserID == o.OwnerID
+		if !mb.RoleID.Valid() {
+			continue
+		}
+		r, ok := roles[mb.RoleID]
+		if !ok {
+			if r, err = m.role(ctx, mb.RoleID); err != nil {
+				return nil, err
+			}
+			roles[mb.RoleID] = r
+		}
+		mb.Role = r
+	}
+	return members, nil
+}
+
+// SetRole changes the role of a member of the active organization, an
+// invalid roleID removes their role.  The member must be granted
+// PermissionMembersManage, outrank the member whose role is changed and
+// outrank the role, unless they are the owner.  The owner's role can not be
+// changed.
+func (m *Manager) SetRole(ctx context.Context, userID, roleID snowflake.Snowflake) (*Membership, error) {
+	_, actor, err := active(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !actor.Can(PermissionMembersManage) {
+		return nil, role.ErrForbidden
+	}
+	target, err := m.member(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if target.Owner {
+		return nil, ErrOwner
+	}
+	if !actor.Outranks(target) {
+		return nil, role.ErrInsufficientRank
+	}
+	r, err := m.assignable(ctx, actor, roleID)
+	if err != nil {
+		return nil, err
+	}
+	target.RoleID, target.Role = snowflake.Nil, r
+	if r != nil {
+		target.RoleID = r.ID
+	}
+	if err := m.store.SetRole(ctx, userID, target.RoleID); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// RemoveMember removes a member from the active organization.  Members may
+// always leave, removing another member requires PermissionMembersManage
+// and outranking them.  The owner can not be removed.
+func (m *Manager) RemoveMember(ctx context.Context, userID snowflake.Snowflake) error {
+	_, actor, err := active(ctx)
+	if err != nil {
+		return err
+	}
+	target, err := m.member(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if target.Owner {
+		return ErrOwner
+	}
+	if userID != actor.UserID {
+		if !actor.Can(PermissionMembersManage) {
+			return role.ErrForbidden
+		}
+		if !actor.Outranks(target) {
+			return role.ErrInsufficientRank
+		}
+	}
+	return m.store.RemoveMember(ctx, userID)
+}
+
+// Invite invites the owner of the email address to the active organization,
+// returning the code that must be given to the invitee, it can not be
+// retrieved again.  If roleID is valid the invitee is given the role, which
+// the member must outrank unless they are the owner.  The member must be
+// granted PermissionMembersInvite.
+func (m *Manager) Invite(ctx context.Context, email string, roleID snowflake.Snowflake) (*Invitation, string, error) {
+	o, actor, err := active(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if !actor.Can(PermissionMembersInvite) {
+		return nil, "", role.ErrForbidden
+	}
+	r, err := m.assignable(ctx, actor, roleID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+	now := m.Now()
+	i := &Invitation{
+		ID:             snowflake.New(),
+		OrganizationID: o.ID,
+		Hash:           token.Hash(code),
+		Email:          email,
+		RoleID:         snowflake.Nil,
+		InvitedBy:      actor.UserID,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(invitationTTL),
+	}
+	if r != nil {
+		i.RoleID = r.ID
+	}
+	if err := m.store.CreateInvitation(ctx, i); err != nil {
+		return nil, "", err
+	}
+	return i, code, nil
+}
+
+// Invitations returns the pending invitations to the active organization,
+// the member must be granted PermissionMembersInvite.
+func (m *Manager) Invitations(ctx context.Context) ([]*Invitation, error) {
+	_, actor, err := active(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !actor.Can(PermissionMembersInvite) {
+		return nil, role.ErrForbidden
+	}
+	return m.store.Invitations(ctx)
+}
+
+// RevokeInvitation revokes an invitation to the active organization, the
+// member must be granted PermissionMembersInvite.
+func (m *Manager) RevokeInvitation(ctx context.Context, id snowflake.Snowflake) error {
+	_, actor, err := active(ctx)
+	if err != nil {
+		return err
+	}
+	if !actor.Can(PermissionMembersInvite) {
+		return role.ErrForbidden
+	}
+	return m.store.DeleteInvitation(ctx, id)
+}
+
+// Accept accepts an invitation on behalf of u, making them a member of the
+// organization it was sent for.  An invitation may only be accepted once,
+// by the user with the email address it was sent to.
+func (m *Manager) Accept(ctx context.Context, u *user.User, code string) (*Organization, *Membership, error) {
+	if code == "" {
+		return nil, nil, ErrInvalidInvitation
+	}
+	mb, err := m.store.AcceptInvitation(ctx, token.Hash(code), m.Now(), u)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil, ErrInvalidInvitation
+		}
+		return nil, nil, err
+	}
+	o, err := m.store.ByID(ctx, mb.OrganizationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := m.load(ctx, o, mb); err != nil {
+		return nil, nil, err
+	}
+	return o, mb, nil
+}
+
+// active returns the active organization and the requesting member.
+func active(ctx context.Context) (*Organization, *Membership, error) {
+	o, mb, ok := FromContext(ctx)
+	if !ok {
+		return nil, nil, ErrNoOrganization
+	}
+	return o, mb, nil
+}
+
+// member returns a member of the active organization with their role.
+func (m *Manager) member(ctx context.Context, userID snowflake.Snowflake) (*Membership, error) {
+	o, _, err := active(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mb, err := m.store.Member(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrNotMember
+		}
+		return nil, err
+	}
+	if err := m.load(ctx, o, mb); err != nil {
+		return nil, err
+	}
+	return mb, nil
+}
+
+// load sets whether the member owns the organization and loads their role.
+func (m *Manager) load(ctx context.Context, o *Organization, mb *Membership) error {
+	mb.Owner = mb.UserID == o.OwnerID
+	r, err := m.role(ctx, mb.RoleID)
+	if err != nil {
+		return err
+	}
+	mb.Role = r
+	return nil
+}
+
+// role returns the role with the ID, or nil if the ID is invalid.
+func (m *Manager) role(ctx context.Context, id snowflake.Snowflake) (*role.Role, error) {
+	if !id.Valid() {
+		return nil, nil
+	}
+	return m.roles.ByID(ctx, id)
+}
+
+// assignable returns the role with the ID if actor may give it to members,
+// or nil if the ID is invalid.
+func (m *Manager) assignable(ctx context.Context, actor *Membership, id snowflake.Snowflake) (*role.Role, error) {
+	r, err := m.role(ctx, id)
+	if err != nil || r == nil {
+		return nil, err
+	}
+	if !actor.Owner && !actor.Role.Outranks(r) {
+		return nil, role.ErrInsufficientRank
+	}
+	return r, nil
+}
+
+// validName returns the name without surrounding whitespace, or
+// ErrInvalidName.
+func validName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxName {
+		return "", ErrInvalidName
+	}
+	return name, nil
+}
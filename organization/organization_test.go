@@ -0,0 +1,523 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package organization_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/organization"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// memoryStore is an in-memory organization.Store used for testing, scoped
+// methods filter by the active organization like the PostgreSQL store.
+type memoryStore struct {
+	organizations map[snowflake.Snowflake]*organization.Organization
+	members       map[snowflake.Snowflake]map[snowflake.Snowflake]*organization.Membership
+	invitations   map[snowflake.Snowflake]*organization.Invitation
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		organizations: make(map[snowflake.Snowflake]*organization.Organization),
+		members:       make(map[snowflake.Snowflake]map[snowflake.Snowflake]*organization.Membership),
+		invitations:   make(map[snowflake.Snowflake]*organization.Invitation),
+	}
+}
+
+// scope returns the ID of the active organization.
+func scope(ctx context.Context) (snowflake.Snowflake, error) {
+	o, _, ok := organization.FromContext(ctx)
+	if !ok {
+		return snowflake.Nil, organization.ErrNoOrganization
+	}
+	return o.ID, nil
+}
+
+func (s *memoryStore) Create(_ context.Context, o *organization.Organization, owner *organization.Membership) error {
+	v := *o
+	s.organizations[o.ID] = &v
+	m := *owner
+	s.members[o.ID] = map[snowflake.Snowflake]*organization.Membership{owner.UserID: &m}
+	return nil
+}
+
+func (s *memoryStore) ByID(_ context.Context, id snowflake.Snowflake) (*organization.Organization, error) {
+	o, ok := s.organizations[id]
+	if !ok {
+		return nil, organization.ErrNotFound
+	}
+	v := *o
+	return &v, nil
+}
+
+func (s *memoryStore) ByUser(_ context.Context, userID snowflake.Snowflake) ([]*organization.Organization, error) {
+	var organizations []*organization.Organization
+	for id, members := range s.members {
+		if _, ok := members[userID]; ok {
+			v := *s.organizations[id]
+			organizations = append(organizations, &v)
+		}
+	}
+	return organizations, nil
+}
+
+func (s *memoryStore) Membership(_ context.Context, organizationID, userID snowflake.Snowflake) (*organization.Membership, error) {
+	m, ok := s.members[organizationID][userID]
+	if !ok {
+		return nil, organization.ErrNotFound
+	}
+	v := *m
+	return &v, nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, o *organization.Organization) error {
+	id, err := scope(ctx)
+	if err != nil {
+		return err
+	}
+	s.organizations[id].Name = o.Name
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context) error {
+	id, err := scope(ctx)
+	if err != nil {
+		return err
+	}
+	delete(s.organizations, id)
+	delete(s.members, id)
+	return nil
+}
+
+func (s *memoryStore) Transfer(ctx context.Context, userID snowflake.Snowflake) error {
+	id, err := scope(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.members[id][userID]; !ok {
+		return organization.ErrNotMember
+	}
+	s.organizations[id].OwnerID = userID
+	return nil
+}
+
+func (s *memoryStore) Members(ctx context.Context) ([]*organization.Membership, error) {
+	id, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var members []*organization.Membership
+	for _, m := range s.members[id] {
+		v := *m
+		members = append(members, &v)
+	}
+	return members, nil
+}
+
+func (s *memoryStore) Member(ctx context.Context, userID snowflake.Snowflake) (*organization.Membership, error) {
+	id, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Membership(ctx, id, userID)
+}
+
+func (s *memoryStore) SetRole(ctx context.Context, userID, roleID snowflake.Snowflake) error {
+	id, err := scope(ctx)
+	if err != nil {
+		return err
+	}
+	m, ok := s.members[id][userID]
+	if !ok {
+		return organization.ErrNotFound
+	}
+	m.RoleID = roleID
+	return nil
+}
+
+func (s *memoryStore) RemoveMember(ctx context.Context, userID snowflake.Snowflake) error {
+	id, err := scope(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.members[id][userID]; !ok {
+		return organization.ErrNotFound
+	}
+	delete(s.members[id], userID)
+	return nil
+}
+
+func (s *memoryStore) CreateInvitation(ctx context.Context, i *organization.Invitation) error {
+	id, err := scope(ctx)
+	if err != nil {
+		return err
+	}
+	i.OrganizationID = id
+	v := *i
+	s.invitations[i.ID] = &v
+	return nil
+}
+
+func (s *memoryStore) Invitations(ctx context.Context) ([]*organization.Invitation, error) {
+	id, err := scope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var invitations []*organization.Invitation
+	for _, i := range s.invitations {
+		if i.OrganizationID == id {
+			v := *i
+			invitations = append(invitations, &v)
+		}
+	}
+	return invitations, nil
+}
+
+func (s *memoryStore) DeleteInvitation(ctx context.Context, id snowflake.Snowflake) error {
+	organizationID, err := scope(ctx)
+	if err != nil {
+		return err
+	}
+	i, ok := s.invitations[id]
+	if !ok || i.OrganizationID != organizationID {
+		return organization.ErrNotFound
+	}
+	delete(s.invitations, id)
+	return nil
+}
+
+func (s *memoryStore) AcceptInvitation(_ context.Context, hash string, now time.Time, u *user.User) (*organization.Membership, error) {
+	for id, i := range s.invitations {
+		if i.Hash != hash {
+			continue
+		}
+		if !i.Valid(u.Email, now) {
+			return nil, organization.ErrInvalidInvitation
+		}
+		if _, ok := s.members[i.OrganizationID][u.ID]; ok {
+			return nil, organization.ErrAlreadyMember
+		}
+		m := &organization.Membership{
+			OrganizationID: i.OrganizationID,
+			UserID:         u.ID,
+			RoleID:         i.RoleID,
+			CreatedAt:      now,
+		}
+		v := *m
+		s.members[i.OrganizationID][u.ID] = &v
+		delete(s.invitations, id)
+		return m, nil
+	}
+	return nil, organization.ErrNotFound
+}
+
+// memoryRoles is an in-memory organization.Roles used for testing.
+type memoryRoles map[snowflake.Snowflake]*role.Role
+
+func (s memoryRoles) ByID(_ context.Context, id snowflake.Snowflake) (*role.Role, error) {
+	r, ok := s[id]
+	if !ok {
+		return nil, role.ErrNotFound
+	}
+	return r, nil
+}
+
+// testOrganization is an organization with an owner, an administrator, a
+// manager and a member, each ranked below the previous.
+type testOrganization struct {
+	m      *organization.Manager
+	store  *memoryStore
+	org    *organization.Organization
+	owner  *user.User
+	admin  *user.User
+	staff  *user.User
+	member *user.User
+}
+
+func newTestOrganization(t *testing.T) *testOrganization {
+	roles := memoryRoles{
+		1: {ID: 1, Name: "Admin", SortID: 1, Permissions: role.Permissions{"organization.*"}},
+		2: {ID: 2, Name: "Manager", SortID: 2, Permissions: role.Permissions{"organization.members.*"}},
+		3: {ID: 3, Name: "Member", SortID: 3},
+	}
+	s := newMemoryStore()
+	to := &testOrganization{m: organization.NewManager(s, roles), store: s}
+	users := make([]*user.User, 4)
+	for i := range users {
+		users[i], _ = user.New("user@example.com", nil)
+		users[i].ID = snowflake.New()
+	}
+	to.owner, to.admin, to.staff, to.member = users[0], users[1], users[2], users[3]
+
+	org, err := to.m.Create(context.Background(), to.owner, " Example ")
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	to.org = org
+	for i, u := range users[1:] {
+		s.members[org.ID][u.ID] = &organization.Membership{
+			OrganizationID: org.ID,
+			UserID:         u.ID,
+			RoleID:         snowflake.Snowflake(i + 1),
+		}
+	}
+	return to
+}
+
+// as returns a context with the organization active on behalf of u.
+func (to *testOrganization) as(t *testing.T, u *user.User) context.Context {
+	o, m, err := to.m.Load(context.Background(), to.org.ID, u.ID)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	return organization.NewContext(context.Background(), o, m)
+}
+
+func TestScope(t *testing.T) {
+	if _, _, err := organization.Scope(context.Background(), "organization_id", nil); !errors.Is(err, organization.ErrNoOrganization) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", organization.ErrNoOrganization, err)
+	}
+
+	o := &organization.Organization{ID: 5}
+	ctx := organization.NewContext(context.Background(), o, &organization.Membership{})
+	cond, args, err := organization.Scope(ctx, "organization_id", []interface{}{"a"})
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if cond != "organization_id = $2" {
+		t.Errorf("Expected \"%v\", but got \"%v\"", "organization_id = $2", cond)
+	}
+	if len(args) != 2 || args[1] != o.ID {
+		t.Errorf("Expected \"%v\", but got \"%v\"", []interface{}{"a", o.ID}, args)
+	}
+}
+
+func TestManager_Create(t *testing.T) {
+	to := newTestOrganization(t)
+	if to.org.Name != "Example" {
+		t.Errorf("Expected \"%v\", but got \"%v\"", "Example", to.org.Name)
+	}
+	if _, err := to.m.Create(context.Background(), to.owner, " "); !errors.Is(err, organization.ErrInvalidName) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", organization.ErrInvalidName, err)
+	}
+
+	ctx := to.as(t, to.owner)
+	_, m, _ := organization.FromContext(ctx)
+	if !m.Owner || !m.Can(organization.PermissionUpdate) {
+		t.Errorf("Expected the creator to own the organization")
+	}
+
+	outsider, _ := user.New("outsider@example.com", nil)
+	if _, _, err := to.m.Load(context.Background(), to.org.ID, outsider.ID); !errors.Is(err, organization.ErrNotFound) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", organization.ErrNotFound, err)
+	}
+}
+
+func TestManager_SetRole(t *testing.T) {
+	for i, tc := range []struct {
+		Actor  func(*testOrganization) *user.User
+		Target func(*testOrganization) *user.User
+		RoleID snowflake.Snowflake
+		Err    error
+	}{
+		{Actor: owner, Target: member, RoleID: 1},
+		{Actor: owner, Target: admin, RoleID: snowflake.Nil},
+		{Actor: admin, Target: member, RoleID: 2},
+		{Actor: staff, Target: member, RoleID: 2, Err: role.ErrInsufficientRank},
+		{Actor: staff, Target: admin, RoleID: 3, Err: role.ErrInsufficientRank},
+		{Actor: admin, Target: owner, RoleID: 3, Err: organization.ErrOwner},
+		{Actor: member, Target: member, RoleID: 1, Err: role.ErrForbidden},
+		{Actor: admin, Target: member, RoleID: 4, Err: role.ErrNotFound},
+		{Actor: admin, Target: outsider, RoleID: 3, Err: organization.ErrNotMember},
+	} {
+		to := newTestOrganization(t)
+		m, err := to.m.SetRole(to.as(t, tc.Actor(to)), tc.Target(to).ID, tc.RoleID)
+		if !errors.Is(err, tc.Err) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Err, err)
+			continue
+		}
+		if err == nil && (m.RoleID != tc.RoleID || to.store.members[to.org.ID][m.UserID].RoleID != tc.RoleID) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.RoleID, m.RoleID)
+		}
+	}
+}
+
+func TestManager_RemoveMember(t *testing.T) {
+	for i, tc := range []struct {
+		Actor  func(*testOrganization) *user.User
+		Target func(*testOrganization) *user.User
+		Err    error
+	}{
+		{Actor: owner, Target: admin},
+		{Actor: staff, Target: member},
+		{Actor: member, Target: member},
+		{Actor: member, Target: staff, Err: role.ErrForbidden},
+		{Actor: staff, Target: admin, Err: role.ErrInsufficientRank},
+		{Actor: owner, Target: owner, Err: organization.ErrOwner},
+		{Actor: admin, Target: outsider, Err: organization.ErrNotMember},
+	} {
+		to := newTestOrganization(t)
+		target := tc.Target(to)
+		if err := to.m.RemoveMember(to.as(t, tc.Actor(to)), target.ID); !errors.Is(err, tc.Err) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Err, err)
+			continue
+		}
+		if _, ok := to.store.members[to.org.ID][target.ID]; ok == (tc.Err == nil) && tc.Err != organization.ErrNotMember {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Err != nil, ok)
+		}
+	}
+}
+
+func TestManager_Invite(t *testing.T) {
+	to := newTestOrganization(t)
+	ctx := to.as(t, to.staff)
+	for i, tc := range []struct {
+		RoleID snowflake.Snowflake
+		Err    error
+	}{
+		{RoleID: 3},
+		{RoleID: snowflake.Nil},
+		{RoleID: 2, Err: role.ErrInsufficientRank},
+		{RoleID: 4, Err: role.ErrNotFound},
+	} {
+		if _, _, err := to.m.Invite(ctx, "invited@example.com", tc.RoleID); !errors.Is(err, tc.Err) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Err, err)
+		}
+	}
+	if _, _, err := to.m.Invite(to.as(t, to.member), "invited@example.com", snowflake.Nil); !errors.Is(err, role.ErrForbidden) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", role.ErrForbidden, err)
+	}
+}
+
+func TestManager_Accept(t *testing.T) {
+	to := newTestOrganization(t)
+	ctx := to.as(t, to.admin)
+	_, code, err := to.m.Invite(ctx, "invited@example.com", 3)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	_, expired, _ := to.m.Invite(ctx, "invited@example.com", snowflake.Nil)
+	i, revoked, _ := to.m.Invite(ctx, "invited@example.com", snowflake.Nil)
+	if err := to.m.RevokeInvitation(ctx, i.ID); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	invited, _ := user.New("invited@example.com", nil)
+	other, _ := user.New("other@example.com", nil)
+	other.ID = snowflake.New()
+	for i, tc := range []struct {
+		User  *user.User
+		Code  string
+		Err   error
+		Later bool
+	}{
+		{User: other, Code: code, Err: organization.ErrInvalidInvitation},
+		{User: invited, Code: expired, Later: true, Err: organization.ErrInvalidInvitation},
+		{User: invited, Code: "unknown", Err: organization.ErrInvalidInvitation},
+		{User: invited, Code: revoked, Err: organization.ErrInvalidInvitation},
+		{User: invited, Code: code},
+		{User: invited, Code: code, Err: organization.ErrInvalidInvitation},
+	} {
+		to.m.Now = time.Now
+		if tc.Later {
+			to.m.Now = func() time.Time { return time.Now().Add(8 * 24 * time.Hour) }
+		}
+		o, m, err := to.m.Accept(context.Background(), tc.User, tc.Code)
+		if !errors.Is(err, tc.Err) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Err, err)
+			continue
+		}
+		if err == nil && (o.ID != to.org.ID || m.Role == nil || m.Role.ID != 3) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, to.org.ID, o.ID)
+		}
+	}
+}
+
+func TestManager_Transfer(t *testing.T) {
+	to := newTestOrganization(t)
+	outsider, _ := user.New("outsider@example.com", nil)
+	outsider.ID = snowflake.New()
+	if err := to.m.Transfer(to.as(t, to.admin), to.staff.ID); !errors.Is(err, role.ErrForbidden) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", role.ErrForbidden, err)
+	}
+	if err := to.m.Transfer(to.as(t, to.owner), outsider.ID); !errors.Is(err, organization.ErrNotMember) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", organization.ErrNotMember, err)
+	}
+	if err := to.m.Transfer(to.as(t, to.owner), to.member.ID); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	// The previous owner remains a member and may now be removed.
+	ctx := to.as(t, to.member)
+	_, m, _ := organization.FromContext(ctx)
+	if !m.Owner {
+		t.Errorf("Expected the member to own the organization")
+	}
+	if err := to.m.RemoveMember(ctx, to.owner.ID); err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+	}
+}
+
+func TestManager_Isolation(t *testing.T) {
+	a := newTestOrganization(t)
+	b := newTestOrganization(t)
+	// Share a store so both organizations are visible to the same manager.
+	for id, members := range b.store.members {
+		a.store.organizations[id] = b.store.organizations[id]
+		a.store.members[id] = members
+	}
+
+	ctx := a.as(t, a.owner)
+	if _, err := a.m.SetRole(ctx, b.member.ID, 1); !errors.Is(err, organization.ErrNotMember) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", organization.ErrNotMember, err)
+	}
+	if err := a.m.RemoveMember(ctx, b.admin.ID); !errors.Is(err, organization.ErrNotMember) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", organization.ErrNotMember, err)
+	}
+	members, _ := a.m.Members(ctx)
+	for _, m := range members {
+		if m.OrganizationID != a.org.ID {
+			t.Errorf("Expected \"%v\", but got \"%v\"", a.org.ID, m.OrganizationID)
+		}
+	}
+	if _, err := a.m.Members(context.Background()); !errors.Is(err, organization.ErrNoOrganization) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", organization.ErrNoOrganization, err)
+	}
+}
+
+func owner(to *testOrganization) *user.User  { return to.owner }
+func admin(to *testOrganization) *user.User  { return to.admin }
+func staff(to *testOrganization) *user.User  { return to.staff }
+func member(to *testOrganization) *user.User { return to.member }
+
+func outsider(*testOrganization) *user.User {
+	u, _ := user.New("outsider@example.com", nil)
+	u.ID = snowflake.New()
+	return u
+}
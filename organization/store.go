@@ -0,0 +1,401 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package organization
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// ErrNotFound is returned when an Organization, Membership or Invitation
+// does not exist.
+var ErrNotFound = errors.New("organization: not found")
+
+// Store persists Organizations, Memberships and Invitations.
+//
+// Methods that take no organization ID act on the active organization, see
+// Scope, and return ErrNoOrganization if the context has none.
+type Store interface {
+	// Create stores a new Organization and its owner's Membership.
+	Create(ctx context.Context, o *Organization, owner *Membership) error
+
+	// ByID returns an Organization, it is used to load the active
+	// organization and so is not scoped.
+	ByID(ctx context.Context, id snowflake.Snowflake) (*Organization, error)
+
+	// ByUser returns the Organizations the user is a member of, oldest
+	// first.
+	ByUser(ctx context.Context, userID snowflake.Snowflake) ([]*Organization, error)
+
+	// Membership returns a user's Membership of an organization, it is used
+	// to load the active organization and so is not scoped.
+	Membership(ctx context.Context, organizationID, userID snowflake.Snowflake) (*Membership, error)
+
+	// Update updates the active organization.
+	Update(ctx context.Context, o *Organization) error
+
+	// Delete deletes the active organization.
+	Delete(ctx context.Context) error
+
+	// Transfer makes a member the owner of the active organization.
+	// Returns ErrNotMember if the user is not a member.
+	Transfer(ctx context.Context, userID snowflake.Snowflake) error
+
+	// Members returns the Memberships of the active organization, oldest
+	// first.
+	Members(ctx context.Context) ([]*Membership, error)
+
+	// Member returns a user's Membership of the active organization.
+	Member(ctx context.Context, userID snowflake.Snowflake) (*Membership, error)
+
+	// SetRole sets the role of a member of the active organization.
+	SetRole(ctx context.Context, userID, roleID snowflake.Snowflake) error
+
+	// RemoveMember removes a member from the active organization.
+	RemoveMember(ctx context.Context, userID snowflake.Snowflake) error
+
+	// CreateInvitation stores a new Invitation to the active organization.
+	CreateInvitation(ctx context.Context, i *Invitation) error
+
+	// Invitations returns the Invitations to the active organization,
+	// newest first.
+	Invitations(ctx context.Context) ([]*Invitation, error)
+
+	// DeleteInvitation deletes an Invitation to the active organization.
+	DeleteInvitation(ctx context.Context, id snowflake.Snowflake) error
+
+	// AcceptInvitation accepts the Invitation with the given hash at now on
+	// behalf of u, adding them to its organization and deleting it in the
+	// same transaction.  It is not scoped, as the invitee is not yet a
+	// member.  Returns ErrInvalidInvitation if the Invitation may not be
+	// accepted by u, or ErrAlreadyMember.
+	AcceptInvitation(ctx context.Context, hash string, now time.Time, u *user.User) (*Membership, error)
+}
+
+const (
+	// selectOrganizations selects the columns expected by scan.
+	selectOrganizations = "SELECT id, name, owner_id, created_at FROM organizations"
+
+	// selectMemberships selects the columns expected by scanMembership.
+	selectMemberships = "SELECT organization_id, user_id, role_id, created_at FROM memberships"
+
+	// selectInvitations selects the columns expected by scanInvitation.
+	selectInvitations = "SELECT id, organization_id, hash, email, role_id, invited_by, created_at, expires_at FROM organization_invitations"
+)
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db db.Querier
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "organizations", "memberships" and
+// "organization_invitations" tables.
+func NewStore(q db.Querier) Store {
+	return &store{db: q}
+}
+
+func (s *store) Create(ctx context.Context, o *Organization, owner *Membership) error {
+	return s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(
+			ctx,
+			"INSERT INTO organizations (id, name, owner_id, created_at) VALUES ($1, $2, $3, $4)",
+			o.ID, o.Name, o.OwnerID, o.CreatedAt,
+		); err != nil {
+			return err
+		}
+		return insertMembership(ctx, tx, owner)
+	})
+}
+
+func (s *store) ByID(ctx context.Context, id snowflake.Snowflake) (*Organization, error) {
+	return scan(s.db.QueryRow(ctx, selectOrganizations+" WHERE id = $1", id))
+}
+
+func (s *store) ByUser(ctx context.Context, userID snowflake.Snowflake) ([]*Organization, error) {
+	rows, err := s.db.Query(
+		ctx,
+		selectOrganizations+" WHERE id IN (SELECT organization_id FROM memberships WHERE user_id = $1) ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var organizations []*Organization
+	for rows.Next() {
+		o, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		organizations = append(organizations, o)
+	}
+	return organizations, rows.Err()
+}
+
+func (s *store) Membership(ctx context.Context, organizationID, userID snowflake.Snowflake) (*Membership, error) {
+	return scanMembership(s.db.QueryRow(ctx, selectMemberships+" WHERE id = $1", membershipID(organizationID, userID)))
+}
+
+func (s *store) Update(ctx context.Context, o *Organization) error {
+	cond, args, err := Scope(ctx, "id", []interface{}{o.Name})
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, "UPDATE organizations SET name = $1 WHERE "+cond, args...)
+}
+
+func (s *store) Delete(ctx context.Context) error {
+	cond, args, err := Scope(ctx, "id", nil)
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, "DELETE FROM organizations WHERE "+cond, args...)
+}
+
+// Transfer only updates the owner if they are a member, checked in the same
+// statement so they can not leave in between.
+func (s *store) Transfer(ctx context.Context, userID snowflake.Snowflake) error {
+	cond, args, err := Scope(ctx, "id", []interface{}{userID})
+	if err != nil {
+		return err
+	}
+	err = s.exec(
+		ctx,
+		"UPDATE organizations SET owner_id = $1 WHERE "+cond+
+			" AND EXISTS (SELECT 1 FROM memberships WHERE organization_id = organizations.id AND user_id = $1)",
+		args...,
+	)
+	if errors.Is(err, ErrNotFound) {
+		return ErrNotMember
+	}
+	return err
+}
+
+func (s *store) Members(ctx context.Context) ([]*Membership, error) {
+	cond, args, err := Scope(ctx, "organization_id", nil)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(ctx, selectMemberships+" WHERE "+cond+" ORDER BY created_at", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*Membership
+	for rows.Next() {
+		m, err := scanMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (s *store) Member(ctx context.Context, userID snowflake.Snowflake) (*Membership, error) {
+	cond, args, err := Scope(ctx, "organization_id", []interface{}{userID})
+	if err != nil {
+		return nil, err
+	}
+	return scanMembership(s.db.QueryRow(ctx, selectMemberships+" WHERE user_id = $1 AND "+cond, args...))
+}
+
+func (s *store) SetRole(ctx context.Context, userID, roleID snowflake.Snowflake) error {
+	cond, args, err := Scope(ctx, "organization_id", []interface{}{userID, roleID})
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, "UPDATE memberships SET role_id = $2 WHERE user_id = $1 AND "+cond, args...)
+}
+
+func (s *store) RemoveMember(ctx context.Context, userID snowflake.Snowflake) error {
+	cond, args, err := Scope(ctx, "organization_id", []interface{}{userID})
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, "DELETE FROM memberships WHERE user_id = $1 AND "+cond, args...)
+}
+
+// CreateInvitation stores the invitation for the active organization,
+// regardless of its OrganizationID.
+func (s *store) CreateInvitation(ctx context.Context, i *Invitation) error {
+	o, _, ok := FromContext(ctx)
+	if !ok {
+		return ErrNoOrganization
+	}
+	i.OrganizationID = o.ID
+	_, err := s.db.Exec(
+		ctx,
+		"INSERT INTO organization_invitations (id, organization_id, hash, email, role_id, invited_by, created_at, expires_at) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		i.ID, i.OrganizationID, i.Hash, i.Email, i.RoleID, i.InvitedBy, i.CreatedAt, i.ExpiresAt,
+	)
+	return err
+}
+
+func (s *store) Invitations(ctx context.Context) ([]*Invitation, error) {
+	cond, args, err := Scope(ctx, "organization_id", nil)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(ctx, selectInvitations+" WHERE "+cond+" ORDER BY id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*Invitation
+	for rows.Next() {
+		i, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, i)
+	}
+	return invitations, rows.Err()
+}
+
+func (s *store) DeleteInvitation(ctx context.Context, id snowflake.Snowflake) error {
+	cond, args, err := Scope(ctx, "organization_id", []interface{}{id})
+	if err != nil {
+		return err
+	}
+	return s.exec(ctx, "DELETE FROM organization_invitations WHERE id = $1 AND "+cond, args...)
+}
+
+// AcceptInvitation locks the invitation's row so it can only be accepted
+// once.
+func (s *store) AcceptInvitation(ctx context.Context, hash string, now time.Time, u *user.User) (*Membership, error) {
+	var m *Membership
+	err := s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		i, err := scanInvitation(tx.QueryRow(ctx, selectInvitations+" WHERE hash = $1 FOR UPDATE", hash))
+		if err != nil {
+			return err
+		}
+		if !i.Valid(u.Email, now) {
+			return ErrInvalidInvitation
+		}
+		m = &Membership{
+			OrganizationID: i.OrganizationID,
+			UserID:         u.ID,
+			RoleID:         i.RoleID,
+			CreatedAt:      now,
+		}
+		if err := insertMembership(ctx, tx, m); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "DELETE FROM organization_invitations WHERE id = $1", i.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// exec executes a statement, returning ErrNotFound if it affected no rows.
+func (s *store) exec(ctx context.Context, sql string, args ...interface{}) error {
+	tag, err := s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// insertMembership inserts a membership, returning ErrAlreadyMember if the
+// user is already a member of the organization.
+func insertMembership(ctx context.Context, tx pgx.Tx, m *Membership) error {
+	tag, err := tx.Exec(
+		ctx,
+		"INSERT INTO memberships (id, organization_id, user_id, role_id, created_at) VALUES ($1, $2, $3, $4, $5) "+
+			"ON CONFLICT (id) DO NOTHING",
+		membershipID(m.OrganizationID, m.UserID), m.OrganizationID, m.UserID, m.RoleID, m.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() < 1 {
+		return ErrAlreadyMember
+	}
+	return nil
+}
+
+// membershipID returns the primary key of a user's membership of an
+// organization.
+func membershipID(organizationID, userID snowflake.Snowflake) string {
+	return organizationID.String() + ":" + userID.String()
+}
+
+// scan scans a row selected by selectOrganizations into an Organization.
+func scan(row pgx.Row) (*Organization, error) {
+	o := &Organization{}
+	if err := row.Scan(&o.ID, &o.Name, &o.OwnerID, &o.CreatedAt); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return o, nil
+}
+
+// scanMembership scans a row selected by selectMemberships into a
+// Membership.
+func scanMembership(row pgx.Row) (*Membership, error) {
+	m := &Membership{}
+	if err := row.Scan(&m.OrganizationID, &m.UserID, &m.RoleID, &m.CreatedAt); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// scanInvitation scans a row selected by selectInvitations into an
+// Invitation.
+func scanInvitation(row pgx.Row) (*Invitation, error) {
+	i := &Invitation{}
+	if err := row.Scan(
+		&i.ID, &i.OrganizationID, &i.Hash, &i.Email, &i.RoleID, &i.InvitedBy, &i.CreatedAt, &i.ExpiresAt,
+	); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return i, nil
+}
@@ -0,0 +1,304 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package passkey provides passwordless login using WebAuthn credentials.
+package passkey
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/token"
+	"github.com/matthewpi/cosmos/internal/uuid"
+	"github.com/matthewpi/cosmos/internal/webauthn"
+	"github.com/matthewpi/cosmos/user"
+)
+
+var (
+	// ErrInvalidCeremony is returned when a ceremony does not exist, has
+	// expired or belongs to another user.
+	ErrInvalidCeremony = errors.New("passkey: invalid or expired ceremony")
+
+	// ErrUnknownCredential is returned when logging in with a credential
+	// that is not registered.
+	ErrUnknownCredential = errors.New("passkey: unknown credential")
+
+	// ErrAlreadyRegistered is returned when registering a credential that is
+	// already registered.
+	ErrAlreadyRegistered = errors.New("passkey: credential is already registered")
+
+	// ErrInvalidName is returned when a passkey's name is empty or too long.
+	ErrInvalidName = errors.New("passkey: name must be between 1 and 64 characters")
+)
+
+// Passkey is a WebAuthn credential registered to a user.
+type Passkey struct {
+	// ID is the credential's ID.
+	ID webauthn.Bytes `json:"id"`
+
+	// UserID is the ID of the user the passkey belongs to.
+	UserID snowflake.Snowflake `json:"-"`
+
+	// Name is a name chosen by the user to identify the passkey.
+	Name string `json:"name"`
+
+	// PublicKey is the COSE encoded public key of the credential.
+	PublicKey []byte `json:"-"`
+
+	// Algorithm is the COSE algorithm of the public key.
+	Algorithm int64 `json:"-"`
+
+	// SignCount is the last signature counter returned by the
+	// authenticator.
+	SignCount uint32 `json:"-"`
+
+	// AAGUID identifies the model of the authenticator.
+	AAGUID []byte `json:"-"`
+
+	// AttestationType is either "none", "self" or "basic".
+	AttestationType string `json:"attestation_type"`
+
+	// CreatedAt is a timestamp of when the passkey was registered.
+	CreatedAt time.Time `json:"created_at"`
+
+	// LastUsedAt is a timestamp of when the passkey was last used to login.
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// credential returns the passkey as a webauthn.Credential.
+func (p *Passkey) credential() *webauthn.Credential {
+	return &webauthn.Credential{
+		ID:              p.ID,
+		PublicKey:       p.PublicKey,
+		Algorithm:       p.Algorithm,
+		SignCount:       p.SignCount,
+		AAGUID:          p.AAGUID,
+		AttestationType: p.AttestationType,
+	}
+}
+
+// Challenge is the server side state of a ceremony.
+type Challenge struct {
+	// ID is the SHA-256 hash of the ceremony token given to the client.
+	ID string
+
+	// UserID is the ID of the user registering a passkey, or snowflake.Nil
+	// when logging in.
+	UserID snowflake.Snowflake
+
+	// Challenge is the challenge the authenticator must sign.
+	Challenge []byte
+
+	// ExpiresAt is a timestamp of when the ceremony expires.
+	ExpiresAt time.Time
+}
+
+// Users is used by a Manager to load the user a passkey belongs to.
+type Users interface {
+	ByID(ctx context.Context, id snowflake.Snowflake) (*user.User, error)
+}
+
+// Manager runs WebAuthn ceremonies and manages users' passkeys.
+type Manager struct {
+	store  Store
+	users  Users
+	config *webauthn.Config
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+}
+
+// NewManager returns a new Manager, if c is nil webauthn.DefaultConfig is
+// used.
+func NewManager(s Store, users Users, c *webauthn.Config) *Manager {
+	if c == nil {
+		c = webauthn.DefaultConfig()
+	}
+	return &Manager{
+		store:  s,
+		users:  users,
+		config: c,
+		Now:    time.Now,
+	}
+}
+
+// BeginRegistration starts registering a new passkey for a user, returning
+// the ceremony token that must be passed to FinishRegistration and the
+// options for navigator.credentials.create().
+func (m *Manager) BeginRegistration(ctx context.Context, u *user.User) (string, *webauthn.CreationOptions, error) {
+	existing, err := m.store.ByUser(ctx, u.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	exclude := make([][]byte, len(existing))
+	for i, p := range existing {
+		exclude[i] = p.ID
+	}
+	ceremony, challenge, err := m.begin(ctx, u.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	return ceremony, m.config.NewCreationOptions(challenge, webauthn.UserEntity{
+		ID:          userHandle(u.ID),
+		Name:        u.Email,
+		DisplayName: u.Email,
+	}, exclude), nil
+}
+
+// FinishRegistration verifies the authenticator's response and registers the
+// new passkey.
+func (m *Manager) FinishRegistration(ctx context.Context, u *user.User, ceremony, name string, r *webauthn.AttestationResponse) (*Passkey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return nil, ErrInvalidName
+	}
+	c, err := m.finish(ctx, ceremony)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserID != u.ID {
+		return nil, ErrInvalidCeremony
+	}
+	cred, err := m.config.VerifyAttestation(c.Challenge, r)
+	if err != nil {
+		return nil, err
+	}
+	p := &Passkey{
+		ID:              cred.ID,
+		UserID:          u.ID,
+		Name:            name,
+		PublicKey:       cred.PublicKey,
+		Algorithm:       cred.Algorithm,
+		SignCount:       cred.SignCount,
+		AAGUID:          cred.AAGUID,
+		AttestationType: cred.AttestationType,
+		CreatedAt:       m.Now(),
+	}
+	if err := m.store.Create(ctx, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// BeginLogin starts logging in with a passkey, returning the ceremony token
+// that must be passed to FinishLogin and the options for
+// navigator.credentials.get().
+func (m *Manager) BeginLogin(ctx context.Context) (string, *webauthn.RequestOptions, error) {
+	ceremony, challenge, err := m.begin(ctx, snowflake.Nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return ceremony, m.config.NewRequestOptions(challenge, nil), nil
+}
+
+// FinishLogin verifies the authenticator's response, returning the user the
+// passkey belongs to.
+func (m *Manager) FinishLogin(ctx context.Context, ceremony string, r *webauthn.AssertionResponse) (*user.User, error) {
+	c, err := m.finish(ctx, ceremony)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserID.Valid() {
+		return nil, ErrInvalidCeremony
+	}
+	p, err := m.store.ByID(ctx, r.RawID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrUnknownCredential
+		}
+		return nil, err
+	}
+	if len(r.Response.UserHandle) > 0 && !bytes.Equal(r.Response.UserHandle, userHandle(p.UserID)) {
+		return nil, ErrUnknownCredential
+	}
+	count, err := m.config.VerifyAssertion(c.Challenge, p.credential(), r)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store.Use(ctx, p.ID, count, m.Now()); err != nil {
+		return nil, err
+	}
+	return m.users.ByID(ctx, p.UserID)
+}
+
+// List returns a user's passkeys.
+func (m *Manager) List(ctx context.Context, userID snowflake.Snowflake) ([]*Passkey, error) {
+	return m.store.ByUser(ctx, userID)
+}
+
+// Delete deletes one of a user's passkeys.
+func (m *Manager) Delete(ctx context.Context, userID snowflake.Snowflake, id []byte) error {
+	return m.store.Delete(ctx, userID, id)
+}
+
+// begin stores the state of a new ceremony.
+func (m *Manager) begin(ctx context.Context, userID snowflake.Snowflake) (string, []byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := uuid.New()
+	if err != nil {
+		return "", nil, err
+	}
+	ceremony := id.String()
+	if err := m.store.CreateChallenge(ctx, &Challenge{
+		ID:        token.Hash(ceremony),
+		UserID:    userID,
+		Challenge: challenge,
+		ExpiresAt: m.Now().Add(m.config.Timeout),
+	}); err != nil {
+		return "", nil, err
+	}
+	return ceremony, challenge, nil
+}
+
+// finish consumes the state of a ceremony, a ceremony can only be finished
+// once.
+func (m *Manager) finish(ctx context.Context, ceremony string) (*Challenge, error) {
+	if ceremony == "" {
+		return nil, ErrInvalidCeremony
+	}
+	c, err := m.store.ConsumeChallenge(ctx, token.Hash(ceremony))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidCeremony
+		}
+		return nil, err
+	}
+	if !m.Now().Before(c.ExpiresAt) {
+		return nil, ErrInvalidCeremony
+	}
+	return c, nil
+}
+
+// userHandle returns the WebAuthn user handle of a user.
+func userHandle(id snowflake.Snowflake) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(id))
+	return b
+}
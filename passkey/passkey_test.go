@@ -0,0 +1,286 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package passkey_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/webauthn"
+	"github.com/matthewpi/cosmos/internal/webauthn/webauthntest"
+	"github.com/matthewpi/cosmos/passkey"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// memoryStore is an in-memory passkey.Store used for testing.
+type memoryStore struct {
+	passkeys   map[string]*passkey.Passkey
+	challenges map[string]*passkey.Challenge
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		passkeys:   make(map[string]*passkey.Passkey),
+		challenges: make(map[string]*passkey.Challenge),
+	}
+}
+
+func key(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+func (s *memoryStore) Create(_ context.Context, p *passkey.Passkey) error {
+	if _, ok := s.passkeys[key(p.ID)]; ok {
+		return passkey.ErrAlreadyRegistered
+	}
+	v := *p
+	s.passkeys[key(p.ID)] = &v
+	return nil
+}
+
+func (s *memoryStore) ByID(_ context.Context, id []byte) (*passkey.Passkey, error) {
+	p, ok := s.passkeys[key(id)]
+	if !ok {
+		return nil, passkey.ErrNotFound
+	}
+	v := *p
+	return &v, nil
+}
+
+func (s *memoryStore) ByUser(_ context.Context, userID snowflake.Snowflake) ([]*passkey.Passkey, error) {
+	var passkeys []*passkey.Passkey
+	for _, p := range s.passkeys {
+		if p.UserID == userID {
+			v := *p
+			passkeys = append(passkeys, &v)
+		}
+	}
+	return passkeys, nil
+}
+
+func (s *memoryStore) Use(_ context.Context, id []byte, signCount uint32, t time.Time) error {
+	p := s.passkeys[key(id)]
+	if p.SignCount >= signCount && signCount != 0 {
+		return webauthn.ErrSignCount
+	}
+	p.SignCount = signCount
+	p.LastUsedAt = &t
+	return nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, userID snowflake.Snowflake, id []byte) error {
+	p, ok := s.passkeys[key(id)]
+	if !ok || p.UserID != userID {
+		return passkey.ErrNotFound
+	}
+	delete(s.passkeys, key(id))
+	return nil
+}
+
+func (s *memoryStore) CreateChallenge(_ context.Context, c *passkey.Challenge) error {
+	s.challenges[c.ID] = c
+	return nil
+}
+
+func (s *memoryStore) ConsumeChallenge(_ context.Context, id string) (*passkey.Challenge, error) {
+	c, ok := s.challenges[id]
+	if !ok {
+		return nil, passkey.ErrNotFound
+	}
+	delete(s.challenges, id)
+	return c, nil
+}
+
+// memoryUsers is an in-memory passkey.Users used for testing.
+type memoryUsers map[snowflake.Snowflake]*user.User
+
+func (s memoryUsers) ByID(_ context.Context, id snowflake.Snowflake) (*user.User, error) {
+	u, ok := s[id]
+	if !ok {
+		return nil, user.ErrNotFound
+	}
+	return u, nil
+}
+
+var config = &webauthn.Config{
+	RPID:    "example.com",
+	RPName:  "Example",
+	Origins: []string{"https://example.com"},
+	Timeout: 5 * time.Minute,
+}
+
+func newTestManager() (*passkey.Manager, *memoryStore, *user.User) {
+	u := &user.User{ID: 1, Email: "user@example.com"}
+	s := newMemoryStore()
+	return passkey.NewManager(s, memoryUsers{u.ID: u}, config), s, u
+}
+
+func register(m *passkey.Manager, a *webauthntest.Authenticator, u *user.User) (*passkey.Passkey, error) {
+	ceremony, o, err := m.BeginRegistration(context.Background(), u)
+	if err != nil {
+		return nil, err
+	}
+	r, err := a.Create(o)
+	if err != nil {
+		return nil, err
+	}
+	return m.FinishRegistration(context.Background(), u, ceremony, "Laptop", r)
+}
+
+func login(m *passkey.Manager, a *webauthntest.Authenticator) (*user.User, error) {
+	ceremony, o, err := m.BeginLogin(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	r, err := a.Get(o)
+	if err != nil {
+		return nil, err
+	}
+	return m.FinishLogin(context.Background(), ceremony, r)
+}
+
+func TestManager_Login(t *testing.T) {
+	m, s, u := newTestManager()
+	a := webauthntest.NewAuthenticator("https://example.com")
+	p, err := register(m, a, u)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if p.Name != "Laptop" {
+		t.Errorf("Expected \"%s\", but got \"%s\"", "Laptop", p.Name)
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := login(m, a)
+		if err != nil {
+			t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+		if got.ID != u.ID {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, u.ID, got.ID)
+		}
+	}
+	if c := s.passkeys[key(p.ID)].SignCount; c != 2 {
+		t.Errorf("Expected \"%d\", but got \"%d\"", 2, c)
+	}
+
+	// A cloned authenticator reports a counter that has not increased.
+	a.SetSignCount(0)
+	if _, err := login(m, a); err != webauthn.ErrSignCount {
+		t.Errorf("Expected \"%v\", but got \"%v\"", webauthn.ErrSignCount, err)
+	}
+}
+
+func TestManager_FinishRegistration(t *testing.T) {
+	m, _, u := newTestManager()
+	a := webauthntest.NewAuthenticator("https://example.com")
+
+	ceremony, o, err := m.BeginRegistration(context.Background(), u)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	r, err := a.Create(o)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	other := &user.User{ID: 2}
+	for i, tc := range []struct {
+		User     *user.User
+		Ceremony string
+		Name     string
+		Expect   error
+	}{
+		{User: u, Ceremony: ceremony, Name: "", Expect: passkey.ErrInvalidName},
+		{User: u, Ceremony: "unknown", Name: "Laptop", Expect: passkey.ErrInvalidCeremony},
+		{User: other, Ceremony: ceremony, Name: "Laptop", Expect: passkey.ErrInvalidCeremony},
+		// The ceremony was consumed by the previous attempt.
+		{User: u, Ceremony: ceremony, Name: "Laptop", Expect: passkey.ErrInvalidCeremony},
+	} {
+		if _, err := m.FinishRegistration(context.Background(), tc.User, tc.Ceremony, tc.Name, r); err != tc.Expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+		}
+	}
+}
+
+func TestManager_BeginRegistration_Exclude(t *testing.T) {
+	m, _, u := newTestManager()
+	a := webauthntest.NewAuthenticator("https://example.com")
+	p, err := register(m, a, u)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	_, o, err := m.BeginRegistration(context.Background(), u)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if len(o.ExcludeCredentials) != 1 || !bytes.Equal(o.ExcludeCredentials[0].ID, p.ID) {
+		t.Errorf("Expected the registered passkey to be excluded")
+	}
+}
+
+func TestManager_FinishLogin_Expired(t *testing.T) {
+	m, _, u := newTestManager()
+	a := webauthntest.NewAuthenticator("https://example.com")
+	if _, err := register(m, a, u); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	ceremony, o, err := m.BeginLogin(context.Background())
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	r, err := a.Get(o)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	m.Now = func() time.Time {
+		return time.Now().Add(config.Timeout)
+	}
+	if _, err := m.FinishLogin(context.Background(), ceremony, r); err != passkey.ErrInvalidCeremony {
+		t.Errorf("Expected \"%v\", but got \"%v\"", passkey.ErrInvalidCeremony, err)
+	}
+}
+
+func TestManager_Delete(t *testing.T) {
+	m, _, u := newTestManager()
+	a := webauthntest.NewAuthenticator("https://example.com")
+	p, err := register(m, a, u)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	if err := m.Delete(context.Background(), 2, p.ID); err != passkey.ErrNotFound {
+		t.Errorf("Expected \"%v\", but got \"%v\"", passkey.ErrNotFound, err)
+	}
+	if err := m.Delete(context.Background(), u.ID, p.ID); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if _, err := login(m, a); err != passkey.ErrUnknownCredential {
+		t.Errorf("Expected \"%v\", but got \"%v\"", passkey.ErrUnknownCredential, err)
+	}
+}
@@ -0,0 +1,211 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package passkey
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/matthewpi/pgconn"
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/webauthn"
+)
+
+// ErrNotFound is returned when a Passkey or Challenge does not exist.
+var ErrNotFound = errors.New("passkey: not found")
+
+// Store persists Passkeys and the state of ceremonies.
+type Store interface {
+	// Create stores a new Passkey.
+	Create(ctx context.Context, p *Passkey) error
+
+	// ByID returns the Passkey with the given credential ID.
+	ByID(ctx context.Context, id []byte) (*Passkey, error)
+
+	// ByUser returns all of a user's Passkeys.
+	ByUser(ctx context.Context, userID snowflake.Snowflake) ([]*Passkey, error)
+
+	// Use records a Passkey being used to login, returning
+	// webauthn.ErrSignCount if the Passkey's signature counter has since
+	// reached signCount.
+	Use(ctx context.Context, id []byte, signCount uint32, t time.Time) error
+
+	// Delete deletes one of a user's Passkeys.
+	Delete(ctx context.Context, userID snowflake.Snowflake, id []byte) error
+
+	// CreateChallenge stores the state of a new ceremony.
+	CreateChallenge(ctx context.Context, c *Challenge) error
+
+	// ConsumeChallenge deletes and returns the state of a ceremony.
+	ConsumeChallenge(ctx context.Context, id string) (*Challenge, error)
+}
+
+// selectPasskeys selects the columns expected by scan.
+const selectPasskeys = "SELECT id, user_id, name, public_key, algorithm, sign_count, aaguid, attestation_type, created_at, last_used_at FROM passkeys"
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db db.Querier
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "passkeys" and "webauthn_challenges"
+// tables.
+func NewStore(q db.Querier) Store {
+	return &store{db: q}
+}
+
+func (s *store) Create(ctx context.Context, p *Passkey) error {
+	_, err := s.db.Exec(
+		ctx,
+		"INSERT INTO passkeys (id, user_id, name, public_key, algorithm, sign_count, aaguid, attestation_type, created_at) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		encodeID(p.ID), p.UserID, p.Name, base64.StdEncoding.EncodeToString(p.PublicKey),
+		p.Algorithm, int64(p.SignCount), hex.EncodeToString(p.AAGUID), p.AttestationType, p.CreatedAt,
+	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrAlreadyRegistered
+	}
+	return err
+}
+
+func (s *store) ByID(ctx context.Context, id []byte) (*Passkey, error) {
+	return scan(s.db.QueryRow(ctx, selectPasskeys+" WHERE id = $1", encodeID(id)))
+}
+
+func (s *store) ByUser(ctx context.Context, userID snowflake.Snowflake) ([]*Passkey, error) {
+	rows, err := s.db.Query(ctx, selectPasskeys+" WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []*Passkey
+	for rows.Next() {
+		p, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, p)
+	}
+	return passkeys, rows.Err()
+}
+
+func (s *store) Use(ctx context.Context, id []byte, signCount uint32, t time.Time) error {
+	// The counter is compared again when updating, as concurrent logins with
+	// a cloned authenticator may both have passed the check in
+	// webauthn.Config.VerifyAssertion.
+	tag, err := s.db.Exec(
+		ctx,
+		"UPDATE passkeys SET sign_count = $2, last_used_at = $3 WHERE id = $1 AND (sign_count < $2 OR $2 = 0)",
+		encodeID(id), int64(signCount), t,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() < 1 {
+		return webauthn.ErrSignCount
+	}
+	return nil
+}
+
+func (s *store) Delete(ctx context.Context, userID snowflake.Snowflake, id []byte) error {
+	tag, err := s.db.Exec(ctx, "DELETE FROM passkeys WHERE id = $1 AND user_id = $2", encodeID(id), userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *store) CreateChallenge(ctx context.Context, c *Challenge) error {
+	_, err := s.db.Exec(
+		ctx,
+		"INSERT INTO webauthn_challenges (id, user_id, challenge, expires_at) VALUES ($1, $2, $3, $4)",
+		c.ID, c.UserID, base64.RawURLEncoding.EncodeToString(c.Challenge), c.ExpiresAt,
+	)
+	return err
+}
+
+func (s *store) ConsumeChallenge(ctx context.Context, id string) (*Challenge, error) {
+	c := &Challenge{}
+	var challenge string
+	if err := s.db.QueryRow(
+		ctx,
+		"DELETE FROM webauthn_challenges WHERE id = $1 RETURNING id, user_id, challenge, expires_at",
+		id,
+	).Scan(&c.ID, &c.UserID, &challenge, &c.ExpiresAt); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	v, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil {
+		return nil, err
+	}
+	c.Challenge = v
+	return c, nil
+}
+
+// scan scans a row selected by selectPasskeys into a Passkey.
+func scan(row pgx.Row) (*Passkey, error) {
+	p := &Passkey{}
+	var id, publicKey, aaguid string
+	var signCount int64
+	if err := row.Scan(
+		&id, &p.UserID, &p.Name, &publicKey, &p.Algorithm, &signCount, &aaguid, &p.AttestationType, &p.CreatedAt, &p.LastUsedAt,
+	); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	var err error
+	if p.ID, err = base64.RawURLEncoding.DecodeString(id); err != nil {
+		return nil, err
+	}
+	if p.PublicKey, err = base64.StdEncoding.DecodeString(publicKey); err != nil {
+		return nil, err
+	}
+	if p.AAGUID, err = hex.DecodeString(aaguid); err != nil {
+		return nil, err
+	}
+	p.SignCount = uint32(signCount)
+	return p, nil
+}
+
+// encodeID encodes a credential ID for storage.
+func encodeID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
@@ -0,0 +1,67 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package passkey_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/db/dbtest"
+	"github.com/matthewpi/cosmos/internal/webauthn"
+	"github.com/matthewpi/cosmos/passkey"
+)
+
+func TestStore_Use(t *testing.T) {
+	// stored emulates the passkey's sign_count column, only updating it when
+	// the statement's condition holds.
+	var stored int64
+	q := dbtest.New()
+	q.Handle("UPDATE passkeys SET sign_count", func(s dbtest.Statement) ([]dbtest.Row, error) {
+		count := s.Args[1].(int64)
+		if stored >= count && count != 0 {
+			return nil, nil
+		}
+		stored = count
+		return []dbtest.Row{{"sign_count": count}}, nil
+	})
+	s := passkey.NewStore(q)
+
+	for i, tc := range []struct {
+		signCount uint32
+		err       error
+	}{
+		{signCount: 1},
+		{signCount: 2},
+		// A concurrent login already stored the counter.
+		{signCount: 2, err: webauthn.ErrSignCount},
+		{signCount: 1, err: webauthn.ErrSignCount},
+		// Authenticators without a counter always report zero.
+		{signCount: 0},
+	} {
+		if err := s.Use(context.Background(), []byte("id"), tc.signCount, time.Now()); !errors.Is(err, tc.err) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package privacy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/config/lexer"
+)
+
+// Config represents the configuration for data export and account deletion.
+type Config struct {
+	// CoolingOff is how long after a user requests their account's deletion
+	// it is erased, during which the request may be cancelled.
+	CoolingOff time.Duration `json:"cooling_off"`
+
+	// PurgeInterval is how often accounts due for deletion are erased.
+	PurgeInterval time.Duration `json:"purge_interval"`
+}
+
+// DefaultConfig returns the default privacy configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		CoolingOff:    30 * 24 * time.Hour,
+		PurgeInterval: time.Hour,
+	}
+}
+
+// FromLexer .
+func FromLexer(b lexer.Block) (*Config, error) {
+	c := DefaultConfig()
+	for _, s := range b.Segments {
+		d := s.Directive()
+		switch d {
+		case "cooling_off", "purge_interval":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after %s directive", d)
+			}
+			v, err := time.ParseDuration(s[1].Text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", d, err)
+			}
+			if v <= 0 {
+				return nil, fmt.Errorf("%s must be positive", d)
+			}
+			switch d {
+			case "cooling_off":
+				c.CoolingOff = v
+			case "purge_interval":
+				c.PurgeInterval = v
+			}
+		default:
+			return nil, fmt.Errorf("unknown directive: \"" + d + "\"")
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,364 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package privacy exports and erases the personal data held about users.
+//
+// A user may download an archive of their account, sessions, audit events and
+// avatar at any time.  A user may also request that their account is
+// deleted, the deletion is scheduled after a cooling-off period during which
+// it can be cancelled, and once due Manager.Purge erases or anonymises the
+// user's personal data in every table that references them.
+package privacy
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/matthewpi/cosmos"
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/avatar"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/user"
+)
+
+var (
+	// ErrDeletionScheduled is returned when requesting the deletion of an
+	// account that is already scheduled to be deleted.
+	ErrDeletionScheduled = errors.New("privacy: account deletion is already scheduled")
+
+	// ErrDeletionNotScheduled is returned when cancelling the deletion of an
+	// account that is not scheduled to be deleted.
+	ErrDeletionNotScheduled = errors.New("privacy: account deletion is not scheduled")
+
+	// ErrTransferRequired is returned when deleting an account that owns an
+	// organization, which must be transferred to another member first.
+	ErrTransferRequired = errors.New("privacy: account owns an organization")
+)
+
+const (
+	// pageSize is the number of audit events read at a time when exporting,
+	// and the number of users read at a time when purging.
+	pageSize = 100
+)
+
+// Users is used by a Manager to schedule and find the accounts to delete, it
+// is satisfied by user.Store.
+type Users interface {
+	ScheduleDeletion(ctx context.Context, id snowflake.Snowflake, t time.Time) error
+	List(ctx context.Context, q *user.Query) ([]*user.User, error)
+}
+
+// Sessions lists a user's sessions, it is satisfied by *session.Manager.
+type Sessions interface {
+	List(ctx context.Context, userID snowflake.Snowflake) ([]*session.Session, error)
+}
+
+// Events queries the audit log, it is satisfied by *audit.Log.
+type Events interface {
+	Query(ctx context.Context, q *audit.Query) ([]*audit.Event, error)
+}
+
+// Owners reports whether a user owns an organization, it is satisfied by
+// *organization.Manager.
+type Owners interface {
+	Owns(ctx context.Context, userID snowflake.Snowflake) (bool, error)
+}
+
+// Manager exports and erases users' personal data.
+type Manager struct {
+	store    Store
+	users    Users
+	sessions Sessions
+	events   Events
+	owners   Owners
+	avatars  *avatar.Storage
+	config   *Config
+
+	// Now returns the current time, it may be replaced in tests.
+	Now func() time.Time
+}
+
+// NewManager returns a new Manager, owners is optional, if nil accounts are
+// deleted regardless of the organizations they own.
+func NewManager(s Store, users Users, sessions Sessions, events Events, owners Owners, avatars *avatar.Storage, c *Config) *Manager {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	return &Manager{
+		store:    s,
+		users:    users,
+		sessions: sessions,
+		events:   events,
+		owners:   owners,
+		avatars:  avatars,
+		config:   c,
+		Now:      time.Now,
+	}
+}
+
+// Config returns the Manager's configuration.
+func (m *Manager) Config() *Config {
+	return m.config
+}
+
+// RequestDeletion schedules a user's account to be deleted once the
+// cooling-off period has passed, updating u.DeleteAfter.  Returns
+// ErrTransferRequired if the user owns an organization.
+func (m *Manager) RequestDeletion(ctx context.Context, u *user.User) error {
+	if !u.DeleteAfter.IsZero() {
+		return ErrDeletionScheduled
+	}
+	if err := m.checkOwner(ctx, u); err != nil {
+		return err
+	}
+	t := m.Now().Add(m.config.CoolingOff).UTC().Truncate(time.Microsecond)
+	if err := m.users.ScheduleDeletion(ctx, u.ID, t); err != nil {
+		return err
+	}
+	u.DeleteAfter = t
+	return nil
+}
+
+// CancelDeletion cancels the scheduled deletion of a user's account.
+func (m *Manager) CancelDeletion(ctx context.Context, u *user.User) error {
+	if u.DeleteAfter.IsZero() {
+		return ErrDeletionNotScheduled
+	}
+	if err := m.users.ScheduleDeletion(ctx, u.ID, time.Time{}); err != nil {
+		return err
+	}
+	u.DeleteAfter = time.Time{}
+	return nil
+}
+
+// Export writes a ZIP archive of the data held about a user to w.
+//
+// The archive contains "user.json", "sessions.json", "audit_events.json" and,
+// if the user has an avatar, "avatar/<size>.png" for every stored size.
+func (m *Manager) Export(ctx context.Context, w io.Writer, u *user.User) error {
+	sessions, err := m.sessions.List(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	if sessions == nil {
+		sessions = []*session.Session{}
+	}
+	events, err := m.auditEvents(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+
+	z := zip.NewWriter(w)
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{name: "user.json", v: u.As(user.ViewAdmin)},
+		{name: "sessions.json", v: sessions},
+		{name: "audit_events.json", v: events},
+	}
+	for _, f := range files {
+		if err := m.writeJSON(z, f.name, f.v); err != nil {
+			return err
+		}
+	}
+	if u.Avatar != "" {
+		if err := m.writeAvatar(z, u.Avatar); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// Run purges the accounts due for deletion every PurgeInterval until the
+// context is cancelled.
+func (m *Manager) Run(ctx context.Context) error {
+	t := time.NewTicker(m.config.PurgeInterval)
+	defer t.Stop()
+	for {
+		n, err := m.Purge(ctx)
+		if err != nil && ctx.Err() == nil {
+			cosmos.Log().Error("failed to purge deleted accounts", zap.Error(err))
+		}
+		if n > 0 {
+			cosmos.Log().Info("purged deleted accounts", zap.Int("accounts", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Purge erases every account whose deletion is due, returning the number of
+// accounts erased.  Avatars are deleted once no other user has them.
+//
+// Accounts that came to own an organization during the cooling-off period
+// are skipped, they are erased by a later Purge once the organization has
+// been transferred or deleted.
+func (m *Manager) Purge(ctx context.Context) (int, error) {
+	var n int
+	var after snowflake.Snowflake
+	for {
+		users, err := m.users.List(ctx, &user.Query{After: after, DeletionDue: m.Now(), Limit: pageSize})
+		if err != nil {
+			return n, err
+		}
+		if len(users) == 0 {
+			return n, nil
+		}
+		for _, u := range users {
+			after = u.ID
+			if err := m.Erase(ctx, u); err != nil {
+				if err == ErrTransferRequired {
+					cosmos.Log().Warn("not deleting account owning an organization", zap.String("user_id", u.ID.String()))
+					continue
+				}
+				if err != user.ErrNotFound {
+					return n, err
+				}
+			}
+			n++
+		}
+	}
+}
+
+// Erase immediately erases a user's account, see Store.Erase.  The user's
+// avatar is deleted once no other user has it.  Returns ErrTransferRequired
+// if the user owns an organization.
+func (m *Manager) Erase(ctx context.Context, u *user.User) error {
+	if err := m.checkOwner(ctx, u); err != nil {
+		return err
+	}
+	if err := m.store.Erase(ctx, u); err != nil {
+		return err
+	}
+	if u.Avatar == "" {
+		return nil
+	}
+	used, err := m.store.AvatarUsed(ctx, u.Avatar)
+	if err != nil {
+		return err
+	}
+	if !used {
+		if err := m.avatars.Delete(u.Avatar); err != nil && err != avatar.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkOwner returns ErrTransferRequired if the user owns an organization,
+// deleting the user would leave it without an owner.
+func (m *Manager) checkOwner(ctx context.Context, u *user.User) error {
+	if m.owners == nil {
+		return nil
+	}
+	owns, err := m.owners.Owns(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	if owns {
+		return ErrTransferRequired
+	}
+	return nil
+}
+
+// auditEvents returns every audit event where the user is either the actor
+// or the target, newest first.
+func (m *Manager) auditEvents(ctx context.Context, userID snowflake.Snowflake) ([]*audit.Event, error) {
+	events := []*audit.Event{}
+	q := &audit.Query{UserID: userID, Limit: pageSize}
+	for {
+		page, err := m.events.Query(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, page...)
+		if len(page) < pageSize {
+			return events, nil
+		}
+		q.Before = page[len(page)-1].ID
+	}
+}
+
+// writeJSON writes v as an indented JSON file to the archive.
+func (m *Manager) writeJSON(z *zip.Writer, name string, v interface{}) error {
+	f, err := z.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: m.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeAvatar writes every stored size of an avatar to the archive.
+func (m *Manager) writeAvatar(z *zip.Writer, hash string) error {
+	sizes, err := m.avatars.Sizes(hash)
+	if err != nil {
+		if err == avatar.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	for _, size := range sizes {
+		if err := m.copyAvatar(z, hash, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyAvatar copies a single size of an avatar to the archive, PNG images
+// are already compressed so they are stored as-is.
+func (m *Manager) copyAvatar(z *zip.Writer, hash string, size int) error {
+	r, err := m.avatars.Open(hash, size)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := z.CreateHeader(&zip.FileHeader{
+		Name:     "avatar/" + strconv.Itoa(size) + ".png",
+		Method:   zip.Store,
+		Modified: m.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
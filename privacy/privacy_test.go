@@ -0,0 +1,342 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package privacy_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/avatar"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/privacy"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// memoryStore is an in-memory privacy.Store used for testing, it erases
+// users from memoryUsers.
+type memoryStore struct {
+	users  memoryUsers
+	erased []snowflake.Snowflake
+}
+
+func (s *memoryStore) Erase(_ context.Context, u *user.User) error {
+	if _, ok := s.users[u.ID]; !ok {
+		return user.ErrNotFound
+	}
+	delete(s.users, u.ID)
+	s.erased = append(s.erased, u.ID)
+	return nil
+}
+
+func (s *memoryStore) AvatarUsed(_ context.Context, hash string) (bool, error) {
+	for _, u := range s.users {
+		if u.Avatar == hash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// memoryUsers is an in-memory privacy.Users used for testing.
+type memoryUsers map[snowflake.Snowflake]*user.User
+
+func (s memoryUsers) ScheduleDeletion(_ context.Context, id snowflake.Snowflake, t time.Time) error {
+	u, ok := s[id]
+	if !ok {
+		return user.ErrNotFound
+	}
+	u.DeleteAfter = t
+	return nil
+}
+
+func (s memoryUsers) List(_ context.Context, q *user.Query) ([]*user.User, error) {
+	var users []*user.User
+	for _, u := range s {
+		if u.ID <= q.After || u.DeleteAfter.IsZero() || u.DeleteAfter.After(q.DeletionDue) {
+			continue
+		}
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if len(users) > q.Limit {
+		users = users[:q.Limit]
+	}
+	return users, nil
+}
+
+// memorySessions is an in-memory privacy.Sessions used for testing.
+type memorySessions []*session.Session
+
+func (s memorySessions) List(_ context.Context, userID snowflake.Snowflake) ([]*session.Session, error) {
+	var sessions []*session.Session
+	for _, v := range s {
+		if v.UserID == userID {
+			sessions = append(sessions, v)
+		}
+	}
+	return sessions, nil
+}
+
+// memoryEvents is an in-memory privacy.Events used for testing, events are
+// ordered newest first.
+type memoryEvents []*audit.Event
+
+func (s memoryEvents) Query(_ context.Context, q *audit.Query) ([]*audit.Event, error) {
+	var events []*audit.Event
+	for _, e := range s {
+		if e.ActorID != q.UserID && e.TargetID != q.UserID {
+			continue
+		}
+		if q.Before.Valid() && e.ID >= q.Before {
+			continue
+		}
+		if len(events) == q.Limit {
+			break
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// newStorage returns avatar storage in a temporary directory containing an
+// avatar, returning the avatar's hash.
+func newStorage(t *testing.T) (*avatar.Storage, string) {
+	dir, err := ioutil.TempDir("", "cosmos-privacy")
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s, err := avatar.NewStorage(dir)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	a, err := avatar.Process(b.Bytes(), []int{32, 64}, 4096)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if err := s.Save(a); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	return s, a.Hash
+}
+
+func TestManager_RequestDeletion(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	u := &user.User{ID: 1}
+	users := memoryUsers{u.ID: u}
+	m := privacy.NewManager(&memoryStore{users: users}, users, memorySessions{}, memoryEvents{}, nil, nil, nil)
+	m.Now = func() time.Time { return now }
+
+	if err := m.CancelDeletion(context.Background(), u); err != privacy.ErrDeletionNotScheduled {
+		t.Errorf("Expected \"%v\", but got \"%v\"", privacy.ErrDeletionNotScheduled, err)
+	}
+	if err := m.RequestDeletion(context.Background(), u); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	expect := now.Add(m.Config().CoolingOff)
+	if !u.DeleteAfter.Equal(expect) || !users[u.ID].DeleteAfter.Equal(expect) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", expect, u.DeleteAfter)
+	}
+	if err := m.RequestDeletion(context.Background(), u); err != privacy.ErrDeletionScheduled {
+		t.Errorf("Expected \"%v\", but got \"%v\"", privacy.ErrDeletionScheduled, err)
+	}
+	if err := m.CancelDeletion(context.Background(), u); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if !u.DeleteAfter.IsZero() || !users[u.ID].DeleteAfter.IsZero() {
+		t.Errorf("Expected the deletion to be cancelled, but got \"%v\"", u.DeleteAfter)
+	}
+}
+
+func TestManager_Export(t *testing.T) {
+	avatars, hash := newStorage(t)
+	u := &user.User{ID: 1, Email: "user@example.com", Avatar: hash}
+	sessions := memorySessions{
+		{ID: "a", UserID: u.ID},
+		{ID: "b", UserID: 2},
+	}
+	var events memoryEvents
+	for i := 250; i > 0; i-- {
+		events = append(events, &audit.Event{ID: snowflake.Snowflake(i), ActorID: snowflake.Snowflake(i%2 + 1), TargetID: snowflake.Nil})
+	}
+	m := privacy.NewManager(&memoryStore{}, memoryUsers{}, sessions, events, nil, avatars, nil)
+
+	var b bytes.Buffer
+	if err := m.Export(context.Background(), &b, u); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+		files[f.Name], _ = ioutil.ReadAll(r)
+		r.Close()
+	}
+
+	for i, tc := range []struct {
+		name   string
+		expect int
+	}{
+		{name: "sessions.json", expect: 1},
+		{name: "audit_events.json", expect: 125},
+	} {
+		var v []json.RawMessage
+		if err := json.Unmarshal(files[tc.name], &v); err != nil {
+			t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+		if len(v) != tc.expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.expect, len(v))
+		}
+	}
+
+	var exported struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(files["user.json"], &exported); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if exported.Email != u.Email {
+		t.Errorf("Expected \"%v\", but got \"%v\"", u.Email, exported.Email)
+	}
+	for i, size := range []int{32, 64} {
+		name := "avatar/" + strconv.Itoa(size) + ".png"
+		f, err := avatars.Open(hash, size)
+		if err != nil {
+			t.Fatalf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+		data, _ := ioutil.ReadAll(f)
+		f.Close()
+		if !bytes.Equal(files[name], data) {
+			t.Errorf("Test #%d: Expected %s to match the stored avatar", i, name)
+		}
+	}
+}
+
+func TestManager_Purge(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	avatars, hash := newStorage(t)
+	users := memoryUsers{
+		1: {ID: 1, Avatar: hash, DeleteAfter: now.Add(-time.Hour)},
+		2: {ID: 2, Avatar: hash, DeleteAfter: now.Add(time.Hour)},
+		3: {ID: 3, DeleteAfter: now},
+		4: {ID: 4},
+	}
+	s := &memoryStore{users: users}
+	m := privacy.NewManager(s, users, memorySessions{}, memoryEvents{}, nil, avatars, nil)
+	m.Now = func() time.Time { return now }
+
+	n, err := m.Purge(context.Background())
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if n != 2 || len(s.erased) != 2 || s.erased[0] != 1 || s.erased[1] != 3 {
+		t.Errorf("Expected users 1 and 3 to be erased, but got \"%v\"", s.erased)
+	}
+	if _, err := avatars.Sizes(hash); err != nil {
+		t.Errorf("Expected the avatar used by user 2 to be kept, but got \"%v\"", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, err := m.Purge(context.Background()); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if _, err := avatars.Sizes(hash); err != avatar.ErrNotFound {
+		t.Errorf("Expected \"%v\", but got \"%v\"", avatar.ErrNotFound, err)
+	}
+	if _, ok := users[4]; !ok {
+		t.Errorf("Expected user 4 to be kept")
+	}
+}
+
+// memoryOwners is an in-memory privacy.Owners used for testing.
+type memoryOwners map[snowflake.Snowflake]bool
+
+func (s memoryOwners) Owns(_ context.Context, userID snowflake.Snowflake) (bool, error) {
+	return s[userID], nil
+}
+
+func TestManager_Owners(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	users := memoryUsers{
+		1: {ID: 1, DeleteAfter: now},
+		2: {ID: 2, DeleteAfter: now},
+		3: {ID: 3},
+	}
+	owners := memoryOwners{1: true, 3: true}
+	s := &memoryStore{users: users}
+	m := privacy.NewManager(s, users, memorySessions{}, memoryEvents{}, owners, nil, nil)
+	m.Now = func() time.Time { return now }
+
+	if err := m.RequestDeletion(context.Background(), users[3]); err != privacy.ErrTransferRequired {
+		t.Errorf("Expected \"%v\", but got \"%v\"", privacy.ErrTransferRequired, err)
+	}
+	if !users[3].DeleteAfter.IsZero() {
+		t.Errorf("Expected the deletion not to be scheduled, but got \"%v\"", users[3].DeleteAfter)
+	}
+	if err := m.Erase(context.Background(), users[3]); err != privacy.ErrTransferRequired {
+		t.Errorf("Expected \"%v\", but got \"%v\"", privacy.ErrTransferRequired, err)
+	}
+
+	// Accounts that came to own an organization after requesting deletion
+	// are skipped until ownership has been transferred.
+	n, err := m.Purge(context.Background())
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if n != 1 || len(s.erased) != 1 || s.erased[0] != 2 {
+		t.Errorf("Expected user 2 to be erased, but got \"%v\"", s.erased)
+	}
+	delete(owners, 1)
+	if n, err := m.Purge(context.Background()); err != nil || n != 1 {
+		t.Errorf("Expected \"1\", but got \"%d\" (%v)", n, err)
+	}
+	if _, ok := users[1]; ok {
+		t.Errorf("Expected user 1 to be erased once ownership was transferred")
+	}
+}
@@ -0,0 +1,177 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package privacy
+
+import (
+	"context"
+
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/audit"
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/outbox"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/lockout"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// personalKeys are the keys removed from the payloads of outbox events that
+// reference an erased user.
+var personalKeys = []string{"email", "avatar", "ip", "user_agent"}
+
+// Store erases users' personal data.
+type Store interface {
+	// Erase erases a user's personal data in a single transaction.
+	//
+	// Rows referencing the user are handled according to the ON DELETE
+	// action of their foreign key.  If a NO ACTION or RESTRICT reference
+	// would prevent the user from being deleted the user is anonymised
+	// instead and the ON DELETE action of every other reference is applied
+	// as PostgreSQL would, otherwise the user is deleted and PostgreSQL
+	// cascades the deletion.  Either way the user's audit events are redacted, their
+	// outbox events are stripped of personal data, their failed logins are
+	// forgotten and invites and magic links for their email address are
+	// deleted.
+	Erase(ctx context.Context, u *user.User) error
+
+	// AvatarUsed returns true if any user has the avatar.
+	AvatarUsed(ctx context.Context, hash string) (bool, error)
+}
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db         db.Querier
+	references []db.ForeignKey
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store that erases users from the "users" table, and the
+// tables with the foreign keys referencing it, see db.Schema.References.
+func NewStore(q db.Querier, references []db.ForeignKey) Store {
+	return &store{db: q, references: references}
+}
+
+func (s *store) Erase(ctx context.Context, u *user.User) error {
+	return s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		restricted, err := s.restricted(ctx, tx, u)
+		if err != nil {
+			return err
+		}
+		if err := audit.NewStore(tx).Redact(ctx, u.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			ctx,
+			"UPDATE outbox SET payload = payload - $2::text[] WHERE (topic LIKE 'user.%' AND payload->>'id' = $1) OR payload->>'user_id' = $1",
+			u.ID.String(), personalKeys,
+		); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM login_failures WHERE key = $1", lockout.AccountKey(u.Email)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM invites WHERE email = $1", u.Email); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM magic_links WHERE user_id = $1 OR email = $2", u.ID, u.Email); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM organization_invitations WHERE email = $1", u.Email); err != nil {
+			return err
+		}
+		if !restricted {
+			return user.NewStore(tx).Delete(ctx, u.ID)
+		}
+		if err := s.cascade(ctx, tx, u); err != nil {
+			return err
+		}
+		tag, err := tx.Exec(
+			ctx,
+			"UPDATE users SET email = $2, password = NULL, confirmed = false, locked = true, avatar = NULL, last_login_at = NULL, delete_after = NULL, updated_at = now() WHERE id = $1",
+			u.ID, "deleted+"+u.ID.String()+"@invalid",
+		)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() < 1 {
+			return user.ErrNotFound
+		}
+		_, err = outbox.Enqueue(ctx, tx, user.EventDeleted, map[string]snowflake.Snowflake{"id": u.ID})
+		return err
+	})
+}
+
+// restricted returns true if a row references the user through a foreign
+// key whose ON DELETE action prevents the user from being deleted.  CASCADE,
+// SET NULL and SET DEFAULT references are left for PostgreSQL to apply.
+func (s *store) restricted(ctx context.Context, tx pgx.Tx, u *user.User) (bool, error) {
+	for _, r := range s.references {
+		if r.OnDelete != db.NoAction && r.OnDelete != db.Restrict {
+			continue
+		}
+		var exists bool
+		if err := tx.QueryRow(
+			ctx,
+			"SELECT EXISTS (SELECT 1 FROM "+pgx.Identifier{r.Table}.Sanitize()+" WHERE "+pgx.Identifier{r.Column}.Sanitize()+" = $1)",
+			u.ID,
+		).Scan(&exists); err != nil {
+			return false, err
+		}
+		if exists {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cascade applies the ON DELETE action of the CASCADE, SET NULL and SET
+// DEFAULT references to a user that is anonymised rather than deleted.
+func (s *store) cascade(ctx context.Context, tx pgx.Tx, u *user.User) error {
+	for _, r := range s.references {
+		table, column := pgx.Identifier{r.Table}.Sanitize(), pgx.Identifier{r.Column}.Sanitize()
+		var sql string
+		switch r.OnDelete {
+		case db.Cascade:
+			sql = "DELETE FROM " + table + " WHERE " + column + " = $1"
+		case db.SetNull:
+			sql = "UPDATE " + table + " SET " + column + " = NULL WHERE " + column + " = $1"
+		case db.SetDefault:
+			sql = "UPDATE " + table + " SET " + column + " = DEFAULT WHERE " + column + " = $1"
+		default:
+			continue
+		}
+		if _, err := tx.Exec(ctx, sql, u.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *store) AvatarUsed(ctx context.Context, hash string) (bool, error) {
+	var used bool
+	if err := s.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE avatar = $1)", hash).Scan(&used); err != nil {
+		return false, err
+	}
+	return used, nil
+}
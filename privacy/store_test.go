@@ -0,0 +1,88 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package privacy_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/db/dbtest"
+	"github.com/matthewpi/cosmos/internal/db/migrations"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/privacy"
+	"github.com/matthewpi/cosmos/user"
+)
+
+func TestStore_Erase_Anonymise(t *testing.T) {
+	schema, err := db.Inspect(migrations.Migrations())
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	u := &user.User{ID: snowflake.New(), Email: "owner@example.com"}
+
+	// The user owns an organization, preventing them from being deleted.
+	q := dbtest.New()
+	q.Handle("SELECT EXISTS", func(dbtest.Statement) ([]dbtest.Row, error) {
+		return []dbtest.Row{{"exists": false}}, nil
+	})
+	q.Handle(`SELECT EXISTS (SELECT 1 FROM "organizations"`, func(dbtest.Statement) ([]dbtest.Row, error) {
+		return []dbtest.Row{{"exists": true}}, nil
+	})
+	q.Handle("UPDATE users SET email", func(s dbtest.Statement) ([]dbtest.Row, error) {
+		return []dbtest.Row{{"id": s.Args[0]}}, nil
+	})
+	if err := privacy.NewStore(q, schema.References("users")).Erase(context.Background(), u); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	if s := q.Statements("DELETE FROM users"); len(s) != 0 {
+		t.Errorf("Expected the user not to be deleted, but got \"%v\"", s)
+	}
+	if s := q.Statements("UPDATE users SET email"); len(s) != 1 {
+		t.Errorf("Expected the user to be anonymised, but got \"%v\"", s)
+	}
+	for i, tc := range []struct {
+		SQL      string
+		Executed bool
+	}{
+		{SQL: `DELETE FROM "sessions" WHERE "user_id" = $1`, Executed: true},
+		{SQL: `DELETE FROM "devices" WHERE "user_id" = $1`, Executed: true},
+		{SQL: `DELETE FROM "oidc_identities" WHERE "user_id" = $1`, Executed: true},
+		{SQL: `DELETE FROM "access_tokens" WHERE "user_id" = $1`, Executed: true},
+		{SQL: `DELETE FROM "passkeys" WHERE "user_id" = $1`, Executed: true},
+		{SQL: `DELETE FROM "totp_secrets" WHERE "user_id" = $1`, Executed: true},
+		{SQL: `DELETE FROM "recovery_codes" WHERE "user_id" = $1`, Executed: true},
+		{SQL: `DELETE FROM "tokens" WHERE "user_id" = $1`, Executed: true},
+		{SQL: `DELETE FROM "memberships" WHERE "user_id" = $1`, Executed: true},
+		{SQL: `UPDATE "users" SET "invited_by" = NULL WHERE "invited_by" = $1`, Executed: true},
+		{SQL: `UPDATE "invites" SET "created_by" = NULL WHERE "created_by" = $1`, Executed: true},
+		// RESTRICT references are left in place.
+		{SQL: `FROM "organizations"`, Executed: false},
+	} {
+		s := q.Statements(tc.SQL)
+		if executed := len(s) == 1 && s[0].Args[0] == u.ID; executed != tc.Executed {
+			t.Errorf("Test #%d: Expected \"%s\" to be executed: \"%t\", but got \"%v\"", i, tc.SQL, tc.Executed, s)
+		}
+	}
+}
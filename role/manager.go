@@ -0,0 +1,235 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package role
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// Permissions required to manage roles.
+const (
+	PermissionRead   Permission = "roles.read"
+	PermissionCreate Permission = "roles.create"
+	PermissionUpdate Permission = "roles.update"
+	PermissionDelete Permission = "roles.delete"
+	PermissionAssign Permission = "roles.assign"
+)
+
+var (
+	// ErrInvalid is returned when a role fails validation.
+	ErrInvalid = errors.New("role: invalid role")
+
+	// ErrForbidden is returned when the actor lacks the permission required
+	// for an operation.
+	ErrForbidden = errors.New("role: missing permission")
+
+	// ErrInsufficientRank is returned when the actor attempts to manage a
+	// role that does not rank below their own.
+	ErrInsufficientRank = errors.New("role: role must rank below your own")
+
+	// ErrEscalation is returned when the actor attempts to grant a permission
+	// that their own role does not have.
+	ErrEscalation = errors.New("role: cannot grant permissions you do not have")
+
+	// ErrLastAdministrator is returned when an operation would leave no
+	// administrator roles.
+	ErrLastAdministrator = errors.New("role: cannot remove the last administrator role")
+)
+
+// maxNameLength is the maximum length of a role's name.
+const maxNameLength = 32
+
+// Manager manages roles while enforcing the role hierarchy.
+//
+// Every operation is performed on behalf of an actor, the role of the user
+// performing the operation.  An actor may only create, update, delete and
+// assign roles that rank below their own, and may only grant permissions
+// their own role has.
+type Manager struct {
+	store Store
+}
+
+// NewManager returns a new Manager.
+func NewManager(s Store) *Manager {
+	return &Manager{store: s}
+}
+
+// All returns every role.
+func (m *Manager) All(ctx context.Context, actor *Role) ([]*Role, error) {
+	if !actor.Can(PermissionRead) {
+		return nil, ErrForbidden
+	}
+	return m.store.All(ctx)
+}
+
+// ByID returns a single role.
+func (m *Manager) ByID(ctx context.Context, actor *Role, id snowflake.Snowflake) (*Role, error) {
+	if !actor.Can(PermissionRead) {
+		return nil, ErrForbidden
+	}
+	return m.store.ByID(ctx, id)
+}
+
+// Create creates a new role, assigning it a new ID.
+func (m *Manager) Create(ctx context.Context, actor *Role, r *Role) error {
+	if !actor.Can(PermissionCreate) {
+		return ErrForbidden
+	}
+	if err := r.validate(); err != nil {
+		return err
+	}
+	if err := checkGrant(actor, r); err != nil {
+		return err
+	}
+	r.ID = snowflake.New()
+	return m.store.Create(ctx, r)
+}
+
+// Update replaces an existing role with r.
+func (m *Manager) Update(ctx context.Context, actor *Role, r *Role) error {
+	if !actor.Can(PermissionUpdate) {
+		return ErrForbidden
+	}
+	if err := r.validate(); err != nil {
+		return err
+	}
+	return m.store.Tx(ctx, func(s Store) error {
+		current, err := s.ByID(ctx, r.ID)
+		if err != nil {
+			return err
+		}
+		if !actor.Outranks(current) {
+			return ErrInsufficientRank
+		}
+		if err := checkGrant(actor, r); err != nil {
+			return err
+		}
+		if current.IsAdministrator() && !r.IsAdministrator() {
+			if err := checkNotLastAdministrator(ctx, s); err != nil {
+				return err
+			}
+		}
+		return s.Update(ctx, r)
+	})
+}
+
+// Delete deletes a role.
+func (m *Manager) Delete(ctx context.Context, actor *Role, id snowflake.Snowflake) error {
+	if !actor.Can(PermissionDelete) {
+		return ErrForbidden
+	}
+	return m.store.Tx(ctx, func(s Store) error {
+		current, err := s.ByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !actor.Outranks(current) {
+			return ErrInsufficientRank
+		}
+		if current.IsAdministrator() {
+			if err := checkNotLastAdministrator(ctx, s); err != nil {
+				return err
+			}
+		}
+		return s.Delete(ctx, id)
+	})
+}
+
+// Assign changes the role of a user from current to the role identified by
+// roleID, if roleID is snowflake.Nil the user's role is removed.
+//
+// The actor must outrank both the user's current role and the new role.
+func (m *Manager) Assign(ctx context.Context, actor *Role, userID snowflake.Snowflake, current *Role, roleID snowflake.Snowflake) error {
+	if !actor.Can(PermissionAssign) {
+		return ErrForbidden
+	}
+	if current != nil && !actor.Outranks(current) {
+		return ErrInsufficientRank
+	}
+	return m.store.Tx(ctx, func(s Store) error {
+		if roleID.Valid() {
+			next, err := s.ByID(ctx, roleID)
+			if err != nil {
+				return err
+			}
+			if !actor.Outranks(next) {
+				return ErrInsufficientRank
+			}
+		}
+		return s.Assign(ctx, userID, roleID)
+	})
+}
+
+// validate validates the user provided fields of a role.
+func (r *Role) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("%w: name must not be empty", ErrInvalid)
+	}
+	if len(r.Name) > maxNameLength {
+		return fmt.Errorf("%w: name must not exceed %d characters", ErrInvalid, maxNameLength)
+	}
+	if r.SortID < 0 {
+		return fmt.Errorf("%w: sort_id must not be negative", ErrInvalid)
+	}
+	for _, p := range r.Permissions {
+		if !p.Valid() {
+			return fmt.Errorf("%w: malformed permission \"%s\"", ErrInvalid, p)
+		}
+	}
+	return nil
+}
+
+// checkGrant ensures the actor is allowed to place r in the hierarchy with its
+// set of permissions.
+func checkGrant(actor, r *Role) error {
+	if !actor.Outranks(r) {
+		return ErrInsufficientRank
+	}
+	if !actor.Permissions.Covers(r.Permissions) {
+		return ErrEscalation
+	}
+	return nil
+}
+
+// checkNotLastAdministrator returns ErrLastAdministrator unless there is more
+// than one administrator role.
+func checkNotLastAdministrator(ctx context.Context, s Store) error {
+	roles, err := s.All(ctx)
+	if err != nil {
+		return err
+	}
+	var n int
+	for _, r := range roles {
+		if r.IsAdministrator() {
+			n++
+		}
+	}
+	if n < 2 {
+		return ErrLastAdministrator
+	}
+	return nil
+}
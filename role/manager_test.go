@@ -0,0 +1,271 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package role_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+)
+
+// memoryStore is an in-memory role.Store used for testing.
+type memoryStore struct {
+	mu    sync.Mutex
+	roles map[snowflake.Snowflake]*role.Role
+	users map[snowflake.Snowflake]snowflake.Snowflake
+}
+
+func newMemoryStore(roles ...*role.Role) *memoryStore {
+	s := &memoryStore{
+		roles: make(map[snowflake.Snowflake]*role.Role),
+		users: make(map[snowflake.Snowflake]snowflake.Snowflake),
+	}
+	for _, r := range roles {
+		s.roles[r.ID] = r
+	}
+	return s
+}
+
+func (s *memoryStore) All(context.Context) ([]*role.Role, error) {
+	var roles []*role.Role
+	for _, r := range s.roles {
+		roles = append(roles, r)
+	}
+	return roles, nil
+}
+
+func (s *memoryStore) ByID(_ context.Context, id snowflake.Snowflake) (*role.Role, error) {
+	r, ok := s.roles[id]
+	if !ok {
+		return nil, role.ErrNotFound
+	}
+	v := *r
+	return &v, nil
+}
+
+func (s *memoryStore) Create(_ context.Context, r *role.Role) error {
+	s.roles[r.ID] = r
+	return nil
+}
+
+func (s *memoryStore) Update(_ context.Context, r *role.Role) error {
+	if _, ok := s.roles[r.ID]; !ok {
+		return role.ErrNotFound
+	}
+	s.roles[r.ID] = r
+	return nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, id snowflake.Snowflake) error {
+	if _, ok := s.roles[id]; !ok {
+		return role.ErrNotFound
+	}
+	delete(s.roles, id)
+	return nil
+}
+
+func (s *memoryStore) Assign(_ context.Context, userID, roleID snowflake.Snowflake) error {
+	s.users[userID] = roleID
+	return nil
+}
+
+func (s *memoryStore) Tx(_ context.Context, f func(role.Store) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return f(s)
+}
+
+var (
+	owner = &role.Role{
+		ID:          1,
+		Name:        "Owner",
+		Permissions: role.Permissions{role.All},
+		SortID:      0,
+	}
+	admin = &role.Role{
+		ID:          2,
+		Name:        "Administrator",
+		Permissions: role.Permissions{role.All},
+		SortID:      1,
+	}
+	moderator = &role.Role{
+		ID:          3,
+		Name:        "Moderator",
+		Permissions: role.Permissions{"roles.*", "users.read"},
+		SortID:      2,
+	}
+	member = &role.Role{
+		ID:          4,
+		Name:        "Member",
+		Permissions: role.Permissions{"users.read"},
+		SortID:      3,
+	}
+)
+
+func newTestManager() (*role.Manager, *memoryStore) {
+	s := newMemoryStore(owner, admin, moderator, member)
+	return role.NewManager(s), s
+}
+
+func TestManager_Create(t *testing.T) {
+	for i, tc := range []struct {
+		actor     *role.Role
+		role      *role.Role
+		expectErr error
+	}{
+		{
+			actor:     moderator,
+			role:      &role.Role{Name: "Helper", Permissions: role.Permissions{"users.read"}, SortID: 5},
+			expectErr: nil,
+		},
+		{
+			actor:     moderator,
+			role:      &role.Role{Name: "Helper", Permissions: role.Permissions{"users.read"}, SortID: 2},
+			expectErr: role.ErrInsufficientRank,
+		},
+		{
+			actor:     moderator,
+			role:      &role.Role{Name: "Helper", Permissions: role.Permissions{"users.update"}, SortID: 5},
+			expectErr: role.ErrEscalation,
+		},
+		{
+			actor:     member,
+			role:      &role.Role{Name: "Helper", SortID: 5},
+			expectErr: role.ErrForbidden,
+		},
+		{
+			actor:     nil,
+			role:      &role.Role{Name: "Helper", SortID: 5},
+			expectErr: role.ErrForbidden,
+		},
+		{
+			actor:     owner,
+			role:      &role.Role{Name: "", SortID: 5},
+			expectErr: role.ErrInvalid,
+		},
+	} {
+		m, _ := newTestManager()
+		err := m.Create(context.Background(), tc.actor, tc.role)
+
+		if !errors.Is(err, tc.expectErr) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.expectErr, err)
+			continue
+		}
+		if err == nil && !tc.role.ID.Valid() {
+			t.Errorf("Test #%d: Expected role to be assigned an ID", i)
+			continue
+		}
+	}
+}
+
+func TestManager_Update(t *testing.T) {
+	for i, tc := range []struct {
+		actor     *role.Role
+		role      role.Role
+		expectErr error
+	}{
+		{
+			actor:     moderator,
+			role:      role.Role{ID: 4, Name: "Members", Permissions: role.Permissions{"users.read"}, SortID: 3},
+			expectErr: nil,
+		},
+		{
+			actor:     moderator,
+			role:      role.Role{ID: 4, Name: "Member", Permissions: role.Permissions{"users.read"}, SortID: 1},
+			expectErr: role.ErrInsufficientRank,
+		},
+		{
+			actor:     moderator,
+			role:      role.Role{ID: 3, Name: "Moderator", Permissions: role.Permissions{"roles.*"}, SortID: 2},
+			expectErr: role.ErrInsufficientRank,
+		},
+		{
+			actor:     owner,
+			role:      role.Role{ID: 2, Name: "Administrator", Permissions: role.Permissions{"users.*"}, SortID: 1},
+			expectErr: nil,
+		},
+		{
+			actor:     owner,
+			role:      role.Role{ID: 5, Name: "Unknown", SortID: 5},
+			expectErr: role.ErrNotFound,
+		},
+	} {
+		m, _ := newTestManager()
+		r := tc.role
+		err := m.Update(context.Background(), tc.actor, &r)
+
+		if !errors.Is(err, tc.expectErr) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.expectErr, err)
+			continue
+		}
+	}
+}
+
+func TestManager_Delete(t *testing.T) {
+	m, s := newTestManager()
+	ctx := context.Background()
+
+	if err := m.Delete(ctx, moderator, moderator.ID); !errors.Is(err, role.ErrInsufficientRank) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", role.ErrInsufficientRank, err)
+	}
+	if err := m.Delete(ctx, moderator, member.ID); err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+	}
+	if err := m.Delete(ctx, owner, admin.ID); err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+	}
+
+	// Demote the owner role so it is no longer at the top of the hierarchy,
+	// then attempt to delete it while it is the only administrator role.
+	s.roles[owner.ID] = &role.Role{ID: owner.ID, Name: owner.Name, Permissions: owner.Permissions, SortID: 10}
+	super := &role.Role{Permissions: role.Permissions{role.All}, SortID: -1}
+	if err := m.Delete(ctx, super, owner.ID); !errors.Is(err, role.ErrLastAdministrator) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", role.ErrLastAdministrator, err)
+	}
+}
+
+func TestManager_Assign(t *testing.T) {
+	m, s := newTestManager()
+	ctx := context.Background()
+	userID := snowflake.Snowflake(100)
+
+	if err := m.Assign(ctx, moderator, userID, nil, member.ID); err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+	}
+	if s.users[userID] != member.ID {
+		t.Errorf("Expected user to be assigned \"%s\", but got \"%s\"", member.ID, s.users[userID])
+	}
+	if err := m.Assign(ctx, moderator, userID, member, moderator.ID); !errors.Is(err, role.ErrInsufficientRank) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", role.ErrInsufficientRank, err)
+	}
+	if err := m.Assign(ctx, moderator, userID, admin, snowflake.Nil); !errors.Is(err, role.ErrInsufficientRank) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", role.ErrInsufficientRank, err)
+	}
+	if err := m.Assign(ctx, moderator, userID, member, snowflake.Nil); err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+	}
+}
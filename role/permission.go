@@ -0,0 +1,170 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package role
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Permission is a dotted permission name, for example "users.read".
+//
+// When granted to a Role a permission may contain wildcards; a "*" segment
+// matches exactly one segment, unless it is the final segment in which case it
+// matches one or more remaining segments.  For example "users.*" matches both
+// "users.read" and "users.roles.assign", but not "users".  The permission "*"
+// matches every permission.
+type Permission string
+
+const (
+	// All grants every permission.
+	All Permission = "*"
+)
+
+// Valid returns true if the permission is well-formed, that is, it consists of
+// one or more non-empty segments made up of lowercase letters, digits,
+// underscores and hyphens, or a single "*".
+func (p Permission) Valid() bool {
+	if p == "" {
+		return false
+	}
+	for _, s := range strings.Split(string(p), ".") {
+		if s == "*" {
+			continue
+		}
+		if s == "" {
+			return false
+		}
+		for _, c := range s {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Matches returns true if p, as a granted permission, matches the requested
+// permission.
+//
+// The requested permission may itself contain wildcards, in which case Matches
+// returns true only if every permission matched by the request is also matched
+// by p, for example "users.*" matches "users.*.read", but not the other way
+// around.
+func (p Permission) Matches(requested Permission) bool {
+	granted := strings.Split(string(p), ".")
+	want := strings.Split(string(requested), ".")
+	for i, g := range granted {
+		if i >= len(want) {
+			return false
+		}
+		if g == "*" {
+			if i == len(granted)-1 {
+				return true
+			}
+			continue
+		}
+		if g != want[i] {
+			return false
+		}
+	}
+	return len(granted) == len(want)
+}
+
+// Permissions is a set of permissions granted to a Role.
+type Permissions []Permission
+
+var (
+	_ json.Unmarshaler = (*Permissions)(nil)
+	_ sql.Scanner      = (*Permissions)(nil)
+	_ driver.Valuer    = (*Permissions)(nil)
+)
+
+// Has returns true if any of the permissions match the requested permission.
+func (ps Permissions) Has(requested Permission) bool {
+	for _, p := range ps {
+		if p.Matches(requested) {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers returns true if every permission in other is matched by ps.
+func (ps Permissions) Covers(other Permissions) bool {
+	for _, p := range other {
+		if !ps.Has(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate returns an error if any of the permissions are malformed.
+func (ps Permissions) Validate() error {
+	for _, p := range ps {
+		if !p.Valid() {
+			return fmt.Errorf("role: invalid permission \"%s\"", p)
+		}
+	}
+	return nil
+}
+
+// UnmarshalJSON satisfies json.Unmarshaler, malformed permissions are rejected.
+func (ps *Permissions) UnmarshalJSON(v []byte) error {
+	var s []Permission
+	if err := json.Unmarshal(v, &s); err != nil {
+		return err
+	}
+	if err := Permissions(s).Validate(); err != nil {
+		return err
+	}
+	*ps = s
+	return nil
+}
+
+// Scan satisfies sql.Scanner.
+func (ps *Permissions) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*ps = nil
+		return nil
+	case string:
+		return ps.UnmarshalJSON([]byte(v))
+	case []byte:
+		return ps.UnmarshalJSON(v)
+	default:
+		return fmt.Errorf("role: cannot scan permissions of type %T", src)
+	}
+}
+
+// Value satisfies driver.Valuer.
+func (ps Permissions) Value() (driver.Value, error) {
+	if ps == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]Permission(ps))
+}
@@ -0,0 +1,157 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package role_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/matthewpi/cosmos/role"
+)
+
+func TestPermission_Valid(t *testing.T) {
+	for i, tc := range []struct {
+		permission role.Permission
+		expect     bool
+	}{
+		{permission: "users", expect: true},
+		{permission: "users.read", expect: true},
+		{permission: "users.*", expect: true},
+		{permission: "*", expect: true},
+		{permission: "users.*.read", expect: true},
+		{permission: "audit_log.read-all", expect: true},
+		{permission: "", expect: false},
+		{permission: "users.", expect: false},
+		{permission: ".users", expect: false},
+		{permission: "users..read", expect: false},
+		{permission: "Users.Read", expect: false},
+		{permission: "users.re*d", expect: false},
+	} {
+		result := tc.permission.Valid()
+
+		if tc.expect != result {
+			t.Errorf("Test #%d: Expected \"%t\", but got \"%t\"", i, tc.expect, result)
+			continue
+		}
+	}
+}
+
+func TestPermission_Matches(t *testing.T) {
+	for i, tc := range []struct {
+		granted   role.Permission
+		requested role.Permission
+		expect    bool
+	}{
+		{granted: "users.read", requested: "users.read", expect: true},
+		{granted: "users.read", requested: "users.update", expect: false},
+		{granted: "users.read", requested: "users", expect: false},
+		{granted: "users.read", requested: "users.read.all", expect: false},
+		{granted: "users.*", requested: "users.read", expect: true},
+		{granted: "users.*", requested: "users.roles.assign", expect: true},
+		{granted: "users.*", requested: "users", expect: false},
+		{granted: "users.*", requested: "roles.read", expect: false},
+		{granted: "users.*.read", requested: "users.roles.read", expect: true},
+		{granted: "users.*.read", requested: "users.roles.update", expect: false},
+		{granted: "users.*.read", requested: "users.a.b.read", expect: false},
+		{granted: "*", requested: "users.read", expect: true},
+		{granted: "*", requested: "users", expect: true},
+		{granted: "users.read", requested: "users.*", expect: false},
+		{granted: "users.*", requested: "users.*", expect: true},
+		{granted: "users.*", requested: "users.*.read", expect: true},
+		{granted: "users.*.read", requested: "users.*", expect: false},
+		{granted: "*", requested: "*", expect: true},
+		{granted: "users.*", requested: "*", expect: false},
+	} {
+		result := tc.granted.Matches(tc.requested)
+
+		if tc.expect != result {
+			t.Errorf("Test #%d: Expected \"%s\" matching \"%s\" to be \"%t\", but got \"%t\"", i, tc.granted, tc.requested, tc.expect, result)
+			continue
+		}
+	}
+}
+
+func TestRole_Can(t *testing.T) {
+	r := &role.Role{
+		Permissions: role.Permissions{"users.read", "roles.*"},
+	}
+	if !r.Can("users.read") {
+		t.Errorf("Expected role to have \"users.read\"")
+	}
+	if !r.Can("roles.update") {
+		t.Errorf("Expected role to have \"roles.update\"")
+	}
+	if r.Can("users.update") {
+		t.Errorf("Expected role to not have \"users.update\"")
+	}
+
+	var nilRole *role.Role
+	if nilRole.Can("users.read") {
+		t.Errorf("Expected nil role to not have any permissions")
+	}
+}
+
+func TestRole_Restrict(t *testing.T) {
+	r := &role.Role{
+		Name:        "Support",
+		Permissions: role.Permissions{"users.read", "roles.*", "audit_log.read"},
+	}
+	restricted := r.Restrict(role.Permissions{"users.*", "roles.read", "avatars.update"})
+	for i, tc := range []struct {
+		Permission role.Permission
+		Expect     bool
+	}{
+		{Permission: "users.read", Expect: true},
+		{Permission: "users.update", Expect: false},
+		{Permission: "roles.read", Expect: true},
+		{Permission: "roles.update", Expect: false},
+		{Permission: "audit_log.read", Expect: false},
+		{Permission: "avatars.update", Expect: false},
+	} {
+		if got := restricted.Can(tc.Permission); got != tc.Expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, got)
+		}
+	}
+	if restricted.Name != r.Name || len(r.Permissions) != 3 {
+		t.Errorf("Expected a restricted copy of the role, but got \"%v\"", restricted)
+	}
+
+	var nilRole *role.Role
+	if nilRole.Restrict(role.Permissions{role.All}) != nil {
+		t.Errorf("Expected nil role to stay nil")
+	}
+}
+
+func TestPermissions_UnmarshalJSON(t *testing.T) {
+	var ps role.Permissions
+	if err := json.Unmarshal([]byte(`["users.read","roles.*"]`), &ps); err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+	}
+	if len(ps) != 2 {
+		t.Errorf("Expected 2 permissions, but got %d", len(ps))
+	}
+
+	if err := json.Unmarshal([]byte(`["users..read"]`), &ps); err == nil {
+		t.Errorf("Expected error return value, but got \"%v\"", err)
+	}
+}
@@ -0,0 +1,100 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package role provides roles, which grant a set of permissions to users.
+package role
+
+import (
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// Role represents a named set of permissions.
+type Role struct {
+	// ID is the role's unique identifier.
+	ID snowflake.Snowflake `json:"id"`
+
+	// Name is the role's name. (unique)
+	Name string `json:"name"`
+
+	// Description is a human-readable description of the role.
+	Description string `json:"description"`
+
+	// Permissions is the set of permissions granted by the role.
+	Permissions Permissions `json:"permissions"`
+
+	// SortID is the role's position in the hierarchy, roles with a lower
+	// SortID rank above roles with a higher SortID.
+	SortID int `json:"sort_id"`
+}
+
+// Can returns true if the role grants the requested permission.  A nil Role
+// does not grant any permissions.
+func (r *Role) Can(p Permission) bool {
+	if r == nil {
+		return false
+	}
+	return r.Permissions.Has(p)
+}
+
+// Restrict returns a copy of the role that only grants the permissions that
+// are granted by both the role and scopes, used to narrow a user's role to
+// the scopes of a credential.  A nil Role stays nil.
+//
+// Restrict is conservative, a permission is kept if it is granted by the role
+// and falls under one of the scopes, or is one of the scopes and falls under
+// the role.  Permissions that both only partially overlap, such as
+// "users.*.read" and "users.roles.*", are not granted.
+func (r *Role) Restrict(scopes Permissions) *Role {
+	if r == nil {
+		return nil
+	}
+	v := *r
+	v.Permissions = Permissions{}
+	for _, p := range r.Permissions {
+		if scopes.Has(p) {
+			v.Permissions = append(v.Permissions, p)
+		}
+	}
+	for _, s := range scopes {
+		if r.Permissions.Has(s) && !v.Permissions.Has(s) {
+			v.Permissions = append(v.Permissions, s)
+		}
+	}
+	return &v
+}
+
+// Outranks returns true if r ranks above o in the role hierarchy.  Any role
+// outranks a nil Role, while a nil Role outranks nothing.
+func (r *Role) Outranks(o *Role) bool {
+	if r == nil {
+		return false
+	}
+	if o == nil {
+		return true
+	}
+	return r.SortID < o.SortID
+}
+
+// IsAdministrator returns true if the role grants every permission.
+func (r *Role) IsAdministrator() bool {
+	return r.Can(All)
+}
@@ -0,0 +1,179 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package role
+
+import (
+	"context"
+	"errors"
+
+	"github.com/matthewpi/pgconn"
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+var (
+	// ErrNotFound is returned when a Role does not exist.
+	ErrNotFound = errors.New("role: not found")
+
+	// ErrNameTaken is returned when a Role's name is already in use.
+	ErrNameTaken = errors.New("role: name is already in use")
+)
+
+// Store persists Roles.
+type Store interface {
+	// All returns every role, ordered by their position in the hierarchy.
+	All(ctx context.Context) ([]*Role, error)
+
+	// ByID returns the Role with the given ID.
+	ByID(ctx context.Context, id snowflake.Snowflake) (*Role, error)
+
+	// Create stores a new Role.
+	Create(ctx context.Context, r *Role) error
+
+	// Update updates an existing Role.
+	Update(ctx context.Context, r *Role) error
+
+	// Delete deletes a Role, users with the role are left without one.
+	Delete(ctx context.Context, id snowflake.Snowflake) error
+
+	// Assign sets the role of a user, if roleID is snowflake.Nil the user's
+	// role is removed.
+	Assign(ctx context.Context, userID, roleID snowflake.Snowflake) error
+
+	// Tx calls f with a Store that runs every operation in a single
+	// transaction, while preventing concurrent changes to any roles.
+	Tx(ctx context.Context, f func(Store) error) error
+}
+
+// selectRoles selects the columns expected by scan.
+const selectRoles = "SELECT id, name, description, permissions, sort_id FROM roles"
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db db.Querier
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "roles" table.
+func NewStore(q db.Querier) Store {
+	return &store{db: q}
+}
+
+func (s *store) All(ctx context.Context) ([]*Role, error) {
+	rows, err := s.db.Query(ctx, selectRoles+" ORDER BY sort_id, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*Role
+	for rows.Next() {
+		r, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+func (s *store) ByID(ctx context.Context, id snowflake.Snowflake) (*Role, error) {
+	return scan(s.db.QueryRow(ctx, selectRoles+" WHERE id = $1", id))
+}
+
+func (s *store) Create(ctx context.Context, r *Role) error {
+	_, err := s.db.Exec(
+		ctx,
+		"INSERT INTO roles (id, name, description, permissions, sort_id) VALUES ($1, $2, $3, $4, $5)",
+		r.ID, r.Name, r.Description, r.Permissions, r.SortID,
+	)
+	return mapError(err)
+}
+
+func (s *store) Update(ctx context.Context, r *Role) error {
+	tag, err := s.db.Exec(
+		ctx,
+		"UPDATE roles SET name = $2, description = $3, permissions = $4, sort_id = $5 WHERE id = $1",
+		r.ID, r.Name, r.Description, r.Permissions, r.SortID,
+	)
+	if err != nil {
+		return mapError(err)
+	}
+	if tag.RowsAffected() < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *store) Delete(ctx context.Context, id snowflake.Snowflake) error {
+	tag, err := s.db.Exec(ctx, "DELETE FROM roles WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *store) Assign(ctx context.Context, userID, roleID snowflake.Snowflake) error {
+	_, err := s.db.Exec(ctx, "UPDATE users SET role_id = $2, updated_at = now() WHERE id = $1", userID, roleID)
+	return err
+}
+
+func (s *store) Tx(ctx context.Context, f func(Store) error) error {
+	return s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "LOCK TABLE roles IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			return err
+		}
+		return f(&store{db: tx})
+	})
+}
+
+// scan scans a row selected by selectRoles into a Role.
+func scan(row pgx.Row) (*Role, error) {
+	r := &Role{}
+	var description *string
+	if err := row.Scan(&r.ID, &r.Name, &description, &r.Permissions, &r.SortID); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if description != nil {
+		r.Description = *description
+	}
+	return r, nil
+}
+
+// mapError maps constraint violations to their respective errors.
+func mapError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrNameTaken
+	}
+	return err
+}
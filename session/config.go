@@ -0,0 +1,129 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/config/lexer"
+)
+
+// Config represents the configuration for sessions.
+type Config struct {
+	// CookieName is the name of the cookie the session token is stored in.
+	CookieName string `json:"cookie_name"`
+
+	// IdleTimeout is how long a session may go unused before it expires.
+	IdleTimeout time.Duration `json:"idle_timeout"`
+
+	// AbsoluteTimeout is how long a session lasts, regardless of activity.
+	AbsoluteTimeout time.Duration `json:"absolute_timeout"`
+
+	// ImpersonationTimeout is how long a session impersonating another user
+	// lasts, regardless of activity.
+	ImpersonationTimeout time.Duration `json:"impersonation_timeout"`
+
+	// Secure controls if the cookie is only sent over HTTPS, this should
+	// only ever be disabled for local development.
+	Secure bool `json:"secure"`
+
+	// SameSite is the SameSite attribute of the cookie.
+	SameSite http.SameSite `json:"same_site"`
+}
+
+// DefaultConfig returns the default session configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		CookieName:           "cosmos_session",
+		IdleTimeout:          24 * time.Hour,
+		AbsoluteTimeout:      30 * 24 * time.Hour,
+		ImpersonationTimeout: 15 * time.Minute,
+		Secure:               true,
+		SameSite:             http.SameSiteLaxMode,
+	}
+}
+
+// FromLexer .
+func FromLexer(b lexer.Block) (*Config, error) {
+	c := DefaultConfig()
+	for _, s := range b.Segments {
+		d := s.Directive()
+		switch d {
+		case "cookie_name":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after cookie_name directive")
+			}
+			c.CookieName = s[1].Text
+		case "idle_timeout", "absolute_timeout", "impersonation_timeout":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after %s directive", d)
+			}
+			v, err := time.ParseDuration(s[1].Text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", d, err)
+			}
+			if v <= 0 {
+				return nil, fmt.Errorf("%s must be positive", d)
+			}
+			switch d {
+			case "idle_timeout":
+				c.IdleTimeout = v
+			case "absolute_timeout":
+				c.AbsoluteTimeout = v
+			case "impersonation_timeout":
+				c.ImpersonationTimeout = v
+			}
+		case "insecure":
+			if len(s) != 1 {
+				return nil, fmt.Errorf("unexpected argument after insecure directive")
+			}
+			c.Secure = false
+		case "same_site":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after same_site directive")
+			}
+			switch v := strings.ToLower(s[1].Text); v {
+			case "lax":
+				c.SameSite = http.SameSiteLaxMode
+			case "strict":
+				c.SameSite = http.SameSiteStrictMode
+			case "none":
+				c.SameSite = http.SameSiteNoneMode
+			default:
+				return nil, fmt.Errorf("unknown same_site mode: \"%s\"", v)
+			}
+		default:
+			return nil, fmt.Errorf("unknown directive: \"" + d + "\"")
+		}
+	}
+	if c.IdleTimeout > c.AbsoluteTimeout {
+		return nil, fmt.Errorf("idle_timeout must not be longer than absolute_timeout")
+	}
+	if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		return nil, fmt.Errorf("same_site none requires a secure cookie")
+	}
+	return c, nil
+}
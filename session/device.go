@@ -0,0 +1,83 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/useragent"
+)
+
+// EventNewDevice is the topic of the outbox event enqueued when a user logs
+// in from a device they have not logged in from before.
+const EventNewDevice = "session.new_device"
+
+// maxUserAgent is the maximum length of a stored User-Agent header.
+const maxUserAgent = 512
+
+// maxIP is the maximum length of a stored IP address.
+const maxIP = 45
+
+// NewDeviceEvent is the payload of EventNewDevice events.
+type NewDeviceEvent struct {
+	// UserID is the ID of the user who logged in.
+	UserID snowflake.Snowflake `json:"user_id"`
+
+	// Device is the browser and operating system of the device.
+	Device useragent.Agent `json:"device"`
+
+	// IP is the IP address the user logged in from.
+	IP string `json:"ip,omitempty"`
+
+	// At is a timestamp of when the user logged in.
+	At time.Time `json:"at"`
+}
+
+// deviceID returns the ID of a user's device.  Only the browser and operating
+// system identify a device, so updating a browser or changing networks is not
+// seen as a new device.
+func deviceID(userID snowflake.Snowflake, a useragent.Agent) string {
+	sum := sha256.Sum256([]byte(userID.String() + "\x00" + a.Browser + "\x00" + a.OS))
+	return hex.EncodeToString(sum[:])
+}
+
+// truncate returns s as valid UTF-8 of at most n characters.
+func truncate(s string, n int) string {
+	s = strings.ToValidUTF8(s, "")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	i := 0
+	for j := range s {
+		if i == n {
+			return s[:j]
+		}
+		i++
+	}
+	return s
+}
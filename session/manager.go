@@ -0,0 +1,512 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package session
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/matthewpi/cosmos"
+	"github.com/matthewpi/cosmos/internal/server"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/user"
+)
+
+var (
+	// ErrNoSession is returned by Manager.Load when the request does not
+	// carry a session cookie.
+	ErrNoSession = errors.New("session: no session")
+
+	// ErrExpired is returned by Manager.Load when the session has expired.
+	ErrExpired = errors.New("session: expired")
+
+	// ErrLocked is returned by Manager.Load when the session's user has been
+	// locked, the session is revoked when this happens.
+	ErrLocked = errors.New("session: user is locked")
+
+	// ErrImpersonationForbidden is returned by Manager.Impersonate when the
+	// actor lacks user.PermissionImpersonate, does not outrank the target or
+	// the target is locked.
+	ErrImpersonationForbidden = errors.New("session: impersonation is not permitted")
+
+	// ErrAlreadyImpersonating is returned by Manager.Impersonate when the
+	// actor is already impersonating a user.
+	ErrAlreadyImpersonating = errors.New("session: already impersonating a user")
+
+	// ErrNotImpersonating is returned by Manager.StopImpersonating when the
+	// session is not impersonating a user.
+	ErrNotImpersonating = errors.New("session: not impersonating a user")
+
+	// ErrImpersonationEnded is returned by Manager.Load when the user
+	// impersonating the session's user is no longer permitted to, the
+	// session is revoked when this happens.
+	ErrImpersonationEnded = errors.New("session: impersonation has ended")
+)
+
+// ImpersonatorHeader is the response header set to the ID of the user
+// impersonating the session's user, allowing clients to display a banner
+// while impersonating.
+const ImpersonatorHeader = "Cosmos-Impersonator"
+
+// touchInterval is how often the last seen time of a session is updated,
+// avoiding a write on every request.
+const touchInterval = time.Minute
+
+// Users is used by a Manager to load the user a session belongs to.
+type Users interface {
+	ByID(ctx context.Context, id snowflake.Snowflake) (*user.User, error)
+}
+
+// Manager issues, loads and revokes sessions.
+type Manager struct {
+	store  Store
+	users  Users
+	config *Config
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+}
+
+// NewManager returns a new Manager.
+func NewManager(s Store, users Users, c *Config) *Manager {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	return &Manager{
+		store:  s,
+		users:  users,
+		config: c,
+		Now:    time.Now,
+	}
+}
+
+// Create creates a new session for a user and sets the session cookie.  The
+// session records the client's IP address and user agent, logging in from a
+// new device enqueues an EventNewDevice event.
+func (m *Manager) Create(ctx context.Context, w http.ResponseWriter, r *http.Request, u *user.User) (*Session, error) {
+	now := m.Now()
+	s, err := m.newSession(r, u, now, now.Add(m.config.AbsoluteTimeout))
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store.Create(ctx, s); err != nil {
+		return nil, err
+	}
+	device := s.Device()
+	if _, err := m.store.AddDevice(ctx, deviceID(u.ID, device), &NewDeviceEvent{
+		UserID: u.ID,
+		Device: device,
+		IP:     s.IP,
+		At:     now,
+	}); err != nil {
+		return nil, err
+	}
+	m.setCookie(w, s)
+	return s, nil
+}
+
+// Load loads the session and user of a request.
+//
+// Expired sessions and sessions belonging to locked users are revoked, as
+// are impersonating sessions whose impersonator is no longer permitted to
+// impersonate the user, see Session.Impersonator.
+func (m *Manager) Load(ctx context.Context, r *http.Request) (*Session, *user.User, error) {
+	c, err := r.Cookie(m.config.CookieName)
+	if err != nil || c.Value == "" {
+		return nil, nil, ErrNoSession
+	}
+	s, err := m.store.ByID(ctx, hashToken(c.Value))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := m.Now()
+	if m.expired(s, now) {
+		if err := m.store.Delete(ctx, s.ID); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrExpired
+	}
+
+	u, err := m.users.ByID(ctx, s.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Locked {
+		if err := m.store.Delete(ctx, s.ID); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrLocked
+	}
+	if s.Impersonating() {
+		a, err := m.users.ByID(ctx, s.ImpersonatorID)
+		if err != nil && !errors.Is(err, user.ErrNotFound) {
+			return nil, nil, err
+		}
+		if err != nil || !mayImpersonate(a, u) {
+			if err := m.store.Delete(ctx, s.ID); err != nil {
+				return nil, nil, err
+			}
+			return nil, nil, ErrImpersonationEnded
+		}
+		s.impersonator = a
+	}
+
+	if now.Sub(s.LastSeenAt) >= touchInterval {
+		if err := m.store.Touch(ctx, s.ID, now); err != nil {
+			return nil, nil, err
+		}
+		s.LastSeenAt = now
+	}
+	return s, u, nil
+}
+
+// Rotate replaces a session with a new one with a different ID, keeping the
+// original expiry, and sets the new session cookie.  This must be called
+// whenever the privileges of the session change, preventing a session ID
+// obtained before the change from being used with the new privileges.
+func (m *Manager) Rotate(ctx context.Context, w http.ResponseWriter, old *Session, u *user.User) (*Session, error) {
+	s, err := m.newSession(nil, u, m.Now(), old.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	s.CreatedAt = old.CreatedAt
+	s.IP = old.IP
+	s.UserAgent = old.UserAgent
+	s.ImpersonatorID = old.ImpersonatorID
+	s.impersonator = old.impersonator
+	if err := m.store.Rotate(ctx, old.ID, s); err != nil {
+		return nil, err
+	}
+	m.setCookie(w, s)
+	return s, nil
+}
+
+// Impersonate creates a session for actor to impersonate target, replacing
+// the actor's session cookie.  The actor must have
+// user.PermissionImpersonate and outrank the target's role.
+//
+// The impersonating session expires after ImpersonationTimeout.  The actor's
+// own session is kept in a separate cookie so it can be restored by
+// StopImpersonating.
+func (m *Manager) Impersonate(ctx context.Context, w http.ResponseWriter, r *http.Request, current *Session, actor, target *user.User) (*Session, error) {
+	if current.Impersonating() {
+		return nil, ErrAlreadyImpersonating
+	}
+	if !mayImpersonate(actor, target) || target.Locked {
+		return nil, ErrImpersonationForbidden
+	}
+	c, err := r.Cookie(m.config.CookieName)
+	if err != nil || hashToken(c.Value) != current.ID {
+		return nil, ErrNoSession
+	}
+
+	now := m.Now()
+	expiresAt := now.Add(m.config.ImpersonationTimeout)
+	if current.ExpiresAt.Before(expiresAt) {
+		expiresAt = current.ExpiresAt
+	}
+	s, err := m.newSession(r, target, now, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	s.ImpersonatorID = actor.ID
+	s.impersonator = actor
+	if err := m.store.Create(ctx, s); err != nil {
+		return nil, err
+	}
+	http.SetCookie(w, m.Cookie(m.impersonatorCookie(), c.Value, current.ExpiresAt.Sub(now)))
+	m.setCookie(w, s)
+	return s, nil
+}
+
+// StopImpersonating revokes an impersonating session, restoring the
+// impersonator's own session if it is still valid, otherwise the session
+// cookie is cleared.
+func (m *Manager) StopImpersonating(ctx context.Context, w http.ResponseWriter, r *http.Request, s *Session) error {
+	if !s.Impersonating() {
+		return ErrNotImpersonating
+	}
+	if err := m.store.Delete(ctx, s.ID); err != nil {
+		return err
+	}
+	http.SetCookie(w, m.Cookie(m.impersonatorCookie(), "", -1))
+
+	c, err := r.Cookie(m.impersonatorCookie())
+	if err != nil || c.Value == "" {
+		m.clearCookie(w)
+		return nil
+	}
+	original, err := m.store.ByID(ctx, hashToken(c.Value))
+	if err != nil {
+		m.clearCookie(w)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	// The stored session must belong to the impersonator, otherwise any
+	// session could be swapped in through the cookie.
+	if original.UserID != s.ImpersonatorID || m.expired(original, m.Now()) {
+		m.clearCookie(w)
+		return nil
+	}
+	original.token = c.Value
+	m.setCookie(w, original)
+	return nil
+}
+
+// Destroy revokes a session and clears the session cookie.
+func (m *Manager) Destroy(ctx context.Context, w http.ResponseWriter, s *Session) error {
+	m.clearCookie(w)
+	return m.Revoke(ctx, s.ID)
+}
+
+// List returns every session belonging to a user that has not expired, most
+// recently created first.
+func (m *Manager) List(ctx context.Context, userID snowflake.Snowflake) ([]*Session, error) {
+	sessions, err := m.store.ByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := m.Now()
+	active := sessions[:0]
+	for _, s := range sessions {
+		if !m.expired(s, now) {
+			active = append(active, s)
+		}
+	}
+	return active, nil
+}
+
+// Revoke revokes a single session.
+func (m *Manager) Revoke(ctx context.Context, id string) error {
+	return m.store.Delete(ctx, id)
+}
+
+// RevokeOthers revokes every session belonging to the user of s except s.
+func (m *Manager) RevokeOthers(ctx context.Context, s *Session) error {
+	return m.store.DeleteOthers(ctx, s.UserID, s.ID)
+}
+
+// RevokeAll revokes every session belonging to a user.
+func (m *Manager) RevokeAll(ctx context.Context, userID snowflake.Snowflake) error {
+	return m.store.DeleteByUser(ctx, userID)
+}
+
+// Middleware loads the session of each request, attaching both the session
+// and its user to the request's context.  Requests without a valid session
+// are passed through without a user, use server.RequirePermission or similar
+// to reject them.
+//
+// If the user's role has changed since the session was issued, the session is
+// rotated before the request is handled.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		s, u, err := m.Load(ctx, r)
+		if err != nil {
+			if err != ErrNoSession {
+				if !expected(err) {
+					cosmos.Log().Error("failed to load session", zap.Error(err))
+				}
+				m.clearCookie(w)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !sameRole(s.RoleID, roleID(u)) {
+			rs, err := m.Rotate(ctx, w, s, u)
+			if err != nil {
+				cosmos.Log().Error("failed to rotate session", zap.Error(err))
+				m.clearCookie(w)
+				next.ServeHTTP(w, r)
+				return
+			}
+			s = rs
+		}
+		ctx = NewContext(ctx, s)
+		ctx = user.NewContext(ctx, u)
+		if s.Impersonating() {
+			ctx = user.NewImpersonatorContext(ctx, s.Impersonator())
+			w.Header().Set(ImpersonatorHeader, s.ImpersonatorID.String())
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireSession is a middleware that only allows requests authenticated by a
+// Session, for routes managing the account itself rather than its data.
+// Requests authenticated any other way, such as with an access token, are
+// rejected with 403 Forbidden, unauthenticated requests with 401
+// Unauthorized.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := user.FromContext(r.Context())
+		if !ok {
+			server.WriteError(w, http.StatusUnauthorized, "unauthorized", "authentication required")
+			return
+		}
+		// The user must be the session's, a middleware running after the
+		// session's may have authenticated the request as someone else.
+		if s, ok := FromContext(r.Context()); !ok || s.UserID != u.ID {
+			server.WriteError(w, http.StatusForbidden, "session_required", "a session is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RejectImpersonation is a middleware that rejects requests made while
+// impersonating a user with 403 Forbidden, for routes that change how the
+// user signs in or what happens to their account, which only the user may
+// decide.
+func RejectImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s, ok := FromContext(r.Context()); ok && s.Impersonating() {
+			server.WriteError(w, http.StatusForbidden, "impersonating", "not permitted while impersonating a user")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// expected returns true if err is an expected reason for a session to be
+// invalid, rather than a failure.
+func expected(err error) bool {
+	return errors.Is(err, ErrNotFound) ||
+		errors.Is(err, ErrExpired) ||
+		errors.Is(err, ErrLocked) ||
+		errors.Is(err, ErrImpersonationEnded) ||
+		errors.Is(err, user.ErrNotFound)
+}
+
+// expired returns true if a session has passed either its idle or absolute
+// expiry.
+func (m *Manager) expired(s *Session, now time.Time) bool {
+	return !now.Before(s.ExpiresAt) || !now.Before(s.LastSeenAt.Add(m.config.IdleTimeout))
+}
+
+// newSession returns a new session for a user with a fresh token, describing
+// the client that made r if it is not nil.
+func (m *Manager) newSession(r *http.Request, u *user.User, now, expiresAt time.Time) (*Session, error) {
+	token, id, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		ID:         id,
+		token:      token,
+		UserID:     u.ID,
+		RoleID:     roleID(u),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  expiresAt,
+	}
+	if r != nil {
+		s.IP = truncate(server.ClientIP(r), maxIP)
+		s.UserAgent = truncate(r.UserAgent(), maxUserAgent)
+	}
+	return s, nil
+}
+
+func (m *Manager) setCookie(w http.ResponseWriter, s *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.config.CookieName,
+		Value:    s.token,
+		Path:     "/",
+		Expires:  s.ExpiresAt,
+		MaxAge:   int(s.ExpiresAt.Sub(m.Now()).Seconds()),
+		Secure:   m.config.Secure,
+		HttpOnly: true,
+		SameSite: m.config.SameSite,
+	})
+}
+
+func (m *Manager) clearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.config.CookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   m.config.Secure,
+		HttpOnly: true,
+		SameSite: m.config.SameSite,
+	})
+}
+
+// Cookie returns a cookie with the same attributes as the session cookie,
+// used for other cookies set during login.  If maxAge is negative the cookie
+// is deleted.
+func (m *Manager) Cookie(name, value string, maxAge time.Duration) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   m.config.Secure,
+		HttpOnly: true,
+		SameSite: m.config.SameSite,
+	}
+	if maxAge >= 0 {
+		c.Expires = m.Now().Add(maxAge)
+		c.MaxAge = int(maxAge.Seconds())
+	}
+	return c
+}
+
+// impersonatorCookie returns the name of the cookie the impersonator's own
+// session token is kept in while impersonating.
+func (m *Manager) impersonatorCookie() string {
+	return m.config.CookieName + "_impersonator"
+}
+
+// mayImpersonate returns true if actor is permitted to impersonate target.
+func mayImpersonate(actor, target *user.User) bool {
+	return !actor.Locked &&
+		actor.ID != target.ID &&
+		actor.Can(user.PermissionImpersonate) &&
+		actor.Role.Outranks(target.Role)
+}
+
+// roleID returns the ID of a user's role, or snowflake.Nil if the user has no
+// role.
+func roleID(u *user.User) snowflake.Snowflake {
+	if u.Role == nil {
+		return snowflake.Nil
+	}
+	return u.Role.ID
+}
+
+// sameRole returns true if two role IDs refer to the same role, treating all
+// invalid IDs as "no role".
+func sameRole(a, b snowflake.Snowflake) bool {
+	if !a.Valid() || !b.Valid() {
+		return a.Valid() == b.Valid()
+	}
+	return a == b
+}
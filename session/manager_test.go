@@ -0,0 +1,606 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package session_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/session"
+	"github.com/matthewpi/cosmos/user"
+	"github.com/matthewpi/cosmos/user/usertest"
+)
+
+// memoryStore is an in-memory session.Store used for testing.
+type memoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]session.Session
+	devices  map[string]snowflake.Snowflake
+	events   []*session.NewDeviceEvent
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		sessions: make(map[string]session.Session),
+		devices:  make(map[string]snowflake.Snowflake),
+	}
+}
+
+func (s *memoryStore) Create(_ context.Context, v *session.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[v.ID] = *v
+	return nil
+}
+
+func (s *memoryStore) ByID(_ context.Context, id string) (*session.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.sessions[id]
+	if !ok {
+		return nil, session.ErrNotFound
+	}
+	return &v, nil
+}
+
+func (s *memoryStore) ByUser(_ context.Context, userID snowflake.Snowflake) ([]*session.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sessions []*session.Session
+	for _, v := range s.sessions {
+		if v.UserID == userID {
+			v := v
+			sessions = append(sessions, &v)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (s *memoryStore) Touch(_ context.Context, id string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.sessions[id]; ok {
+		v.LastSeenAt = t
+		s.sessions[id] = v
+	}
+	return nil
+}
+
+func (s *memoryStore) Rotate(_ context.Context, oldID string, v *session.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[oldID]; !ok {
+		return session.ErrNotFound
+	}
+	delete(s.sessions, oldID)
+	s.sessions[v.ID] = *v
+	return nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *memoryStore) DeleteByUser(_ context.Context, userID snowflake.Snowflake) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, v := range s.sessions {
+		if v.UserID == userID {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteOthers(_ context.Context, userID snowflake.Snowflake, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.sessions {
+		if v.UserID == userID && k != id {
+			delete(s.sessions, k)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) AddDevice(_ context.Context, id string, e *session.NewDeviceEvent) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.devices[id]; ok {
+		return false, nil
+	}
+	known := false
+	for _, userID := range s.devices {
+		known = known || userID == e.UserID
+	}
+	s.devices[id] = e.UserID
+	if !known {
+		return false, nil
+	}
+	s.events = append(s.events, e)
+	return true, nil
+}
+
+// memoryUsers is an in-memory session.Users used for testing.
+type memoryUsers map[snowflake.Snowflake]*user.User
+
+func (m memoryUsers) ByID(_ context.Context, id snowflake.Snowflake) (*user.User, error) {
+	u, ok := m[id]
+	if !ok {
+		return nil, user.ErrNotFound
+	}
+	return u, nil
+}
+
+// clock is a manually advanced clock.
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.t
+}
+
+func newTestManager(t *testing.T) (*session.Manager, *memoryStore, *user.User, *clock) {
+	u, err := user.New("user@example.com", nil)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	store := newMemoryStore()
+	c := &clock{t: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := session.NewManager(store, memoryUsers{u.ID: u}, &session.Config{
+		CookieName:      "session",
+		IdleTimeout:     time.Hour,
+		AbsoluteTimeout: 24 * time.Hour,
+		Secure:          true,
+		SameSite:        http.SameSiteStrictMode,
+	})
+	m.Now = c.Now
+	return m, store, u, c
+}
+
+// create creates a session and returns the cookie that was set.
+func create(t *testing.T, m *session.Manager, u *user.User) (*session.Session, *http.Cookie) {
+	w := httptest.NewRecorder()
+	s, err := m.Create(context.Background(), w, request(nil), u)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Expected a single cookie, but got %d", len(cookies))
+	}
+	return s, cookies[0]
+}
+
+func request(c *http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if c != nil {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestManager_Create(t *testing.T) {
+	m, store, u, _ := newTestManager(t)
+	s, c := create(t, m, u)
+
+	if c.Value == "" || c.Value != s.Token() {
+		t.Errorf("Expected cookie to contain the session token")
+	}
+	if s.ID == s.Token() {
+		t.Errorf("Expected session ID to not be the token")
+	}
+	if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteStrictMode || c.Path != "/" {
+		t.Errorf("Expected an HttpOnly, Secure, SameSite=Strict cookie, but got \"%s\"", c.String())
+	}
+	if c.MaxAge != int((24 * time.Hour).Seconds()) {
+		t.Errorf("Expected \"%d\", but got \"%d\"", int((24 * time.Hour).Seconds()), c.MaxAge)
+	}
+	if _, ok := store.sessions[s.ID]; !ok {
+		t.Errorf("Expected session to be stored")
+	}
+
+	_, lu, err := m.Load(context.Background(), request(c))
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if lu.ID != u.ID {
+		t.Errorf("Expected \"%s\", but got \"%s\"", u.ID, lu.ID)
+	}
+}
+
+func TestManager_Load_Expiry(t *testing.T) {
+	for i, tc := range []struct {
+		Steps  []time.Duration
+		Expect error
+	}{
+		{Steps: []time.Duration{30 * time.Minute}},
+		{Steps: []time.Duration{2 * time.Hour}, Expect: session.ErrExpired},
+		// Activity keeps the session alive past the idle timeout.
+		{Steps: []time.Duration{50 * time.Minute, 50 * time.Minute, 50 * time.Minute}},
+		// But not past the absolute timeout.
+		{Steps: repeat(50*time.Minute, 30), Expect: session.ErrExpired},
+	} {
+		m, store, u, c := newTestManager(t)
+		s, cookie := create(t, m, u)
+
+		var err error
+		for _, step := range tc.Steps {
+			c.t = c.t.Add(step)
+			if _, _, err = m.Load(context.Background(), request(cookie)); err != nil {
+				break
+			}
+		}
+		if err != tc.Expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+		}
+		if _, ok := store.sessions[s.ID]; ok == (tc.Expect != nil) {
+			t.Errorf("Test #%d: Expected expired sessions to be deleted", i)
+		}
+	}
+}
+
+func repeat(d time.Duration, n int) []time.Duration {
+	v := make([]time.Duration, n)
+	for i := range v {
+		v[i] = d
+	}
+	return v
+}
+
+func TestManager_Load_Locked(t *testing.T) {
+	m, store, u, _ := newTestManager(t)
+	s, c := create(t, m, u)
+
+	u.Locked = true
+	if _, _, err := m.Load(context.Background(), request(c)); err != session.ErrLocked {
+		t.Errorf("Expected \"%v\", but got \"%v\"", session.ErrLocked, err)
+	}
+	if _, ok := store.sessions[s.ID]; ok {
+		t.Errorf("Expected session of a locked user to be deleted")
+	}
+}
+
+func TestManager_Middleware(t *testing.T) {
+	m, store, u, _ := newTestManager(t)
+	s, c := create(t, m, u)
+
+	var got *user.User
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = user.FromContext(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, request(c))
+	if got == nil || got.ID != u.ID {
+		t.Fatalf("Expected user to be attached to the request context")
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("Expected no cookies to be set")
+	}
+
+	// Changing the user's role must rotate the session.
+	u.Role = &role.Role{ID: snowflake.New(), Name: "Moderator"}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, request(c))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value == c.Value {
+		t.Fatalf("Expected session to be rotated after a privilege change")
+	}
+	if _, ok := store.sessions[s.ID]; ok {
+		t.Errorf("Expected old session to be deleted after rotation")
+	}
+
+	// The old cookie must no longer be valid.
+	got = nil
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, request(c))
+	if got != nil {
+		t.Errorf("Expected old session to be rejected")
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("Expected invalid session cookie to be cleared")
+	}
+}
+
+func TestRequireSession(t *testing.T) {
+	m, _, u, _ := newTestManager(t)
+	_, c := create(t, m, u)
+	other := &user.User{ID: u.ID + 1}
+
+	for i, tc := range []struct {
+		Cookie *http.Cookie
+		User   *user.User
+		Status int
+	}{
+		{Status: http.StatusUnauthorized},
+		{Cookie: c, Status: http.StatusOK},
+		// Authenticated by something other than a session.
+		{User: u, Status: http.StatusForbidden},
+		// Authenticated as a different user than the session's.
+		{Cookie: c, User: other, Status: http.StatusForbidden},
+	} {
+		var h http.Handler = session.RequireSession(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		if tc.User != nil {
+			next := h
+			h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.WithContext(user.NewContext(r.Context(), tc.User)))
+			})
+		}
+		h = m.Middleware(h)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.Cookie != nil {
+			r = request(tc.Cookie)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.Status {
+			t.Errorf("Test #%d: Expected \"%d\", but got \"%d\"", i, tc.Status, w.Code)
+		}
+	}
+}
+
+func TestManager_RevokeAll(t *testing.T) {
+	m, store, u, _ := newTestManager(t)
+	create(t, m, u)
+	create(t, m, u)
+
+	if err := m.RevokeAll(context.Background(), u.ID); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if len(store.sessions) != 0 {
+		t.Errorf("Expected all sessions to be revoked, but %d remain", len(store.sessions))
+	}
+}
+
+func newImpersonationTest(t *testing.T) (*session.Manager, *memoryStore, *user.User, *user.User, *clock) {
+	admin, err := user.New("admin@example.com", nil)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	admin.Role = &role.Role{ID: snowflake.New(), SortID: 1, Permissions: role.Permissions{user.PermissionImpersonate}}
+	target, err := user.New("user@example.com", nil)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	// user.New derives IDs from the current time alone.
+	target.ID = snowflake.New()
+	store := newMemoryStore()
+	c := &clock{t: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m := session.NewManager(store, memoryUsers{admin.ID: admin, target.ID: target}, &session.Config{
+		CookieName:           "session",
+		IdleTimeout:          time.Hour,
+		AbsoluteTimeout:      24 * time.Hour,
+		ImpersonationTimeout: 15 * time.Minute,
+	})
+	m.Now = c.Now
+	return m, store, admin, target, c
+}
+
+// impersonate starts impersonating target, returning the impersonating
+// session and the cookies that were set.
+func impersonate(t *testing.T, m *session.Manager, admin, target *user.User) (*session.Session, *session.Session, []*http.Cookie) {
+	current, c := create(t, m, admin)
+	w := httptest.NewRecorder()
+	s, err := m.Impersonate(context.Background(), w, request(c), current, admin, target)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	return current, s, w.Result().Cookies()
+}
+
+func TestManager_Impersonate(t *testing.T) {
+	m, store, admin, target, c := newImpersonationTest(t)
+	current, s, cookies := impersonate(t, m, admin, target)
+
+	if s.UserID != target.ID || s.ImpersonatorID != admin.ID {
+		t.Errorf("Expected session of \"%s\" impersonated by \"%s\"", target.ID, admin.ID)
+	}
+	if want := c.t.Add(15 * time.Minute); !s.ExpiresAt.Equal(want) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", want, s.ExpiresAt)
+	}
+	if _, ok := store.sessions[current.ID]; !ok {
+		t.Errorf("Expected impersonator's session to be kept")
+	}
+	if len(cookies) != 2 {
+		t.Fatalf("Expected 2 cookies, but got %d", len(cookies))
+	}
+
+	var sc *http.Cookie
+	for _, v := range cookies {
+		if v.Name == "session" {
+			sc = v
+		}
+	}
+	var got, impersonator *user.User
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = user.FromContext(r.Context())
+		impersonator, _ = user.ImpersonatorFromContext(r.Context())
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, request(sc))
+	if got == nil || got.ID != target.ID || impersonator == nil || impersonator.ID != admin.ID {
+		t.Fatalf("Expected impersonated user and impersonator to be attached to the request context")
+	}
+	if v := w.Header().Get(session.ImpersonatorHeader); v != admin.ID.String() {
+		t.Errorf("Expected \"%s\", but got \"%s\"", admin.ID, v)
+	}
+
+	// Impersonation cannot be nested.
+	if _, err := m.Impersonate(context.Background(), httptest.NewRecorder(), request(sc), s, admin, target); err != session.ErrAlreadyImpersonating {
+		t.Errorf("Expected \"%v\", but got \"%v\"", session.ErrAlreadyImpersonating, err)
+	}
+
+	// Impersonating sessions never outlive the impersonator's session.
+	c.t = c.t.Add(16 * time.Minute)
+	if _, _, err := m.Load(context.Background(), request(sc)); err != session.ErrExpired {
+		t.Errorf("Expected \"%v\", but got \"%v\"", session.ErrExpired, err)
+	}
+}
+
+func TestManager_Impersonate_Forbidden(t *testing.T) {
+	for i, tc := range []struct {
+		Setup func(admin, target *user.User)
+	}{
+		{Setup: func(admin, _ *user.User) { admin.Role.Permissions = nil }},
+		{Setup: func(_, target *user.User) { target.Role = &role.Role{ID: snowflake.New(), SortID: 1} }},
+		{Setup: func(_, target *user.User) { target.Role = &role.Role{ID: snowflake.New(), SortID: 0} }},
+		{Setup: func(_, target *user.User) { target.Locked = true }},
+		{Setup: func(admin, target *user.User) { *target = *admin }},
+	} {
+		m, _, admin, target, _ := newImpersonationTest(t)
+		tc.Setup(admin, target)
+		current, c := create(t, m, admin)
+		if _, err := m.Impersonate(context.Background(), httptest.NewRecorder(), request(c), current, admin, target); err != session.ErrImpersonationForbidden {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, session.ErrImpersonationForbidden, err)
+		}
+	}
+}
+
+func TestManager_Impersonate_StoredRoles(t *testing.T) {
+	ctx := context.Background()
+	db := usertest.New()
+	staff := &role.Role{ID: snowflake.New(), Name: "Staff", SortID: 0, Permissions: role.Permissions{role.All}}
+	support := &role.Role{ID: snowflake.New(), Name: "Support", SortID: 1, Permissions: role.Permissions{user.PermissionImpersonate}}
+	member := &role.Role{ID: snowflake.New(), Name: "Member", SortID: 2}
+	for _, r := range []*role.Role{staff, support, member} {
+		if err := db.Roles().Create(ctx, r); err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+	}
+	users := make(map[*role.Role]*user.User)
+	for _, r := range []*role.Role{staff, support, member} {
+		u, err := user.New(r.Name+"@example.com", nil)
+		if err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+		u.ID = snowflake.New()
+		u.Role = r
+		if err := db.Users().Create(ctx, u); err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+		if users[r], err = db.Users().ByID(ctx, u.ID); err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+	}
+	m := session.NewManager(newMemoryStore(), db.Users(), &session.Config{
+		CookieName:           "session",
+		IdleTimeout:          time.Hour,
+		AbsoluteTimeout:      24 * time.Hour,
+		ImpersonationTimeout: 15 * time.Minute,
+	})
+
+	for i, tc := range []struct {
+		Actor, Target *role.Role
+		Expect        error
+	}{
+		{Actor: support, Target: staff, Expect: session.ErrImpersonationForbidden},
+		{Actor: member, Target: support, Expect: session.ErrImpersonationForbidden},
+		{Actor: support, Target: member},
+		{Actor: staff, Target: support},
+	} {
+		current, c := create(t, m, users[tc.Actor])
+		s, err := m.Impersonate(ctx, httptest.NewRecorder(), request(c), current, users[tc.Actor], users[tc.Target])
+		if err != tc.Expect {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.Expect, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		// Loading the session checks the impersonator is still permitted.
+		if _, _, err := m.Load(ctx, request(&http.Cookie{Name: "session", Value: s.Token()})); err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+		}
+	}
+}
+
+func TestManager_Load_ImpersonationEnded(t *testing.T) {
+	m, store, admin, target, _ := newImpersonationTest(t)
+	_, s, cookies := impersonate(t, m, admin, target)
+
+	admin.Role.Permissions = nil
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	if _, _, err := m.Load(context.Background(), r); err != session.ErrImpersonationEnded {
+		t.Errorf("Expected \"%v\", but got \"%v\"", session.ErrImpersonationEnded, err)
+	}
+	if _, ok := store.sessions[s.ID]; ok {
+		t.Errorf("Expected impersonating session to be deleted")
+	}
+}
+
+func TestManager_StopImpersonating(t *testing.T) {
+	m, store, admin, target, _ := newImpersonationTest(t)
+	current, s, cookies := impersonate(t, m, admin, target)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	if err := m.StopImpersonating(context.Background(), w, r, s); err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if _, ok := store.sessions[s.ID]; ok {
+		t.Errorf("Expected impersonating session to be deleted")
+	}
+
+	var restored *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "session" {
+			restored = c
+		}
+	}
+	if restored == nil || restored.MaxAge <= 0 {
+		t.Fatalf("Expected impersonator's session cookie to be restored")
+	}
+	ls, lu, err := m.Load(context.Background(), request(restored))
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if ls.ID != current.ID || lu.ID != admin.ID {
+		t.Errorf("Expected \"%s\", but got \"%s\"", admin.ID, lu.ID)
+	}
+
+	if err := m.StopImpersonating(context.Background(), httptest.NewRecorder(), r, ls); err != session.ErrNotImpersonating {
+		t.Errorf("Expected \"%v\", but got \"%v\"", session.ErrNotImpersonating, err)
+	}
+}
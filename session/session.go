@@ -0,0 +1,136 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package session provides server-side sessions for authenticated users.
+//
+// A session is identified by a random token delivered to the client in a
+// cookie.  Only the SHA-256 hash of the token is stored, so the contents of
+// the sessions table cannot be used to hijack a session.
+package session
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/useragent"
+	"github.com/matthewpi/cosmos/internal/uuid"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// Session represents an authenticated session.
+type Session struct {
+	// ID is the SHA-256 hash of the session's token, it is safe to expose.
+	ID string `json:"id"`
+
+	// token is the session's secret token, only known when the session is
+	// created or rotated.
+	token string
+
+	// UserID is the ID of the user the session belongs to.
+	UserID snowflake.Snowflake `json:"-"`
+
+	// RoleID is the ID of the user's role when the session was issued, used
+	// to detect privilege changes.
+	RoleID snowflake.Snowflake `json:"-"`
+
+	// CreatedAt is a timestamp of when the session was created.
+	CreatedAt time.Time `json:"created_at"`
+
+	// LastSeenAt is a timestamp of when the session was last used.
+	LastSeenAt time.Time `json:"last_seen_at"`
+
+	// ExpiresAt is a timestamp of when the session expires, regardless of
+	// activity.
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// IP is the IP address of the client the session was created by.
+	IP string `json:"ip"`
+
+	// UserAgent is the User-Agent header of the client the session was
+	// created by.
+	UserAgent string `json:"user_agent"`
+
+	// ImpersonatorID is the ID of the user impersonating the session's user,
+	// it is invalid unless the session was created by Manager.Impersonate.
+	ImpersonatorID snowflake.Snowflake `json:"-"`
+
+	// impersonator is the user impersonating the session's user, set by
+	// Manager.Load.
+	impersonator *user.User
+}
+
+// Token returns the session's secret token, this is only available on
+// sessions that were just created or rotated.
+func (s *Session) Token() string {
+	return s.token
+}
+
+// Device returns the browser and operating system parsed from the session's
+// user agent.
+func (s *Session) Device() useragent.Agent {
+	return useragent.Parse(s.UserAgent)
+}
+
+// Impersonating returns true if the session is used by another user to
+// impersonate the session's user.
+func (s *Session) Impersonating() bool {
+	return s.ImpersonatorID.Valid()
+}
+
+// Impersonator returns the user impersonating the session's user, this is
+// only available on impersonating sessions loaded by Manager.Load.
+func (s *Session) Impersonator() *user.User {
+	return s.impersonator
+}
+
+// newToken generates a new random token, returning it and its hash.
+func newToken() (string, string, error) {
+	id, err := uuid.New()
+	if err != nil {
+		return "", "", err
+	}
+	token := id.String()
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// contextKey is the type of the context key used to store a Session.
+type contextKey struct{}
+
+// NewContext returns a new context carrying the Session.
+func NewContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the Session stored in the context, if any.
+func FromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(contextKey{}).(*Session)
+	return s, ok && s != nil
+}
@@ -0,0 +1,193 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/outbox"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// ErrNotFound is returned when a Session does not exist.
+var ErrNotFound = errors.New("session: not found")
+
+// Store persists Sessions.
+type Store interface {
+	// Create stores a new Session.
+	Create(ctx context.Context, s *Session) error
+
+	// ByID returns the Session with the given ID.
+	ByID(ctx context.Context, id string) (*Session, error)
+
+	// ByUser returns every Session belonging to a user, most recently
+	// created first.
+	ByUser(ctx context.Context, userID snowflake.Snowflake) ([]*Session, error)
+
+	// Touch updates the time a Session was last seen at.
+	Touch(ctx context.Context, id string, t time.Time) error
+
+	// Rotate atomically replaces the Session identified by oldID with s.
+	Rotate(ctx context.Context, oldID string, s *Session) error
+
+	// Delete deletes a single Session.
+	Delete(ctx context.Context, id string) error
+
+	// DeleteByUser deletes every Session belonging to a user.
+	DeleteByUser(ctx context.Context, userID snowflake.Snowflake) error
+
+	// DeleteOthers deletes every Session belonging to a user except the
+	// Session with the given ID.
+	DeleteOthers(ctx context.Context, userID snowflake.Snowflake, id string) error
+
+	// AddDevice records that a user has logged in from a device.  If the
+	// device is new and the user has logged in from another device before,
+	// an EventNewDevice event is enqueued in the same transaction.  Returns
+	// true if an event was enqueued.
+	AddDevice(ctx context.Context, id string, e *NewDeviceEvent) (bool, error)
+}
+
+// selectSessions selects the columns expected by scan.
+const selectSessions = "SELECT id, user_id, role_id, created_at, last_seen_at, expires_at, impersonator_id, ip, user_agent FROM sessions"
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db db.Querier
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "sessions" table.
+func NewStore(q db.Querier) Store {
+	return &store{db: q}
+}
+
+func (s *store) Create(ctx context.Context, v *Session) error {
+	_, err := s.db.Exec(
+		ctx,
+		"INSERT INTO sessions (id, user_id, role_id, created_at, last_seen_at, expires_at, impersonator_id, ip, user_agent) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		v.ID, v.UserID, v.RoleID, v.CreatedAt, v.LastSeenAt, v.ExpiresAt, v.ImpersonatorID, v.IP, v.UserAgent,
+	)
+	return err
+}
+
+func (s *store) ByID(ctx context.Context, id string) (*Session, error) {
+	return scan(s.db.QueryRow(ctx, selectSessions+" WHERE id = $1", id))
+}
+
+func (s *store) ByUser(ctx context.Context, userID snowflake.Snowflake) ([]*Session, error) {
+	rows, err := s.db.Query(ctx, selectSessions+" WHERE user_id = $1 ORDER BY created_at DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, v)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *store) Touch(ctx context.Context, id string, t time.Time) error {
+	_, err := s.db.Exec(ctx, "UPDATE sessions SET last_seen_at = $2 WHERE id = $1", id, t)
+	return err
+}
+
+func (s *store) Rotate(ctx context.Context, oldID string, v *Session) error {
+	return s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, "DELETE FROM sessions WHERE id = $1", oldID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() < 1 {
+			return ErrNotFound
+		}
+		return (&store{db: tx}).Create(ctx, v)
+	})
+}
+
+func (s *store) Delete(ctx context.Context, id string) error {
+	_, err := s.db.Exec(ctx, "DELETE FROM sessions WHERE id = $1", id)
+	return err
+}
+
+func (s *store) DeleteByUser(ctx context.Context, userID snowflake.Snowflake) error {
+	_, err := s.db.Exec(ctx, "DELETE FROM sessions WHERE user_id = $1", userID)
+	return err
+}
+
+func (s *store) DeleteOthers(ctx context.Context, userID snowflake.Snowflake, id string) error {
+	_, err := s.db.Exec(ctx, "DELETE FROM sessions WHERE user_id = $1 AND id <> $2", userID, id)
+	return err
+}
+
+func (s *store) AddDevice(ctx context.Context, id string, e *NewDeviceEvent) (bool, error) {
+	var enqueued bool
+	err := s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(
+			ctx,
+			"INSERT INTO devices (id, user_id, created_at) VALUES ($1, $2, $3) ON CONFLICT (id) DO NOTHING",
+			id, e.UserID, e.At,
+		)
+		if err != nil || tag.RowsAffected() < 1 {
+			return err
+		}
+		// The first device a user logs in from is not worth notifying
+		// them about.
+		var known bool
+		if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM devices WHERE user_id = $1 AND id <> $2)", e.UserID, id).Scan(&known); err != nil {
+			return err
+		}
+		if !known {
+			return nil
+		}
+		if _, err := outbox.Enqueue(ctx, tx, EventNewDevice, e); err != nil {
+			return err
+		}
+		enqueued = true
+		return nil
+	})
+	return enqueued, err
+}
+
+// scan scans a row selected by selectSessions into a Session.
+func scan(row pgx.Row) (*Session, error) {
+	v := &Session{}
+	if err := row.Scan(&v.ID, &v.UserID, &v.RoleID, &v.CreatedAt, &v.LastSeenAt, &v.ExpiresAt, &v.ImpersonatorID, &v.IP, &v.UserAgent); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,91 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package twofactor
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/matthewpi/cosmos/internal/config/lexer"
+)
+
+// Config represents the configuration for two-factor authentication.
+type Config struct {
+	// Issuer is the name displayed by authenticator apps.
+	Issuer string `json:"issuer"`
+
+	// Skew is the number of periods of clock drift allowed in either
+	// direction when validating a TOTP code.
+	Skew uint `json:"skew"`
+
+	// RecoveryCodes is the number of recovery codes generated for a user.
+	RecoveryCodes int `json:"recovery_codes"`
+}
+
+// DefaultConfig returns the default two-factor authentication configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		Issuer:        "Cosmos",
+		Skew:          1,
+		RecoveryCodes: 10,
+	}
+}
+
+// FromLexer .
+func FromLexer(b lexer.Block) (*Config, error) {
+	c := DefaultConfig()
+	for _, s := range b.Segments {
+		d := s.Directive()
+		switch d {
+		case "issuer":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after issuer directive")
+			}
+			c.Issuer = s[1].Text
+		case "skew":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after skew directive")
+			}
+			v, err := strconv.ParseUint(s[1].Text, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid skew: %w", err)
+			}
+			c.Skew = uint(v)
+		case "recovery_codes":
+			if len(s) != 2 {
+				return nil, fmt.Errorf("expected a single argument after recovery_codes directive")
+			}
+			v, err := strconv.Atoi(s[1].Text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid recovery_codes: %w", err)
+			}
+			if v < 1 || v > 32 {
+				return nil, fmt.Errorf("recovery_codes must be between 1 and 32")
+			}
+			c.RecoveryCodes = v
+		default:
+			return nil, fmt.Errorf("unknown directive: \"" + d + "\"")
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,192 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package twofactor
+
+import (
+	"context"
+	"errors"
+
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+)
+
+// ErrNotFound is returned by a Store when a user has no TOTP secret.
+var ErrNotFound = errors.New("twofactor: not found")
+
+// Store persists TOTP secrets and recovery codes.
+type Store interface {
+	// TOTP returns a user's TOTP secret.
+	TOTP(ctx context.Context, userID snowflake.Snowflake) (*TOTP, error)
+
+	// SaveTOTP creates or replaces a user's TOTP secret.
+	SaveTOTP(ctx context.Context, t *TOTP) error
+
+	// Enable confirms a user's TOTP secret, recording the counter of the
+	// code used to confirm it and replacing the user's recovery codes.
+	Enable(ctx context.Context, userID snowflake.Snowflake, counter int64, codes []*RecoveryCode) error
+
+	// UseCounter records the counter of an accepted code, returning false if
+	// an equal or greater counter has already been recorded.
+	UseCounter(ctx context.Context, userID snowflake.Snowflake, counter int64) (bool, error)
+
+	// RecoveryCodes returns a user's unused recovery codes.
+	RecoveryCodes(ctx context.Context, userID snowflake.Snowflake) ([]*RecoveryCode, error)
+
+	// ReplaceRecoveryCodes replaces all of a user's recovery codes.
+	ReplaceRecoveryCodes(ctx context.Context, userID snowflake.Snowflake, codes []*RecoveryCode) error
+
+	// UseRecoveryCode marks a recovery code as used, returning false if it
+	// has already been used.
+	UseRecoveryCode(ctx context.Context, id snowflake.Snowflake) (bool, error)
+
+	// Disable deletes a user's TOTP secret and recovery codes.
+	Disable(ctx context.Context, userID snowflake.Snowflake) error
+}
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db db.Querier
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "totp_secrets" and "recovery_codes"
+// tables.
+func NewStore(q db.Querier) Store {
+	return &store{db: q}
+}
+
+func (s *store) TOTP(ctx context.Context, userID snowflake.Snowflake) (*TOTP, error) {
+	t := &TOTP{}
+	if err := s.db.QueryRow(
+		ctx,
+		"SELECT user_id, secret, confirmed, last_counter, created_at FROM totp_secrets WHERE user_id = $1",
+		userID,
+	).Scan(&t.UserID, &t.Secret, &t.Confirmed, &t.LastCounter, &t.CreatedAt); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *store) SaveTOTP(ctx context.Context, t *TOTP) error {
+	_, err := s.db.Exec(
+		ctx,
+		"INSERT INTO totp_secrets (user_id, secret, confirmed, last_counter, created_at) VALUES ($1, $2, $3, $4, $5) "+
+			"ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, confirmed = EXCLUDED.confirmed, "+
+			"last_counter = EXCLUDED.last_counter, created_at = EXCLUDED.created_at",
+		t.UserID, t.Secret, t.Confirmed, t.LastCounter, t.CreatedAt,
+	)
+	return err
+}
+
+func (s *store) Enable(ctx context.Context, userID snowflake.Snowflake, counter int64, codes []*RecoveryCode) error {
+	return s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(
+			ctx,
+			"UPDATE totp_secrets SET confirmed = true, last_counter = $2 WHERE user_id = $1 AND NOT confirmed",
+			userID, counter,
+		)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() < 1 {
+			return ErrAlreadyEnabled
+		}
+		return (&store{db: tx}).ReplaceRecoveryCodes(ctx, userID, codes)
+	})
+}
+
+func (s *store) UseCounter(ctx context.Context, userID snowflake.Snowflake, counter int64) (bool, error) {
+	tag, err := s.db.Exec(
+		ctx,
+		"UPDATE totp_secrets SET last_counter = $2 WHERE user_id = $1 AND last_counter < $2",
+		userID, counter,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *store) RecoveryCodes(ctx context.Context, userID snowflake.Snowflake) ([]*RecoveryCode, error) {
+	rows, err := s.db.Query(
+		ctx,
+		"SELECT id, user_id, hash FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []*RecoveryCode
+	for rows.Next() {
+		c := &RecoveryCode{}
+		if err := rows.Scan(&c.ID, &c.UserID, &c.Hash); err != nil {
+			return nil, err
+		}
+		codes = append(codes, c)
+	}
+	return codes, rows.Err()
+}
+
+func (s *store) ReplaceRecoveryCodes(ctx context.Context, userID snowflake.Snowflake, codes []*RecoveryCode) error {
+	return s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		for _, c := range codes {
+			if _, err := tx.Exec(
+				ctx,
+				"INSERT INTO recovery_codes (id, user_id, hash) VALUES ($1, $2, $3)",
+				c.ID, userID, c.Hash,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *store) UseRecoveryCode(ctx context.Context, id snowflake.Snowflake) (bool, error) {
+	tag, err := s.db.Exec(ctx, "UPDATE recovery_codes SET used_at = now() WHERE id = $1 AND used_at IS NULL", id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *store) Disable(ctx context.Context, userID snowflake.Snowflake) error {
+	return s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, "DELETE FROM totp_secrets WHERE user_id = $1", userID)
+		return err
+	})
+}
@@ -0,0 +1,322 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package twofactor provides two-factor authentication using time-based
+// one-time passwords and single-use recovery codes.
+package twofactor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/argon2"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/totp"
+	"github.com/matthewpi/cosmos/user"
+)
+
+var (
+	// ErrNotEnrolled is returned when a user has not started enrolling.
+	ErrNotEnrolled = errors.New("twofactor: not enrolled")
+
+	// ErrAlreadyEnabled is returned when enrolling a user who already has
+	// two-factor authentication enabled.
+	ErrAlreadyEnabled = errors.New("twofactor: already enabled")
+
+	// ErrNotEnabled is returned when a user does not have two-factor
+	// authentication enabled.
+	ErrNotEnabled = errors.New("twofactor: not enabled")
+
+	// ErrInvalidCode is returned when a code is incorrect or has already
+	// been used.
+	ErrInvalidCode = errors.New("twofactor: invalid code")
+)
+
+// TOTP represents a user's TOTP secret.
+type TOTP struct {
+	// UserID is the ID of the user the secret belongs to.
+	UserID snowflake.Snowflake
+
+	// Secret is the base32 encoded secret.
+	Secret string
+
+	// Confirmed is true once the user has proven they have the secret by
+	// providing a valid code, only confirmed secrets are enforced on login.
+	Confirmed bool
+
+	// LastCounter is the counter of the last accepted code, codes with an
+	// equal or lower counter are rejected so a code can't be used twice.
+	LastCounter int64
+
+	// CreatedAt is a timestamp of when the secret was generated.
+	CreatedAt time.Time
+}
+
+// RecoveryCode is a single-use code that can be used instead of a TOTP code.
+type RecoveryCode struct {
+	// ID is the code's unique identifier.
+	ID snowflake.Snowflake
+
+	// UserID is the ID of the user the code belongs to.
+	UserID snowflake.Snowflake
+
+	// Hash is an argon2 hash of the code.
+	Hash string
+}
+
+// Enrollment is returned when starting to enroll a user.
+type Enrollment struct {
+	// Secret is the base32 encoded secret, for manual entry.
+	Secret string `json:"secret"`
+
+	// URI is the otpauth:// URI of the secret, for displaying as a QR code.
+	URI string `json:"uri"`
+}
+
+// Manager manages users' two-factor authentication.
+type Manager struct {
+	store  Store
+	config *Config
+
+	// Now returns the current time, it may be overridden for testing.
+	Now func() time.Time
+}
+
+// NewManager returns a new Manager, if c is nil DefaultConfig is used.
+func NewManager(s Store, c *Config) *Manager {
+	if c == nil {
+		c = DefaultConfig()
+	}
+	return &Manager{
+		store:  s,
+		config: c,
+		Now:    time.Now,
+	}
+}
+
+// Enroll starts enrolling a user by generating a new secret.  The secret is
+// not enforced until it is confirmed with Enable.
+func (m *Manager) Enroll(ctx context.Context, u *user.User) (*Enrollment, error) {
+	t, err := m.store.TOTP(ctx, u.ID)
+	switch {
+	case err == nil:
+		if t.Confirmed {
+			return nil, ErrAlreadyEnabled
+		}
+	case !errors.Is(err, ErrNotFound):
+		return nil, err
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store.SaveTOTP(ctx, &TOTP{
+		UserID:    u.ID,
+		Secret:    secret,
+		CreatedAt: m.Now(),
+	}); err != nil {
+		return nil, err
+	}
+	return &Enrollment{
+		Secret: secret,
+		URI:    totp.URI(m.config.Issuer, u.Email, secret),
+	}, nil
+}
+
+// Enable finishes enrolling a user, the code must be valid for the secret
+// generated by Enroll.  The user's recovery codes are returned, these are
+// only stored hashed so they can never be retrieved again.
+func (m *Manager) Enable(ctx context.Context, userID snowflake.Snowflake, code string) ([]string, error) {
+	t, err := m.store.TOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrNotEnrolled
+		}
+		return nil, err
+	}
+	if t.Confirmed {
+		return nil, ErrAlreadyEnabled
+	}
+	counter, err := m.validate(t, code)
+	if err != nil {
+		return nil, err
+	}
+	codes, hashed, err := m.newRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store.Enable(ctx, userID, counter, hashed); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Enabled returns true if a user has two-factor authentication enabled.
+func (m *Manager) Enabled(ctx context.Context, userID snowflake.Snowflake) (bool, error) {
+	t, err := m.store.TOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return t.Confirmed, nil
+}
+
+// Verify verifies a TOTP code, each code can only be used once.
+func (m *Manager) Verify(ctx context.Context, userID snowflake.Snowflake, code string) error {
+	t, err := m.enabled(ctx, userID)
+	if err != nil {
+		return err
+	}
+	counter, err := m.validate(t, code)
+	if err != nil {
+		return err
+	}
+	if counter <= t.LastCounter {
+		return ErrInvalidCode
+	}
+	ok, err := m.store.UseCounter(ctx, userID, counter)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// Another request used the code first.
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// Recover verifies and uses up a recovery code.
+func (m *Manager) Recover(ctx context.Context, userID snowflake.Snowflake, code string) error {
+	if _, err := m.enabled(ctx, userID); err != nil {
+		return err
+	}
+	codes, err := m.store.RecoveryCodes(ctx, userID)
+	if err != nil {
+		return err
+	}
+	code = normalizeRecoveryCode(code)
+	for _, c := range codes {
+		if argon2.Verify([]byte(code), c.Hash) != nil {
+			continue
+		}
+		ok, err := m.store.UseRecoveryCode(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+	return ErrInvalidCode
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes.
+func (m *Manager) RegenerateRecoveryCodes(ctx context.Context, userID snowflake.Snowflake) ([]string, error) {
+	if _, err := m.enabled(ctx, userID); err != nil {
+		return nil, err
+	}
+	codes, hashed, err := m.newRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store.ReplaceRecoveryCodes(ctx, userID, hashed); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable disables two-factor authentication for a user, deleting their
+// secret and recovery codes.
+func (m *Manager) Disable(ctx context.Context, userID snowflake.Snowflake) error {
+	return m.store.Disable(ctx, userID)
+}
+
+// enabled returns a user's confirmed TOTP secret.
+func (m *Manager) enabled(ctx context.Context, userID snowflake.Snowflake) (*TOTP, error) {
+	t, err := m.store.TOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrNotEnabled
+		}
+		return nil, err
+	}
+	if !t.Confirmed {
+		return nil, ErrNotEnabled
+	}
+	return t, nil
+}
+
+// validate validates a code against a secret, returning the code's counter.
+func (m *Manager) validate(t *TOTP, code string) (int64, error) {
+	key, err := totp.DecodeSecret(t.Secret)
+	if err != nil {
+		return 0, err
+	}
+	counter, ok := totp.Validate(key, strings.TrimSpace(code), m.Now(), m.config.Skew)
+	if !ok {
+		return 0, ErrInvalidCode
+	}
+	return counter, nil
+}
+
+// recoveryCodeEncoding is the encoding used for recovery codes.
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// newRecoveryCodes generates a new set of recovery codes, returning the
+// codes and their hashes.
+func (m *Manager) newRecoveryCodes(userID snowflake.Snowflake) ([]string, []*RecoveryCode, error) {
+	codes := make([]string, m.config.RecoveryCodes)
+	hashed := make([]*RecoveryCode, m.config.RecoveryCodes)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := recoveryCodeEncoding.EncodeToString(b)
+		hash, err := argon2.Hash([]byte(code))
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i] = code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+		hashed[i] = &RecoveryCode{
+			ID:     snowflake.New(),
+			UserID: userID,
+			Hash:   hash,
+		}
+	}
+	return codes, hashed, nil
+}
+
+// normalizeRecoveryCode removes formatting from a recovery code.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
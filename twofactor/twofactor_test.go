@@ -0,0 +1,232 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package twofactor_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/argon2"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/internal/totp"
+	"github.com/matthewpi/cosmos/twofactor"
+	"github.com/matthewpi/cosmos/user"
+)
+
+func TestMain(m *testing.M) {
+	argon2.Memory = 1024
+	argon2.Iterations = 1
+	os.Exit(m.Run())
+}
+
+// memoryStore is an in-memory twofactor.Store used for testing.
+type memoryStore struct {
+	secrets map[snowflake.Snowflake]twofactor.TOTP
+	codes   map[snowflake.Snowflake]*twofactor.RecoveryCode
+	used    map[snowflake.Snowflake]bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		secrets: make(map[snowflake.Snowflake]twofactor.TOTP),
+		codes:   make(map[snowflake.Snowflake]*twofactor.RecoveryCode),
+		used:    make(map[snowflake.Snowflake]bool),
+	}
+}
+
+func (s *memoryStore) TOTP(_ context.Context, userID snowflake.Snowflake) (*twofactor.TOTP, error) {
+	t, ok := s.secrets[userID]
+	if !ok {
+		return nil, twofactor.ErrNotFound
+	}
+	return &t, nil
+}
+
+func (s *memoryStore) SaveTOTP(_ context.Context, t *twofactor.TOTP) error {
+	s.secrets[t.UserID] = *t
+	return nil
+}
+
+func (s *memoryStore) Enable(ctx context.Context, userID snowflake.Snowflake, counter int64, codes []*twofactor.RecoveryCode) error {
+	t := s.secrets[userID]
+	if t.Confirmed {
+		return twofactor.ErrAlreadyEnabled
+	}
+	t.Confirmed = true
+	t.LastCounter = counter
+	s.secrets[userID] = t
+	return s.ReplaceRecoveryCodes(ctx, userID, codes)
+}
+
+func (s *memoryStore) UseCounter(_ context.Context, userID snowflake.Snowflake, counter int64) (bool, error) {
+	t := s.secrets[userID]
+	if t.LastCounter >= counter {
+		return false, nil
+	}
+	t.LastCounter = counter
+	s.secrets[userID] = t
+	return true, nil
+}
+
+func (s *memoryStore) RecoveryCodes(_ context.Context, userID snowflake.Snowflake) ([]*twofactor.RecoveryCode, error) {
+	var codes []*twofactor.RecoveryCode
+	for _, c := range s.codes {
+		if c.UserID == userID && !s.used[c.ID] {
+			codes = append(codes, c)
+		}
+	}
+	return codes, nil
+}
+
+func (s *memoryStore) ReplaceRecoveryCodes(_ context.Context, userID snowflake.Snowflake, codes []*twofactor.RecoveryCode) error {
+	for id, c := range s.codes {
+		if c.UserID == userID {
+			delete(s.codes, id)
+		}
+	}
+	for _, c := range codes {
+		s.codes[c.ID] = c
+	}
+	return nil
+}
+
+func (s *memoryStore) UseRecoveryCode(_ context.Context, id snowflake.Snowflake) (bool, error) {
+	if s.used[id] {
+		return false, nil
+	}
+	s.used[id] = true
+	return true, nil
+}
+
+func (s *memoryStore) Disable(ctx context.Context, userID snowflake.Snowflake) error {
+	delete(s.secrets, userID)
+	return s.ReplaceRecoveryCodes(ctx, userID, nil)
+}
+
+// enable enrolls a user and enables two-factor authentication, returning the
+// user's key and recovery codes.
+func enable(t *testing.T, m *twofactor.Manager, u *user.User) ([]byte, []string) {
+	e, err := m.Enroll(context.Background(), u)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	key, err := totp.DecodeSecret(e.Secret)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if _, err := m.Enable(context.Background(), u.ID, "000000x"); err != twofactor.ErrInvalidCode {
+		t.Errorf("Expected \"%v\", but got \"%v\"", twofactor.ErrInvalidCode, err)
+	}
+	codes, err := m.Enable(context.Background(), u.ID, totp.Generate(key, totp.Counter(m.Now()), totp.Digits))
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	return key, codes
+}
+
+func newTestManager(t *testing.T) (*twofactor.Manager, *user.User, *time.Time) {
+	u, err := user.New("user@example.com", nil)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	now := time.Unix(1234567890, 0)
+	m := twofactor.NewManager(newMemoryStore(), nil)
+	m.Now = func() time.Time { return now }
+	return m, u, &now
+}
+
+func TestManager_Enable(t *testing.T) {
+	m, u, _ := newTestManager(t)
+
+	if enabled, _ := m.Enabled(context.Background(), u.ID); enabled {
+		t.Errorf("Expected two-factor authentication to be disabled")
+	}
+	if _, err := m.Enable(context.Background(), u.ID, "123456"); err != twofactor.ErrNotEnrolled {
+		t.Errorf("Expected \"%v\", but got \"%v\"", twofactor.ErrNotEnrolled, err)
+	}
+	_, codes := enable(t, m, u)
+	if len(codes) != twofactor.DefaultConfig().RecoveryCodes {
+		t.Errorf("Expected \"%d\", but got \"%d\"", twofactor.DefaultConfig().RecoveryCodes, len(codes))
+	}
+	if enabled, _ := m.Enabled(context.Background(), u.ID); !enabled {
+		t.Errorf("Expected two-factor authentication to be enabled")
+	}
+	if _, err := m.Enroll(context.Background(), u); err != twofactor.ErrAlreadyEnabled {
+		t.Errorf("Expected \"%v\", but got \"%v\"", twofactor.ErrAlreadyEnabled, err)
+	}
+}
+
+func TestManager_Verify(t *testing.T) {
+	m, u, now := newTestManager(t)
+	key, _ := enable(t, m, u)
+
+	// The code used to enable two-factor authentication can't be reused.
+	code := totp.Generate(key, totp.Counter(*now), totp.Digits)
+	if err := m.Verify(context.Background(), u.ID, code); err != twofactor.ErrInvalidCode {
+		t.Errorf("Expected \"%v\", but got \"%v\"", twofactor.ErrInvalidCode, err)
+	}
+
+	*now = now.Add(totp.Period * time.Second)
+	code = totp.Generate(key, totp.Counter(*now), totp.Digits)
+	if err := m.Verify(context.Background(), u.ID, code); err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+	}
+	if err := m.Verify(context.Background(), u.ID, code); err != twofactor.ErrInvalidCode {
+		t.Errorf("Expected code to only be accepted once, but got \"%v\"", err)
+	}
+
+	// A code from the previous period is within the skew window, but is
+	// older than the last accepted code.
+	*now = now.Add(totp.Period * time.Second)
+	if err := m.Verify(context.Background(), u.ID, code); err != twofactor.ErrInvalidCode {
+		t.Errorf("Expected \"%v\", but got \"%v\"", twofactor.ErrInvalidCode, err)
+	}
+}
+
+func TestManager_Recover(t *testing.T) {
+	m, u, _ := newTestManager(t)
+	_, codes := enable(t, m, u)
+
+	if err := m.Recover(context.Background(), u.ID, "aaaa-aaaa-aaaa-aaaa"); err != twofactor.ErrInvalidCode {
+		t.Errorf("Expected \"%v\", but got \"%v\"", twofactor.ErrInvalidCode, err)
+	}
+	if err := m.Recover(context.Background(), u.ID, codes[3]); err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+	}
+	if err := m.Recover(context.Background(), u.ID, codes[3]); err != twofactor.ErrInvalidCode {
+		t.Errorf("Expected recovery code to only be accepted once, but got \"%v\"", err)
+	}
+
+	regenerated, err := m.RegenerateRecoveryCodes(context.Background(), u.ID)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	if err := m.Recover(context.Background(), u.ID, codes[4]); err != twofactor.ErrInvalidCode {
+		t.Errorf("Expected old recovery codes to be replaced, but got \"%v\"", err)
+	}
+	if err := m.Recover(context.Background(), u.ID, regenerated[0]); err != nil {
+		t.Errorf("Should not have error return value, but received \"%v\"", err)
+	}
+}
@@ -0,0 +1,58 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package user
+
+import (
+	"context"
+)
+
+// contextKey is the type of the context key used to store a User.
+type contextKey struct{}
+
+// NewContext returns a new context carrying the User.
+func NewContext(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, u)
+}
+
+// FromContext returns the User stored in the context, if any.
+func FromContext(ctx context.Context) (*User, bool) {
+	u, ok := ctx.Value(contextKey{}).(*User)
+	return u, ok && u != nil
+}
+
+// impersonatorKey is the type of the context key used to store the User
+// impersonating the User stored by NewContext.
+type impersonatorKey struct{}
+
+// NewImpersonatorContext returns a new context carrying the User who is
+// impersonating the context's User.
+func NewImpersonatorContext(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, impersonatorKey{}, u)
+}
+
+// ImpersonatorFromContext returns the User impersonating the context's User,
+// if any.
+func ImpersonatorFromContext(ctx context.Context) (*User, bool) {
+	u, ok := ctx.Value(impersonatorKey{}).(*User)
+	return u, ok && u != nil
+}
@@ -0,0 +1,380 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package user
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matthewpi/pgconn"
+	"github.com/matthewpi/pgx/v4"
+
+	"github.com/matthewpi/cosmos/internal/db"
+	"github.com/matthewpi/cosmos/internal/outbox"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+)
+
+const (
+	// EventCreated is the topic of the outbox event enqueued when a User is
+	// created.
+	EventCreated = "user.created"
+
+	// EventDeleted is the topic of the outbox event enqueued when a User is
+	// deleted.
+	EventDeleted = "user.deleted"
+)
+
+var (
+	// ErrNotFound is returned when a User does not exist.
+	ErrNotFound = errors.New("user: not found")
+
+	// ErrEmailTaken is returned when another User already has the email
+	// address.
+	ErrEmailTaken = errors.New("user: email address is already in use")
+)
+
+// Store persists Users.
+type Store interface {
+	// Create stores a new User, enqueueing an EventCreated event in the same
+	// transaction.
+	Create(ctx context.Context, u *User) error
+
+	// Update updates an existing User, the User's role is not updated, see
+	// role.Store.Assign.
+	//
+	// Update rewrites every column, overwriting changes made since the User
+	// was loaded, such as a lockout, flows changing a single attribute use
+	// the targeted methods below instead.
+	Update(ctx context.Context, u *User) error
+
+	// Confirm marks the User with the given ID as having confirmed their
+	// email address.
+	Confirm(ctx context.Context, id snowflake.Snowflake) error
+
+	// SetEmail changes the email address of the User with the given ID and
+	// marks it as confirmed.
+	SetEmail(ctx context.Context, id snowflake.Snowflake, email string) error
+
+	// UpdatePassword stores the User's password hash, see User.SetPassword.
+	UpdatePassword(ctx context.Context, u *User) error
+
+	// SetAvatar sets the avatar of the User with the given ID, an empty hash
+	// removes it.
+	SetAvatar(ctx context.Context, id snowflake.Snowflake, hash string) error
+
+	// ByID returns the User with the given ID.
+	ByID(ctx context.Context, id snowflake.Snowflake) (*User, error)
+
+	// ByEmail returns the User with the given email address.
+	ByEmail(ctx context.Context, email string) (*User, error)
+
+	// SetLastLogin records that the User with the given ID logged in at t.
+	SetLastLogin(ctx context.Context, id snowflake.Snowflake, t time.Time) error
+
+	// ScheduleDeletion sets when the User with the given ID is deleted, a
+	// zero t cancels the deletion.
+	ScheduleDeletion(ctx context.Context, id snowflake.Snowflake, t time.Time) error
+
+	// List returns the Users matching q, ordered by ID.
+	List(ctx context.Context, q *Query) ([]*User, error)
+
+	// Delete deletes the User with the given ID, enqueueing an EventDeleted
+	// event in the same transaction.
+	Delete(ctx context.Context, id snowflake.Snowflake) error
+}
+
+// Query filters and pages the Users returned by Store.List.
+//
+// IDs are snowflakes generated from a User's CreatedAt, so ordering by ID
+// orders Users by when they were created, and the ID of the last User seen is
+// used as the cursor for the next page.
+type Query struct {
+	// After, if valid, only matches Users after the cursor in the requested
+	// order.
+	After snowflake.Snowflake
+
+	// Descending orders the newest Users first.
+	Descending bool
+
+	// Confirmed and Locked, if not nil, only match Users with the given
+	// status.
+	Confirmed *bool
+	Locked    *bool
+
+	// EmailPrefix, if not empty, only matches Users whose email address
+	// starts with the prefix.
+	EmailPrefix string
+
+	// DeletionDue, if not zero, only matches Users scheduled to be deleted
+	// at or before the time.
+	DeletionDue time.Time
+
+	// Limit is the maximum number of Users returned.
+	Limit int
+}
+
+// selectUsers selects the columns expected by scan, joining the user's role.
+const selectUsers = "SELECT users.id, users.email, users.password, users.confirmed, users.locked, users.avatar, users.created_at, users.last_login_at, users.delete_after, users.invited_by, " +
+	"roles.id, roles.name, roles.description, roles.permissions, roles.sort_id " +
+	"FROM users LEFT JOIN roles ON roles.id = users.role_id"
+
+// store is a PostgreSQL backed Store.
+type store struct {
+	db db.Querier
+}
+
+var _ Store = (*store)(nil)
+
+// NewStore returns a Store backed by the "users" table.
+func NewStore(q db.Querier) Store {
+	return &store{db: q}
+}
+
+func (s *store) Create(ctx context.Context, u *User) error {
+	err := s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(
+			ctx,
+			"INSERT INTO users (id, email, password, confirmed, locked, avatar, role_id, invited_by, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)",
+			u.ID, u.Email, u.password, u.Confirmed, u.Locked, nullString(u.Avatar), roleID(u.Role), u.InvitedBy, u.CreatedAt,
+		); err != nil {
+			return err
+		}
+		_, err := outbox.Enqueue(ctx, tx, EventCreated, u)
+		return err
+	})
+	return mapError(err)
+}
+
+func (s *store) Update(ctx context.Context, u *User) error {
+	tag, err := s.db.Exec(
+		ctx,
+		"UPDATE users SET email = $2, password = $3, confirmed = $4, locked = $5, avatar = $6, updated_at = now() WHERE id = $1",
+		u.ID, u.Email, u.password, u.Confirmed, u.Locked, nullString(u.Avatar),
+	)
+	if err != nil {
+		return mapError(err)
+	}
+	if tag.RowsAffected() < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *store) Confirm(ctx context.Context, id snowflake.Snowflake) error {
+	return s.exec(ctx, "UPDATE users SET confirmed = true, updated_at = now() WHERE id = $1", id)
+}
+
+func (s *store) SetEmail(ctx context.Context, id snowflake.Snowflake, email string) error {
+	return s.exec(ctx, "UPDATE users SET email = $2, confirmed = true, updated_at = now() WHERE id = $1", id, email)
+}
+
+func (s *store) UpdatePassword(ctx context.Context, u *User) error {
+	return s.exec(ctx, "UPDATE users SET password = $2, updated_at = now() WHERE id = $1", u.ID, u.password)
+}
+
+func (s *store) SetAvatar(ctx context.Context, id snowflake.Snowflake, hash string) error {
+	return s.exec(ctx, "UPDATE users SET avatar = $2, updated_at = now() WHERE id = $1", id, nullString(hash))
+}
+
+// exec executes a statement updating a single user, returning ErrNotFound if
+// no user was updated.
+func (s *store) exec(ctx context.Context, sql string, args ...interface{}) error {
+	tag, err := s.db.Exec(ctx, sql, args...)
+	if err != nil {
+		return mapError(err)
+	}
+	if tag.RowsAffected() < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *store) ByID(ctx context.Context, id snowflake.Snowflake) (*User, error) {
+	return scan(s.db.QueryRow(ctx, selectUsers+" WHERE users.id = $1", id))
+}
+
+func (s *store) ByEmail(ctx context.Context, email string) (*User, error) {
+	return scan(s.db.QueryRow(ctx, selectUsers+" WHERE users.email = $1", email))
+}
+
+func (s *store) SetLastLogin(ctx context.Context, id snowflake.Snowflake, t time.Time) error {
+	tag, err := s.db.Exec(ctx, "UPDATE users SET last_login_at = $2 WHERE id = $1", id, t)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *store) ScheduleDeletion(ctx context.Context, id snowflake.Snowflake, t time.Time) error {
+	var deleteAfter *time.Time
+	if !t.IsZero() {
+		deleteAfter = &t
+	}
+	tag, err := s.db.Exec(ctx, "UPDATE users SET delete_after = $2 WHERE id = $1", id, deleteAfter)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *store) List(ctx context.Context, q *Query) ([]*User, error) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	order, op := "ASC", " > "
+	if q.Descending {
+		order, op = "DESC", " < "
+	}
+	if q.After.Valid() {
+		where = append(where, "users.id"+op+arg(q.After))
+	}
+	if q.Confirmed != nil {
+		where = append(where, "users.confirmed = "+arg(*q.Confirmed))
+	}
+	if q.Locked != nil {
+		where = append(where, "users.locked = "+arg(*q.Locked))
+	}
+	if q.EmailPrefix != "" {
+		where = append(where, "users.email LIKE "+arg(likePrefix(q.EmailPrefix))+" ESCAPE '\\'")
+	}
+	if !q.DeletionDue.IsZero() {
+		where = append(where, "users.delete_after <= "+arg(q.DeletionDue))
+	}
+	sql := selectUsers
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	sql += " ORDER BY users.id " + order + " LIMIT " + arg(q.Limit)
+
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []*User
+	for rows.Next() {
+		u, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *store) Delete(ctx context.Context, id snowflake.Snowflake) error {
+	return s.db.BeginFunc(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, "DELETE FROM users WHERE id = $1", id)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() < 1 {
+			return ErrNotFound
+		}
+		_, err = outbox.Enqueue(ctx, tx, EventDeleted, map[string]snowflake.Snowflake{"id": id})
+		return err
+	})
+}
+
+// likePrefix returns a LIKE pattern matching strings starting with prefix,
+// escaping the pattern's special characters.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
+
+// scan scans a row selected by selectUsers into a User.
+func scan(row pgx.Row) (*User, error) {
+	u := &User{}
+	var avatar *string
+	var lastLoginAt, deleteAfter *time.Time
+	r := &role.Role{}
+	var roleName, roleDescription *string
+	var roleSortID *int
+	if err := row.Scan(
+		&u.ID, &u.Email, &u.password, &u.Confirmed, &u.Locked, &avatar, &u.CreatedAt, &lastLoginAt, &deleteAfter, &u.InvitedBy,
+		&r.ID, &roleName, &roleDescription, &r.Permissions, &roleSortID,
+	); err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if avatar != nil {
+		u.Avatar = *avatar
+	}
+	if lastLoginAt != nil {
+		u.LastLoginAt = *lastLoginAt
+	}
+	if deleteAfter != nil {
+		u.DeleteAfter = *deleteAfter
+	}
+	if r.ID.Valid() {
+		r.Name = *roleName
+		if roleDescription != nil {
+			r.Description = *roleDescription
+		}
+		if roleSortID != nil {
+			r.SortID = *roleSortID
+		}
+		u.Role = r
+	}
+	return u, nil
+}
+
+// mapError maps constraint violations to their respective errors.
+func mapError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+// roleID returns the ID of a role, or nil if there is no role.
+func roleID(r *role.Role) interface{} {
+	if r == nil {
+		return nil
+	}
+	return r.ID
+}
+
+// nullString returns nil for an empty string, so it is stored as NULL.
+func nullString(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,184 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package user_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+	"github.com/matthewpi/cosmos/user/usertest"
+)
+
+func TestStore_Roles(t *testing.T) {
+	ctx := context.Background()
+	db := usertest.New()
+	users, roles := db.Users(), db.Roles()
+
+	owner := &role.Role{ID: snowflake.New(), Name: "Owner", SortID: 0, Permissions: role.Permissions{"*"}}
+	admin := &role.Role{ID: snowflake.New(), Name: "Administrator", SortID: 1, Permissions: role.Permissions{role.PermissionAssign}}
+	member := &role.Role{ID: snowflake.New(), Name: "Member", SortID: 2}
+	for _, r := range []*role.Role{owner, admin, member} {
+		if err := roles.Create(ctx, r); err != nil {
+			t.Errorf("Should not have error return value, but received \"%v\"", err)
+			return
+		}
+	}
+
+	ids := make(map[*role.Role]snowflake.Snowflake)
+	for _, r := range []*role.Role{owner, admin, member, nil} {
+		u, err := user.New(snowflake.New().String()+"@example.com", nil)
+		if err != nil {
+			t.Errorf("Should not have error return value, but received \"%v\"", err)
+			return
+		}
+		u.ID = snowflake.New()
+		u.Role = r
+		if err := users.Create(ctx, u); err != nil {
+			t.Errorf("Should not have error return value, but received \"%v\"", err)
+			return
+		}
+		ids[r] = u.ID
+	}
+	load := func(r *role.Role) *role.Role {
+		u, err := users.ByID(ctx, ids[r])
+		if err != nil {
+			t.Fatalf("Should not have error return value, but received \"%v\"", err)
+		}
+		return u.Role
+	}
+
+	for i, tc := range []struct {
+		role   *role.Role
+		sortID int
+	}{
+		{owner, 0},
+		{admin, 1},
+		{member, 2},
+	} {
+		if r := load(tc.role); r == nil || r.SortID != tc.sortID {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.sortID, r)
+		}
+	}
+	if r := load(nil); r != nil {
+		t.Errorf("Expected \"%v\", but got \"%v\"", nil, r)
+	}
+
+	for i, tc := range []struct {
+		actor, target *role.Role
+		outranks      bool
+	}{
+		{owner, admin, true},
+		{admin, member, true},
+		{admin, owner, false},
+		{member, admin, false},
+		{admin, admin, false},
+	} {
+		if v := load(tc.actor).Outranks(load(tc.target)); v != tc.outranks {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.outranks, v)
+		}
+	}
+
+	m := role.NewManager(roles)
+	for i, tc := range []struct {
+		actor, current, next *role.Role
+		err                  error
+	}{
+		{admin, owner, member, role.ErrInsufficientRank},
+		{admin, member, owner, role.ErrInsufficientRank},
+		{admin, member, nil, nil},
+		{owner, admin, member, nil},
+	} {
+		nextID := snowflake.Nil
+		if tc.next != nil {
+			nextID = tc.next.ID
+		}
+		err := m.Assign(ctx, load(tc.actor), ids[tc.current], load(tc.current), nextID)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, tc.err, err)
+		}
+	}
+}
+
+func TestStore_KeepsConcurrentLock(t *testing.T) {
+	ctx := context.Background()
+	db := usertest.New()
+	users := db.Users()
+
+	for i, tc := range []struct {
+		name   string
+		update func(u *user.User) error
+	}{
+		{"confirm", func(u *user.User) error { return users.Confirm(ctx, u.ID) }},
+		{"email", func(u *user.User) error { return users.SetEmail(ctx, u.ID, "new-"+u.Email) }},
+		{"password", func(u *user.User) error {
+			if err := u.SetPassword([]byte("a new secure password")); err != nil {
+				return err
+			}
+			return users.UpdatePassword(ctx, u)
+		}},
+		{"avatar", func(u *user.User) error { return users.SetAvatar(ctx, u.ID, "hash") }},
+	} {
+		u, err := user.New(tc.name+"@example.com", nil)
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		u.ID = snowflake.New()
+		if err := users.Create(ctx, u); err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+
+		// The account is locked after the flow loaded the user.
+		loaded, err := users.ByID(ctx, u.ID)
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		if _, err := db.Exec(ctx, "UPDATE users SET locked = true, updated_at = now() WHERE id = $1", u.ID); err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		if err := tc.update(loaded); err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+
+		got, err := users.ByID(ctx, u.ID)
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		if !got.Locked {
+			t.Errorf("Test #%d: Expected \"%v\", but got \"%v\"", i, true, got.Locked)
+		}
+	}
+
+	if err := users.Confirm(ctx, snowflake.New()); !errors.Is(err, user.ErrNotFound) {
+		t.Errorf("Expected \"%v\", but got \"%v\"", user.ErrNotFound, err)
+	}
+}
@@ -24,12 +24,29 @@
 package user
 
 import (
+	"errors"
 	"time"
 
 	"github.com/matthewpi/cosmos/internal/argon2"
 	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
 )
 
+// Permissions required to manage other users.
+const (
+	PermissionRead   role.Permission = "users.read"
+	PermissionCreate role.Permission = "users.create"
+	PermissionUpdate role.Permission = "users.update"
+	PermissionDelete role.Permission = "users.delete"
+
+	// PermissionImpersonate allows impersonating users of a lower role.
+	PermissionImpersonate role.Permission = "users.impersonate"
+)
+
+// ErrEmptyPassword is returned when setting an empty password, a User
+// without a password should be created by passing a nil password to New.
+var ErrEmptyPassword = errors.New("user: password must not be empty")
+
 // User represents a Cosmos User.
 type User struct {
 	// ID is the user's unique identifier. (unique, crypto-secure random)
@@ -47,11 +64,26 @@ type User struct {
 	// Locked represents if the User's account is locked.
 	Locked bool `json:"-"`
 
-	// Avatar is a hash of the User's avatar.
+	// Avatar is the hash of the User's avatar, see package avatar.
 	Avatar string `json:"avatar"`
 
+	// Role is the User's role, if nil the User has no permissions.
+	Role *role.Role `json:"role,omitempty"`
+
 	// CreatedAt is a timestamp of when the account was created.
 	CreatedAt time.Time `json:"created_at,omitempty"`
+
+	// LastLoginAt is a timestamp of when the User last logged in, zero if
+	// they never have.
+	LastLoginAt time.Time `json:"-"`
+
+	// DeleteAfter is a timestamp of when the User's account is erased, zero
+	// if its deletion has not been requested.
+	DeleteAfter time.Time `json:"-"`
+
+	// InvitedBy is the ID of the user who created the invite the User
+	// registered with, it is invalid if they were not invited.
+	InvitedBy snowflake.Snowflake `json:"-"`
 }
 
 // New .
@@ -77,8 +109,13 @@ func (u *User) HasPassword() bool {
 	return u.password != ""
 }
 
-// SetPassword hashes a raw password and updates the user's password.
+// SetPassword hashes a raw password and updates the user's password.  The
+// password should be checked against the password policy beforehand, see
+// internal/password.
 func (u *User) SetPassword(password []byte) error {
+	if len(password) == 0 {
+		return ErrEmptyPassword
+	}
 	h, err := argon2.Hash(password)
 	if err != nil {
 		return err
@@ -91,3 +128,8 @@ func (u *User) SetPassword(password []byte) error {
 func (u *User) VerifyPassword(password []byte) error {
 	return argon2.Verify(password, u.password)
 }
+
+// Can returns true if the User's role grants the requested permission.
+func (u *User) Can(p role.Permission) bool {
+	return u.Role.Can(p)
+}
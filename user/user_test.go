@@ -47,6 +47,12 @@ func Test_New(t *testing.T) {
 			expectPassword: false,
 			expectErr:      nil,
 		},
+		{
+			email:          "matthew@example.com",
+			password:       []byte{},
+			expectPassword: false,
+			expectErr:      user.ErrEmptyPassword,
+		},
 	} {
 		u, err := user.New(tc.email, tc.password)
 
@@ -0,0 +1,222 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+// Package usertest provides users and roles tables answering the queries of
+// user.Store and role.Store, for testing code that depends on users and
+// their roles as loaded from PostgreSQL.
+package usertest
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matthewpi/pgconn"
+
+	"github.com/matthewpi/cosmos/internal/db/dbtest"
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+// assignment matches an assignment of a SET clause.
+var assignment = regexp.MustCompile(`(\w+) = (\$\d+|now\(\)|NULL|true|false)`)
+
+// DB is an in-memory database of users and roles.
+type DB struct {
+	*dbtest.Querier
+
+	mu    sync.Mutex
+	users map[snowflake.Snowflake]dbtest.Row
+	roles map[snowflake.Snowflake]dbtest.Row
+}
+
+// New returns a new, empty DB.
+func New() *DB {
+	d := &DB{
+		Querier: dbtest.New(),
+		users:   make(map[snowflake.Snowflake]dbtest.Row),
+		roles:   make(map[snowflake.Snowflake]dbtest.Row),
+	}
+	d.Handle("INSERT INTO users", d.insertUser)
+	d.Handle("UPDATE users SET", d.updateUser)
+	d.Handle("DELETE FROM users", d.deleteUser)
+	d.Handle("FROM users LEFT JOIN roles", d.selectUsers)
+	d.Handle("INSERT INTO roles", d.insertRole)
+	d.Handle("SELECT id, name, description, permissions, sort_id FROM roles", d.selectRoles)
+	return d
+}
+
+// Users returns a user.Store backed by the DB.
+func (d *DB) Users() user.Store {
+	return user.NewStore(d.Querier)
+}
+
+// Roles returns a role.Store backed by the DB.
+func (d *DB) Roles() role.Store {
+	return role.NewStore(d.Querier)
+}
+
+func (d *DB) insertUser(s dbtest.Statement) ([]dbtest.Row, error) {
+	r := dbtest.Inserted(s)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, u := range d.users {
+		if u["email"] == r["email"] {
+			return nil, &pgconn.PgError{Code: "23505"}
+		}
+	}
+	d.users[id(r["id"])] = r
+	return []dbtest.Row{r}, nil
+}
+
+// updateUser applies the assignments of an UPDATE to the user whose ID is
+// compared with "id = $N" in its WHERE clause.
+func (d *DB) updateUser(s dbtest.Statement) ([]dbtest.Row, error) {
+	set := s.SQL[strings.Index(s.SQL, " SET ")+len(" SET "):]
+	where := ""
+	if i := strings.Index(set, " WHERE "); i >= 0 {
+		set, where = set[:i], set[i+len(" WHERE "):]
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	u, ok := d.users[id(arg(s, where, "id"))]
+	if !ok {
+		return nil, nil
+	}
+	for _, m := range assignment.FindAllStringSubmatch(set, -1) {
+		u[m[1]] = value(s, m[2])
+	}
+	return []dbtest.Row{u}, nil
+}
+
+func (d *DB) deleteUser(s dbtest.Statement) ([]dbtest.Row, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	userID := id(arg(s, s.SQL, "id"))
+	u, ok := d.users[userID]
+	if !ok {
+		return nil, nil
+	}
+	delete(d.users, userID)
+	return []dbtest.Row{u}, nil
+}
+
+// selectUsers answers lookups by ID and email address, joining the user's
+// role.
+func (d *DB) selectUsers(s dbtest.Statement) ([]dbtest.Row, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var rows []dbtest.Row
+	for _, u := range d.users {
+		switch {
+		case strings.Contains(s.SQL, "WHERE users.id = $1"):
+			if id(u["id"]) != id(s.Args[0]) {
+				continue
+			}
+		case strings.Contains(s.SQL, "WHERE users.email = $1"):
+			if u["email"] != s.Args[0] {
+				continue
+			}
+		}
+		r := d.roles[id(u["role_id"])]
+		row := dbtest.Row{}
+		for _, c := range dbtest.Columns(s.SQL) {
+			switch {
+			case strings.HasPrefix(c, "users."):
+				row[c] = u[strings.TrimPrefix(c, "users.")]
+			case strings.HasPrefix(c, "roles."):
+				row[c] = r[strings.TrimPrefix(c, "roles.")]
+			}
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return id(rows[i]["users.id"]) < id(rows[j]["users.id"])
+	})
+	return rows, nil
+}
+
+func (d *DB) insertRole(s dbtest.Statement) ([]dbtest.Row, error) {
+	r := dbtest.Inserted(s)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.roles[id(r["id"])] = r
+	return []dbtest.Row{r}, nil
+}
+
+func (d *DB) selectRoles(s dbtest.Statement) ([]dbtest.Row, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var rows []dbtest.Row
+	for _, r := range d.roles {
+		if strings.Contains(s.SQL, "WHERE id = $1") && id(r["id"]) != id(s.Args[0]) {
+			continue
+		}
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i]["sort_id"].(int) < rows[j]["sort_id"].(int)
+	})
+	return rows, nil
+}
+
+// arg returns the argument compared with column in clause.
+func arg(s dbtest.Statement, clause, column string) interface{} {
+	m := regexp.MustCompile(`\b` + column + ` = (\$\d+)`).FindStringSubmatch(clause)
+	if m == nil {
+		return nil
+	}
+	return value(s, m[1])
+}
+
+// value returns the value of a placeholder or literal.
+func value(s dbtest.Statement, v string) interface{} {
+	switch v {
+	case "now()":
+		return time.Now()
+	case "NULL":
+		return nil
+	case "true", "false":
+		return v == "true"
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(v, "$"))
+	if err != nil || n < 1 || n > len(s.Args) {
+		return nil
+	}
+	return s.Args[n-1]
+}
+
+// id returns the snowflake of an argument or value.
+func id(v interface{}) snowflake.Snowflake {
+	switch v := v.(type) {
+	case snowflake.Snowflake:
+		return v
+	case int64:
+		return snowflake.Snowflake(v)
+	default:
+		return snowflake.Nil
+	}
+}
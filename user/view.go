@@ -0,0 +1,122 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package user
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/matthewpi/cosmos/internal/snowflake"
+	"github.com/matthewpi/cosmos/role"
+)
+
+// View controls which fields of a User are included when it is marshaled for
+// an audience, see User.As.
+type View int
+
+const (
+	// ViewPublic is a User as seen by anyone, only its ID and avatar.
+	ViewPublic View = iota
+
+	// ViewSelf is a User as seen by themselves, adding their email address,
+	// whether it is confirmed, when the account was created and when it will
+	// be deleted.
+	ViewSelf
+
+	// ViewAdmin is a User as seen by an administrator, adding whether the
+	// account is locked, its role, when it last logged in and who invited
+	// them.
+	ViewAdmin
+)
+
+// Viewed is a User marshaled as seen through a View.
+type Viewed struct {
+	user *User
+	view View
+}
+
+var _ json.Marshaler = Viewed{}
+
+// As returns the User as seen through the view.  API handlers should always
+// respond with a Viewed User rather than the User itself, whose own JSON
+// encoding is meant for internal use, such as outbox events.
+func (u *User) As(v View) Viewed {
+	return Viewed{user: u, view: v}
+}
+
+// AsAll returns every User as seen through the view.
+func AsAll(users []*User, v View) []Viewed {
+	viewed := make([]Viewed, len(users))
+	for i, u := range users {
+		viewed[i] = u.As(v)
+	}
+	return viewed
+}
+
+// viewedJSON is the JSON encoding of a Viewed User, fields are nil when they
+// are not part of the view.
+type viewedJSON struct {
+	ID     snowflake.Snowflake `json:"id"`
+	Avatar string              `json:"avatar"`
+
+	Email       *string    `json:"email,omitempty"`
+	Confirmed   *bool      `json:"confirmed,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	DeleteAfter *time.Time `json:"delete_after,omitempty"`
+
+	Locked      *bool                `json:"locked,omitempty"`
+	Role        *role.Role           `json:"role,omitempty"`
+	LastLoginAt *time.Time           `json:"last_login_at,omitempty"`
+	InvitedBy   *snowflake.Snowflake `json:"invited_by,omitempty"`
+}
+
+// MarshalJSON satisfies json.Marshaler.
+func (v Viewed) MarshalJSON() ([]byte, error) {
+	u := v.user
+	if u == nil {
+		return []byte("null"), nil
+	}
+	j := viewedJSON{
+		ID:     u.ID,
+		Avatar: u.Avatar,
+	}
+	if v.view >= ViewSelf {
+		j.Email = &u.Email
+		j.Confirmed = &u.Confirmed
+		j.CreatedAt = &u.CreatedAt
+		if !u.DeleteAfter.IsZero() {
+			j.DeleteAfter = &u.DeleteAfter
+		}
+	}
+	if v.view >= ViewAdmin {
+		j.Locked = &u.Locked
+		j.Role = u.Role
+		if !u.LastLoginAt.IsZero() {
+			j.LastLoginAt = &u.LastLoginAt
+		}
+		if u.InvitedBy.Valid() {
+			j.InvitedBy = &u.InvitedBy
+		}
+	}
+	return json.Marshal(j)
+}
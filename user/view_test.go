@@ -0,0 +1,97 @@
+//
+// Copyright (c) 2021 Matthew Penner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+
+package user_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/matthewpi/cosmos/role"
+	"github.com/matthewpi/cosmos/user"
+)
+
+func TestUser_As(t *testing.T) {
+	u := &user.User{
+		ID:          1,
+		Email:       "user@example.com",
+		Confirmed:   true,
+		Locked:      true,
+		Avatar:      "abc",
+		Role:        &role.Role{ID: 2, Name: "Staff", Permissions: role.Permissions{}},
+		CreatedAt:   time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+		LastLoginAt: time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC),
+		InvitedBy:   3,
+	}
+	for i, tc := range []struct {
+		user   *user.User
+		view   user.View
+		expect string
+	}{
+		{
+			user:   u,
+			view:   user.ViewPublic,
+			expect: `{"id":"1","avatar":"abc"}`,
+		},
+		{
+			user:   u,
+			view:   user.ViewSelf,
+			expect: `{"id":"1","avatar":"abc","email":"user@example.com","confirmed":true,"created_at":"2021-01-02T03:04:05Z"}`,
+		},
+		{
+			user: u,
+			view: user.ViewAdmin,
+			expect: `{"id":"1","avatar":"abc","email":"user@example.com","confirmed":true,"created_at":"2021-01-02T03:04:05Z",` +
+				`"locked":true,"role":` + mustMarshal(t, u.Role) + `,"last_login_at":"2021-02-03T04:05:06Z","invited_by":"3"}`,
+		},
+		{
+			// Booleans are included even when false, a user who never
+			// logged in has no last_login_at.
+			user:   &user.User{ID: 1},
+			view:   user.ViewAdmin,
+			expect: `{"id":"1","avatar":"","email":"","confirmed":false,"created_at":"0001-01-01T00:00:00Z","locked":false}`,
+		},
+		{
+			user:   nil,
+			view:   user.ViewAdmin,
+			expect: `null`,
+		},
+	} {
+		b, err := json.Marshal(tc.user.As(tc.view))
+		if err != nil {
+			t.Errorf("Test #%d: Should not have error return value, but received \"%v\"", i, err)
+			continue
+		}
+		if string(b) != tc.expect {
+			t.Errorf("Test #%d: Expected \"%s\", but got \"%s\"", i, tc.expect, string(b))
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Should not have error return value, but received \"%v\"", err)
+	}
+	return string(b)
+}